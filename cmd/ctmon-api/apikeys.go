@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+)
+
+// Values of -api_keys
+const (
+	APIKeysOff      = "off"      // No keys, limits or accounting
+	APIKeysOptional = "optional" // Keys get their own limits, anonymous clients share the limit of their IP
+	APIKeysRequired = "required" // Requests without a valid key are refused
+)
+
+const (
+	apiKeyRefreshInterval = 1 * time.Minute  // Reload of keys created or revoked by `ctmon-api keys`
+	usageFlushInterval    = 1 * time.Minute  // Interval to write accumulated usage to api_usage
+	idleBucketTimeout     = 10 * time.Minute // Rate limit state of clients idle this long is dropped
+	apiKeyPrefix          = "ctmon_"         // Prefix of generated keys, followed by <key_id>_<secret>
+)
+
+// APIKey is a credential for the query API with its own rate limit
+type APIKey struct {
+	ID        string
+	Name      string
+	RateLimit float64 // Requests per second, 0 for the -default_rate_limit
+	Burst     int     // Requests allowed at once, 0 for the rate rounded up
+	Disabled  bool
+	CreatedAt time.Time
+}
+
+// hashAPIKey returns the key_hash stored for a key; keys themselves are never stored
+func hashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// tokenBucket allows rate requests per second on average and burst at once
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+// take consumes a token, or returns how long until one is available
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// usageKey identifies a row of api_usage
+type usageKey struct {
+	keyID  string
+	route  string
+	window time.Time
+}
+
+// usageCounts are the requests of a key to a route within a minute, and what they cost
+type usageCounts struct {
+	requests   uint64
+	rejected   uint64
+	errors     uint64
+	durationMs uint64
+	rowsRead   uint64
+	bytesRead  uint64
+}
+
+// APIKeyStore authenticates API clients by key, enforces per-key (and, for anonymous clients,
+// per-IP) rate limits, and accounts for the cost of each key's requests in api_usage. Keys are
+// kept in the ReplacingMergeTree api_keys table, with an in-memory copy reloaded every
+// apiKeyRefreshInterval.
+type APIKeyStore struct {
+	db            *sql.DB
+	mode          string
+	defaultRate   float64 // Requests per second of keys without their own limit
+	anonymousRate float64 // Requests per second of each anonymous client IP, 0 for unlimited
+	proxyHeader   string  // Header carrying the client IP set by a trusted reverse proxy
+
+	mu      sync.Mutex
+	keys    map[string]*APIKey // By key_hash
+	buckets map[string]*tokenBucket
+	usage   map[usageKey]*usageCounts
+}
+
+// NewAPIKeyStore loads the current keys
+func NewAPIKeyStore(db *sql.DB, mode string, defaultRate, anonymousRate float64, proxyHeader string) (*APIKeyStore, error) {
+	switch mode {
+	case APIKeysOptional, APIKeysRequired:
+	default:
+		return nil, fmt.Errorf("unknown API key mode %q (expected off, optional or required)", mode)
+	}
+	if defaultRate <= 0 {
+		return nil, fmt.Errorf("default rate limit must be positive")
+	}
+	if anonymousRate < 0 {
+		return nil, fmt.Errorf("anonymous rate limit must not be negative")
+	}
+	store := &APIKeyStore{
+		db:            db,
+		mode:          mode,
+		defaultRate:   defaultRate,
+		anonymousRate: anonymousRate,
+		proxyHeader:   proxyHeader,
+		keys:          make(map[string]*APIKey),
+		buckets:       make(map[string]*tokenBucket),
+		usage:         make(map[usageKey]*usageCounts),
+	}
+	if err := store.reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *APIKeyStore) reload() error {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT key_id, key_hash, name, rate_limit, burst, created_at
+		FROM api_keys FINAL
+		WHERE disabled = 0
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to load API keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := make(map[string]*APIKey)
+	for rows.Next() {
+		var key APIKey
+		var hash string
+		var burst uint32
+		if err := rows.Scan(&key.ID, &hash, &key.Name, &key.RateLimit, &burst, &key.CreatedAt); err != nil {
+			return fmt.Errorf("failed to scan API key: %w", err)
+		}
+		key.Burst = int(burst)
+		keys[hash] = &key
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read API keys: %w", err)
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+	return nil
+}
+
+// Len returns the number of active keys
+func (s *APIKeyStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.keys)
+}
+
+// Start reloads keys and flushes usage periodically until done is closed, flushing once more on the way out
+func (s *APIKeyStore) Start(done <-chan struct{}) {
+	go func() {
+		refresh := time.NewTicker(apiKeyRefreshInterval)
+		defer refresh.Stop()
+		flush := time.NewTicker(usageFlushInterval)
+		defer flush.Stop()
+		for {
+			select {
+			case <-refresh.C:
+				if err := s.reload(); err != nil {
+					log.Printf("Warning: %v", err)
+				}
+			case <-flush.C:
+				s.flushUsage()
+			case <-done:
+				s.flushUsage()
+				return
+			}
+		}
+	}()
+}
+
+// requestKey returns the key sent with a request, as a bearer token, an X-API-Key header or an
+// api_key query parameter (for EventSource clients, which cannot set headers)
+func requestKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("api_key")
+}
+
+// clientIP returns the IP anonymous requests are limited by
+func (s *APIKeyStore) clientIP(r *http.Request) string {
+	if s.proxyHeader != "" {
+		if forwarded := r.Header.Get(s.proxyHeader); forwarded != "" {
+			// The proxy appends the address it was connected from, which clients cannot forge
+			return strings.TrimSpace(forwarded[strings.LastIndex(forwarded, ",")+1:])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// admit authenticates a request and takes a token from its bucket. It returns the key ID ("" for
+// anonymous requests), or the status to refuse the request with.
+func (s *APIKeyStore) admit(r *http.Request) (keyID string, status int, retryAfter time.Duration) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var bucketID string
+	var rate float64
+	var burst int
+	if sent := requestKey(r); sent != "" {
+		key := s.keys[hashAPIKey(sent)]
+		if key == nil {
+			return "", http.StatusUnauthorized, 0
+		}
+		keyID, bucketID, rate, burst = key.ID, "key:"+key.ID, key.RateLimit, key.Burst
+		if rate <= 0 {
+			rate = s.defaultRate
+		}
+	} else {
+		if s.mode == APIKeysRequired {
+			return "", http.StatusUnauthorized, 0
+		}
+		if s.anonymousRate == 0 {
+			return "", 0, 0
+		}
+		bucketID, rate = "ip:"+s.clientIP(r), s.anonymousRate
+	}
+
+	bucket := s.buckets[bucketID]
+	if bucket == nil || bucket.rate != rate || (burst > 0 && bucket.burst != float64(burst)) {
+		// New client, or limits of the key changed since its bucket was created
+		bucket = newTokenBucket(rate, burst, now)
+		s.buckets[bucketID] = bucket
+	}
+	if ok, wait := bucket.take(now); !ok {
+		return keyID, http.StatusTooManyRequests, wait
+	}
+	return keyID, 0, 0
+}
+
+// record adds a request to the usage of its key and route for the current minute
+func (s *APIKeyStore) record(keyID, route string, update func(*usageCounts)) {
+	k := usageKey{keyID: keyID, route: route, window: time.Now().UTC().Truncate(time.Minute)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := s.usage[k]
+	if counts == nil {
+		counts = &usageCounts{}
+		s.usage[k] = counts
+	}
+	update(counts)
+}
+
+// flushUsage writes the accumulated usage to api_usage and drops the state of idle clients.
+// Usage is kept for the next flush if the insert fails.
+func (s *APIKeyStore) flushUsage() {
+	s.mu.Lock()
+	usage := s.usage
+	s.usage = make(map[usageKey]*usageCounts)
+	for id, bucket := range s.buckets {
+		if time.Since(bucket.last) > idleBucketTimeout {
+			delete(s.buckets, id)
+		}
+	}
+	s.mu.Unlock()
+	if len(usage) == 0 {
+		return
+	}
+
+	var values []string
+	var args []interface{}
+	for k, counts := range usage {
+		values = append(values, "(?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		args = append(args, k.keyID, k.route, k.window, counts.requests, counts.rejected, counts.errors,
+			counts.durationMs, counts.rowsRead, counts.bytesRead)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO api_usage (key_id, route, window_start, requests, rejected, errors, duration_ms, rows_read, bytes_read)
+		VALUES `+strings.Join(values, ", "), args...)
+	if err != nil {
+		log.Printf("Warning: Failed to record API usage: %v", err)
+		s.mu.Lock()
+		for k, counts := range usage {
+			if current := s.usage[k]; current != nil {
+				counts.requests += current.requests
+				counts.rejected += current.rejected
+				counts.errors += current.errors
+				counts.durationMs += current.durationMs
+				counts.rowsRead += current.rowsRead
+				counts.bytesRead += current.bytesRead
+			}
+			s.usage[k] = counts
+		}
+		s.mu.Unlock()
+	}
+}
+
+// statusRecorder captures the status of a response, passing flushes through for streams
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Wrap limits and accounts the requests of a route. The ClickHouse queries of admitted requests
+// carry log_comment api_key:<key_id> for system.query_log, and the rows and bytes they read are
+// added to the key's usage (reported over the native protocol only). A nil store passes requests
+// through.
+func (s *APIKeyStore) Wrap(route string, next http.HandlerFunc) http.HandlerFunc {
+	if s == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		keyID, status, retryAfter := s.admit(r)
+		switch status {
+		case http.StatusUnauthorized:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ctmon"`)
+			http.Error(w, "a valid API key is required", status)
+			return
+		case http.StatusTooManyRequests:
+			s.record(keyID, route, func(c *usageCounts) { c.rejected++ })
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(w, "rate limit exceeded", status)
+			return
+		}
+
+		var rowsRead, bytesRead atomic.Uint64
+		ctx := clickhouse.Context(r.Context(),
+			clickhouse.WithSettings(clickhouse.Settings{"log_comment": "api_key:" + keyID}),
+			clickhouse.WithProgress(func(p *clickhouse.Progress) {
+				rowsRead.Add(p.Rows)
+				bytesRead.Add(p.Bytes)
+			}))
+		recorder := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		next(recorder, r.WithContext(ctx))
+
+		elapsed := time.Since(start)
+		s.record(keyID, route, func(c *usageCounts) {
+			c.requests++
+			if recorder.status >= 500 {
+				c.errors++
+			}
+			c.durationMs += uint64(elapsed.Milliseconds())
+			c.rowsRead += rowsRead.Load()
+			c.bytesRead += bytesRead.Load()
+		})
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	bundleMediaType      = "application/vnd.dev.sigstore.bundle+json;version=0.1" // Inclusion promise only
+	bundleProofMediaType = "application/vnd.dev.sigstore.bundle+json;version=0.2" // With inclusion proof
+	shardRefreshInterval = time.Hour                                              // Age after which Rekor's shard list is fetched again
+	shardRetryInterval   = time.Minute                                            // Least time between fetches when a tree is unknown
+	shardRequestTimeout  = 10 * time.Second                                       // Timeout of the log info request
+	defaultRekorURL      = "https://rekor.sigstore.dev"
+)
+
+// errNotBundleable is returned for entries of other kinds than hashedrekord: dsse and intoto
+// entries do not store the signed payload, which their bundles would have to carry
+var errNotBundleable = errors.New("bundles can only be reconstructed for hashedrekord entries")
+
+// bundleDigestAlgorithms maps Rekor hash algorithms to the HashAlgorithm names of the bundle
+var bundleDigestAlgorithms = map[string]string{
+	"sha256": "SHA2_256",
+	"sha384": "SHA2_384",
+	"sha512": "SHA2_512",
+}
+
+// RekorShards maps tree IDs to the global index of their first entry, from the inactive shards
+// listed by Rekor's /api/v1/log. Signed entry timestamps cover the global index while the
+// mirror stores the index within the tree.
+type RekorShards struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	offsets map[string]int64
+	fetched time.Time
+}
+
+// NewRekorShards creates a shard list fetched from the Rekor instance at url when first needed
+func NewRekorShards(url string) *RekorShards {
+	return &RekorShards{url: strings.TrimSuffix(url, "/"), client: &http.Client{Timeout: shardRequestTimeout}}
+}
+
+// Offset returns the global index of the first entry of a tree, fetching the shard list if it is
+// older than shardRefreshInterval or does not know the tree yet
+func (s *RekorShards) Offset(ctx context.Context, treeID string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	offset, ok := s.offsets[treeID]
+	age := time.Since(s.fetched)
+	if ok && age < shardRefreshInterval {
+		return offset, nil
+	}
+	if !ok && age < shardRetryInterval {
+		return 0, fmt.Errorf("tree %s is not a shard of %s", treeID, s.url)
+	}
+
+	offsets, err := s.fetch(ctx)
+	if err != nil {
+		if ok {
+			log.Printf("Warning: Failed to refresh Rekor shards, using the previous list: %v", err)
+			return offset, nil
+		}
+		return 0, err
+	}
+	s.offsets, s.fetched = offsets, time.Now()
+	if offset, ok = offsets[treeID]; !ok {
+		return 0, fmt.Errorf("tree %s is not a shard of %s", treeID, s.url)
+	}
+	return offset, nil
+}
+
+// fetch reads the tree offsets from the log info. Inactive shards are listed oldest first and the
+// active tree follows them.
+func (s *RekorShards) fetch(ctx context.Context) (map[string]int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url+"/api/v1/log", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch Rekor log info: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch Rekor log info: status %d", resp.StatusCode)
+	}
+
+	var info struct {
+		TreeID         string `json:"treeID"`
+		InactiveShards []struct {
+			TreeID   string `json:"treeID"`
+			TreeSize int64  `json:"treeSize"`
+		} `json:"inactiveShards"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("failed to decode Rekor log info: %w", err)
+	}
+
+	offsets := make(map[string]int64)
+	var offset int64
+	for _, shard := range info.InactiveShards {
+		offsets[shard.TreeID] = offset
+		offset += shard.TreeSize
+	}
+	offsets[info.TreeID] = offset
+	return offsets, nil
+}
+
+// Bundle is a Sigstore bundle (v0.1 or v0.2) in its protobuf JSON form
+type Bundle struct {
+	MediaType            string               `json:"mediaType"`
+	VerificationMaterial verificationMaterial `json:"verificationMaterial"`
+	MessageSignature     messageSignature     `json:"messageSignature"`
+}
+
+type verificationMaterial struct {
+	X509CertificateChain *x509CertificateChain `json:"x509CertificateChain,omitempty"`
+	PublicKey            *publicKeyIdentifier  `json:"publicKey,omitempty"`
+	TlogEntries          []tlogEntry           `json:"tlogEntries"`
+}
+
+type x509CertificateChain struct {
+	Certificates []rawBytes `json:"certificates"`
+}
+
+type rawBytes struct {
+	RawBytes string `json:"rawBytes"`
+}
+
+type publicKeyIdentifier struct {
+	Hint string `json:"hint,omitempty"`
+}
+
+// tlogEntry is a TransparencyLogEntry; 64-bit integers are strings in protobuf JSON
+type tlogEntry struct {
+	LogIndex    string `json:"logIndex"`
+	LogID       logID  `json:"logId"`
+	KindVersion struct {
+		Kind    string `json:"kind"`
+		Version string `json:"version"`
+	} `json:"kindVersion"`
+	IntegratedTime   string `json:"integratedTime"`
+	InclusionPromise struct {
+		SignedEntryTimestamp string `json:"signedEntryTimestamp"`
+	} `json:"inclusionPromise"`
+	InclusionProof    *inclusionProof `json:"inclusionProof,omitempty"`
+	CanonicalizedBody string          `json:"canonicalizedBody"`
+}
+
+// inclusionProof is the InclusionProof of a bundle; hashes are base64 rather than hex
+type inclusionProof struct {
+	LogIndex   string   `json:"logIndex"` // Within the tree
+	RootHash   string   `json:"rootHash"`
+	TreeSize   string   `json:"treeSize"`
+	Hashes     []string `json:"hashes"`
+	Checkpoint struct {
+		Envelope string `json:"envelope"`
+	} `json:"checkpoint"`
+}
+
+type logID struct {
+	KeyID string `json:"keyId"`
+}
+
+type messageSignature struct {
+	MessageDigest struct {
+		Algorithm string `json:"algorithm"`
+		Digest    string `json:"digest"`
+	} `json:"messageDigest"`
+	Signature string `json:"signature"`
+}
+
+// hashedRekordBody is the part of a hashedrekord entry body a bundle is built from
+type hashedRekordBody struct {
+	Spec struct {
+		Signature struct {
+			Content   string `json:"content"`
+			PublicKey struct {
+				Content string `json:"content"` // Base64 encoded PEM certificate or public key
+			} `json:"publicKey"`
+		} `json:"signature"`
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+	} `json:"spec"`
+}
+
+// storedBundleEntry is the stored data of the entry a bundle is reconstructed from
+type storedBundleEntry struct {
+	TreeID               string
+	LogIndex             int64
+	Body                 []byte
+	IntegratedTime       int64
+	LogID                string
+	SignedEntryTimestamp string
+	Kind                 string
+	APIVersion           string
+	PublicKeyFingerprint string
+	ProofHashes          []string
+	ProofRootHash        string
+	ProofTreeSize        int64
+	ProofCheckpoint      string
+}
+
+// getBundleEntry returns the stored entry with the given UUID or entry ID, or nil
+func getBundleEntry(ctx context.Context, db *sql.DB, uuid string) (*storedBundleEntry, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT tree_id, log_index, body, blob_codec, toInt64(toUnixTimestamp(integrated_time)), log_id,
+			signed_entry_timestamp, kind, api_version, public_key_fingerprint,
+			inclusion_proof_hashes, inclusion_proof_root_hash, inclusion_proof_tree_size, inclusion_proof_checkpoint
+		FROM rekor_log_entries
+		WHERE entry_uuid IN (?, ?)
+		LIMIT 1
+		`+querySettings, strings.ToLower(uuid), leafUUID(uuid))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rekor entry: %w", err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	var e storedBundleEntry
+	var body, blobCodec string
+	if err := rows.Scan(&e.TreeID, &e.LogIndex, &body, &blobCodec, &e.IntegratedTime, &e.LogID,
+		&e.SignedEntryTimestamp, &e.Kind, &e.APIVersion, &e.PublicKeyFingerprint,
+		&e.ProofHashes, &e.ProofRootHash, &e.ProofTreeSize, &e.ProofCheckpoint); err != nil {
+		return nil, fmt.Errorf("failed to scan rekor entry: %w", err)
+	}
+	if e.Body, err = decodeBlob(body, blobCodec); err != nil {
+		return nil, fmt.Errorf("failed to decode body of rekor entry %s: %w", uuid, err)
+	}
+	return &e, nil
+}
+
+// buildBundle reconstructs the bundle of a hashedrekord entry in a tree whose first entry has the
+// global index offset. Entries stored with their inclusion proof get a v0.2 bundle carrying it,
+// others a v0.1 bundle with only the signed entry timestamp as inclusion promise.
+func buildBundle(e *storedBundleEntry, offset int64) (*Bundle, error) {
+	if e.Kind != "hashedrekord" {
+		return nil, errNotBundleable
+	}
+	if len(e.Body) == 0 {
+		return nil, errors.New("no body stored")
+	}
+	if e.SignedEntryTimestamp == "" {
+		return nil, errors.New("no signed entry timestamp stored")
+	}
+	var body hashedRekordBody
+	if err := json.Unmarshal(e.Body, &body); err != nil {
+		return nil, fmt.Errorf("failed to parse body: %w", err)
+	}
+
+	bundle := &Bundle{MediaType: bundleMediaType}
+
+	algorithm, ok := bundleDigestAlgorithms[body.Spec.Data.Hash.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash algorithm %q", body.Spec.Data.Hash.Algorithm)
+	}
+	digest, err := hex.DecodeString(body.Spec.Data.Hash.Value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data hash: %w", err)
+	}
+	bundle.MessageSignature.MessageDigest.Algorithm = algorithm
+	bundle.MessageSignature.MessageDigest.Digest = base64.StdEncoding.EncodeToString(digest)
+	bundle.MessageSignature.Signature = body.Spec.Signature.Content
+
+	keyPEM, err := base64.StdEncoding.DecodeString(body.Spec.Signature.PublicKey.Content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key content: %w", err)
+	}
+	var certificates []rawBytes
+	for block, rest := pem.Decode(keyPEM); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			certificates = append(certificates, rawBytes{RawBytes: base64.StdEncoding.EncodeToString(block.Bytes)})
+		}
+	}
+	if len(certificates) > 0 {
+		bundle.VerificationMaterial.X509CertificateChain = &x509CertificateChain{Certificates: certificates}
+	} else {
+		// Verifiers are given the key itself, the hint only tells them which one
+		bundle.VerificationMaterial.PublicKey = &publicKeyIdentifier{Hint: e.PublicKeyFingerprint}
+	}
+
+	keyID, err := hex.DecodeString(e.LogID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log ID: %w", err)
+	}
+	var entry tlogEntry
+	entry.LogIndex = strconv.FormatInt(offset+e.LogIndex, 10)
+	entry.LogID.KeyID = base64.StdEncoding.EncodeToString(keyID)
+	entry.KindVersion.Kind = e.Kind
+	entry.KindVersion.Version = e.APIVersion
+	entry.IntegratedTime = strconv.FormatInt(e.IntegratedTime, 10)
+	entry.InclusionPromise.SignedEntryTimestamp = e.SignedEntryTimestamp
+	entry.CanonicalizedBody = base64.StdEncoding.EncodeToString(e.Body)
+	if e.ProofTreeSize > 0 && e.ProofCheckpoint != "" {
+		if entry.InclusionProof, err = bundleInclusionProof(e); err != nil {
+			return nil, err
+		}
+		bundle.MediaType = bundleProofMediaType
+	}
+	bundle.VerificationMaterial.TlogEntries = []tlogEntry{entry}
+	return bundle, nil
+}
+
+// bundleInclusionProof converts the stored inclusion proof of an entry for its bundle
+func bundleInclusionProof(e *storedBundleEntry) (*inclusionProof, error) {
+	rootHash, err := hex.DecodeString(e.ProofRootHash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid inclusion proof root hash: %w", err)
+	}
+	proof := &inclusionProof{
+		LogIndex: strconv.FormatInt(e.LogIndex, 10),
+		RootHash: base64.StdEncoding.EncodeToString(rootHash),
+		TreeSize: strconv.FormatInt(e.ProofTreeSize, 10),
+		Hashes:   make([]string, len(e.ProofHashes)),
+	}
+	for i, hash := range e.ProofHashes {
+		raw, err := hex.DecodeString(hash)
+		if err != nil {
+			return nil, fmt.Errorf("invalid inclusion proof hash: %w", err)
+		}
+		proof.Hashes[i] = base64.StdEncoding.EncodeToString(raw)
+	}
+	proof.Checkpoint.Envelope = e.ProofCheckpoint
+	return proof, nil
+}
+
+// bundleHandler serves the Sigstore bundle of a stored hashedrekord entry, for verifying its
+// artifact offline against the mirror
+func bundleHandler(db *sql.DB, shards *RekorShards) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		uuid := r.PathValue("uuid")
+		if !entryUUIDPattern.MatchString(uuid) {
+			http.Error(w, "invalid entry UUID", http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+		defer cancel()
+
+		entry, err := getBundleEntry(ctx, db, uuid)
+		if err != nil {
+			log.Printf("Failed to retrieve rekor entry %s for bundle: %v", uuid, err)
+			http.Error(w, "failed to retrieve entry", http.StatusInternalServerError)
+			return
+		}
+		if entry == nil {
+			http.Error(w, "entry not found", http.StatusNotFound)
+			return
+		}
+		if entry.Kind != "hashedrekord" {
+			http.Error(w, errNotBundleable.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		offset, err := shards.Offset(ctx, entry.TreeID)
+		if err != nil {
+			log.Printf("Failed to find the global index of rekor entry %s: %v", uuid, err)
+			http.Error(w, "global log index unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		bundle, err := buildBundle(entry, offset)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("cannot reconstruct bundle: %v", err), http.StatusUnprocessableEntity)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(bundle)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"container/list"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const invalidatorBuffer = 50000 // Events buffered for cache invalidation
+
+// cacheEntry is a cached query result with the tags that invalidate it
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	tags    []string
+	expires time.Time
+}
+
+// cacheCall is a query being loaded, shared by the requests asking for it meanwhile
+type cacheCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+	tags  []string
+	stale bool // Invalidated while loading, so the result is returned but not cached
+}
+
+// QueryCache keeps the results of hot API queries for up to ttl, the least recently used beyond
+// maxEntries dropped. Concurrent requests for the same query share one ClickHouse query. Results
+// are tagged with what they depend on (certificate:<sha256>, domain:<name>, rekor:<uuid>,
+// identity:<stored identity>) and dropped as soon as an ingester publishes an event for one of
+// their tags; results without tags, or whose events were missed, only expire. A nil QueryCache
+// caches nothing.
+type QueryCache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu       sync.Mutex
+	entries  map[string]*list.Element // Of *cacheEntry
+	lru      *list.List               // Most recently used first
+	tags     map[string]map[string]struct{}
+	inflight map[string]*cacheCall
+}
+
+// NewQueryCache creates a cache of up to maxEntries results kept for ttl
+func NewQueryCache(ttl time.Duration, maxEntries int) *QueryCache {
+	return &QueryCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		tags:       make(map[string]map[string]struct{}),
+		inflight:   make(map[string]*cacheCall),
+	}
+}
+
+// cached returns the cached result of a query, or loads and caches it. Errors are not cached.
+func cached[T any](c *QueryCache, key string, tags []string, load func() (T, error)) (T, error) {
+	if c == nil {
+		return load()
+	}
+
+	c.mu.Lock()
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		if time.Now().Before(entry.expires) {
+			c.lru.MoveToFront(element)
+			c.mu.Unlock()
+			return entry.value.(T), nil
+		}
+		c.remove(element)
+	}
+	if call, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		<-call.done
+		if call.err != nil {
+			var zero T
+			return zero, call.err
+		}
+		return call.value.(T), nil
+	}
+	call := &cacheCall{done: make(chan struct{}), tags: tags}
+	c.inflight[key] = call
+	c.mu.Unlock()
+
+	value, err := load()
+	call.value, call.err = value, err
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	if err == nil && !call.stale {
+		c.add(&cacheEntry{key: key, value: value, tags: tags, expires: time.Now().Add(c.ttl)})
+	}
+	c.mu.Unlock()
+	close(call.done)
+	return value, err
+}
+
+// add stores an entry, evicting the least recently used ones beyond maxEntries. Called with mu held.
+func (c *QueryCache) add(entry *cacheEntry) {
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	for _, tag := range entry.tags {
+		if c.tags[tag] == nil {
+			c.tags[tag] = make(map[string]struct{})
+		}
+		c.tags[tag][entry.key] = struct{}{}
+	}
+	for c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops an entry. Called with mu held.
+func (c *QueryCache) remove(element *list.Element) {
+	entry := element.Value.(*cacheEntry)
+	c.lru.Remove(element)
+	delete(c.entries, entry.key)
+	for _, tag := range entry.tags {
+		delete(c.tags[tag], entry.key)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+}
+
+// Invalidate drops the results depending on any of the tags, and keeps queries loading for them
+// from being cached
+func (c *QueryCache) Invalidate(tags ...string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, tag := range tags {
+		for key := range c.tags[tag] {
+			c.remove(c.entries[key])
+		}
+	}
+	for _, call := range c.inflight {
+		for _, tag := range call.tags {
+			if slices.Contains(tags, tag) {
+				call.stale = true
+				break
+			}
+		}
+	}
+}
+
+// runInvalidation drops the results affected by each published event until done is closed
+func (c *QueryCache) runInvalidation(broker *Broker, done <-chan struct{}) {
+	sub := broker.subscribeBuffered(StreamFilter{}, invalidatorBuffer)
+	go func() {
+		defer broker.unsubscribe(sub)
+		for {
+			select {
+			case event := <-sub.events:
+				c.Invalidate(eventTags(event)...)
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// eventTags returns the tags of the results a newly ingested entry may change: for a certificate,
+// lookups of it and of each of its names and their parent domains, which include subdomains; for
+// a Rekor entry, lookups of it and of its identities
+func eventTags(event *StreamEvent) []string {
+	if event.Source == "rekor" {
+		tags := []string{"rekor:" + strings.ToLower(event.ID)}
+		for _, identity := range event.Identities {
+			tags = append(tags, "identity:"+identity)
+		}
+		return tags
+	}
+
+	tags := []string{"certificate:" + strings.ToLower(event.ID)}
+	seen := make(map[string]bool)
+	for _, domain := range event.Domains {
+		domain = strings.TrimPrefix(strings.ToLower(strings.TrimSuffix(domain, ".")), "*.")
+		for domain != "" && !seen[domain] {
+			seen[domain] = true
+			tags = append(tags, "domain:"+domain)
+			_, domain, _ = strings.Cut(domain, ".")
+		}
+	}
+	return tags
+}
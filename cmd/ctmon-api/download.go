@@ -0,0 +1,309 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+	cttls "github.com/google/certificate-transparency-go/tls"
+)
+
+const (
+	maxDownloadCertificates = 1000  // Most fingerprints in one download
+	maxDownloadRange        = 10000 // Most log entries in one index range download
+)
+
+// sha256Pattern matches a hex SHA-256 fingerprint
+var sha256Pattern = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// errNoRawCertificate is returned for entries stored without the blob holding their certificate
+var errNoRawCertificate = errors.New("no raw certificate stored")
+
+// downloadRequest selects the certificates of a download: either fingerprints or an index range of
+// one log. As a query string, sha256 may be repeated or comma separated.
+type downloadRequest struct {
+	SHA256 []string `json:"sha256"`
+	LogID  string   `json:"log_id"`
+	Start  *uint64  `json:"start"`
+	End    *uint64  `json:"end"` // Inclusive
+	Format string   `json:"format"`
+}
+
+// downloadedCertificate is a certificate read back from the stored raw columns
+type downloadedCertificate struct {
+	SHA256    string
+	LogID     string
+	LogIndex  uint64
+	EntryType string
+	DER       []byte
+	Err       error
+}
+
+// parseDownloadRequest reads the request from the query string (GET) or a JSON body (POST)
+func parseDownloadRequest(w http.ResponseWriter, r *http.Request) (*downloadRequest, error) {
+	var req downloadRequest
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&req); err != nil {
+			return nil, errors.New("invalid request body")
+		}
+	} else {
+		query := r.URL.Query()
+		for _, value := range query["sha256"] {
+			for _, sha256 := range strings.Split(value, ",") {
+				if sha256 = strings.TrimSpace(sha256); sha256 != "" {
+					req.SHA256 = append(req.SHA256, sha256)
+				}
+			}
+		}
+		req.LogID = query.Get("log_id")
+		req.Format = query.Get("format")
+		for name, target := range map[string]**uint64{"start": &req.Start, "end": &req.End} {
+			if value := query.Get(name); value != "" {
+				n, err := strconv.ParseUint(value, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid %s", name)
+				}
+				*target = &n
+			}
+		}
+	}
+
+	switch req.Format {
+	case "":
+		req.Format = "pem"
+	case "pem", "zip":
+	default:
+		return nil, errors.New("format must be pem or zip")
+	}
+
+	if len(req.SHA256) > 0 {
+		if req.LogID != "" || req.Start != nil || req.End != nil {
+			return nil, errors.New("sha256 cannot be combined with log_id, start and end")
+		}
+		if len(req.SHA256) > maxDownloadCertificates {
+			return nil, fmt.Errorf("at most %d fingerprints can be downloaded at once", maxDownloadCertificates)
+		}
+		for i, sha256 := range req.SHA256 {
+			if !sha256Pattern.MatchString(sha256) {
+				return nil, fmt.Errorf("invalid sha256 %q", sha256)
+			}
+			req.SHA256[i] = strings.ToLower(sha256)
+		}
+		return &req, nil
+	}
+
+	if req.LogID == "" || req.Start == nil || req.End == nil {
+		return nil, errors.New("either sha256 or log_id, start and end are required")
+	}
+	if *req.End < *req.Start {
+		return nil, errors.New("end must not be before start")
+	}
+	if *req.End-*req.Start >= maxDownloadRange {
+		return nil, fmt.Errorf("at most %d entries can be downloaded at once", maxDownloadRange)
+	}
+	return &req, nil
+}
+
+// decodeBlob returns the raw bytes of a blob column as stored with the given blob_codec
+func decodeBlob(blob, blobCodec string) ([]byte, error) {
+	switch blobCodec {
+	case "", "none":
+		return base64.StdEncoding.DecodeString(blob)
+	case "zstd":
+		return zstdDecoder.DecodeAll([]byte(blob), nil)
+	default:
+		return nil, fmt.Errorf("unknown blob codec %q", blobCodec)
+	}
+}
+
+// storedCertificateDER reconstructs the DER of an entry's certificate: for an x509_entry from
+// leaf_certificate_der or else the MerkleTreeLeaf in leaf_input, for a precert_entry the
+// precertificate in extra_data, as leaf_input only holds its TBSCertificate
+func storedCertificateDER(entryType, leafInput, extraData, leafCertificateDER, blobCodec string) ([]byte, error) {
+	switch entryType {
+	case "x509_entry":
+		if leafCertificateDER != "" {
+			return decodeBlob(leafCertificateDER, blobCodec)
+		}
+		if leafInput == "" {
+			return nil, errNoRawCertificate
+		}
+		raw, err := decodeBlob(leafInput, blobCodec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode leaf_input: %w", err)
+		}
+		var leaf ct.MerkleTreeLeaf
+		if _, err := cttls.Unmarshal(raw, &leaf); err != nil {
+			return nil, fmt.Errorf("failed to parse leaf_input: %w", err)
+		}
+		if leaf.TimestampedEntry == nil || leaf.TimestampedEntry.X509Entry == nil {
+			return nil, errors.New("leaf_input does not hold an X.509 entry")
+		}
+		return leaf.TimestampedEntry.X509Entry.Data, nil
+	case "precert_entry":
+		if extraData == "" {
+			return nil, errNoRawCertificate
+		}
+		raw, err := decodeBlob(extraData, blobCodec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode extra_data: %w", err)
+		}
+		var chain ct.PrecertChainEntry
+		if _, err := cttls.Unmarshal(raw, &chain); err != nil {
+			return nil, fmt.Errorf("failed to parse extra_data: %w", err)
+		}
+		return chain.PreCertificate.Data, nil
+	default:
+		return nil, fmt.Errorf("unknown entry type %q", entryType)
+	}
+}
+
+// queryDownload selects the stored entries of a download, one per fingerprint (preferring an
+// x509_entry with its raw columns) or every entry of the index range
+func queryDownload(ctx context.Context, db *sql.DB, req *downloadRequest) (*sql.Rows, error) {
+	const columns = `certificate_sha256, log_id, log_index, entry_type, leaf_input, extra_data, leaf_certificate_der, blob_codec`
+	if len(req.SHA256) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(req.SHA256)), ", ")
+		args := make([]interface{}, len(req.SHA256))
+		for i, sha256 := range req.SHA256 {
+			args[i] = sha256
+		}
+		return db.QueryContext(ctx, `
+			SELECT `+columns+`
+			FROM ct_log_entries
+			WHERE (log_id, log_index) IN (
+				SELECT log_id, log_index FROM ct_log_entries_by_sha256
+				WHERE certificate_sha256 IN (`+placeholders+`)
+			)
+			ORDER BY certificate_sha256, entry_type = 'x509_entry' DESC, leaf_input != '' DESC
+			LIMIT 1 BY certificate_sha256
+			`+querySettings, args...)
+	}
+	return db.QueryContext(ctx, `
+		SELECT `+columns+`
+		FROM ct_log_entries
+		WHERE log_id = ? AND log_index BETWEEN ? AND ?
+		ORDER BY log_index
+		LIMIT 1 BY log_index
+		`+querySettings, req.LogID, *req.Start, *req.End)
+}
+
+// certificateDownloadHandler serves stored certificates as concatenated PEM or a zip of DER files
+// (<sha256>.der, <sha256>.precert.der for precertificates, named by their TBS hash like in
+// ct_log_entries), streamed as they are read. Certificates that were not found or were stored
+// without raw columns are listed at the end, as comment lines after the PEM blocks or in
+// missing.txt.
+func certificateDownloadHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := parseDownloadRequest(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+		defer cancel()
+
+		rows, err := queryDownload(ctx, db, req)
+		if err != nil {
+			log.Printf("Failed to query certificates for download: %v", err)
+			http.Error(w, "failed to query certificates", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		var out *downloadWriter
+		if req.Format == "zip" {
+			w.Header().Set("Content-Type", "application/zip")
+			w.Header().Set("Content-Disposition", `attachment; filename="certificates.zip"`)
+			out = &downloadWriter{zip: zip.NewWriter(w)}
+		} else {
+			w.Header().Set("Content-Type", "application/x-pem-file")
+			out = &downloadWriter{w: w}
+		}
+
+		found := make(map[string]bool)
+		for rows.Next() {
+			var c downloadedCertificate
+			var leafInput, extraData, leafCertificateDER, blobCodec string
+			if err := rows.Scan(&c.SHA256, &c.LogID, &c.LogIndex, &c.EntryType, &leafInput, &extraData, &leafCertificateDER, &blobCodec); err != nil {
+				log.Printf("Failed to scan certificate for download: %v", err)
+				out.missing = append(out.missing, fmt.Sprintf("download incomplete: %v", err))
+				break
+			}
+			found[c.SHA256] = true
+			c.DER, c.Err = storedCertificateDER(c.EntryType, leafInput, extraData, leafCertificateDER, blobCodec)
+			if err := out.write(&c); err != nil {
+				// The client went away
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("Failed to read certificates for download: %v", err)
+			out.missing = append(out.missing, fmt.Sprintf("download incomplete: %v", err))
+		}
+		for _, sha256 := range req.SHA256 {
+			if !found[sha256] {
+				out.missing = append(out.missing, fmt.Sprintf("%s: not found", sha256))
+			}
+		}
+		out.close()
+	}
+}
+
+// downloadWriter writes downloaded certificates as PEM or into a zip archive
+type downloadWriter struct {
+	w       io.Writer
+	zip     *zip.Writer
+	missing []string
+}
+
+func (d *downloadWriter) write(c *downloadedCertificate) error {
+	if c.Err != nil {
+		d.missing = append(d.missing, fmt.Sprintf("%s (%s/%d): %v", c.SHA256, c.LogID, c.LogIndex, c.Err))
+		return nil
+	}
+	if d.zip == nil {
+		return pem.Encode(d.w, &pem.Block{Type: "CERTIFICATE", Bytes: c.DER})
+	}
+
+	name := c.SHA256 + ".der"
+	if c.EntryType == "precert_entry" {
+		name = c.SHA256 + ".precert.der"
+	}
+	f, err := d.zip.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(c.DER)
+	return err
+}
+
+// close lists the missing certificates and finishes the archive
+func (d *downloadWriter) close() {
+	if d.zip == nil {
+		for _, line := range d.missing {
+			fmt.Fprintf(d.w, "# missing %s\n", line)
+		}
+		return
+	}
+	if len(d.missing) > 0 {
+		if f, err := d.zip.Create("missing.txt"); err == nil {
+			io.WriteString(f, strings.Join(d.missing, "\n")+"\n")
+		}
+	}
+	d.zip.Close()
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+)
+
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+
+	// Keep ad-hoc API queries from monopolizing the cluster
+	querySettings = "SETTINGS max_execution_time = 10, max_threads = 1, max_memory_usage = 134217728"
+)
+
+// Certificate is a CT certificate, merged across the logs it appears in
+type Certificate struct {
+	SHA256                  string    `json:"sha256"`
+	EntryType               string    `json:"entryType"`
+	NotBefore               time.Time `json:"notBefore"`
+	NotAfter                time.Time `json:"notAfter"`
+	SubjectCommonName       string    `json:"subjectCommonName"`
+	SubjectAlternativeNames []string  `json:"subjectAlternativeNames"`
+	IssuerCommonName        string    `json:"issuerCommonName"`
+	IssuerOrganization      []string  `json:"issuerOrganization"`
+	SerialNumber            string    `json:"serialNumber"`
+	IsCA                    bool      `json:"isCa"`
+	Cursor                  string    `json:"cursor,omitempty"` // Position in the list it was returned in
+}
+
+// LogEntry is one appearance of a certificate in a CT log
+type LogEntry struct {
+	LogID          string    `json:"logId"`
+	LogIndex       int64     `json:"logIndex"`
+	EntryType      string    `json:"entryType"`
+	EntryTimestamp time.Time `json:"entryTimestamp"`
+}
+
+// RekorEntry is a Rekor transparency log entry
+type RekorEntry struct {
+	UUID                  string    `json:"uuid"`
+	TreeID                string    `json:"treeId"`
+	LogIndex              int64     `json:"logIndex"`
+	IntegratedTime        time.Time `json:"integratedTime"`
+	Kind                  string    `json:"kind"`
+	SignatureFormat       string    `json:"signatureFormat"`
+	DataHashAlgorithm     string    `json:"dataHashAlgorithm"`
+	DataHashValue         string    `json:"dataHashValue"`
+	X509CertificateSHA256 string    `json:"x509CertificateSha256"`
+	X509SubjectCN         string    `json:"x509SubjectCn"`
+	X509IssuerCN          string    `json:"x509IssuerCn"`
+	X509SANs              []string  `json:"x509Sans"`
+	PGPSignerEmail        string    `json:"pgpSignerEmail"`
+	Cursor                string    `json:"cursor,omitempty"` // Position in the list it was returned in
+}
+
+const certificateColumns = `
+	certificate_sha256, entry_type, not_before, not_after, subject_common_name, subject_alternative_names,
+	issuer_common_name, issuer_organization, serial_number, is_ca`
+
+const rekorEntryColumns = `
+	entry_uuid, tree_id, log_index, integrated_time, kind, signature_format, data_hash_algorithm, data_hash_value,
+	x509_certificate_sha256, x509_subject_cn, x509_issuer_cn, x509_sans, pgp_signer_email`
+
+func scanCertificates(rows *sql.Rows) ([]*Certificate, error) {
+	defer rows.Close()
+	var certs []*Certificate
+	for rows.Next() {
+		var c Certificate
+		var isCA uint8
+		if err := rows.Scan(&c.SHA256, &c.EntryType, &c.NotBefore, &c.NotAfter, &c.SubjectCommonName,
+			&c.SubjectAlternativeNames, &c.IssuerCommonName, &c.IssuerOrganization, &c.SerialNumber, &isCA); err != nil {
+			return nil, fmt.Errorf("failed to scan certificate: %w", err)
+		}
+		c.IsCA = isCA == 1
+		certs = append(certs, &c)
+	}
+	return certs, rows.Err()
+}
+
+func scanRekorEntries(rows *sql.Rows) ([]*RekorEntry, error) {
+	defer rows.Close()
+	var entries []*RekorEntry
+	for rows.Next() {
+		var e RekorEntry
+		if err := rows.Scan(&e.UUID, &e.TreeID, &e.LogIndex, &e.IntegratedTime, &e.Kind, &e.SignatureFormat,
+			&e.DataHashAlgorithm, &e.DataHashValue, &e.X509CertificateSHA256, &e.X509SubjectCN, &e.X509IssuerCN,
+			&e.X509SANs, &e.PGPSignerEmail); err != nil {
+			return nil, fmt.Errorf("failed to scan rekor entry: %w", err)
+		}
+		entries = append(entries, &e)
+	}
+	return entries, rows.Err()
+}
+
+// withRekorCursors sets the cursor of each entry of a page
+func withRekorCursors(entries []*RekorEntry, err error) ([]*RekorEntry, error) {
+	for _, e := range entries {
+		e.Cursor = encodeCursor(e.IntegratedTime, e.TreeID, uint64(e.LogIndex))
+	}
+	return entries, err
+}
+
+// getCertificate returns a certificate by SHA-256, preferring the x509_entry over precert entries
+func getCertificate(ctx context.Context, db *sql.DB, sha256 string) (*Certificate, error) {
+	if len(sha256) != 64 {
+		return nil, nil
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+certificateColumns+`
+		FROM ct_log_entries
+		WHERE (log_id, log_index) IN (
+			SELECT log_id, log_index FROM ct_log_entries_by_sha256
+			WHERE certificate_sha256 = ?
+		)
+		ORDER BY entry_type = 'x509_entry' DESC
+		LIMIT 1
+		`+querySettings, strings.ToLower(sha256))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query certificate: %w", err)
+	}
+	certs, err := scanCertificates(rows)
+	if err != nil || len(certs) == 0 {
+		return nil, err
+	}
+	return certs[0], nil
+}
+
+// cachedCertificate returns a certificate by SHA-256 from the cache, loading it on a miss
+func cachedCertificate(ctx context.Context, db *sql.DB, cache *QueryCache, sha256 string) (*Certificate, error) {
+	sha256 = strings.ToLower(sha256)
+	return cached(cache, "certificate:"+sha256, []string{"certificate:" + sha256}, func() (*Certificate, error) {
+		return getCertificate(ctx, db, sha256)
+	})
+}
+
+// getCertificatesByDomain returns the most recently logged certificates for a domain and its subdomains,
+// continuing after the cursor if one is given. The page covers limit log entries; a certificate in
+// several of them is returned once, with the cursor of the last entry before the next certificate,
+// so the page is shorter and only an empty page marks the end.
+func getCertificatesByDomain(ctx context.Context, db *sql.DB, domain string, limit int, after *pageCursor) ([]*Certificate, error) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	condition, conditionArgs := keysetCondition(after, "entry_timestamp", "log_id", "log_index")
+	order := keysetOrder("entry_timestamp", "log_id", "log_index")
+	args := append([]interface{}{domain, "%." + domain}, conditionArgs...)
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+certificateColumns+`, entry_timestamp, log_id, log_index
+		FROM ct_log_entries
+		WHERE (log_id, log_index) IN (
+			SELECT log_id, log_index FROM ct_log_entries_by_name
+			WHERE (name_rev = reverse(?) OR name_rev LIKE reverse(?)) `+condition+`
+			ORDER BY `+order+`
+			LIMIT ?
+		)
+		ORDER BY `+order+`
+		`+querySettings, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query certificates for %s: %w", domain, err)
+	}
+	defer rows.Close()
+
+	var certs []*Certificate
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var c Certificate
+		var isCA uint8
+		var entryTimestamp time.Time
+		var logID string
+		var logIndex uint64
+		if err := rows.Scan(&c.SHA256, &c.EntryType, &c.NotBefore, &c.NotAfter, &c.SubjectCommonName,
+			&c.SubjectAlternativeNames, &c.IssuerCommonName, &c.IssuerOrganization, &c.SerialNumber, &isCA,
+			&entryTimestamp, &logID, &logIndex); err != nil {
+			return nil, fmt.Errorf("failed to scan certificate: %w", err)
+		}
+		cursor := encodeCursor(entryTimestamp, logID, logIndex)
+		if seen[c.SHA256] {
+			certs[len(certs)-1].Cursor = cursor
+			continue
+		}
+		seen[c.SHA256] = true
+		c.IsCA = isCA == 1
+		c.Cursor = cursor
+		certs = append(certs, &c)
+	}
+	return certs, rows.Err()
+}
+
+// getLogEntries returns every CT log entry of a certificate
+func getLogEntries(ctx context.Context, db *sql.DB, sha256 string) ([]*LogEntry, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT log_id, log_index, entry_type, entry_timestamp
+		FROM ct_log_entries
+		WHERE (log_id, log_index) IN (
+			SELECT log_id, log_index FROM ct_log_entries_by_sha256
+			WHERE certificate_sha256 = ?
+		)
+		ORDER BY entry_timestamp
+		`+querySettings, sha256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query log entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*LogEntry
+	for rows.Next() {
+		var e LogEntry
+		if err := rows.Scan(&e.LogID, &e.LogIndex, &e.EntryType, &e.EntryTimestamp); err != nil {
+			return nil, fmt.Errorf("failed to scan log entry: %w", err)
+		}
+		entries = append(entries, &e)
+	}
+	return entries, rows.Err()
+}
+
+// getRekorEntry returns a Rekor entry by UUID
+func getRekorEntry(ctx context.Context, db *sql.DB, uuid string) (*RekorEntry, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+rekorEntryColumns+`
+		FROM rekor_log_entries
+		WHERE entry_uuid = ?
+		LIMIT 1
+		`+querySettings, strings.ToLower(uuid))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rekor entry: %w", err)
+	}
+	entries, err := scanRekorEntries(rows)
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+	return entries[0], nil
+}
+
+// getRekorEntriesByCertificate returns Rekor entries signed with the given certificate, continuing
+// after the cursor if one is given
+func getRekorEntriesByCertificate(ctx context.Context, db *sql.DB, sha256 string, limit int, after *pageCursor) ([]*RekorEntry, error) {
+	condition, conditionArgs := keysetCondition(after, "integrated_time", "tree_id", "log_index")
+	args := append([]interface{}{strings.ToLower(sha256)}, conditionArgs...)
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+rekorEntryColumns+`
+		FROM rekor_log_entries
+		WHERE x509_certificate_sha256 = ? `+condition+`
+		ORDER BY `+keysetOrder("integrated_time", "tree_id", "log_index")+`
+		LIMIT ?
+		`+querySettings, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rekor entries by certificate: %w", err)
+	}
+	return withRekorCursors(scanRekorEntries(rows))
+}
+
+// getRekorEntriesByIdentity returns Rekor entries whose signer identity (certificate SAN or PGP email) matches,
+// continuing after the cursor if one is given
+func getRekorEntriesByIdentity(ctx context.Context, db *sql.DB, identity string, limit int, after *pageCursor) ([]*RekorEntry, error) {
+	condition, conditionArgs := keysetCondition(after, "integrated_time", "tree_id", "log_index")
+	args := append([]interface{}{queryIdentity(identity), queryIdentity(identity)}, conditionArgs...)
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+rekorEntryColumns+`
+		FROM rekor_log_entries
+		WHERE (has(x509_sans, ?) OR pgp_signer_email = ?) `+condition+`
+		ORDER BY `+keysetOrder("integrated_time", "tree_id", "log_index")+`
+		LIMIT ?
+		`+querySettings, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rekor entries by identity: %w", err)
+	}
+	return withRekorCursors(scanRekorEntries(rows))
+}
+
+// nullable converts a nil result pointer into an untyped nil so GraphQL renders it as null
+func nullable[T any](value *T, err error) (interface{}, error) {
+	if value == nil {
+		return nil, err
+	}
+	return value, err
+}
+
+// listLimit reads the optional limit argument, clamped to maxListLimit
+func listLimit(args map[string]interface{}) int {
+	limit, ok := args["limit"].(int)
+	if !ok || limit <= 0 {
+		return defaultListLimit
+	}
+	if limit > maxListLimit {
+		return maxListLimit
+	}
+	return limit
+}
+
+// listAfter reads the optional after argument, the cursor of the last result of the previous page
+func listAfter(args map[string]interface{}) (string, *pageCursor, error) {
+	after, _ := args["after"].(string)
+	cursor, err := decodeCursor(after)
+	return after, cursor, err
+}
+
+// newGraphQLSchema builds the schema joining CT certificates and the Rekor entries that use them.
+// Query results are kept in cache, which may be nil.
+func newGraphQLSchema(db *sql.DB, cache *QueryCache) (graphql.Schema, error) {
+	limitArg := &graphql.ArgumentConfig{Type: graphql.Int, Description: fmt.Sprintf("Maximum results (default %d, max %d)", defaultListLimit, maxListLimit)}
+	afterArg := &graphql.ArgumentConfig{Type: graphql.String, Description: "Cursor of the last result of the previous page; an empty page marks the end"}
+	cursorField := &graphql.Field{Type: graphql.String, Description: "Position of this result in a paginated list, for the after argument"}
+
+	logEntryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "LogEntry",
+		Fields: graphql.Fields{
+			"logId":          &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"logIndex":       &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+			"entryType":      &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"entryTimestamp": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+		},
+	})
+
+	// Certificate and RekorEntry reference each other, so their fields are declared lazily
+	var certificateType, rekorEntryType *graphql.Object
+
+	certificateType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Certificate",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"sha256":                  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"entryType":               &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"notBefore":               &graphql.Field{Type: graphql.DateTime},
+				"notAfter":                &graphql.Field{Type: graphql.DateTime},
+				"subjectCommonName":       &graphql.Field{Type: graphql.String},
+				"subjectAlternativeNames": &graphql.Field{Type: graphql.NewList(graphql.String)},
+				"issuerCommonName":        &graphql.Field{Type: graphql.String},
+				"issuerOrganization":      &graphql.Field{Type: graphql.NewList(graphql.String)},
+				"serialNumber":            &graphql.Field{Type: graphql.String},
+				"isCa":                    &graphql.Field{Type: graphql.Boolean},
+				"cursor":                  cursorField,
+				"logEntries": &graphql.Field{
+					Type:        graphql.NewList(logEntryType),
+					Description: "Every CT log entry of this certificate",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						sha256 := strings.ToLower(p.Source.(*Certificate).SHA256)
+						return cached(cache, "logEntries:"+sha256, []string{"certificate:" + sha256}, func() ([]*LogEntry, error) {
+							return getLogEntries(p.Context, db, sha256)
+						})
+					},
+				},
+				"rekorEntries": &graphql.Field{
+					Type:        graphql.NewList(rekorEntryType),
+					Description: "Rekor entries signed with this certificate",
+					Args:        graphql.FieldConfigArgument{"limit": limitArg, "after": afterArg},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						sha256, limit := strings.ToLower(p.Source.(*Certificate).SHA256), listLimit(p.Args)
+						after, cursor, err := listAfter(p.Args)
+						if err != nil {
+							return nil, err
+						}
+						// Rekor events do not name their certificate, so these results only expire
+						return cached(cache, fmt.Sprintf("rekorEntriesByCertificate:%s:%d:%s", sha256, limit, after), nil, func() ([]*RekorEntry, error) {
+							return getRekorEntriesByCertificate(p.Context, db, sha256, limit, cursor)
+						})
+					},
+				},
+			}
+		}),
+	})
+
+	rekorEntryType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "RekorEntry",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"uuid":                  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"treeId":                &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"logIndex":              &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+				"integratedTime":        &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+				"kind":                  &graphql.Field{Type: graphql.String},
+				"signatureFormat":       &graphql.Field{Type: graphql.String},
+				"dataHashAlgorithm":     &graphql.Field{Type: graphql.String},
+				"dataHashValue":         &graphql.Field{Type: graphql.String},
+				"x509CertificateSha256": &graphql.Field{Type: graphql.String},
+				"x509SubjectCn":         &graphql.Field{Type: graphql.String},
+				"x509IssuerCn":          &graphql.Field{Type: graphql.String},
+				"x509Sans":              &graphql.Field{Type: graphql.NewList(graphql.String)},
+				"pgpSignerEmail":        &graphql.Field{Type: graphql.String},
+				"cursor":                cursorField,
+				"certificate": &graphql.Field{
+					Type:        certificateType,
+					Description: "The signing certificate, if it was also logged to a CT log",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return nullable(cachedCertificate(p.Context, db, cache, p.Source.(*RekorEntry).X509CertificateSHA256))
+					},
+				},
+			}
+		}),
+	})
+
+	queryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"certificate": &graphql.Field{
+				Type: certificateType,
+				Args: graphql.FieldConfigArgument{
+					"sha256": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return nullable(cachedCertificate(p.Context, db, cache, p.Args["sha256"].(string)))
+				},
+			},
+			"certificatesByDomain": &graphql.Field{
+				Type: graphql.NewList(certificateType),
+				Args: graphql.FieldConfigArgument{
+					"domain": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"limit":  limitArg,
+					"after":  afterArg,
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					domain, limit := strings.ToLower(strings.TrimSuffix(p.Args["domain"].(string), ".")), listLimit(p.Args)
+					after, cursor, err := listAfter(p.Args)
+					if err != nil {
+						return nil, err
+					}
+					return cached(cache, fmt.Sprintf("certificatesByDomain:%s:%d:%s", domain, limit, after), []string{"domain:" + domain}, func() ([]*Certificate, error) {
+						return getCertificatesByDomain(p.Context, db, domain, limit, cursor)
+					})
+				},
+			},
+			"rekorEntry": &graphql.Field{
+				Type: rekorEntryType,
+				Args: graphql.FieldConfigArgument{
+					"uuid": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					uuid := strings.ToLower(p.Args["uuid"].(string))
+					return nullable(cached(cache, "rekorEntry:"+uuid, []string{"rekor:" + uuid}, func() (*RekorEntry, error) {
+						return getRekorEntry(p.Context, db, uuid)
+					}))
+				},
+			},
+			"rekorEntriesByIdentity": &graphql.Field{
+				Type:        graphql.NewList(rekorEntryType),
+				Description: "Rekor entries whose certificate SAN or PGP signer email equals the identity",
+				Args: graphql.FieldConfigArgument{
+					"identity": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"limit":    limitArg,
+					"after":    afterArg,
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					identity, limit := p.Args["identity"].(string), listLimit(p.Args)
+					after, cursor, err := listAfter(p.Args)
+					if err != nil {
+						return nil, err
+					}
+					return cached(cache, fmt.Sprintf("rekorEntriesByIdentity:%s:%d:%s", identity, limit, after), []string{"identity:" + queryIdentity(identity)}, func() ([]*RekorEntry, error) {
+						return getRekorEntriesByIdentity(p.Context, db, identity, limit, cursor)
+					})
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: queryType})
+}
+
+// graphQLRequest is the standard GraphQL-over-HTTP request body
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// graphQLHandler serves GraphQL queries via POST (JSON body) or GET (?query=)
+func graphQLHandler(schema graphql.Schema) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req graphQLRequest
+		switch r.Method {
+		case http.MethodGet:
+			req.Query = r.URL.Query().Get("query")
+			req.OperationName = r.URL.Query().Get("operationName")
+			if vars := r.URL.Query().Get("variables"); vars != "" {
+				if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+					http.Error(w, "invalid variables", http.StatusBadRequest)
+					return
+				}
+			}
+		case http.MethodPost:
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&req); err != nil {
+				http.Error(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if req.Query == "" {
+			http.Error(w, "query is required", http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+		defer cancel()
+
+		result := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  req.Query,
+			VariableValues: req.Variables,
+			OperationName:  req.OperationName,
+			Context:        ctx,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
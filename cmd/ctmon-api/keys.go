@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+const keysUsage = `Usage: ctmon-api keys <command> [flags]
+
+Commands:
+  create -name NAME [-rate_limit N] [-burst N]  create a key and print it (it is not stored)
+  revoke -id KEY_ID                             disable a key
+  list [-days N]                                list keys with their usage over the last N days`
+
+// runKeys manages the API keys of -api_keys. Running API instances pick changes up within
+// apiKeyRefreshInterval.
+func runKeys(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, keysUsage)
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("keys "+args[0], flag.ExitOnError)
+	nameFlag := fs.String("name", "", "Owner or purpose of the key")
+	rateLimitFlag := fs.Float64("rate_limit", 0, "Requests per second allowed to the key (0 for the -default_rate_limit of the API)")
+	burstFlag := fs.Int("burst", 0, "Requests the key may make at once (0 for the rate limit rounded up)")
+	idFlag := fs.String("id", "", "ID of the key")
+	daysFlag := fs.Int("days", 30, "Days of usage to sum")
+	fs.Parse(args[1:])
+
+	db, err := initClickHouse()
+	if err != nil {
+		log.Fatalf("Failed to initialize ClickHouse connection: %v", err)
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "create":
+		if *nameFlag == "" {
+			log.Fatal("Error: -name is required")
+		}
+		if *rateLimitFlag < 0 || *burstFlag < 0 {
+			log.Fatal("Error: -rate_limit and -burst must not be negative")
+		}
+		id, err := randomHex(8)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		secret, err := randomHex(24)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		key := apiKeyPrefix + id + "_" + secret
+		apiKey := &APIKey{ID: id, Name: *nameFlag, RateLimit: *rateLimitFlag, Burst: *burstFlag, CreatedAt: time.Now().UTC()}
+		if err := saveAPIKey(db, apiKey, hashAPIKey(key)); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		fmt.Printf("Created key %s for %s:\n%s\n", id, *nameFlag, key)
+
+	case "revoke":
+		if *idFlag == "" {
+			log.Fatal("Error: -id is required")
+		}
+		if err := revokeAPIKey(db, *idFlag); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		fmt.Printf("Revoked key %s\n", *idFlag)
+
+	case "list":
+		if *daysFlag <= 0 {
+			log.Fatal("Error: -days must be positive")
+		}
+		if err := listAPIKeys(db, *daysFlag); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+
+	default:
+		fmt.Fprintln(os.Stderr, keysUsage)
+		os.Exit(2)
+	}
+}
+
+// saveAPIKey writes a new version of a key
+func saveAPIKey(db *sql.DB, key *APIKey, hash string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO api_keys (key_id, key_hash, name, rate_limit, burst, disabled, created_at, version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		key.ID, hash, key.Name, key.RateLimit, uint32(key.Burst), boolToUint8(key.Disabled), key.CreatedAt, uint64(time.Now().UnixNano()),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save API key %s: %w", key.ID, err)
+	}
+	return nil
+}
+
+// revokeAPIKey writes a disabled version of a key
+func revokeAPIKey(db *sql.DB, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	var key APIKey
+	var hash string
+	var burst uint32
+	err := db.QueryRowContext(ctx, `
+		SELECT key_id, key_hash, name, rate_limit, burst, created_at
+		FROM api_keys FINAL
+		WHERE key_id = ?`, id).Scan(&key.ID, &hash, &key.Name, &key.RateLimit, &burst, &key.CreatedAt)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("no API key %s", id)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query API key %s: %w", id, err)
+	}
+	key.Burst = int(burst)
+	key.Disabled = true
+	return saveAPIKey(db, &key, hash)
+}
+
+// listAPIKeys prints all keys with the requests they made and their cost over the last days
+func listAPIKeys(db *sql.DB, days int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT k.key_id, k.name, k.rate_limit, k.burst, k.disabled, k.created_at,
+			u.requests, u.rejected, u.errors, u.duration_ms, u.rows_read
+		FROM (SELECT * FROM api_keys FINAL) AS k
+		LEFT JOIN (
+			SELECT key_id, sum(requests) AS requests, sum(rejected) AS rejected, sum(errors) AS errors,
+				sum(duration_ms) AS duration_ms, sum(rows_read) AS rows_read
+			FROM api_usage
+			WHERE window_start >= now() - toIntervalDay(?)
+			GROUP BY key_id
+		) AS u ON u.key_id = k.key_id
+		ORDER BY k.created_at`, days)
+	if err != nil {
+		return fmt.Errorf("failed to query API keys: %w", err)
+	}
+	defer rows.Close()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "KEY ID\tNAME\tRATE (/s)\tBURST\tSTATUS\tCREATED\tREQUESTS (%dd)\tREJECTED\tERRORS\tTIME\tROWS READ\n", days)
+	for rows.Next() {
+		var key APIKey
+		var burst uint32
+		var disabled uint8
+		var requests, rejected, errors, durationMs, rowsRead uint64
+		if err := rows.Scan(&key.ID, &key.Name, &key.RateLimit, &burst, &disabled, &key.CreatedAt,
+			&requests, &rejected, &errors, &durationMs, &rowsRead); err != nil {
+			return fmt.Errorf("failed to scan API key: %w", err)
+		}
+		status := "active"
+		if disabled == 1 {
+			status = "revoked"
+		}
+		rate := "default"
+		if key.RateLimit > 0 {
+			rate = fmt.Sprintf("%g", key.RateLimit)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%d\t%d\t%d\t%s\t%d\n", key.ID, key.Name, rate, burst, status,
+			key.CreatedAt.Format(time.DateOnly), requests, rejected, errors, time.Duration(durationMs)*time.Millisecond, rowsRead)
+	}
+	w.Flush()
+	return rows.Err()
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	notifyInterval       = 1 * time.Minute // Interval to check subscriptions for pending notifications
+	maxNotificationItems = 100             // Matches listed in one email; the rest are only counted
+)
+
+// Notifier delivers matched entries to subscribers by email or Slack/Discord webhook. SMTP is
+// configured with SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD and SMTP_FROM; without
+// SMTP_HOST only webhook subscriptions are accepted.
+type Notifier struct {
+	db             *sql.DB
+	store          *SubscriptionStore
+	publicURL      string
+	digestInterval time.Duration
+	webhooks       *http.Client
+
+	addr string // Empty when email is not configured
+	auth smtp.Auth
+	from string
+}
+
+// NewNotifier creates a notifier from the SMTP environment variables
+func NewNotifier(db *sql.DB, store *SubscriptionStore, publicURL string, digestInterval time.Duration) (*Notifier, error) {
+	n := &Notifier{
+		db:             db,
+		store:          store,
+		publicURL:      strings.TrimSuffix(publicURL, "/"),
+		digestInterval: digestInterval,
+		webhooks:       &http.Client{Timeout: 10 * time.Second},
+	}
+
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return n, nil
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	n.from = os.Getenv("SMTP_FROM")
+	if n.from == "" {
+		return nil, fmt.Errorf("SMTP_FROM is not set")
+	}
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		n.auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	n.addr = net.JoinHostPort(host, port)
+	return n, nil
+}
+
+// emailEnabled reports whether SMTP is configured
+func (n *Notifier) emailEnabled() bool {
+	return n.addr != ""
+}
+
+// Start sends due notifications until done is closed
+func (n *Notifier) Start(done <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(notifyInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				n.notifyAll()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+func (n *Notifier) notifyAll() {
+	// Matches are buffered before insertion, so stop short of now to avoid skipping rows that
+	// are still in flight
+	cutoff := time.Now().UTC().Add(-2 * matchFlushInterval)
+
+	for _, sub := range n.store.confirmed() {
+		if sub.Mode == SubscriptionModeDigest && sub.LastNotifiedAt.Add(n.digestInterval).After(cutoff) {
+			continue
+		}
+		if err := n.notify(sub, cutoff); err != nil {
+			log.Printf("Warning: Failed to notify subscription %s: %v", sub.ID, err)
+		}
+	}
+}
+
+// notificationItem is a matched entry listed in a notification
+type notificationItem struct {
+	source    string
+	entryID   string
+	summary   string
+	event     StreamEvent
+	matchedAt time.Time
+}
+
+// notify delivers the matches recorded since the subscription was last notified, up to cutoff
+func (n *Notifier) notify(sub *Subscription, cutoff time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	var total uint64
+	err := n.db.QueryRowContext(ctx, `
+		SELECT count()
+		FROM subscription_matches
+		WHERE subscription_id = ? AND matched_at > ? AND matched_at <= ?`,
+		sub.ID, sub.LastNotifiedAt, cutoff,
+	).Scan(&total)
+	if err != nil {
+		return fmt.Errorf("failed to count matches: %w", err)
+	}
+	if total == 0 {
+		return nil
+	}
+
+	rows, err := n.db.QueryContext(ctx, `
+		SELECT source, entry_id, summary, event, matched_at
+		FROM subscription_matches
+		WHERE subscription_id = ? AND matched_at > ? AND matched_at <= ?
+		ORDER BY matched_at
+		LIMIT ?`,
+		sub.ID, sub.LastNotifiedAt, cutoff, maxNotificationItems,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to query matches: %w", err)
+	}
+	defer rows.Close()
+
+	var items []notificationItem
+	for rows.Next() {
+		var item notificationItem
+		var event string
+		if err := rows.Scan(&item.source, &item.entryID, &item.summary, &event, &item.matchedAt); err != nil {
+			return fmt.Errorf("failed to scan match: %w", err)
+		}
+		if err := json.Unmarshal([]byte(event), &item.event); err != nil {
+			// Only the summary is needed to notify; the embed fields are left empty
+			item.event = StreamEvent{Source: item.source, ID: item.entryID}
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read matches: %w", err)
+	}
+
+	switch sub.Channel {
+	case SubscriptionChannelSlack, SubscriptionChannelDiscord:
+		err = n.postMatches(sub, items, total)
+	default:
+		err = n.emailMatches(sub, items, total)
+	}
+	if err != nil {
+		return err
+	}
+
+	sub.LastNotifiedAt = cutoff
+	return n.store.save(sub)
+}
+
+// matchHeadline describes the number of matches of a subscription, e.g. "3 new certificates for example.com"
+func matchHeadline(sub *Subscription, total uint64) string {
+	noun := "certificates"
+	if sub.Kind == SubscriptionKindIdentity {
+		noun = "Rekor entries"
+	}
+	return fmt.Sprintf("%d new %s for %s", total, noun, sub.Value)
+}
+
+func (n *Notifier) emailMatches(sub *Subscription, items []notificationItem, total uint64) error {
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s matched your %s subscription:\n\n", matchHeadline(sub, total), sub.Kind)
+	for _, item := range items {
+		fmt.Fprintf(&body, "%s  %s\n    %s\n", item.matchedAt.Format(time.RFC3339), item.summary, n.entryURL(item.source, item.entryID))
+	}
+	if total > uint64(len(items)) {
+		fmt.Fprintf(&body, "\n... and %d more.\n", total-uint64(len(items)))
+	}
+	return n.send(sub, "[ctmon] "+matchHeadline(sub, total), body.String())
+}
+
+// sendConfirmation sends the link that confirms a new subscription to its channel
+func (n *Notifier) sendConfirmation(sub *Subscription) error {
+	if sub.Channel == SubscriptionChannelSlack || sub.Channel == SubscriptionChannelDiscord {
+		return n.postConfirmation(sub)
+	}
+
+	subject := fmt.Sprintf("[ctmon] Confirm your subscription to %s", sub.Value)
+	body := fmt.Sprintf(
+		"Someone, hopefully you, subscribed this address to %s notifications for %s %s.\n\n"+
+			"Confirm the subscription:\n    %s\n\n"+
+			"If you did not request this, ignore this email and no notifications will be sent.\n",
+		sub.Mode, sub.Kind, sub.Value, n.subscriptionURL(sub, "confirm"),
+	)
+	return n.send(sub, subject, body)
+}
+
+// send emails a plain-text message to the subscriber, with an unsubscribe link appended
+func (n *Notifier) send(sub *Subscription, subject, body string) error {
+	if !n.emailEnabled() {
+		return fmt.Errorf("email is not configured (SMTP_HOST is not set)")
+	}
+	unsubscribeURL := n.subscriptionURL(sub, "unsubscribe")
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", n.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", sub.Email)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "List-Unsubscribe: <%s>\r\n", unsubscribeURL)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	fmt.Fprintf(&msg, "\r\n--\r\nUnsubscribe: %s\r\n", unsubscribeURL)
+
+	if err := smtp.SendMail(n.addr, n.auth, n.from, []string{sub.Email}, []byte(msg.String())); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+func (n *Notifier) subscriptionURL(sub *Subscription, action string) string {
+	return fmt.Sprintf("%s/api/subscriptions/%s/%s?token=%s", n.publicURL, sub.ID, action, n.store.token(sub.ID))
+}
+
+// entryURL links to the UI page of a matched entry
+func (n *Notifier) entryURL(source, entryID string) string {
+	if source == "rekor" {
+		return fmt.Sprintf("%s/sigstore/entry/%s", n.publicURL, entryID)
+	}
+	return fmt.Sprintf("%s/certificate/%s", n.publicURL, entryID)
+}
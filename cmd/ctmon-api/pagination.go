@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// pageCursor is the sort key of the last row of a page. Lists are ordered newest first by entry
+// time, then log (CT log ID or Rekor tree ID) and index, all descending, so every row has a
+// unique position and the next page continues strictly after it however many rows are ingested
+// in between.
+type pageCursor struct {
+	Time  int64  `json:"t"` // Entry timestamp or integrated time, Unix seconds
+	Log   string `json:"l"`
+	Index uint64 `json:"i"`
+}
+
+// encodeCursor returns the opaque cursor clients pass back as after
+func encodeCursor(t time.Time, log string, index uint64) string {
+	data, _ := json.Marshal(pageCursor{Time: t.Unix(), Log: log, Index: index})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a cursor from the after argument; an empty one starts at the newest row
+func decodeCursor(cursor string) (*pageCursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var c pageCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Log == "" {
+		return nil, errInvalidCursor
+	}
+	return &c, nil
+}
+
+// keysetCondition returns the condition selecting the rows after the cursor in the order of
+// keysetOrder over the same columns, and its arguments; both are empty without a cursor
+func keysetCondition(c *pageCursor, timeColumn, logColumn, indexColumn string) (string, []interface{}) {
+	if c == nil {
+		return "", nil
+	}
+	return "AND (" + timeColumn + ", " + logColumn + ", " + indexColumn + ") < (toDateTime(?), ?, ?)",
+		[]interface{}{c.Time, c.Log, c.Index}
+}
+
+// keysetOrder returns the ORDER BY list matching keysetCondition
+func keysetOrder(timeColumn, logColumn, indexColumn string) string {
+	return timeColumn + " DESC, " + logColumn + " DESC, " + indexColumn + " DESC"
+}
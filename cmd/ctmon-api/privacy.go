@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	emailHMACKeyEnv   = "CTMON_EMAIL_HMAC_KEY" // Key of the email hashes, shared by the ingesters and the API
+	minEmailHMACKey   = 16                     // Shortest accepted key, in bytes
+	hashedEmailPrefix = "hmac:"                // Prefix of stored email hashes
+)
+
+// EmailHasher replaces email addresses with their keyed hash, hmac:<hex HMAC-SHA256 of the
+// lowercased address>, so deployments that must not store them in plaintext can still find the
+// entries of a known address by hashing it with the same key. A nil EmailHasher leaves addresses
+// unchanged.
+type EmailHasher struct {
+	key []byte
+}
+
+// NewEmailHasher creates a hasher with the given key
+func NewEmailHasher(key string) (*EmailHasher, error) {
+	if len(key) < minEmailHMACKey {
+		return nil, fmt.Errorf("%s must be at least %d bytes", emailHMACKeyEnv, minEmailHMACKey)
+	}
+	return &EmailHasher{key: []byte(key)}, nil
+}
+
+// Hash returns the keyed hash of an email address
+func (h *EmailHasher) Hash(email string) string {
+	if h == nil || email == "" || strings.HasPrefix(email, hashedEmailPrefix) {
+		return email
+	}
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(strings.ToLower(email)))
+	return hashedEmailPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// isEmailAddress reports whether a name (SAN, common name or identity) is an email address
+// rather than a host name or URI
+func isEmailAddress(name string) bool {
+	at := strings.LastIndex(name, "@")
+	return at > 0 && at < len(name)-1 && !strings.Contains(name, "://") && !strings.ContainsAny(name, " <>/")
+}
+
+// emailHasher hashes email identities in queries when the ingesters store them hashed, set from
+// CTMON_EMAIL_HMAC_KEY
+var emailHasher *EmailHasher
+
+// queryIdentity returns the identity as stored: hashed if it is an email address and emails are
+// stored hashed
+func queryIdentity(identity string) string {
+	if isEmailAddress(identity) {
+		return emailHasher.Hash(identity)
+	}
+	return identity
+}
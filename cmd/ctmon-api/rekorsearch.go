@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	maxSearchResults  = 10000 // Most entry UUIDs returned by an index search
+	maxRetrieveLookup = 100   // Most entries fetched by one entries retrieve request
+)
+
+// searchHashPattern matches the hash of a Rekor index search: sha1, sha256 or sha512 hex, with an
+// optional algorithm prefix
+var searchHashPattern = regexp.MustCompile(`^(?:(sha1|sha256|sha512):)?([0-9a-fA-F]{40}|[0-9a-fA-F]{64}|[0-9a-fA-F]{128})$`)
+
+// entryUUIDPattern matches an entry UUID (64 hex) or an entry ID prefixed with the tree ID (80 hex)
+var entryUUIDPattern = regexp.MustCompile(`^(?:[0-9a-fA-F]{16})?[0-9a-fA-F]{64}$`)
+
+// zstdDecoder decodes bodies stored with blob_codec zstd; DecodeAll is safe for concurrent use
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+// searchIndexRequest is the body of POST /api/v1/index/retrieve, as in the Rekor API
+type searchIndexRequest struct {
+	Hash      string `json:"hash"`
+	Email     string `json:"email"`
+	PublicKey *struct {
+		Format  string `json:"format"`
+		Content string `json:"content"` // Base64 encoded key or certificate
+		URL     string `json:"url"`
+	} `json:"publicKey"`
+	Operator string `json:"operator"` // and (default) or or
+}
+
+// searchLogQueryRequest is the body of POST /api/v1/log/entries/retrieve, as in the Rekor API
+type searchLogQueryRequest struct {
+	EntryUUIDs []string `json:"entryUUIDs"`
+	LogIndexes []int64  `json:"logIndexes"`
+}
+
+// rekorLogEntry is a log entry as the Rekor API serves it. The inclusion proof is the one stored
+// at retrieval, so it leads to the root of the tree size at that time.
+type rekorLogEntry struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+	Verification   struct {
+		InclusionProof       *rekorInclusionProof `json:"inclusionProof,omitempty"`
+		SignedEntryTimestamp string               `json:"signedEntryTimestamp,omitempty"`
+	} `json:"verification"`
+}
+
+// rekorInclusionProof is an inclusion proof as the Rekor API serves it
+type rekorInclusionProof struct {
+	LogIndex   int64    `json:"logIndex"` // Within the tree
+	RootHash   string   `json:"rootHash"`
+	TreeSize   int64    `json:"treeSize"`
+	Hashes     []string `json:"hashes"`
+	Checkpoint string   `json:"checkpoint"`
+}
+
+// rekorError writes an error in the shape of Rekor API errors
+func rekorError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{"code": code, "message": message})
+}
+
+// rekorSearchIndexHandler serves Rekor's index search: the UUIDs of the entries matching an
+// artifact hash, a signer email and/or a signing key, combined with the operator
+func rekorSearchIndexHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req searchIndexRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&req); err != nil {
+			rekorError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		var conditions []string
+		var args []interface{}
+		if req.Hash != "" {
+			match := searchHashPattern.FindStringSubmatch(req.Hash)
+			if match == nil {
+				rekorError(w, http.StatusBadRequest, "invalid hash")
+				return
+			}
+			conditions = append(conditions, "data_hash_value = ?")
+			args = append(args, strings.ToLower(match[2]))
+		}
+		if req.Email != "" {
+			if !isEmailAddress(req.Email) {
+				rekorError(w, http.StatusBadRequest, "invalid email")
+				return
+			}
+			conditions = append(conditions, "signer_identity = ?")
+			args = append(args, queryIdentity(req.Email))
+		}
+		if req.PublicKey != nil {
+			if req.PublicKey.URL != "" {
+				rekorError(w, http.StatusBadRequest, "public key URLs are not fetched by the mirror, pass the key content")
+				return
+			}
+			content, err := base64.StdEncoding.DecodeString(req.PublicKey.Content)
+			if err != nil || len(content) == 0 {
+				rekorError(w, http.StatusBadRequest, "invalid public key content")
+				return
+			}
+			keyType, fingerprint, err := searchKeyFingerprint(req.PublicKey.Format, content)
+			if err != nil {
+				rekorError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			conditions = append(conditions, "(public_key_type = ? AND public_key_fingerprint = ?)")
+			args = append(args, keyType, fingerprint)
+		}
+		if len(conditions) == 0 {
+			rekorError(w, http.StatusBadRequest, "at least one of hash, email or publicKey is required")
+			return
+		}
+
+		operator := " AND "
+		switch req.Operator {
+		case "", "and":
+		case "or":
+			operator = " OR "
+		default:
+			rekorError(w, http.StatusBadRequest, "operator must be and or or")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+		defer cancel()
+
+		rows, err := db.QueryContext(ctx, `
+			SELECT DISTINCT entry_uuid
+			FROM rekor_log_entries
+			WHERE `+strings.Join(conditions, operator)+`
+			LIMIT ?
+			`+querySettings, append(args, maxSearchResults)...)
+		if err != nil {
+			rekorError(w, http.StatusInternalServerError, "failed to search entries")
+			return
+		}
+		defer rows.Close()
+
+		uuids := []string{}
+		for rows.Next() {
+			var uuid string
+			if err := rows.Scan(&uuid); err != nil {
+				rekorError(w, http.StatusInternalServerError, "failed to search entries")
+				return
+			}
+			uuids = append(uuids, uuid)
+		}
+		if err := rows.Err(); err != nil {
+			rekorError(w, http.StatusInternalServerError, "failed to search entries")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(uuids)
+	}
+}
+
+// rekorRetrieveEntriesHandler serves Rekor's entries retrieve by UUID. Entries that are not
+// stored are left out. Log indexes are not supported: Rekor looks them up across all shards,
+// while the mirror only stores indexes within each tree.
+func rekorRetrieveEntriesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req searchLogQueryRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&req); err != nil {
+			rekorError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		if len(req.LogIndexes) > 0 {
+			rekorError(w, http.StatusBadRequest, "logIndexes are not supported by the mirror, which stores indexes within each tree")
+			return
+		}
+		if len(req.EntryUUIDs) == 0 || len(req.EntryUUIDs) > maxRetrieveLookup {
+			rekorError(w, http.StatusBadRequest, fmt.Sprintf("between 1 and %d entryUUIDs are required", maxRetrieveLookup))
+			return
+		}
+		for _, uuid := range req.EntryUUIDs {
+			if !entryUUIDPattern.MatchString(uuid) {
+				rekorError(w, http.StatusBadRequest, fmt.Sprintf("invalid entry UUID %q", uuid))
+				return
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+		defer cancel()
+
+		entries, err := getRekorLogEntries(ctx, db, req.EntryUUIDs)
+		if err != nil {
+			rekorError(w, http.StatusInternalServerError, "failed to retrieve entries")
+			return
+		}
+		response := []map[string]*rekorLogEntry{}
+		for _, uuid := range req.EntryUUIDs {
+			if entry := entries[leafUUID(uuid)]; entry != nil {
+				response = append(response, map[string]*rekorLogEntry{uuid: entry})
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// rekorGetEntryHandler serves Rekor's entry lookup by UUID
+func rekorGetEntryHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		uuid := r.PathValue("uuid")
+		if !entryUUIDPattern.MatchString(uuid) {
+			rekorError(w, http.StatusBadRequest, "invalid entry UUID")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+		defer cancel()
+
+		entries, err := getRekorLogEntries(ctx, db, []string{uuid})
+		if err != nil {
+			rekorError(w, http.StatusInternalServerError, "failed to retrieve entry")
+			return
+		}
+		entry := entries[leafUUID(uuid)]
+		if entry == nil {
+			rekorError(w, http.StatusNotFound, "entry not found")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]*rekorLogEntry{uuid: entry})
+	}
+}
+
+// leafUUID returns the UUID of an entry without the tree ID prefix of entry IDs
+func leafUUID(uuid string) string {
+	uuid = strings.ToLower(uuid)
+	if len(uuid) <= 64 {
+		return uuid
+	}
+	return uuid[len(uuid)-64:]
+}
+
+// getRekorLogEntries returns the stored entries with the given UUIDs or entry IDs, by leafUUID.
+// Entries are stored under the UUID or entry ID Rekor listed them with, so entry IDs are looked
+// up in both forms, while UUIDs only find entries stored as UUIDs.
+func getRekorLogEntries(ctx context.Context, db *sql.DB, uuids []string) (map[string]*rekorLogEntry, error) {
+	var placeholders []string
+	var args []interface{}
+	for _, uuid := range uuids {
+		placeholders = append(placeholders, "?")
+		args = append(args, strings.ToLower(uuid))
+		if len(uuid) > 64 {
+			placeholders = append(placeholders, "?")
+			args = append(args, leafUUID(uuid))
+		}
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT entry_uuid, body, blob_codec, toInt64(toUnixTimestamp(integrated_time)), log_id, log_index, signed_entry_timestamp,
+			inclusion_proof_hashes, inclusion_proof_root_hash, inclusion_proof_tree_size, inclusion_proof_checkpoint
+		FROM rekor_log_entries
+		WHERE entry_uuid IN (`+strings.Join(placeholders, ", ")+`)
+		LIMIT 1 BY entry_uuid
+		`+querySettings, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rekor entries: %w", err)
+	}
+	defer rows.Close()
+
+	entries := make(map[string]*rekorLogEntry)
+	for rows.Next() {
+		var uuid, blobCodec string
+		var entry rekorLogEntry
+		var proof rekorInclusionProof
+		if err := rows.Scan(&uuid, &entry.Body, &blobCodec, &entry.IntegratedTime, &entry.LogID, &entry.LogIndex, &entry.Verification.SignedEntryTimestamp,
+			&proof.Hashes, &proof.RootHash, &proof.TreeSize, &proof.Checkpoint); err != nil {
+			return nil, fmt.Errorf("failed to scan rekor entry: %w", err)
+		}
+		if proof.TreeSize > 0 {
+			proof.LogIndex = entry.LogIndex
+			entry.Verification.InclusionProof = &proof
+		}
+		if entry.Body, err = base64Body(entry.Body, blobCodec); err != nil {
+			return nil, fmt.Errorf("failed to decode body of rekor entry %s: %w", uuid, err)
+		}
+		entries[leafUUID(uuid)] = &entry
+	}
+	return entries, rows.Err()
+}
+
+// base64Body returns a stored entry body base64 encoded, as Rekor serves it
+func base64Body(body, blobCodec string) (string, error) {
+	switch blobCodec {
+	case "", "none":
+		return body, nil
+	case "zstd":
+		raw, err := zstdDecoder.DecodeAll([]byte(body), nil)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(raw), nil
+	default:
+		return "", fmt.Errorf("unknown blob codec %q", blobCodec)
+	}
+}
+
+// searchKeyFingerprint returns the public_key_type and public_key_fingerprint the ingester stores
+// for a search key: the SHA-256 (hex) of the SubjectPublicKeyInfo of an x509 certificate or PEM
+// public key, the OpenSSH SHA256 fingerprint of an ssh key, or the fingerprint of a PGP key
+func searchKeyFingerprint(format string, content []byte) (keyType, fingerprint string, err error) {
+	switch format {
+	case "x509":
+		block, _ := pem.Decode(content)
+		der := content
+		if block != nil {
+			der = block.Bytes
+		}
+		if cert, err := x509.ParseCertificate(der); err == nil {
+			der = cert.RawSubjectPublicKeyInfo
+		} else if _, err := x509.ParsePKIXPublicKey(der); err != nil {
+			return "", "", errors.New("invalid x509 certificate or public key")
+		}
+		hash := sha256.Sum256(der)
+		return "x509", hex.EncodeToString(hash[:]), nil
+	case "ssh":
+		fields := strings.Fields(string(content))
+		if len(fields) < 2 {
+			return "", "", errors.New("invalid ssh public key")
+		}
+		blob, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(blob) == 0 {
+			return "", "", errors.New("invalid ssh public key")
+		}
+		hash := sha256.Sum256(blob)
+		return "ssh", "SHA256:" + base64.RawStdEncoding.EncodeToString(hash[:]), nil
+	case "pgp":
+		fingerprint, err := pgpFingerprint(content)
+		if err != nil {
+			return "", "", err
+		}
+		return "pgp", fingerprint, nil
+	case "minisign", "tuf":
+		return "", "", fmt.Errorf("%s keys are not indexed by the mirror", format)
+	default:
+		return "", "", fmt.Errorf("unknown public key format %q", format)
+	}
+}
+
+// pgpFingerprint returns the fingerprint the ingester stores for a PGP key, armored or binary:
+// the SHA-256 (hex) of its primary key packet, framed as for a v4 fingerprint
+func pgpFingerprint(content []byte) (string, error) {
+	if armored := string(content); strings.Contains(armored, "-----BEGIN PGP PUBLIC KEY BLOCK-----") {
+		var lines []string
+		inData := false
+		for _, line := range strings.Split(armored, "\n") {
+			line = strings.TrimSpace(line)
+			switch {
+			case strings.HasPrefix(line, "-----BEGIN PGP"):
+				inData = true
+			case strings.HasPrefix(line, "-----END PGP"):
+				inData = false
+			case inData && line != "" && !strings.HasPrefix(line, "=") && !strings.Contains(line, ":"):
+				lines = append(lines, line)
+			}
+		}
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(lines, ""))
+		if err != nil {
+			return "", errors.New("invalid PGP armor")
+		}
+		content = decoded
+	}
+
+	body, err := pgpPrimaryKeyPacket(content)
+	if err != nil {
+		return "", err
+	}
+	hasher := sha256.New()
+	hasher.Write([]byte{0x99, byte(len(body) >> 8), byte(len(body))})
+	hasher.Write(body)
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// pgpPrimaryKeyPacket returns the body of the first packet of a key, which must be a public key
+// packet (tag 6) with a definite length
+func pgpPrimaryKeyPacket(data []byte) ([]byte, error) {
+	invalid := errors.New("invalid PGP public key")
+	if len(data) < 2 || data[0]&0x80 == 0 {
+		return nil, invalid
+	}
+
+	var tag, length, offset int
+	if data[0]&0x40 != 0 {
+		tag = int(data[0] & 0x3f)
+		switch first := int(data[1]); {
+		case first < 192:
+			length, offset = first, 2
+		case first < 224 && len(data) >= 3:
+			length, offset = (first-192)<<8+int(data[2])+192, 3
+		case first == 255 && len(data) >= 6:
+			length, offset = int(data[2])<<24|int(data[3])<<16|int(data[4])<<8|int(data[5]), 6
+		default:
+			return nil, invalid
+		}
+	} else {
+		tag = int(data[0]&0x3c) >> 2
+		switch data[0] & 0x03 {
+		case 0:
+			length, offset = int(data[1]), 2
+		case 1:
+			if len(data) < 3 {
+				return nil, invalid
+			}
+			length, offset = int(data[1])<<8|int(data[2]), 3
+		case 2:
+			if len(data) < 5 {
+				return nil, invalid
+			}
+			length, offset = int(data[1])<<24|int(data[2])<<16|int(data[3])<<8|int(data[4]), 5
+		default:
+			return nil, invalid
+		}
+	}
+	if tag != 6 || length <= 0 || offset+length > len(data) {
+		return nil, invalid
+	}
+	return data[offset : offset+length], nil
+}
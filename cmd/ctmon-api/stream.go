@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	subscriberBuffer  = 256              // Events buffered per SSE client before events are dropped
+	heartbeatInterval = 15 * time.Second // Interval of SSE keep-alive comments
+	maxPublishBody    = 32 << 20         // Largest accepted publish request (one ingester batch)
+)
+
+// StreamEvent is a newly ingested entry as published by the ingesters
+type StreamEvent struct {
+	Source             string    `json:"source"` // "ct" or "rekor"
+	ID                 string    `json:"id"`     // certificate_sha256 or entry_uuid
+	LogID              string    `json:"log_id"`
+	LogIndex           int64     `json:"log_index"`
+	Timestamp          time.Time `json:"timestamp"`
+	EntryType          string    `json:"entry_type,omitempty"` // CT entry type or Rekor kind
+	SubjectCommonName  string    `json:"subject_common_name,omitempty"`
+	Domains            []string  `json:"domains,omitempty"`
+	IssuerCommonName   string    `json:"issuer_common_name,omitempty"`
+	IssuerOrganization []string  `json:"issuer_organization,omitempty"`
+	Identities         []string  `json:"identities,omitempty"` // Rekor certificate SANs and PGP signer email
+}
+
+// StreamFilter selects the events delivered to a subscriber; empty fields match everything
+type StreamFilter struct {
+	Source   string
+	Domain   string // Matches the domain and its subdomains
+	Issuer   string // Matches the issuer CN or organization, case-insensitively
+	Identity string // Matches a Rekor identity exactly
+}
+
+// Match reports whether an event satisfies the filter
+func (f StreamFilter) Match(event *StreamEvent) bool {
+	if f.Source != "" && event.Source != f.Source {
+		return false
+	}
+	if f.Domain != "" {
+		matched := false
+		for _, domain := range event.Domains {
+			domain = strings.TrimPrefix(strings.ToLower(domain), "*.")
+			if domain == f.Domain || strings.HasSuffix(domain, "."+f.Domain) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if f.Issuer != "" {
+		matched := strings.EqualFold(event.IssuerCommonName, f.Issuer)
+		for _, org := range event.IssuerOrganization {
+			matched = matched || strings.EqualFold(org, f.Issuer)
+		}
+		if !matched {
+			return false
+		}
+	}
+	if f.Identity != "" {
+		matched := false
+		for _, identity := range event.Identities {
+			if identity == f.Identity {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+type subscriber struct {
+	filter StreamFilter
+	events chan *StreamEvent
+}
+
+// Broker fans out published events to SSE subscribers. Slow subscribers lose events rather than
+// blocking the publishers.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+}
+
+// NewBroker creates an empty broker
+func NewBroker() *Broker {
+	return &Broker{subscribers: make(map[*subscriber]struct{})}
+}
+
+func (b *Broker) subscribe(filter StreamFilter) *subscriber {
+	return b.subscribeBuffered(filter, subscriberBuffer)
+}
+
+func (b *Broker) subscribeBuffered(filter StreamFilter, size int) *subscriber {
+	sub := &subscriber{filter: filter, events: make(chan *StreamEvent, size)}
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+func (b *Broker) unsubscribe(sub *subscriber) {
+	b.mu.Lock()
+	delete(b.subscribers, sub)
+	b.mu.Unlock()
+}
+
+// Publish delivers events to every subscriber whose filter matches
+func (b *Broker) Publish(events []*StreamEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subscribers {
+		for _, event := range events {
+			if !sub.filter.Match(event) {
+				continue
+			}
+			select {
+			case sub.events <- event:
+			default:
+			}
+		}
+	}
+}
+
+// publishHandler accepts event batches from the ingesters (-publish_url), which must send token
+// (CTMON_PUBLISH_TOKEN) as a bearer token. Requests are rejected while token is empty.
+func publishHandler(broker *Broker, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var events []*StreamEvent
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPublishBody)).Decode(&events); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		broker.Publish(events)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// streamHandler serves matching events as Server-Sent Events until the client disconnects or
+// shutdown is closed.
+//
+// Example: GET /api/stream?source=ct&domain=example.com
+func streamHandler(broker *Broker, shutdown <-chan struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		query := r.URL.Query()
+		filter := StreamFilter{
+			Source:   query.Get("source"),
+			Domain:   strings.TrimSuffix(strings.ToLower(query.Get("domain")), "."),
+			Issuer:   query.Get("issuer"),
+			Identity: queryIdentity(query.Get("identity")),
+		}
+		if filter.Source != "" && filter.Source != "ct" && filter.Source != "rekor" {
+			http.Error(w, "source must be ct or rekor", http.StatusBadRequest)
+			return
+		}
+
+		sub := broker.subscribe(filter)
+		defer broker.unsubscribe(sub)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(heartbeatInterval)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case event := <-sub.events:
+				data, err := json.Marshal(event)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\nid: %s\ndata: %s\n\n", event.Source, event.ID, data)
+				flusher.Flush()
+			case <-heartbeat.C:
+				fmt.Fprintf(w, ": ping\n\n")
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			case <-shutdown:
+				return
+			}
+		}
+	}
+}
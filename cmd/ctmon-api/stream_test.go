@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPublishHandlerAuth(t *testing.T) {
+	const body = `[{"source":"ct","id":"ab12","log_id":"log","log_index":7,"timestamp":"2025-06-01T00:00:00Z","domains":["example.com"]}]`
+
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		wantStatus    int
+	}{
+		{name: "no authorization", token: "secret", wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", token: "secret", authorization: "Bearer guess", wantStatus: http.StatusUnauthorized},
+		{name: "token without bearer scheme", token: "secret", authorization: "secret", wantStatus: http.StatusUnauthorized},
+		{name: "no token configured", authorization: "Bearer ", wantStatus: http.StatusUnauthorized},
+		{name: "valid token", token: "secret", authorization: "Bearer secret", wantStatus: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := NewBroker()
+			sub := broker.subscribe(StreamFilter{})
+			defer broker.unsubscribe(sub)
+
+			req := httptest.NewRequest(http.MethodPost, "/internal/publish", strings.NewReader(body))
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			publishHandler(broker, tt.token)(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			delivered := len(sub.events)
+			if tt.wantStatus == http.StatusNoContent && delivered != 1 {
+				t.Errorf("delivered %d events, want 1", delivered)
+			}
+			if tt.wantStatus != http.StatusNoContent && delivered != 0 {
+				t.Errorf("delivered %d events of a rejected publish", delivered)
+			}
+		})
+	}
+}
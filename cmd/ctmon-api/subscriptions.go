@@ -0,0 +1,456 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Subscription kinds and notification modes
+const (
+	SubscriptionKindDomain   = "domain"   // Matches CT certificates for the domain and its subdomains
+	SubscriptionKindIdentity = "identity" // Matches Rekor entries signed by the identity (certificate SAN or PGP email)
+
+	SubscriptionModeImmediate = "immediate" // Notify on the next notifier run after a match
+	SubscriptionModeDigest    = "digest"    // Notify at most once per -digest_interval
+
+	SubscriptionChannelEmail   = "email"   // Plain-text email over SMTP
+	SubscriptionChannelSlack   = "slack"   // Slack incoming webhook
+	SubscriptionChannelDiscord = "discord" // Discord channel webhook
+)
+
+const (
+	subscriptionRefreshInterval = 1 * time.Minute // Reload of subscriptions written by other API instances
+	matchFlushInterval          = 5 * time.Second // Interval to write buffered matches to subscription_matches
+	matcherBuffer               = 50000           // Events buffered for the subscription matcher
+)
+
+// Subscription is a user's registration for notifications about a domain or Sigstore identity
+type Subscription struct {
+	ID             string    `json:"id"`
+	Email          string    `json:"email"`
+	Kind           string    `json:"kind"`
+	Value          string    `json:"value"`
+	Mode           string    `json:"mode"`
+	Channel        string    `json:"channel"`
+	WebhookURL     string    `json:"webhook_url,omitempty"`
+	Confirmed      bool      `json:"confirmed"`
+	CreatedAt      time.Time `json:"created_at"`
+	LastNotifiedAt time.Time `json:"last_notified_at"`
+
+	deleted bool
+}
+
+// filter returns the stream filter selecting events for this subscription
+func (s *Subscription) filter() StreamFilter {
+	if s.Kind == SubscriptionKindDomain {
+		return StreamFilter{Source: "ct", Domain: s.Value}
+	}
+	return StreamFilter{Source: "rekor", Identity: queryIdentity(s.Value)}
+}
+
+// SubscriptionStore keeps subscriptions in ClickHouse, with an in-memory copy used for matching.
+// Every change is written as a new row version of the ReplacingMergeTree subscriptions table.
+type SubscriptionStore struct {
+	db     *sql.DB
+	secret []byte // Key for the per-subscription tokens in confirmation and unsubscribe links
+
+	mu            sync.RWMutex
+	subscriptions map[string]*Subscription
+}
+
+// NewSubscriptionStore loads the current subscriptions
+func NewSubscriptionStore(db *sql.DB, secret string) (*SubscriptionStore, error) {
+	if len(secret) < 16 {
+		return nil, fmt.Errorf("subscription secret must be at least 16 characters")
+	}
+	store := &SubscriptionStore{db: db, secret: []byte(secret), subscriptions: make(map[string]*Subscription)}
+	if err := store.reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *SubscriptionStore) reload() error {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT subscription_id, email, kind, value, mode, channel, webhook_url, confirmed, created_at, last_notified_at
+		FROM subscriptions FINAL
+		WHERE deleted = 0
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to load subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	subscriptions := make(map[string]*Subscription)
+	for rows.Next() {
+		var sub Subscription
+		var confirmed uint8
+		if err := rows.Scan(&sub.ID, &sub.Email, &sub.Kind, &sub.Value, &sub.Mode, &sub.Channel, &sub.WebhookURL, &confirmed,
+			&sub.CreatedAt, &sub.LastNotifiedAt); err != nil {
+			return fmt.Errorf("failed to scan subscription: %w", err)
+		}
+		sub.Confirmed = confirmed == 1
+		subscriptions[sub.ID] = &sub
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read subscriptions: %w", err)
+	}
+
+	s.mu.Lock()
+	s.subscriptions = subscriptions
+	s.mu.Unlock()
+	return nil
+}
+
+// StartRefresh periodically reloads subscriptions until done is closed
+func (s *SubscriptionStore) StartRefresh(done <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(subscriptionRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.reload(); err != nil {
+					log.Printf("Warning: %v", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// save writes a new version of a subscription and updates the in-memory copy
+func (s *SubscriptionStore) save(sub *Subscription) error {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO subscriptions (
+			subscription_id, email, kind, value, mode, channel, webhook_url, confirmed, deleted,
+			created_at, last_notified_at, version
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		sub.ID, sub.Email, sub.Kind, sub.Value, sub.Mode, sub.Channel, sub.WebhookURL,
+		boolToUint8(sub.Confirmed), boolToUint8(sub.deleted),
+		sub.CreatedAt, sub.LastNotifiedAt, uint64(time.Now().UnixNano()),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save subscription %s: %w", sub.ID, err)
+	}
+
+	s.mu.Lock()
+	if sub.deleted {
+		delete(s.subscriptions, sub.ID)
+	} else {
+		s.subscriptions[sub.ID] = sub
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// get returns a copy of a subscription
+func (s *SubscriptionStore) get(id string) (*Subscription, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sub, ok := s.subscriptions[id]
+	if !ok {
+		return nil, false
+	}
+	copied := *sub
+	return &copied, true
+}
+
+// confirmed returns copies of all confirmed subscriptions
+func (s *SubscriptionStore) confirmed() []*Subscription {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var subs []*Subscription
+	for _, sub := range s.subscriptions {
+		if sub.Confirmed {
+			copied := *sub
+			subs = append(subs, &copied)
+		}
+	}
+	return subs
+}
+
+// token returns the secret included in a subscription's confirmation and unsubscribe links
+func (s *SubscriptionStore) token(id string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkToken reports whether a link token belongs to the subscription
+func (s *SubscriptionStore) checkToken(id, token string) bool {
+	return hmac.Equal([]byte(s.token(id)), []byte(token))
+}
+
+func boolToUint8(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// subscriptionMatch is an event that matched a subscription, pending insertion into subscription_matches
+type subscriptionMatch struct {
+	subscriptionID string
+	event          *StreamEvent
+	matchedAt      time.Time
+}
+
+// runMatcher matches published events against confirmed subscriptions and records the matches
+func (s *SubscriptionStore) runMatcher(broker *Broker, done <-chan struct{}) {
+	sub := broker.subscribeBuffered(StreamFilter{}, matcherBuffer)
+	go func() {
+		defer broker.unsubscribe(sub)
+		ticker := time.NewTicker(matchFlushInterval)
+		defer ticker.Stop()
+
+		var pending []subscriptionMatch
+		flush := func() {
+			if len(pending) == 0 {
+				return
+			}
+			if err := s.insertMatches(pending); err != nil {
+				log.Printf("Warning: %v", err)
+				return
+			}
+			pending = pending[:0]
+		}
+
+		for {
+			select {
+			case event := <-sub.events:
+				for _, subscription := range s.confirmed() {
+					if subscription.filter().Match(event) {
+						pending = append(pending, subscriptionMatch{subscriptionID: subscription.ID, event: event, matchedAt: time.Now().UTC()})
+					}
+				}
+			case <-ticker.C:
+				flush()
+			case <-done:
+				flush()
+				return
+			}
+		}
+	}()
+}
+
+func (s *SubscriptionStore) insertMatches(matches []subscriptionMatch) error {
+	var values []string
+	var args []interface{}
+	for _, match := range matches {
+		event, err := json.Marshal(match.event)
+		if err != nil {
+			return fmt.Errorf("failed to marshal matched event: %w", err)
+		}
+		values = append(values, "(?, ?, ?, ?, ?, ?, ?, ?)")
+		args = append(args,
+			match.subscriptionID,
+			match.event.Source,
+			match.event.ID,
+			match.event.LogID,
+			match.event.LogIndex,
+			eventSummary(match.event),
+			string(event),
+			match.matchedAt,
+		)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	query := `
+		INSERT INTO subscription_matches (
+			subscription_id, source, entry_id, log_id, log_index, summary, event, matched_at
+		) VALUES ` + strings.Join(values, ", ")
+	if _, err := s.db.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to insert %d subscription matches: %w", len(matches), err)
+	}
+	return nil
+}
+
+// eventSummary describes an event in one line for notifications
+func eventSummary(event *StreamEvent) string {
+	if event.Source == "rekor" {
+		return fmt.Sprintf("%s entry signed by %s", event.EntryType, strings.Join(event.Identities, ", "))
+	}
+	issuer := event.IssuerCommonName
+	if len(event.IssuerOrganization) > 0 {
+		issuer = event.IssuerOrganization[0]
+	}
+	return fmt.Sprintf("%s for %s issued by %s", event.EntryType, strings.Join(event.Domains, ", "), issuer)
+}
+
+// createSubscriptionRequest is the body of POST /api/subscriptions
+type createSubscriptionRequest struct {
+	Email      string `json:"email"`
+	Kind       string `json:"kind"`
+	Value      string `json:"value"`
+	Mode       string `json:"mode"`
+	Channel    string `json:"channel"`
+	WebhookURL string `json:"webhook_url"`
+}
+
+// subscriptionsHandler serves the subscription API:
+//
+//	POST /api/subscriptions                          create (sends a confirmation message)
+//	GET  /api/subscriptions/{id}?token=              view
+//	GET  /api/subscriptions/{id}/confirm?token=      confirm (linked from the confirmation message)
+//	GET  /api/subscriptions/{id}/unsubscribe?token=  delete (linked from every notification)
+func subscriptionsHandler(store *SubscriptionStore, notifier *Notifier) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /api/subscriptions", func(w http.ResponseWriter, r *http.Request) {
+		var req createSubscriptionRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Channel == "" {
+			req.Channel = SubscriptionChannelEmail
+		}
+		var email string
+		switch req.Channel {
+		case SubscriptionChannelEmail:
+			if !notifier.emailEnabled() {
+				http.Error(w, "email notifications are not configured", http.StatusBadRequest)
+				return
+			}
+			address, err := mail.ParseAddress(req.Email)
+			if err != nil {
+				http.Error(w, "invalid email address", http.StatusBadRequest)
+				return
+			}
+			email = address.Address
+			req.WebhookURL = ""
+		case SubscriptionChannelSlack, SubscriptionChannelDiscord:
+			if err := validateWebhookURL(req.Channel, req.WebhookURL); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "channel must be email, slack or discord", http.StatusBadRequest)
+			return
+		}
+		value := strings.TrimSpace(req.Value)
+		switch req.Kind {
+		case SubscriptionKindDomain:
+			value = strings.TrimSuffix(strings.ToLower(value), ".")
+		case SubscriptionKindIdentity:
+		default:
+			http.Error(w, "kind must be domain or identity", http.StatusBadRequest)
+			return
+		}
+		if value == "" {
+			http.Error(w, "value is required", http.StatusBadRequest)
+			return
+		}
+		if req.Mode == "" {
+			req.Mode = SubscriptionModeImmediate
+		}
+		if req.Mode != SubscriptionModeImmediate && req.Mode != SubscriptionModeDigest {
+			http.Error(w, "mode must be immediate or digest", http.StatusBadRequest)
+			return
+		}
+
+		id, err := randomHex(16)
+		if err != nil {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		now := time.Now().UTC()
+		sub := &Subscription{
+			ID:             id,
+			Email:          email,
+			Kind:           req.Kind,
+			Value:          value,
+			Mode:           req.Mode,
+			Channel:        req.Channel,
+			WebhookURL:     req.WebhookURL,
+			CreatedAt:      now,
+			LastNotifiedAt: now,
+		}
+		if err := store.save(sub); err != nil {
+			log.Printf("Error creating subscription: %v", err)
+			http.Error(w, "failed to create subscription", http.StatusInternalServerError)
+			return
+		}
+		if err := notifier.sendConfirmation(sub); err != nil {
+			log.Printf("Error sending confirmation for subscription %s: %v", sub.ID, err)
+			http.Error(w, "failed to send confirmation message", http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"id": sub.ID, "status": "pending_confirmation"})
+	})
+
+	// withSubscription resolves {id} and checks the token before calling next
+	withSubscription := func(next func(http.ResponseWriter, *Subscription)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			sub, ok := store.get(r.PathValue("id"))
+			if !ok || !store.checkToken(sub.ID, r.URL.Query().Get("token")) {
+				http.Error(w, "subscription not found", http.StatusNotFound)
+				return
+			}
+			next(w, sub)
+		}
+	}
+
+	mux.HandleFunc("GET /api/subscriptions/{id}", withSubscription(func(w http.ResponseWriter, sub *Subscription) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sub)
+	}))
+
+	mux.HandleFunc("GET /api/subscriptions/{id}/confirm", withSubscription(func(w http.ResponseWriter, sub *Subscription) {
+		sub.Confirmed = true
+		sub.LastNotifiedAt = time.Now().UTC()
+		if err := store.save(sub); err != nil {
+			log.Printf("Error confirming subscription: %v", err)
+			http.Error(w, "failed to confirm subscription", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "Subscription to %s %s confirmed.\n", sub.Kind, sub.Value)
+	}))
+
+	mux.HandleFunc("GET /api/subscriptions/{id}/unsubscribe", withSubscription(func(w http.ResponseWriter, sub *Subscription) {
+		sub.deleted = true
+		if err := store.save(sub); err != nil {
+			log.Printf("Error deleting subscription: %v", err)
+			http.Error(w, "failed to unsubscribe", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "Unsubscribed from %s %s.\n", sub.Kind, sub.Value)
+	}))
+
+	return mux
+}
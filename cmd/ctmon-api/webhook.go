@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxWebhookItems = 10 // Matches posted in one webhook message (Discord allows at most 10 embeds)
+
+	discordColorCT    = 0x2563eb
+	discordColorRekor = 0x7c3aed
+)
+
+// discordHosts are the hosts Discord serves channel webhooks on
+var discordHosts = map[string]bool{
+	"discord.com":        true,
+	"discordapp.com":     true,
+	"ptb.discord.com":    true,
+	"canary.discord.com": true,
+}
+
+// validateWebhookURL checks that a webhook URL points at the service of the channel, so that
+// subscriptions cannot be used to make the API post to arbitrary hosts
+func validateWebhookURL(channel, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" {
+		return fmt.Errorf("webhook_url must be an https URL")
+	}
+	switch channel {
+	case SubscriptionChannelSlack:
+		if u.Host != "hooks.slack.com" || !strings.HasPrefix(u.Path, "/services/") {
+			return fmt.Errorf("webhook_url must be a Slack incoming webhook (https://hooks.slack.com/services/...)")
+		}
+	case SubscriptionChannelDiscord:
+		if !discordHosts[u.Host] || !strings.HasPrefix(u.Path, "/api/webhooks/") {
+			return fmt.Errorf("webhook_url must be a Discord webhook (https://discord.com/api/webhooks/...)")
+		}
+	}
+	return nil
+}
+
+// webhookField is a key field of a matched entry, rendered as a Slack section field or Discord embed field
+type webhookField struct {
+	name   string
+	value  string
+	inline bool
+}
+
+// eventFields returns the key fields of a certificate or Rekor entry, skipping empty ones
+func eventFields(event *StreamEvent) []webhookField {
+	var fields []webhookField
+	add := func(name, value string, inline bool) {
+		if value != "" {
+			fields = append(fields, webhookField{name: name, value: truncate(value, 1000), inline: inline})
+		}
+	}
+
+	issuer := event.IssuerCommonName
+	if len(event.IssuerOrganization) > 0 {
+		issuer = strings.TrimSpace(issuer + " (" + strings.Join(event.IssuerOrganization, ", ") + ")")
+	}
+
+	if event.Source == "rekor" {
+		add("Kind", event.EntryType, true)
+		add("Log index", strconv.FormatInt(event.LogIndex, 10), true)
+		add("Identities", strings.Join(event.Identities, "\n"), false)
+		add("Certificate issuer", issuer, false)
+		add("Entry UUID", event.ID, false)
+	} else {
+		add("Subject", event.SubjectCommonName, true)
+		add("Entry type", event.EntryType, true)
+		add("Domains", strings.Join(event.Domains, "\n"), false)
+		add("Issuer", issuer, false)
+		add("Log", fmt.Sprintf("%s #%d", event.LogID, event.LogIndex), false)
+		add("SHA-256", event.ID, false)
+	}
+	return fields
+}
+
+// truncate shortens s to at most n bytes without splitting a UTF-8 sequence
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	cut := n - len("…")
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "…"
+}
+
+// slackEscape escapes the characters Slack mrkdwn treats as control characters
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// slackMessage builds a Block Kit message with one section per matched entry
+func (n *Notifier) slackMessage(sub *Subscription, items []notificationItem, total uint64) map[string]interface{} {
+	headline := matchHeadline(sub, total)
+	blocks := []interface{}{
+		map[string]interface{}{
+			"type": "header",
+			"text": map[string]interface{}{"type": "plain_text", "text": truncate(headline, 150)},
+		},
+	}
+
+	for _, item := range items {
+		var fields []interface{}
+		for _, field := range eventFields(&item.event) {
+			fields = append(fields, map[string]interface{}{
+				"type": "mrkdwn",
+				"text": fmt.Sprintf("*%s*\n%s", field.name, slackEscape(field.value)),
+			})
+		}
+		block := map[string]interface{}{
+			"type": "section",
+			"text": map[string]interface{}{
+				"type": "mrkdwn",
+				"text": fmt.Sprintf("*<%s|%s>*", n.entryURL(item.source, item.entryID), slackEscape(truncate(item.summary, 1000))),
+			},
+		}
+		if len(fields) > 0 {
+			block["fields"] = fields
+		}
+		blocks = append(blocks, block)
+	}
+
+	footer := fmt.Sprintf("<%s|Unsubscribe>", n.subscriptionURL(sub, "unsubscribe"))
+	if total > uint64(len(items)) {
+		footer = fmt.Sprintf("... and %d more. %s", total-uint64(len(items)), footer)
+	}
+	blocks = append(blocks, map[string]interface{}{
+		"type":     "context",
+		"elements": []interface{}{map[string]interface{}{"type": "mrkdwn", "text": footer}},
+	})
+
+	return map[string]interface{}{"text": headline, "blocks": blocks}
+}
+
+// discordMessage builds a webhook message with one embed per matched entry
+func (n *Notifier) discordMessage(sub *Subscription, items []notificationItem, total uint64) map[string]interface{} {
+	var embeds []interface{}
+	for _, item := range items {
+		color := discordColorCT
+		if item.source == "rekor" {
+			color = discordColorRekor
+		}
+
+		fields := []interface{}{}
+		for _, field := range eventFields(&item.event) {
+			fields = append(fields, map[string]interface{}{
+				"name":   field.name,
+				"value":  field.value,
+				"inline": field.inline,
+			})
+		}
+
+		embed := map[string]interface{}{
+			"title":  truncate(item.summary, 256),
+			"url":    n.entryURL(item.source, item.entryID),
+			"color":  color,
+			"fields": fields,
+		}
+		if !item.event.Timestamp.IsZero() {
+			embed["timestamp"] = item.event.Timestamp.UTC().Format(time.RFC3339)
+		}
+		embeds = append(embeds, embed)
+	}
+
+	content := fmt.Sprintf("**%s**", matchHeadline(sub, total))
+	if total > uint64(len(items)) {
+		content += fmt.Sprintf(" (showing %d)", len(items))
+	}
+	content += fmt.Sprintf("\n[Unsubscribe](%s)", n.subscriptionURL(sub, "unsubscribe"))
+
+	return map[string]interface{}{
+		"content":          content,
+		"embeds":           embeds,
+		"allowed_mentions": map[string]interface{}{"parse": []string{}},
+	}
+}
+
+// postMatches posts matched entries to the subscription's Slack or Discord webhook
+func (n *Notifier) postMatches(sub *Subscription, items []notificationItem, total uint64) error {
+	if len(items) > maxWebhookItems {
+		items = items[:maxWebhookItems]
+	}
+	if sub.Channel == SubscriptionChannelDiscord {
+		return n.postWebhook(sub.WebhookURL, n.discordMessage(sub, items, total))
+	}
+	return n.postWebhook(sub.WebhookURL, n.slackMessage(sub, items, total))
+}
+
+// postConfirmation posts the confirmation link to the subscription's webhook, so that only
+// members of the channel can enable notifications to it
+func (n *Notifier) postConfirmation(sub *Subscription) error {
+	text := fmt.Sprintf("A ctmon %s subscription to %s %s was created for this channel.", sub.Mode, sub.Kind, sub.Value)
+	confirmURL := n.subscriptionURL(sub, "confirm")
+	if sub.Channel == SubscriptionChannelDiscord {
+		return n.postWebhook(sub.WebhookURL, map[string]interface{}{
+			"content":          fmt.Sprintf("%s [Confirm](%s) to start receiving notifications.", text, confirmURL),
+			"allowed_mentions": map[string]interface{}{"parse": []string{}},
+		})
+	}
+	return n.postWebhook(sub.WebhookURL, map[string]interface{}{
+		"text": fmt.Sprintf("%s <%s|Confirm> to start receiving notifications.", slackEscape(text), confirmURL),
+	})
+}
+
+func (n *Notifier) postWebhook(webhookURL string, message map[string]interface{}) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook message: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.webhooks.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	anomalyWindow          = time.Hour // Issuance is counted per hour of entry timestamp
+	anomalyBaselineAlpha   = 0.3       // Weight of the latest closed hour in an issuer's baseline
+	anomalyMinBaselineHour = 3         // Closed hours an issuer needs before its spikes are flagged
+	anomalyQueueSize       = 1000
+)
+
+// Kinds of issuance anomalies
+const (
+	anomalyDomainBurst = "domain_burst" // Many entries for one registrable domain within an hour
+	anomalyIssuerSpike = "issuer_spike" // An issuer logging a multiple of its usual hourly volume
+)
+
+var metricAnomalies = newCounter("ctmon_ingest_anomalies_total", "Issuance anomalies detected")
+
+// Anomaly is an issuance spike, written to ct_anomalies and posted to -alert_webhook. The text
+// field makes the payload usable as-is with Slack-compatible incoming webhooks.
+type Anomaly struct {
+	Alert       string    `json:"alert"` // Always anomaly, to tell it apart from watchdog alerts
+	Kind        string    `json:"kind"`
+	Log         string    `json:"log"`
+	Subject     string    `json:"subject"` // Registrable domain or issuer DN
+	WindowStart time.Time `json:"window_start"`
+	Count       int64     `json:"count"`
+	Baseline    float64   `json:"baseline"` // Usual hourly volume of the issuer, 0 for domain bursts
+	Threshold   float64   `json:"threshold"`
+	Text        string    `json:"text"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// anomalyCounts are the entries counted in one hour, and the subjects already flagged in it
+type anomalyCounts struct {
+	domains map[string]int64
+	issuers map[string]int64
+	flagged map[string]bool
+}
+
+// issuerBaseline is the exponentially weighted hourly volume of an issuer over closed hours
+type issuerBaseline struct {
+	rate  float64
+	hours int
+}
+
+// AnomalyDetector counts inserted entries per registrable domain and issuer by hour of entry
+// timestamp, so backfills are judged by when certificates were logged, and flags a domain
+// reaching domainThreshold entries in an hour and an issuer exceeding issuerFactor times its
+// baseline (and at least issuerMin entries). Only the latest two hours are counted; entries of
+// older hours, which a log may still include within its merge delay, are ignored.
+type AnomalyDetector struct {
+	logID           string
+	domainThreshold int64
+	issuerFactor    float64
+	issuerMin       int64
+	db              *sql.DB
+	client          *http.Client
+	webhookURL      string // Anomalies are only logged and stored when empty
+
+	mu        sync.Mutex
+	windows   map[int64]*anomalyCounts // By hour start, Unix seconds
+	newest    int64
+	first     int64 // Hour of the first entry, counted from its middle and so left out of baselines
+	baselines map[string]*issuerBaseline
+	queue     chan Anomaly
+}
+
+// NewAnomalyDetector creates a detector for a log. db may be nil to only log anomalies.
+func NewAnomalyDetector(logID string, domainThreshold int64, issuerFactor float64, issuerMin int64, db *sql.DB, client *http.Client, webhookURL string) *AnomalyDetector {
+	return &AnomalyDetector{
+		logID:           logID,
+		domainThreshold: domainThreshold,
+		issuerFactor:    issuerFactor,
+		issuerMin:       issuerMin,
+		db:              db,
+		client:          client,
+		webhookURL:      webhookURL,
+		windows:         make(map[int64]*anomalyCounts),
+		baselines:       make(map[string]*issuerBaseline),
+		queue:           make(chan Anomaly, anomalyQueueSize),
+	}
+}
+
+// RecordInsert counts a successfully inserted batch. A nil detector does nothing.
+func (a *AnomalyDetector) RecordInsert(batch []*CertificateDetails) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, details := range batch {
+		hour := details.EntryTimestamp.Truncate(anomalyWindow).Unix()
+		if a.first == 0 {
+			a.first = hour
+		}
+		if hour > a.newest {
+			a.newest = hour
+			a.closeWindows()
+		}
+		counts := a.windows[hour]
+		if counts == nil {
+			if hour < a.newest-int64(anomalyWindow.Seconds()) {
+				continue
+			}
+			counts = &anomalyCounts{domains: make(map[string]int64), issuers: make(map[string]int64), flagged: make(map[string]bool)}
+			a.windows[hour] = counts
+		}
+
+		// Precerts are skipped so a certificate logged both as precert and as final certificate
+		// counts once
+		names := details.NormalizedNames
+		if details.EntryType == "precert_entry" {
+			names = nil
+		}
+		seen := make(map[string]bool)
+		for _, name := range names {
+			domain := name.RegistrableDomain
+			if domain == "" || seen[domain] {
+				continue
+			}
+			seen[domain] = true
+			counts.domains[domain]++
+			if a.domainThreshold > 0 && counts.domains[domain] >= a.domainThreshold && !counts.flagged[anomalyDomainBurst+domain] {
+				counts.flagged[anomalyDomainBurst+domain] = true
+				a.flag(Anomaly{
+					Kind:        anomalyDomainBurst,
+					Subject:     domain,
+					WindowStart: time.Unix(hour, 0).UTC(),
+					Count:       counts.domains[domain],
+					Threshold:   float64(a.domainThreshold),
+					Text:        fmt.Sprintf("%d entries for %s logged to %s within an hour", counts.domains[domain], domain, a.logID),
+				})
+			}
+		}
+
+		issuer := details.IssuerDN
+		if issuer == "" {
+			continue
+		}
+		counts.issuers[issuer]++
+		baseline := a.baselines[issuer]
+		if a.issuerFactor <= 0 || baseline == nil || baseline.hours < anomalyMinBaselineHour || counts.flagged[anomalyIssuerSpike+issuer] {
+			continue
+		}
+		threshold := max(a.issuerFactor*baseline.rate, float64(a.issuerMin))
+		if float64(counts.issuers[issuer]) > threshold {
+			counts.flagged[anomalyIssuerSpike+issuer] = true
+			a.flag(Anomaly{
+				Kind:        anomalyIssuerSpike,
+				Subject:     issuer,
+				WindowStart: time.Unix(hour, 0).UTC(),
+				Count:       counts.issuers[issuer],
+				Baseline:    baseline.rate,
+				Threshold:   threshold,
+				Text: fmt.Sprintf("%s logged %d entries to %s within an hour, %.1fx its usual %.0f",
+					issuer, counts.issuers[issuer], a.logID, float64(counts.issuers[issuer])/max(baseline.rate, 1), baseline.rate),
+			})
+		}
+	}
+}
+
+// closeWindows folds the hours before the previous one into the issuer baselines and drops them.
+// Issuers that logged nothing in a closed hour count as zero. Called with mu held.
+func (a *AnomalyDetector) closeWindows() {
+	var closed []int64
+	for hour := range a.windows {
+		if hour < a.newest-int64(anomalyWindow.Seconds()) {
+			closed = append(closed, hour)
+		}
+	}
+	slices.Sort(closed)
+	for _, hour := range closed {
+		counts := a.windows[hour]
+		delete(a.windows, hour)
+		if hour <= a.first {
+			continue
+		}
+		for issuer, count := range counts.issuers {
+			if a.baselines[issuer] == nil {
+				a.baselines[issuer] = &issuerBaseline{rate: float64(count)}
+			}
+		}
+		for issuer, baseline := range a.baselines {
+			count := float64(counts.issuers[issuer])
+			if baseline.hours > 0 {
+				baseline.rate = anomalyBaselineAlpha*count + (1-anomalyBaselineAlpha)*baseline.rate
+			}
+			baseline.hours++
+		}
+	}
+}
+
+// flag queues an anomaly for the sinks. Called with mu held.
+func (a *AnomalyDetector) flag(anomaly Anomaly) {
+	anomaly.Alert = "anomaly"
+	anomaly.Log = a.logID
+	anomaly.Timestamp = time.Now().UTC()
+	metricAnomalies.Add(1, "log", a.logID, "kind", anomaly.Kind)
+	log.Printf("ANOMALY %s: %s", anomaly.Kind, anomaly.Text)
+	select {
+	case a.queue <- anomaly:
+	default:
+		log.Printf("Warning: Anomaly queue is full, not recording %s anomaly for %s", anomaly.Kind, anomaly.Subject)
+	}
+}
+
+// Start writes queued anomalies to ct_anomalies and posts them to the webhook until done is closed
+func (a *AnomalyDetector) Start(done <-chan struct{}) {
+	go func() {
+		for {
+			select {
+			case anomaly := <-a.queue:
+				a.record(anomaly)
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+func (a *AnomalyDetector) record(anomaly Anomaly) {
+	if a.db != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		_, err := a.db.ExecContext(ctx, `
+			INSERT INTO ct_anomalies (log_id, kind, subject, window_start, entry_count, baseline, threshold, detected_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			anomaly.Log, anomaly.Kind, anomaly.Subject, anomaly.WindowStart, anomaly.Count, anomaly.Baseline, anomaly.Threshold, anomaly.Timestamp)
+		cancel()
+		if err != nil {
+			log.Printf("Warning: Failed to record anomaly in ct_anomalies: %v", err)
+		}
+	}
+	if a.webhookURL != "" {
+		if err := postWebhook(a.client, a.webhookURL, anomaly); err != nil {
+			log.Printf("Warning: Failed to send anomaly to webhook: %v", err)
+		}
+	}
+}
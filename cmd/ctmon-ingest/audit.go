@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Audit result statuses
+const (
+	AuditOK       = "ok"       // The stored leaf is included in the log at its index
+	AuditMismatch = "mismatch" // The log does not prove the stored leaf at its index
+	AuditSkipped  = "skipped"  // The entry was stored without its raw leaf_input
+	AuditError    = "error"    // The entry could not be checked
+)
+
+// AuditResult is the outcome of auditing one stored entry
+type AuditResult struct {
+	LogIndex int64  `json:"log_index"`
+	Status   string `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// AuditReport summarizes an audit run. Only entries that are not ok are listed.
+type AuditReport struct {
+	Log        string        `json:"log"`
+	TreeSize   int64         `json:"tree_size"`
+	RootHash   string        `json:"root_hash"`
+	Start      int64         `json:"start"`
+	End        int64         `json:"end"`
+	Checked    int           `json:"checked"`
+	OK         int           `json:"ok"`
+	Mismatches int           `json:"mismatches"`
+	Skipped    int           `json:"skipped"`
+	Errors     int           `json:"errors"`
+	Failures   []AuditResult `json:"failures,omitempty"`
+}
+
+// GetProofByHashResponse matches the JSON response from get-proof-by-hash
+type GetProofByHashResponse struct {
+	LeafIndex int64    `json:"leaf_index"`
+	AuditPath []string `json:"audit_path"`
+}
+
+// auditJob is a stored entry waiting to be audited
+type auditJob struct {
+	logIndex  int64
+	leafInput string
+	blobCodec string
+}
+
+// runAudit implements the audit subcommand: for every stored entry of a log in an index range, or
+// a random sample of them, it recomputes the leaf hash from the stored leaf_input and verifies it
+// against a fresh inclusion proof from the log, proving the mirror matches the log
+func runAudit(args []string) {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	logURLFlag := fs.String("log_url", "", "Base URL of the CT log to audit")
+	startFlag := fs.Int64("start", 0, "First log index to audit")
+	endFlag := fs.Int64("end", -1, "Log index to stop before (use -1 for the current tree size)")
+	sampleFlag := fs.Int("sample", 0, "Audit a random sample of this many stored entries in the range (0 audits all)")
+	concurrencyFlag := fs.Int("concurrency", 4, "Number of concurrent get-proof-by-hash requests")
+	maxRequestsPerSecFlag := fs.Float64("max_requests_per_sec", 10, "Maximum get-proof-by-hash requests per second (0 for unlimited)")
+	jsonFlag := fs.Bool("json", false, "Print JSON instead of a table")
+	fs.Parse(args)
+
+	if *logURLFlag == "" {
+		log.Fatal("Error: -log_url is required")
+	}
+	if *startFlag < 0 {
+		log.Fatal("Error: -start must not be negative")
+	}
+	if *sampleFlag < 0 {
+		log.Fatal("Error: -sample must not be negative")
+	}
+	if *concurrencyFlag <= 0 {
+		log.Fatal("Error: -concurrency must be positive")
+	}
+	if *maxRequestsPerSecFlag < 0 {
+		log.Fatal("Error: -max_requests_per_sec must not be negative")
+	}
+
+	parsedLogURL, err := url.Parse(*logURLFlag)
+	if err != nil {
+		log.Fatalf("Error: Invalid -log_url: %v", err)
+	}
+	logID := parsedLogURL.Host + parsedLogURL.Path
+
+	db, err := initClickHouse()
+	if err != nil {
+		log.Fatalf("Failed to initialize ClickHouse connection: %v", err)
+	}
+	defer db.Close()
+
+	client := &http.Client{Timeout: requestTimeout}
+	sth, err := fetchSTH(client, *logURLFlag)
+	if err != nil {
+		log.Fatalf("Failed to fetch STH: %v", err)
+	}
+	rootHash, err := base64.StdEncoding.DecodeString(sth.SHA256RootHash)
+	if err != nil {
+		log.Fatalf("Failed to decode STH root hash: %v", err)
+	}
+
+	// Entries past the STH cannot be proven yet
+	end := sth.TreeSize
+	if *endFlag >= 0 {
+		end = min(*endFlag, sth.TreeSize)
+	}
+	report := AuditReport{Log: *logURLFlag, TreeSize: sth.TreeSize, RootHash: sth.SHA256RootHash, Start: *startFlag, End: end}
+	log.Printf("Auditing %s entries [%d, %d) against tree size %d", logID, *startFlag, end, sth.TreeSize)
+
+	politeness := NewPolitenessLimiter(*maxRequestsPerSecFlag, 0, nil)
+	jobs := make(chan auditJob, *concurrencyFlag)
+	results := make(chan AuditResult, *concurrencyFlag)
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrencyFlag; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				politeness.Wait(0)
+				results <- auditEntry(client, *logURLFlag, sth.TreeSize, rootHash, job)
+			}
+		}()
+	}
+
+	var queryErr error
+	go func() {
+		queryErr = queryAuditEntries(db, logID, *startFlag, end, *sampleFlag, jobs)
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	for result := range results {
+		report.Checked++
+		if report.Checked%10000 == 0 {
+			log.Printf("Audited %d entries", report.Checked)
+		}
+		switch result.Status {
+		case AuditOK:
+			report.OK++
+			continue
+		case AuditMismatch:
+			report.Mismatches++
+		case AuditSkipped:
+			report.Skipped++
+		case AuditError:
+			report.Errors++
+		}
+		report.Failures = append(report.Failures, result)
+	}
+	if queryErr != nil {
+		log.Fatalf("Failed to read stored entries: %v", queryErr)
+	}
+
+	printAuditReport(report, *jsonFlag)
+	if report.Mismatches > 0 || report.Errors > 0 {
+		os.Exit(1)
+	}
+}
+
+// queryAuditEntries sends the stored entries of the log in [start, end) to jobs, in index order or
+// as a random sample of sample entries
+func queryAuditEntries(db *sql.DB, logID string, start, end int64, sample int, jobs chan<- auditJob) error {
+	query := `
+		SELECT log_index, leaf_input, blob_codec
+		FROM ct_log_entries
+		WHERE log_id = ? AND log_index >= ? AND log_index < ?`
+	args := []interface{}{logID, start, end}
+	if sample > 0 {
+		query += " ORDER BY rand() LIMIT 1 BY log_index LIMIT ?"
+		args = append(args, sample)
+	} else {
+		query += " ORDER BY log_index LIMIT 1 BY log_index"
+	}
+
+	rows, err := db.QueryContext(context.Background(), query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query ct_log_entries: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var job auditJob
+		if err := rows.Scan(&job.logIndex, &job.leafInput, &job.blobCodec); err != nil {
+			return fmt.Errorf("failed to scan entry: %w", err)
+		}
+		jobs <- job
+	}
+	return rows.Err()
+}
+
+// auditEntry verifies one stored entry against an inclusion proof for the given tree
+func auditEntry(client *http.Client, logURL string, treeSize int64, rootHash []byte, job auditJob) AuditResult {
+	result := AuditResult{LogIndex: job.logIndex, Status: AuditError}
+	if job.leafInput == "" {
+		result.Status = AuditSkipped
+		result.Detail = "no leaf_input stored"
+		return result
+	}
+
+	leafInput, err := decodeStoredBlob(job.leafInput, job.blobCodec)
+	if err != nil {
+		result.Detail = fmt.Sprintf("failed to decode leaf_input: %v", err)
+		return result
+	}
+	leafHash := merkleLeafHash(leafInput)
+
+	proof, err := fetchProofByHash(client, logURL, leafHash, treeSize)
+	if err != nil {
+		if errors.Is(err, errLeafNotFound) {
+			result.Status = AuditMismatch
+		}
+		result.Detail = err.Error()
+		return result
+	}
+	if proof.LeafIndex != job.logIndex {
+		result.Status = AuditMismatch
+		result.Detail = fmt.Sprintf("log has the leaf at index %d", proof.LeafIndex)
+		return result
+	}
+
+	auditPath := make([][]byte, len(proof.AuditPath))
+	for i, node := range proof.AuditPath {
+		if auditPath[i], err = base64.StdEncoding.DecodeString(node); err != nil {
+			result.Detail = fmt.Sprintf("failed to decode audit path: %v", err)
+			return result
+		}
+	}
+	if err := verifyInclusion(job.logIndex, treeSize, leafHash, auditPath, rootHash); err != nil {
+		result.Status = AuditMismatch
+		result.Detail = err.Error()
+		return result
+	}
+
+	result.Status = AuditOK
+	return result
+}
+
+// errLeafNotFound is returned by fetchProofByHash when the log does not know the leaf hash
+var errLeafNotFound = errors.New("leaf hash not found in log")
+
+func fetchProofByHash(client *http.Client, logURL string, leafHash []byte, treeSize int64) (*GetProofByHashResponse, error) {
+	if !strings.HasSuffix(logURL, "/") {
+		logURL += "/"
+	}
+	apiURL := fmt.Sprintf("%sct/v1/get-proof-by-hash?hash=%s&tree_size=%d",
+		logURL, url.QueryEscape(base64.StdEncoding.EncodeToString(leafHash)), treeSize)
+
+	var lastErr error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(calculateBackoffDelay(attempt - 1))
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to create proof request: %w", err)
+		}
+		req.Header.Set("User-Agent", userAgent)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			cancel()
+			lastErr = fmt.Errorf("failed to get proof from %s: %w", apiURL, err)
+			continue
+		}
+		bodyBytes, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		cancel()
+		if err != nil {
+			lastErr = fmt.Errorf("failed to read proof response: %w", err)
+			continue
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			var proof GetProofByHashResponse
+			if err := json.Unmarshal(bodyBytes, &proof); err != nil {
+				return nil, fmt.Errorf("failed to decode proof response: %w", err)
+			}
+			return &proof, nil
+		case resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusNotFound:
+			return nil, fmt.Errorf("%w: %s", errLeafNotFound, strings.TrimSpace(string(bodyBytes)))
+		default:
+			lastErr = fmt.Errorf("proof request failed with status %s: %s", resp.Status, string(bodyBytes))
+		}
+	}
+	return nil, lastErr
+}
+
+// merkleLeafHash returns the RFC 6962 hash of a leaf
+func merkleLeafHash(leaf []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	h.Write(leaf)
+	return h.Sum(nil)
+}
+
+// merkleNodeHash returns the RFC 6962 hash of an interior node
+func merkleNodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// verifyInclusion checks an RFC 9162 (section 2.1.3.2) inclusion proof of the leaf at index in a
+// tree of treeSize leaves with the given root hash
+func verifyInclusion(index, treeSize int64, leafHash []byte, proof [][]byte, rootHash []byte) error {
+	if index < 0 || index >= treeSize {
+		return fmt.Errorf("index %d is outside the tree of size %d", index, treeSize)
+	}
+
+	fn, sn := index, treeSize-1
+	r := leafHash
+	for _, p := range proof {
+		if sn == 0 {
+			return fmt.Errorf("inclusion proof has %d extra nodes", len(proof))
+		}
+		if fn&1 == 1 || fn == sn {
+			r = merkleNodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = merkleNodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return fmt.Errorf("inclusion proof is too short")
+	}
+	if !bytes.Equal(r, rootHash) {
+		return fmt.Errorf("inclusion proof does not match the root hash")
+	}
+	return nil
+}
+
+func printAuditReport(report AuditReport, asJSON bool) {
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+		return
+	}
+
+	if len(report.Failures) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "LOG INDEX\tSTATUS\tDETAIL")
+		for _, f := range report.Failures {
+			fmt.Fprintf(w, "%d\t%s\t%s\n", f.LogIndex, f.Status, f.Detail)
+		}
+		w.Flush()
+		fmt.Println()
+	}
+	fmt.Printf("%s [%d, %d) at tree size %d: %d checked, %d ok, %d mismatches, %d skipped, %d errors\n",
+		report.Log, report.Start, report.End, report.TreeSize, report.Checked, report.OK, report.Mismatches, report.Skipped, report.Errors)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+)
+
+var metricAuditPaths = newCounter("ctmon_ingest_audit_paths_total", "Audit paths fetched with -record_audit_path, by result (recorded, failed, mismatch)")
+
+// AuditPathRecorder fetches the audit path of every parsed entry with get-proof-by-hash at the
+// tree size of the latest verified STH, verifies it against that STH's root hash and keeps it with
+// the entry. This costs one request per entry, so it goes through the politeness budget. An entry
+// whose path cannot be fetched or does not verify is still stored, without a path. A nil recorder
+// records nothing.
+type AuditPathRecorder struct {
+	client      *http.Client
+	logURL      string
+	logID       string
+	concurrency int
+	politeness  *PolitenessLimiter
+	sths        *TreeHeadTracker
+}
+
+// NewAuditPathRecorder creates a recorder fetching up to concurrency proofs at a time
+func NewAuditPathRecorder(client *http.Client, logURL, logID string, concurrency int, politeness *PolitenessLimiter, sths *TreeHeadTracker) *AuditPathRecorder {
+	return &AuditPathRecorder{client: client, logURL: logURL, logID: logID, concurrency: concurrency, politeness: politeness, sths: sths}
+}
+
+// Record sets AuditPath and AuditPathTreeSize of the successfully parsed entries
+func (r *AuditPathRecorder) Record(parsed []parseResult) {
+	if r == nil {
+		return
+	}
+	sth := r.sths.Latest()
+	if sth == nil {
+		return
+	}
+	rootHash, err := base64.StdEncoding.DecodeString(sth.SHA256RootHash)
+	if err != nil {
+		log.Printf("Warning: Cannot record audit paths, invalid STH root hash: %v", err)
+		return
+	}
+
+	sem := make(chan struct{}, r.concurrency)
+	var wg sync.WaitGroup
+	for _, result := range parsed {
+		details := result.details
+		if result.err != nil || details.LeafHash == "" || details.LogIndex >= sth.TreeSize {
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			if err := r.record(details, sth.TreeSize, rootHash); err != nil {
+				log.Printf("Warning: No audit path recorded for index %d: %v", details.LogIndex, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// record fetches, verifies and stores the audit path of one entry
+func (r *AuditPathRecorder) record(details *CertificateDetails, treeSize int64, rootHash []byte) error {
+	leafHash, err := hex.DecodeString(details.LeafHash)
+	if err != nil {
+		return err
+	}
+
+	r.politeness.Wait(0)
+	proof, err := fetchProofByHash(r.client, r.logURL, leafHash, treeSize)
+	if err != nil {
+		metricAuditPaths.Add(1, "log", r.logID, "result", "failed")
+		return err
+	}
+
+	path := make([][]byte, len(proof.AuditPath))
+	hexPath := make([]string, len(proof.AuditPath))
+	for i, node := range proof.AuditPath {
+		if path[i], err = base64.StdEncoding.DecodeString(node); err != nil {
+			metricAuditPaths.Add(1, "log", r.logID, "result", "failed")
+			return fmt.Errorf("invalid audit path node: %w", err)
+		}
+		hexPath[i] = hex.EncodeToString(path[i])
+	}
+	if proof.LeafIndex != details.LogIndex {
+		metricAuditPaths.Add(1, "log", r.logID, "result", "mismatch")
+		return fmt.Errorf("log proves the leaf at index %d", proof.LeafIndex)
+	}
+	if err := verifyInclusion(details.LogIndex, treeSize, leafHash, path, rootHash); err != nil {
+		metricAuditPaths.Add(1, "log", r.logID, "result", "mismatch")
+		return err
+	}
+
+	details.AuditPath = hexPath
+	details.AuditPathTreeSize = treeSize
+	metricAuditPaths.Add(1, "log", r.logID, "result", "recorded")
+	return nil
+}
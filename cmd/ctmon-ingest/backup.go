@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	backupFormatVersion = 1                // Version of the object layout and manifest, checked by restore
+	backupQueryTimeout  = 60 * time.Minute // Timeout of the export or import of each chunk
+)
+
+// Environment variables holding the object storage credentials of backup and restore. Without
+// them, ClickHouse uses its own (e.g. use_environment_credentials) or anonymous access.
+const (
+	backupAccessKeyEnv = "CTMON_BACKUP_ACCESS_KEY_ID"
+	backupSecretKeyEnv = "CTMON_BACKUP_SECRET_ACCESS_KEY"
+)
+
+// backupManifestStructure is the structure of the manifest objects for the s3 table function
+const backupManifestStructure = "format_version UInt32, table String, log String, start_index Int64, end_index Int64, rows UInt64, columns Array(String), object String, created_at DateTime"
+
+// backupLogKey matches the characters of a log ID or tree ID replaced in object keys (log IDs hold
+// slashes)
+var backupLogKey = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// backupTables are the tables backup and restore handle, with the column holding the log their
+// log_index is within. Derived tables (by name, public keys, identities, rollups) are filled again
+// by their materialized views when the entries are restored.
+var backupTables = map[string]string{
+	"ct_log_entries":    "log_id",
+	"rekor_log_entries": "tree_id",
+}
+
+// backupManifest describes one chunk of a backup, stored as a JSON object next to it
+type backupManifest struct {
+	FormatVersion uint32
+	Table         string
+	Log           string
+	StartIndex    int64 // First log index of the chunk
+	EndIndex      int64 // Log index the chunk stops before
+	Rows          uint64
+	Columns       []string
+	Object        string // URL of the Native, zstd compressed rows
+}
+
+// backupLocation is where the chunks of one log of one table are stored:
+// <url>/<table>/<log key>/<start>-<end>.native.zst with manifests under manifest/
+type backupLocation struct {
+	prefix    string
+	accessKey string
+	secretKey string
+}
+
+// newBackupLocation returns the location of the chunks of logID of table under url
+func newBackupLocation(url, table, logID string) backupLocation {
+	return backupLocation{
+		prefix:    strings.TrimSuffix(url, "/") + "/" + table + "/" + backupLogKey.ReplaceAllString(logID, "_"),
+		accessKey: os.Getenv(backupAccessKeyEnv),
+		secretKey: os.Getenv(backupSecretKeyEnv),
+	}
+}
+
+// chunkName is the object name of the chunk from start to end, zero padded to sort by index
+func chunkName(start, end int64) string {
+	return fmt.Sprintf("%012d-%012d", start, end)
+}
+
+// s3 returns an s3 table function call reading or writing url in format, with its arguments
+func (l backupLocation) s3(url, format string, extra ...string) (string, []interface{}) {
+	args := []interface{}{url}
+	call := "s3(?"
+	if l.accessKey != "" {
+		call += ", ?, ?"
+		args = append(args, l.accessKey, l.secretKey)
+	}
+	call += ", ?"
+	args = append(args, format)
+	for _, e := range extra {
+		call += ", ?"
+		args = append(args, e)
+	}
+	return call + ")", args
+}
+
+// manifests returns the manifests of the chunks at the location in index order, or none if
+// nothing was backed up there yet
+func (l backupLocation) manifests(ctx context.Context, db *sql.DB) ([]backupManifest, error) {
+	call, args := l.s3(l.prefix+"/manifest/*.json", "JSONEachRow", backupManifestStructure)
+	rows, err := db.QueryContext(ctx, "SELECT format_version, table, log, start_index, end_index, rows, columns, object FROM "+call+" ORDER BY start_index", args...)
+	if err != nil {
+		// Globs matching no object fail
+		if code := clickHouseErrorCode(err); code == chFileDoesntExist || code == chCannotExtractTableStructure {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read manifests: %w", err)
+	}
+	defer rows.Close()
+	var manifests []backupManifest
+	for rows.Next() {
+		var m backupManifest
+		if err := rows.Scan(&m.FormatVersion, &m.Table, &m.Log, &m.StartIndex, &m.EndIndex, &m.Rows, &m.Columns, &m.Object); err != nil {
+			return nil, fmt.Errorf("failed to scan manifest: %w", err)
+		}
+		manifests = append(manifests, m)
+	}
+	return manifests, rows.Err()
+}
+
+// insertableColumns returns the columns of table a SELECT * returns and an INSERT accepts, in
+// table order
+func insertableColumns(ctx context.Context, db *sql.DB, table string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT name FROM system.columns
+		WHERE database = currentDatabase() AND table = ? AND default_kind NOT IN ('MATERIALIZED', 'ALIAS', 'EPHEMERAL')
+		ORDER BY position`, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan columns of %s: %w", table, err)
+		}
+		columns = append(columns, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %s does not exist", table)
+	}
+	return columns, nil
+}
+
+// parseBackupTable validates -table and returns its log column
+func parseBackupTable(table string) string {
+	logColumn, ok := backupTables[table]
+	if !ok {
+		log.Fatalf("Error: Invalid -table %q (expected ct_log_entries or rekor_log_entries)", table)
+	}
+	return logColumn
+}
+
+// runBackup implements the backup subcommand: it exports the entries of one log of a table to
+// object storage in chunks of log index ranges, each with a manifest, through the s3 table
+// function of ClickHouse. Without -start it continues after the last chunk already there, so
+// running it periodically keeps an incremental backup.
+func runBackup(args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	urlFlag := fs.String("url", "", "Object storage URL to back up to, e.g. https://bucket.s3.amazonaws.com/ctmon (credentials from env "+backupAccessKeyEnv+" and "+backupSecretKeyEnv+")")
+	tableFlag := fs.String("table", "ct_log_entries", "Table to back up: ct_log_entries or rekor_log_entries")
+	logFlag := fs.String("log", "", "Log ID (ct_log_entries) or tree ID (rekor_log_entries) to back up (required)")
+	startFlag := fs.Int64("start", -1, "First log index to back up (use -1 to continue after the last backed up chunk)")
+	endFlag := fs.Int64("end", -1, "Log index to stop before (use -1 for the end of the stored entries)")
+	chunkSizeFlag := fs.Int64("chunk_size", 1_000_000, "Log indexes per backup object")
+	fs.Parse(args)
+
+	if *urlFlag == "" || *logFlag == "" {
+		log.Fatal("Error: -url and -log are required")
+	}
+	if *chunkSizeFlag <= 0 {
+		log.Fatal("Error: -chunk_size must be positive")
+	}
+	logColumn := parseBackupTable(*tableFlag)
+
+	db, err := initClickHouse()
+	if err != nil {
+		log.Fatalf("Failed to initialize ClickHouse connection: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	location := newBackupLocation(*urlFlag, *tableFlag, *logFlag)
+	columns, err := insertableColumns(ctx, db, *tableFlag)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	start := *startFlag
+	var refreshed uint64
+	if start < 0 {
+		manifests, err := location.manifests(ctx, db)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		start = 0
+		if len(manifests) > 0 {
+			start = manifests[len(manifests)-1].EndIndex
+		}
+		// Entries in flight or spooled when a chunk was written can be inserted later, so chunks
+		// with holes are written again once the table has more of their entries
+		for _, m := range manifests {
+			if m.Rows >= uint64(m.EndIndex-m.StartIndex) {
+				continue
+			}
+			present, err := countChunkEntries(ctx, db, *tableFlag, logColumn, *logFlag, m.StartIndex, m.EndIndex)
+			if err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			if present <= m.Rows {
+				continue
+			}
+			log.Printf("Chunk %d to %d has %d entries now, %d when it was backed up; backing it up again", m.StartIndex, m.EndIndex, present, m.Rows)
+			rows, err := backupChunk(ctx, db, location, *tableFlag, logColumn, *logFlag, columns, m.StartIndex, m.EndIndex)
+			if err != nil {
+				log.Fatalf("Backup of indexes %d to %d failed: %v", m.StartIndex, m.EndIndex, err)
+			}
+			refreshed += rows
+		}
+	}
+	end := *endFlag
+	if end < 0 {
+		if err := db.QueryRowContext(ctx, "SELECT toInt64(if(count() = 0, 0, max(log_index) + 1)) FROM "+*tableFlag+" WHERE "+logColumn+" = ?", *logFlag).Scan(&end); err != nil {
+			log.Fatalf("Failed to query the last log index: %v", err)
+		}
+	}
+	if start >= end {
+		if refreshed > 0 {
+			log.Printf("Backed up %d entries of chunks with holes again", refreshed)
+		}
+		log.Printf("Nothing to back up: %s of %s is backed up to index %d", *tableFlag, *logFlag, start)
+		return
+	}
+
+	log.Printf("Backing up %s of %s from index %d to %d to %s", *tableFlag, *logFlag, start, end, location.prefix)
+	began := time.Now()
+	total := refreshed
+	for chunkStart := start; chunkStart < end; chunkStart += *chunkSizeFlag {
+		chunkEnd := min(chunkStart+*chunkSizeFlag, end)
+		rows, err := backupChunk(ctx, db, location, *tableFlag, logColumn, *logFlag, columns, chunkStart, chunkEnd)
+		if err != nil {
+			log.Fatalf("Backup of indexes %d to %d failed: %v", chunkStart, chunkEnd, err)
+		}
+		total += rows
+		log.Printf("Backed up %d entries of indexes %d to %d", rows, chunkStart, chunkEnd)
+	}
+	log.Printf("Backed up %d entries in %v", total, time.Since(began).Round(time.Second))
+}
+
+// backupChunk writes the entries from start to end, one row per log index, and then the manifest
+// of the chunk, so a chunk without manifest is never restored. It returns the number of entries.
+func backupChunk(ctx context.Context, db *sql.DB, location backupLocation, table, logColumn, logID string, columns []string, start, end int64) (uint64, error) {
+	ctx, cancel := context.WithTimeout(ctx, backupQueryTimeout)
+	defer cancel()
+
+	rows, err := countChunkEntries(ctx, db, table, logColumn, logID, start, end)
+	if err != nil {
+		return 0, err
+	}
+	where := " FROM " + table + " WHERE " + logColumn + " = ? AND log_index >= ? AND log_index < ?"
+
+	object := location.prefix + "/" + chunkName(start, end) + ".native.zst"
+	call, args := location.s3(object, "Native")
+	args = append(args, logID, start, end)
+	query := "INSERT INTO FUNCTION " + call + " SETTINGS s3_truncate_on_insert = 1 SELECT " + strings.Join(columns, ", ") + where +
+		" ORDER BY log_index LIMIT 1 BY log_index"
+	if _, err := db.ExecContext(ctx, query, args...); err != nil {
+		return 0, fmt.Errorf("failed to write %s: %w", object, err)
+	}
+
+	call, args = location.s3(location.prefix+"/manifest/"+chunkName(start, end)+".json", "JSONEachRow", backupManifestStructure)
+	args = append(args, backupFormatVersion, table, logID, start, end, rows, columns, object, time.Now().UTC())
+	if _, err := db.ExecContext(ctx, "INSERT INTO FUNCTION "+call+" SETTINGS s3_truncate_on_insert = 1 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", args...); err != nil {
+		return 0, fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return rows, nil
+}
+
+// countChunkEntries returns the number of distinct log indexes of logID from start to end in table
+func countChunkEntries(ctx context.Context, db *sql.DB, table, logColumn, logID string, start, end int64) (uint64, error) {
+	var rows uint64
+	if err := db.QueryRowContext(ctx, "SELECT uniqExact(log_index) FROM "+table+" WHERE "+logColumn+" = ? AND log_index >= ? AND log_index < ?", logID, start, end).Scan(&rows); err != nil {
+		return 0, fmt.Errorf("failed to count entries: %w", err)
+	}
+	return rows, nil
+}
+
+// runRestore implements the restore subcommand: it imports the chunks backup wrote for one log of
+// a table, e.g. into a new cluster with the schema applied. Columns the table no longer has are
+// dropped and columns it gained get their defaults, so a backup can be restored into a newer
+// schema. Chunks whose entries are all in the table already are skipped, so an interrupted
+// restore can be run again.
+func runRestore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	urlFlag := fs.String("url", "", "Object storage URL backed up to (credentials from env "+backupAccessKeyEnv+" and "+backupSecretKeyEnv+")")
+	tableFlag := fs.String("table", "ct_log_entries", "Table to restore: ct_log_entries or rekor_log_entries")
+	logFlag := fs.String("log", "", "Log ID (ct_log_entries) or tree ID (rekor_log_entries) to restore (required)")
+	startFlag := fs.Int64("start", 0, "Skip chunks ending at or before this log index")
+	endFlag := fs.Int64("end", -1, "Skip chunks starting at or after this log index (use -1 for no upper bound)")
+	dryRunFlag := fs.Bool("dry_run", false, "Only list the chunks that would be restored")
+	fs.Parse(args)
+
+	if *urlFlag == "" || *logFlag == "" {
+		log.Fatal("Error: -url and -log are required")
+	}
+	logColumn := parseBackupTable(*tableFlag)
+
+	db, err := initClickHouse()
+	if err != nil {
+		log.Fatalf("Failed to initialize ClickHouse connection: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	location := newBackupLocation(*urlFlag, *tableFlag, *logFlag)
+	manifests, err := location.manifests(ctx, db)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if len(manifests) == 0 {
+		log.Fatalf("Error: No backup of %s of %s at %s", *tableFlag, *logFlag, location.prefix)
+	}
+	columns, err := insertableColumns(ctx, db, *tableFlag)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	began := time.Now()
+	var restored, skipped uint64
+	for _, m := range manifests {
+		if m.EndIndex <= *startFlag || (*endFlag >= 0 && m.StartIndex >= *endFlag) {
+			continue
+		}
+		if m.FormatVersion > backupFormatVersion {
+			log.Fatalf("Error: Chunk %d to %d has backup format version %d, this version reads up to %d", m.StartIndex, m.EndIndex, m.FormatVersion, backupFormatVersion)
+		}
+		if m.Table != *tableFlag || m.Log != *logFlag {
+			log.Fatalf("Error: Chunk %d to %d holds %s of %s", m.StartIndex, m.EndIndex, m.Table, m.Log)
+		}
+		if *dryRunFlag {
+			log.Printf("Would restore %d entries of indexes %d to %d from %s", m.Rows, m.StartIndex, m.EndIndex, m.Object)
+			continue
+		}
+		done, err := restoreChunk(ctx, db, location, m, logColumn, columns)
+		if err != nil {
+			log.Fatalf("Restore of indexes %d to %d failed: %v", m.StartIndex, m.EndIndex, err)
+		}
+		if !done {
+			skipped += m.Rows
+			continue
+		}
+		restored += m.Rows
+		log.Printf("Restored %d entries of indexes %d to %d", m.Rows, m.StartIndex, m.EndIndex)
+	}
+	log.Printf("Restored %d entries (%d already present) in %v", restored, skipped, time.Since(began).Round(time.Second))
+}
+
+// restoreChunk inserts the entries of a chunk unless the table holds them all already, and
+// returns whether it did
+func restoreChunk(ctx context.Context, db *sql.DB, location backupLocation, m backupManifest, logColumn string, columns []string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, backupQueryTimeout)
+	defer cancel()
+
+	var present uint64
+	if err := db.QueryRowContext(ctx, "SELECT uniqExact(log_index) FROM "+m.Table+" WHERE "+logColumn+" = ? AND log_index >= ? AND log_index < ?",
+		m.Log, m.StartIndex, m.EndIndex).Scan(&present); err != nil {
+		return false, fmt.Errorf("failed to count stored entries: %w", err)
+	}
+	if present >= m.Rows {
+		return false, nil
+	}
+
+	backedUp := make(map[string]bool, len(m.Columns))
+	for _, column := range m.Columns {
+		backedUp[column] = true
+	}
+	var common []string
+	for _, column := range columns {
+		if backedUp[column] {
+			common = append(common, column)
+		}
+	}
+	if len(common) < len(m.Columns) {
+		log.Printf("Warning: %d backed up columns of chunk %d to %d are not in %s and are dropped", len(m.Columns)-len(common), m.StartIndex, m.EndIndex, m.Table)
+	}
+
+	call, args := location.s3(m.Object, "Native")
+	list := strings.Join(common, ", ")
+	if _, err := db.ExecContext(ctx, "INSERT INTO "+m.Table+" ("+list+") SELECT "+list+" FROM "+call, args...); err != nil {
+		return false, fmt.Errorf("failed to insert %s: %w", m.Object, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	metricBudgetPaused = newGauge("ctmon_ingest_budget_paused", "1 while fetching is paused until the next window, by the budget used up (max_requests_per_hour or max_bytes_per_day)")
+	metricBudgetWait   = newCounter("ctmon_ingest_budget_wait_seconds_total", "Time fetches spent paused for the -max_requests_per_hour and -max_bytes_per_day budgets")
+)
+
+// RequestBudget caps the requests to the log per clock hour and the response bytes received from
+// it per UTC day. Once a budget is used up, requests wait for the next hour or day, so ingestion
+// pauses and resumes by itself. Bytes are those counted by the UsageTracker, earlier runs of the
+// day included; requests are counted as they are admitted, by this process only. A nil
+// RequestBudget does not limit.
+type RequestBudget struct {
+	requestsPerHour uint64 // 0 is unlimited
+	bytesPerDay     uint64 // 0 is unlimited
+	usage           *UsageTracker
+	stop            chan struct{} // Closed at shutdown, releasing paused requests
+
+	mu           sync.Mutex
+	hour         time.Time
+	hourRequests uint64
+	paused       string // Budget used up, empty while not paused
+}
+
+// NewRequestBudget returns nil if both budgets are 0 (unlimited)
+func NewRequestBudget(requestsPerHour, bytesPerDay uint64, usage *UsageTracker) *RequestBudget {
+	if requestsPerHour == 0 && bytesPerDay == 0 {
+		return nil
+	}
+	return &RequestBudget{requestsPerHour: requestsPerHour, bytesPerDay: bytesPerDay, usage: usage, stop: make(chan struct{})}
+}
+
+// Start releases paused requests once done is closed, so shutdown does not wait for the next
+// window
+func (b *RequestBudget) Start(done <-chan struct{}) {
+	if b == nil {
+		return
+	}
+	go func() {
+		<-done
+		close(b.stop)
+	}()
+}
+
+// Wait blocks while a budget is used up, until the window it is counted in has passed, then
+// counts one request. It returns at once after shutdown.
+func (b *RequestBudget) Wait() {
+	if b == nil {
+		return
+	}
+	for {
+		now := time.Now().UTC()
+		var budget string
+		var until time.Time
+
+		b.mu.Lock()
+		if hour := now.Truncate(time.Hour); !hour.Equal(b.hour) {
+			b.hour, b.hourRequests = hour, 0
+		}
+		switch {
+		case b.requestsPerHour > 0 && b.hourRequests >= b.requestsPerHour:
+			budget, until = "max_requests_per_hour", b.hour.Add(time.Hour)
+		case b.bytesPerDay > 0 && b.usage.DayBytes() >= b.bytesPerDay:
+			budget, until = "max_bytes_per_day", now.Truncate(24*time.Hour).Add(24*time.Hour)
+		}
+		if budget == "" {
+			b.hourRequests++
+			if b.paused != "" {
+				log.Printf("Resuming fetching, the -%s window has passed", b.paused)
+				metricBudgetPaused.Set(0, "budget", b.paused)
+				b.paused = ""
+			}
+			b.mu.Unlock()
+			return
+		}
+		if b.paused != budget {
+			log.Printf("Pausing fetching: the -%s budget is used up, resuming at %s", budget, until.Format(time.RFC3339))
+			if b.paused != "" {
+				metricBudgetPaused.Set(0, "budget", b.paused)
+			}
+			metricBudgetPaused.Set(1, "budget", budget)
+			b.paused = budget
+		}
+		b.mu.Unlock()
+
+		start := time.Now()
+		select {
+		case <-time.After(time.Until(until)):
+			metricBudgetWait.Add(time.Since(start).Seconds())
+		case <-b.stop:
+			metricBudgetWait.Add(time.Since(start).Seconds())
+			return
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// claimLeaseExpiry sets expiry to the server time plus the lease TTL ARGV[2] in milliseconds, so
+// leases do not depend on the clocks of the replicas
+const claimLeaseExpiry = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local expiry = now + tonumber(ARGV[2])
+`
+
+// claimRangeScript claims a range for ARGV[1]: the pending range whose lease expired first, or
+// else the next unclaimed range of ARGV[3] entries below ARGV[4]. The next unclaimed index starts
+// at ARGV[5]. It returns the range as "<start>-<end>", or nil once the log is fully claimed. The
+// next unclaimed index only advances by a claimed range, truncated at the limit, so ranges between
+// the limit and a larger later limit (a grown tree) are claimed once that limit is used. Finding
+// an abandoned range is a lookup by score, so claims stay cheap however many ranges are pending.
+//
+// KEYS[1]: sorted set of pending ranges (claimed but not complete), scored by lease expiry in ms
+// KEYS[2]: next unclaimed index
+// KEYS[3]: hash of the replica holding each pending range
+// ARGV[1]: replica
+// ARGV[2]: lease TTL in milliseconds
+// ARGV[3]: range size
+// ARGV[4]: limit, the tree size
+// ARGV[5]: first index to claim if no range of the log was claimed yet
+const claimRangeScript = claimLeaseExpiry + `
+local r = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', now, 'LIMIT', 0, 1)[1]
+if not r then
+	redis.call('SET', KEYS[2], ARGV[5], 'NX')
+	local size, limit = tonumber(ARGV[3]), tonumber(ARGV[4])
+	local start = tonumber(redis.call('GET', KEYS[2]))
+	if start >= limit then
+		return false
+	end
+	local stop = math.min(start + size, limit)
+	redis.call('SET', KEYS[2], stop)
+	r = start .. '-' .. (stop - 1)
+end
+redis.call('ZADD', KEYS[1], expiry, r)
+redis.call('HSET', KEYS[3], r, ARGV[1])
+return r`
+
+// renewLeaseScript extends the lease on the pending range ARGV[3] by ARGV[2] milliseconds if it is
+// still held by ARGV[1], returning 0 otherwise
+//
+// KEYS[1]: sorted set of pending ranges, KEYS[2]: hash of their holders
+const renewLeaseScript = claimLeaseExpiry + `
+if redis.call('HGET', KEYS[2], ARGV[3]) ~= ARGV[1] or not redis.call('ZSCORE', KEYS[1], ARGV[3]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], 'XX', expiry, ARGV[3])
+return 1`
+
+// releaseClaimScript removes the range ARGV[3] from the pending ranges as complete if ARGV[2] is
+// "1", and otherwise ends its lease at once if it is still held by ARGV[1], so the next replica
+// looking for work takes it over
+//
+// KEYS[1]: sorted set of pending ranges, KEYS[2]: hash of their holders
+const releaseClaimScript = `
+if ARGV[2] == '1' then
+	redis.call('ZREM', KEYS[1], ARGV[3])
+	redis.call('HDEL', KEYS[2], ARGV[3])
+elseif redis.call('HGET', KEYS[2], ARGV[3]) == ARGV[1] then
+	redis.call('ZADD', KEYS[1], 'XX', 0, ARGV[3])
+	redis.call('HDEL', KEYS[2], ARGV[3])
+end
+return 0`
+
+// claimKey names a claim key of the log. The hash tag puts all of them in one Redis Cluster slot,
+// as the claim scripts access several of them.
+func (c *Coordinator) claimKey(name string) string {
+	return "ctmon:{" + c.logID + "}:claims:" + name
+}
+
+// claimedRange is a range of the log leased by this replica
+type claimedRange struct {
+	name   string // "<start>-<end>", the member in the pending set
+	start  int64
+	end    int64
+	target int64 // Entries sent to the inserter once the range was fetched, -1 while fetching
+}
+
+// EnableClaims makes the replica work on ranges of size entries claimed in Redis instead of
+// following a cursor, so several replicas can backfill disjoint parts of the same log. Claims are
+// leased for ttl and renewed while the replica works on them; the ranges of a replica that stops
+// renewing are taken over by the next replica looking for work.
+func (c *Coordinator) EnableClaims(size int64, ttl time.Duration) {
+	c.claimSize = size
+	c.claimTTL = ttl
+}
+
+// Claiming reports whether EnableClaims was called
+func (c *Coordinator) Claiming() bool {
+	return c != nil && c.claimSize > 0
+}
+
+// ClaimRange claims the next range to fetch: an abandoned range of another replica, or else the
+// next unclaimed range below limit, starting at startIndex if no replica has claimed a range of
+// the log yet. ok is false once every range below limit is claimed.
+func (c *Coordinator) ClaimRange(startIndex, limit int64) (start, end int64, ok bool, err error) {
+	reply, err := c.redis.Do("EVAL", claimRangeScript, "3", c.claimKey("pending"), c.claimKey("next"), c.claimKey("holders"),
+		c.replica, strconv.FormatInt(c.claimTTL.Milliseconds(), 10),
+		strconv.FormatInt(c.claimSize, 10), strconv.FormatInt(limit, 10), strconv.FormatInt(startIndex, 10))
+	if err != nil || reply == nil {
+		return 0, 0, false, err
+	}
+	name, _ := reply.(string)
+	if _, err := fmt.Sscanf(name, "%d-%d", &start, &end); err != nil {
+		return 0, 0, false, fmt.Errorf("invalid claimed range %q", name)
+	}
+
+	c.claimMu.Lock()
+	c.claims = append(c.claims, &claimedRange{name: name, start: start, end: end, target: -1})
+	c.claimMu.Unlock()
+	return start, end, true, nil
+}
+
+// FinishRange marks the most recently claimed range as fetched once sent entries in total have
+// been handed to the inserter. It is complete when that many entries are inserted.
+func (c *Coordinator) FinishRange(sent int64) {
+	c.claimMu.Lock()
+	defer c.claimMu.Unlock()
+	if len(c.claims) > 0 && c.claims[len(c.claims)-1].target < 0 {
+		c.claims[len(c.claims)-1].target = sent
+	}
+}
+
+// renewClaims completes the claimed ranges whose entries are all inserted and renews the leases
+// of the others
+func (c *Coordinator) renewClaims() {
+	c.claimMu.Lock()
+	defer c.claimMu.Unlock()
+
+	inserted := c.insertedEntries.Load()
+	ttl := strconv.FormatInt(c.claimTTL.Milliseconds(), 10)
+	remaining := c.claims[:0]
+	for _, claim := range c.claims {
+		if claim.target >= 0 && inserted >= claim.target {
+			if err := c.releaseClaim(claim, true); err != nil {
+				log.Printf("Warning: Failed to complete claimed range %s in redis: %v", claim.name, err)
+				remaining = append(remaining, claim)
+			} else {
+				log.Printf("Completed claimed range %s", claim.name)
+			}
+			continue
+		}
+		reply, err := c.redis.Do("EVAL", renewLeaseScript, "2", c.claimKey("pending"), c.claimKey("holders"), c.replica, ttl, claim.name)
+		if err != nil {
+			log.Printf("Warning: Failed to renew claim on range %s: %v", claim.name, err)
+		} else if renewed, _ := reply.(int64); renewed == 0 {
+			log.Printf("Warning: Lost the claim on range %s to another replica, entries may be inserted twice", claim.name)
+		}
+		remaining = append(remaining, claim)
+	}
+	c.claims = remaining
+}
+
+// releaseClaims completes the ranges whose entries are all inserted and gives up the others
+func (c *Coordinator) releaseClaims() {
+	c.claimMu.Lock()
+	defer c.claimMu.Unlock()
+
+	inserted := c.insertedEntries.Load()
+	for _, claim := range c.claims {
+		complete := claim.target >= 0 && inserted >= claim.target
+		if err := c.releaseClaim(claim, complete); err != nil {
+			log.Printf("Warning: Failed to release claimed range %s in redis, it is taken over once its lease expires: %v", claim.name, err)
+		} else if complete {
+			log.Printf("Completed claimed range %s", claim.name)
+		} else {
+			log.Printf("Released incomplete claimed range %s for other replicas", claim.name)
+		}
+	}
+	c.claims = nil
+}
+
+func (c *Coordinator) releaseClaim(claim *claimedRange, complete bool) error {
+	completeArg := "0"
+	if complete {
+		completeArg = "1"
+	}
+	_, err := c.redis.Do("EVAL", releaseClaimScript, "2", c.claimKey("pending"), c.claimKey("holders"),
+		c.replica, completeArg, claim.name)
+	return err
+}
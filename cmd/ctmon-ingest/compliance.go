@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// defaultMMD is the maximum merge delay assumed for logs missing from ct_log_lists
+const defaultMMD = 24 * time.Hour
+
+// logCompliance holds the root program policy metrics of one log on one day
+type logCompliance struct {
+	LogID              string
+	Day                time.Time
+	MMD                int64 // Seconds
+	GetSTHRequests     uint64
+	GetSTHOK           uint64
+	GetSTHUptime       float64
+	GetEntriesRequests uint64
+	GetEntriesOK       uint64
+	GetEntriesUptime   float64
+	STHCount           uint64
+	STHMaxAge          int64 // Seconds
+	EntriesChecked     uint64
+	MaxMergeDelay      int64 // Seconds
+	P99MergeDelay      float64
+	EntriesOverMMD     uint64
+}
+
+// runCompliance implements the compliance subcommand: it derives the metrics root programs hold
+// logs to (endpoint uptime, STH freshness, MMD adherence) per log and day from ct_log_health and
+// ct_log_entries, and writes them to ct_log_compliance
+func runCompliance(args []string) {
+	fs := flag.NewFlagSet("compliance", flag.ExitOnError)
+	daysFlag := fs.Int("days", 7, "Number of days to (re)compute, ending today")
+	intervalFlag := fs.Duration("interval", 0, "Interval between runs (0 runs once)")
+	fs.Parse(args)
+
+	if *daysFlag <= 0 || *intervalFlag < 0 {
+		log.Fatal("Error: -days must be positive and -interval non-negative")
+	}
+
+	db, err := initClickHouse()
+	if err != nil {
+		log.Fatalf("Failed to initialize ClickHouse connection: %v", err)
+	}
+	defer db.Close()
+
+	for {
+		to := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+		from := to.AddDate(0, 0, -*daysFlag)
+		if err := computeCompliance(db, from, to); err != nil {
+			if *intervalFlag == 0 {
+				log.Fatalf("Error: %v", err)
+			}
+			log.Printf("Warning: Compliance computation failed: %v", err)
+		}
+		if *intervalFlag == 0 {
+			return
+		}
+		time.Sleep(*intervalFlag)
+	}
+}
+
+// computeCompliance writes a ct_log_compliance row per log and day from from to to
+func computeCompliance(db *sql.DB, from, to time.Time) error {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	mmds, err := logListMMDs(ctx, db)
+	if err != nil {
+		return err
+	}
+	// transform in the merge delay query needs non-empty arrays
+	mmdURLs, mmdValues := []string{""}, []int64{int64(defaultMMD / time.Second)}
+	for logURL, mmd := range mmds {
+		mmdURLs = append(mmdURLs, logURL)
+		mmdValues = append(mmdValues, mmd)
+	}
+	rows := make(map[string]*logCompliance)
+	row := func(logID string, day time.Time) *logCompliance {
+		key := logID + "/" + day.Format(time.DateOnly)
+		if rows[key] == nil {
+			mmd, ok := mmds[normalizeLogURL(logID)]
+			if !ok {
+				mmd = int64(defaultMMD / time.Second)
+			}
+			rows[key] = &logCompliance{LogID: logID, Day: day, MMD: mmd}
+		}
+		return rows[key]
+	}
+
+	// Uptime is the share of -health_interval windows with at least one successful request, so
+	// retries of a failing request do not weigh more than the outage they span
+	health, err := db.QueryContext(ctx, `
+		SELECT
+			log_id,
+			toDate(window_start) AS day,
+			sumIf(requests, endpoint = 'get-sth'),
+			sumIf(ok, endpoint = 'get-sth'),
+			countIf(endpoint = 'get-sth' AND ok > 0) / greatest(countIf(endpoint = 'get-sth'), 1),
+			sumIf(requests, endpoint = 'get-entries'),
+			sumIf(ok, endpoint = 'get-entries'),
+			countIf(endpoint = 'get-entries' AND ok > 0) / greatest(countIf(endpoint = 'get-entries'), 1),
+			uniqExactIf(sth_timestamp, sth_tree_size > 0),
+			maxIf(dateDiff('second', sth_timestamp, window_end), sth_tree_size > 0)
+		FROM ct_log_health
+		WHERE window_start >= ? AND window_start < ?
+		GROUP BY log_id, day`, from, to)
+	if err != nil {
+		return fmt.Errorf("failed to query ct_log_health: %w", err)
+	}
+	for health.Next() {
+		var logID string
+		var day time.Time
+		var c logCompliance
+		if err := health.Scan(&logID, &day, &c.GetSTHRequests, &c.GetSTHOK, &c.GetSTHUptime,
+			&c.GetEntriesRequests, &c.GetEntriesOK, &c.GetEntriesUptime, &c.STHCount, &c.STHMaxAge); err != nil {
+			health.Close()
+			return fmt.Errorf("failed to scan health: %w", err)
+		}
+		r := row(logID, day)
+		c.LogID, c.Day, c.MMD = r.LogID, r.Day, r.MMD
+		*r = c
+	}
+	health.Close()
+	if err := health.Err(); err != nil {
+		return fmt.Errorf("failed to read health: %w", err)
+	}
+
+	// The merge delay of an entry is measured against the first observed STH including it. Only
+	// entries between two observed STHs count: the first STH seen of a log includes entries
+	// merged before it was observed.
+	delays, err := db.QueryContext(ctx, `
+		SELECT
+			e.log_id,
+			toDate(e.entry_timestamp) AS day,
+			uniqExact(e.log_index),
+			max(dateDiff('second', e.entry_timestamp, s.sth_timestamp)),
+			quantile(0.99)(dateDiff('second', e.entry_timestamp, s.sth_timestamp)),
+			uniqExactIf(e.log_index, dateDiff('second', e.entry_timestamp, s.sth_timestamp) >
+				transform(replaceRegexpOne(e.log_id, '/$', ''), ?, ?, toInt64(?)))
+		FROM (
+			SELECT log_id, log_index, entry_timestamp
+			FROM ct_log_entries
+			WHERE entry_timestamp >= ? AND entry_timestamp < ?
+		) AS e
+		ASOF JOIN (
+			SELECT
+				log_id,
+				tree_size,
+				sth_timestamp,
+				lagInFrame(tree_size, 1, 0) OVER (PARTITION BY log_id ORDER BY tree_size
+					ROWS BETWEEN 1 PRECEDING AND CURRENT ROW) AS previous_tree_size
+			FROM (
+				SELECT log_id, toUInt64(sth_tree_size) AS tree_size, min(sth_timestamp) AS sth_timestamp
+				FROM ct_log_health
+				WHERE window_start >= ? AND sth_tree_size > 0
+				GROUP BY log_id, tree_size
+			)
+		) AS s
+		ON e.log_id = s.log_id AND e.log_index < s.tree_size
+		WHERE s.previous_tree_size > 0 AND e.log_index >= s.previous_tree_size
+		GROUP BY e.log_id, day`, mmdURLs, mmdValues, int64(defaultMMD/time.Second), from, to, from.Add(-defaultMMD))
+	if err != nil {
+		return fmt.Errorf("failed to query merge delays: %w", err)
+	}
+	for delays.Next() {
+		var logID string
+		var day time.Time
+		var c logCompliance
+		if err := delays.Scan(&logID, &day, &c.EntriesChecked, &c.MaxMergeDelay, &c.P99MergeDelay, &c.EntriesOverMMD); err != nil {
+			delays.Close()
+			return fmt.Errorf("failed to scan merge delays: %w", err)
+		}
+		r := row(logID, day)
+		r.EntriesChecked, r.MaxMergeDelay, r.P99MergeDelay, r.EntriesOverMMD = c.EntriesChecked, c.MaxMergeDelay, c.P99MergeDelay, c.EntriesOverMMD
+	}
+	delays.Close()
+	if err := delays.Err(); err != nil {
+		return fmt.Errorf("failed to read merge delays: %w", err)
+	}
+
+	keys := make([]string, 0, len(rows))
+	for key := range rows {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	now := time.Now().UTC()
+	insert := insertQuery(`INSERT INTO ct_log_compliance (log_id, day, mmd_seconds, get_sth_requests, get_sth_ok,
+		get_sth_uptime, get_entries_requests, get_entries_ok, get_entries_uptime, sth_count, sth_max_age_seconds,
+		entries_checked, max_merge_delay_seconds, p99_merge_delay_seconds, entries_over_mmd, computed_at) VALUES`)
+	for _, key := range keys {
+		c := rows[key]
+		insert.add(c.LogID, c.Day, c.MMD, c.GetSTHRequests, c.GetSTHOK, c.GetSTHUptime, c.GetEntriesRequests,
+			c.GetEntriesOK, c.GetEntriesUptime, c.STHCount, c.STHMaxAge, c.EntriesChecked, c.MaxMergeDelay,
+			c.P99MergeDelay, c.EntriesOverMMD, now)
+	}
+	if err := insert.exec(ctx, db); err != nil {
+		return fmt.Errorf("failed to insert into ct_log_compliance: %w", err)
+	}
+	log.Printf("Computed %d log days of compliance metrics from %s to %s in %v", len(rows), from.Format(time.DateOnly), to.Format(time.DateOnly), time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// logListMMDs returns the MMD in seconds of the logs in ct_log_lists by normalized URL, from the
+// Chrome list where a log is in both
+func logListMMDs(ctx context.Context, db *sql.DB) (map[string]int64, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT url, argMax(mmd, list = 'chrome')
+		FROM ct_log_lists FINAL
+		WHERE kind = 'rfc6962' AND mmd > 0
+		GROUP BY url`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ct_log_lists: %w", err)
+	}
+	defer rows.Close()
+	mmds := make(map[string]int64)
+	for rows.Next() {
+		var logURL string
+		var mmd int64
+		if err := rows.Scan(&logURL, &mmd); err != nil {
+			return nil, fmt.Errorf("failed to scan ct_log_lists: %w", err)
+		}
+		mmds[normalizeLogURL(logURL)] = mmd
+	}
+	return mmds, rows.Err()
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	coordinationInterval = 10 * time.Second // Interval between updates of a replica's in-flight range
+	inFlightTTL          = 1 * time.Minute  // In-flight ranges not updated for this long are abandoned
+)
+
+// advanceCursorScript stores the cursor only if it moves forward, so a lagging replica cannot move
+// it back
+const advanceCursorScript = `
+local current = tonumber(redis.call('GET', KEYS[1]) or '-1')
+if tonumber(ARGV[1]) > current then
+	redis.call('SET', KEYS[1], ARGV[1])
+end
+return 0`
+
+// InFlightRange is a range of a log a replica has fetched but not yet inserted
+type InFlightRange struct {
+	Replica string
+	Start   int64 // First index not yet inserted
+	End     int64 // Last fetched index
+	Expires time.Time
+}
+
+// Coordinator shares the ingestion state of one log with other replicas through Redis: the cursor
+// below which entries are inserted, the range each replica has fetched but not yet inserted, and
+// recently stored certificate fingerprints for -dedup. Redis errors are logged and ingestion goes
+// on, as ClickHouse stays the source of truth. With EnableClaims the replica instead works on
+// claimed ranges (see claims.go) and no cursor is kept. A nil Coordinator shares nothing.
+type Coordinator struct {
+	redis    *RedisClient
+	logID    string
+	replica  string
+	dedupTTL time.Duration // How long stored certificate fingerprints are remembered
+
+	nextIndex atomic.Int64 // Next index the fetcher will request
+	inserted  atomic.Int64 // Index after the last inserted entry
+
+	claimSize       int64         // Entries per claimed range, 0 unless claiming
+	claimTTL        time.Duration // Lease of a claimed range, renewed every claimTTL/3
+	insertedEntries atomic.Int64  // Entries inserted while claiming
+	claimMu         sync.Mutex
+	claims          []*claimedRange // Claimed ranges not yet complete, oldest first
+}
+
+// NewCoordinator creates a coordinator for a log, identifying this process as replica
+func NewCoordinator(redis *RedisClient, logID, replica string, dedupTTL time.Duration) *Coordinator {
+	return &Coordinator{redis: redis, logID: logID, replica: replica, dedupTTL: dedupTTL}
+}
+
+// defaultReplicaID identifies this process as <hostname>-<pid>
+func defaultReplicaID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+func (c *Coordinator) key(name string) string {
+	return "ctmon:" + c.logID + ":" + name
+}
+
+// Cursor returns the shared cursor of the log, or -1 if none is stored
+func (c *Coordinator) Cursor() (int64, error) {
+	reply, err := c.redis.Do("GET", c.key("cursor"))
+	if err != nil || reply == nil {
+		return -1, err
+	}
+	s, _ := reply.(string)
+	cursor, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return -1, fmt.Errorf("invalid cursor %q: %w", s, err)
+	}
+	return cursor, nil
+}
+
+// InFlight returns the live in-flight ranges of other replicas
+func (c *Coordinator) InFlight() ([]InFlightRange, error) {
+	reply, err := c.redis.Do("HGETALL", c.key("inflight"))
+	if err != nil {
+		return nil, err
+	}
+	items, _ := reply.([]interface{})
+	now := time.Now()
+	var ranges []InFlightRange
+	for i := 0; i+1 < len(items); i += 2 {
+		replica, _ := items[i].(string)
+		value, _ := items[i+1].(string)
+		var r InFlightRange
+		var expires int64
+		if _, err := fmt.Sscanf(value, "%d %d %d", &r.Start, &r.End, &expires); err != nil {
+			continue
+		}
+		r.Replica = replica
+		r.Expires = time.Unix(expires, 0)
+		if replica != c.replica && r.Expires.After(now) {
+			ranges = append(ranges, r)
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+	return ranges, nil
+}
+
+// SetNextIndex records the next index the fetcher will request
+func (c *Coordinator) SetNextIndex(index int64) {
+	if c == nil {
+		return
+	}
+	c.nextIndex.Store(index)
+}
+
+// RecordInsert advances the shared cursor past an inserted batch, or counts its entries towards
+// the claimed ranges when claiming
+func (c *Coordinator) RecordInsert(batch []*CertificateDetails) {
+	if c == nil || len(batch) == 0 {
+		return
+	}
+	if c.claimSize > 0 {
+		c.insertedEntries.Add(int64(len(batch)))
+		return
+	}
+	next := batch[len(batch)-1].LogIndex + 1
+	for {
+		inserted := c.inserted.Load()
+		if next <= inserted || c.inserted.CompareAndSwap(inserted, next) {
+			break
+		}
+	}
+	if _, err := c.redis.Do("EVAL", advanceCursorScript, "1", c.key("cursor"), strconv.FormatInt(next, 10)); err != nil {
+		log.Printf("Warning: Failed to store cursor %d in redis: %v", next, err)
+	}
+}
+
+// Start publishes this replica's in-flight range every coordinationInterval, or renews its claims
+// every claimTTL/3 when claiming, until done is closed
+func (c *Coordinator) Start(startIndex int64, done <-chan struct{}) {
+	if c == nil {
+		return
+	}
+	c.nextIndex.Store(startIndex)
+	c.inserted.Store(startIndex)
+	update, interval := c.publishInFlight, coordinationInterval
+	if c.claimSize > 0 {
+		update, interval = c.renewClaims, c.claimTTL/3
+	}
+	update()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				update()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+func (c *Coordinator) publishInFlight() {
+	start, next := c.inserted.Load(), c.nextIndex.Load()
+	var err error
+	if next <= start {
+		_, err = c.redis.Do("HDEL", c.key("inflight"), c.replica)
+	} else {
+		value := fmt.Sprintf("%d %d %d", start, next-1, time.Now().Add(inFlightTTL).Unix())
+		_, err = c.redis.Do("HSET", c.key("inflight"), c.replica, value)
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to publish in-flight range to redis: %v", err)
+	}
+}
+
+// Release removes this replica's in-flight range once ingestion has stopped. When claiming, it
+// completes the claimed ranges whose entries are all inserted and gives up the others, so other
+// replicas take them over without waiting for their leases to expire.
+func (c *Coordinator) Release() {
+	if c == nil {
+		return
+	}
+	if c.claimSize > 0 {
+		c.releaseClaims()
+		return
+	}
+	if _, err := c.redis.Do("HDEL", c.key("inflight"), c.replica); err != nil {
+		log.Printf("Warning: Failed to remove in-flight range from redis: %v", err)
+	}
+}
+
+// ClaimCertificates records each entry as the first occurrence of its certificate unless another
+// entry (from any replica) claimed it within dedupTTL, and returns the claiming entry of each
+// certificate SHA-256. Certificate claims are shared across logs.
+func (c *Coordinator) ClaimCertificates(entries []*CertificateDetails) (map[string]string, error) {
+	commands := make([][]string, 0, 2*len(entries))
+	ttl := strconv.FormatInt(c.dedupTTL.Milliseconds(), 10)
+	for _, details := range entries {
+		key := "ctmon:certificate:" + strings.ToLower(details.CertificateSHA256)
+		commands = append(commands,
+			[]string{"SET", key, entryKey(details.LogID, details.LogIndex), "NX", "PX", ttl},
+			[]string{"GET", key})
+	}
+	replies, err := c.redis.Pipeline(commands)
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make(map[string]string, len(entries))
+	for i, details := range entries {
+		reply := replies[2*i+1]
+		if replyErr, ok := reply.(redisError); ok {
+			return nil, replyErr
+		}
+		if owner, ok := reply.(string); ok {
+			owners[details.CertificateSHA256] = owner
+		}
+	}
+	return owners, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	ctx509 "github.com/google/certificate-transparency-go/x509"
+)
+
+// Ways a precertificate and a final certificate can be linked
+const (
+	linkMatchTBS          = "tbs"           // Final TBS with the SCT list removed equals the precert TBS
+	linkMatchSerialIssuer = "serial_issuer" // Same serial number and issuer_id
+)
+
+// finalCertPrecertTBSHash returns the hex SHA-256 of a final certificate's TBSCertificate with the
+// embedded SCT list removed, which equals the TBS logged for its precertificate. Certificates
+// without an SCT list extension were not issued from a precertificate and return "".
+func finalCertPrecertTBSHash(rawTBS []byte) string {
+	precertTBS, err := ctx509.RemoveSCTList(rawTBS)
+	if err != nil {
+		return ""
+	}
+	hash := sha256.Sum256(precertTBS)
+	return hex.EncodeToString(hash[:])
+}
+
+// certificateLinkCandidate is an entry already stored in ct_log_entries that may pair with a batch entry
+type certificateLinkCandidate struct {
+	logID             string
+	logIndex          int64
+	entryType         string
+	certificateSHA256 string
+	precertTBSSHA256  string
+	serialNumber      string
+	issuerID          uint64
+	entryTimestamp    time.Time
+}
+
+// ingestCertificateLinks pairs the batch's precerts and final certificates with their counterparts
+// (from this batch or earlier ones, in any log) and writes the pairs into ct_certificate_links.
+// It must run after the batch has been inserted into ct_log_entries.
+func ingestCertificateLinks(db *sql.DB, batch []*CertificateDetails) error {
+	var tbsHashes, serials []string
+	for _, details := range batch {
+		if details.PrecertTBSSHA256 != "" {
+			tbsHashes = append(tbsHashes, details.PrecertTBSSHA256)
+		}
+		if details.SerialNumber != "" && details.IssuerID != 0 {
+			serials = append(serials, details.SerialNumber)
+		}
+	}
+	if len(tbsHashes) == 0 && len(serials) == 0 {
+		return nil
+	}
+
+	query := `
+		SELECT log_id, log_index, entry_type, certificate_sha256, ifNull(precert_tbs_sha256, ''),
+			serial_number, issuer_id, entry_timestamp
+		FROM ct_log_entries
+		WHERE has(?, ifNull(precert_tbs_sha256, '')) OR has(?, serial_number)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, query, ensureStringSlice(tbsHashes), ensureStringSlice(serials))
+	if err != nil {
+		return fmt.Errorf("failed to query certificate link candidates: %w", err)
+	}
+	defer rows.Close()
+
+	byTBS := make(map[string][]certificateLinkCandidate)
+	bySerial := make(map[string][]certificateLinkCandidate)
+	for rows.Next() {
+		var c certificateLinkCandidate
+		if err := rows.Scan(&c.logID, &c.logIndex, &c.entryType, &c.certificateSHA256, &c.precertTBSSHA256,
+			&c.serialNumber, &c.issuerID, &c.entryTimestamp); err != nil {
+			return fmt.Errorf("failed to scan certificate link candidate: %w", err)
+		}
+		if c.precertTBSSHA256 != "" {
+			byTBS[c.precertTBSSHA256] = append(byTBS[c.precertTBSSHA256], c)
+		}
+		if c.serialNumber != "" {
+			bySerial[c.serialNumber] = append(bySerial[c.serialNumber], c)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read certificate link candidates: %w", err)
+	}
+
+	var values []string
+	var args []interface{}
+	linked := make(map[string]bool)
+
+	for _, details := range batch {
+		self := certificateLinkCandidate{
+			logID:             details.LogID,
+			logIndex:          details.LogIndex,
+			entryType:         details.EntryType,
+			certificateSHA256: details.CertificateSHA256,
+			entryTimestamp:    details.EntryTimestamp,
+		}
+
+		addLink := func(other certificateLinkCandidate, matchType string) {
+			if other.entryType == self.entryType {
+				return
+			}
+			precert, final := self, other
+			if self.entryType == "x509_entry" {
+				precert, final = other, self
+			}
+			key := precert.certificateSHA256 + final.certificateSHA256
+			if linked[key] {
+				return
+			}
+			linked[key] = true
+
+			values = append(values, "(?, ?, ?, ?, ?, ?, ?, ?, ?)")
+			args = append(args,
+				precert.certificateSHA256,
+				final.certificateSHA256,
+				matchType,
+				precert.logID,
+				precert.logIndex,
+				precert.entryTimestamp,
+				final.logID,
+				final.logIndex,
+				final.entryTimestamp,
+			)
+		}
+
+		if details.PrecertTBSSHA256 != "" {
+			for _, other := range byTBS[details.PrecertTBSSHA256] {
+				addLink(other, linkMatchTBS)
+			}
+		}
+		if details.SerialNumber != "" && details.IssuerID != 0 {
+			for _, other := range bySerial[details.SerialNumber] {
+				if other.issuerID == details.IssuerID {
+					addLink(other, linkMatchSerialIssuer)
+				}
+			}
+		}
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	insert := `
+		INSERT INTO ct_certificate_links (
+			precert_sha256, final_certificate_sha256, match_type,
+			precert_log_id, precert_log_index, precert_timestamp,
+			final_log_id, final_log_index, final_timestamp
+		) VALUES ` + strings.Join(values, ", ")
+
+	if _, err := db.ExecContext(ctx, insert, args...); err != nil {
+		return fmt.Errorf("failed to insert %d certificate links: %w", len(values), err)
+	}
+	return nil
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dedupFalsePositiveRate = 0.01 // Default target false positive rate of the -dedup bloom filter
+
+	// dedupCheckpointSlack is subtracted from the checkpoint time when catching up from
+	// ct_certificates, for clock skew between the ingester and ClickHouse
+	dedupCheckpointSlack = 5 * time.Minute
+
+	bloomFileMagic   = "CTMBLOOM"
+	bloomFileVersion = 1
+	bloomChunkWords  = 1 << 16 // Words written or read per lock and buffer
+)
+
+// bloomFilter is a fixed-size bloom filter over certificate SHA-256 digests. The digests are
+// already uniformly distributed, so the probe positions are derived from the digest bytes directly.
+type bloomFilter struct {
+	bits   []uint64
+	m      uint64 // Number of bits
+	hashes int    // Number of probes per key
+}
+
+// newBloomFilter sizes a bloom filter for the expected number of keys and false positive rate
+func newBloomFilter(capacity int64, falsePositiveRate float64) *bloomFilter {
+	m := uint64(math.Ceil(-float64(capacity) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := int(math.Round(float64(m) / float64(capacity) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloomFilter{bits: make([]uint64, (m+63)/64), m: m, hashes: k}
+}
+
+// probes returns the bit positions for a digest using double hashing (Kirsch-Mitzenmacher)
+func (b *bloomFilter) probes(digest []byte) []uint64 {
+	h1 := binary.BigEndian.Uint64(digest[0:8])
+	h2 := binary.BigEndian.Uint64(digest[8:16]) | 1
+	positions := make([]uint64, b.hashes)
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % b.m
+	}
+	return positions
+}
+
+func (b *bloomFilter) add(digest []byte) {
+	for _, p := range b.probes(digest) {
+		b.bits[p/64] |= 1 << (p % 64)
+	}
+}
+
+func (b *bloomFilter) mayContain(digest []byte) bool {
+	for _, p := range b.probes(digest) {
+		if b.bits[p/64]&(1<<(p%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomFileHeader starts a bloom filter checkpoint, followed by the bits as little-endian words
+type bloomFileHeader struct {
+	Magic        [8]byte
+	Version      uint32
+	Hashes       uint32
+	M            uint64
+	Checkpointed int64 // Unix seconds when writing started
+}
+
+// writeBloomFilter checkpoints the filter to path through a temporary file renamed over it. The
+// bits are copied one chunk at a time under mu, so keys added while writing may be missing; they
+// are caught up from ct_certificates as they were recorded after the checkpoint time.
+func writeBloomFilter(path string, b *bloomFilter, mu *sync.Mutex, checkpointed time.Time) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := bufio.NewWriterSize(f, 1<<20)
+	header := bloomFileHeader{Version: bloomFileVersion, Hashes: uint32(b.hashes), M: b.m, Checkpointed: checkpointed.Unix()}
+	copy(header.Magic[:], bloomFileMagic)
+	if err := binary.Write(w, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	buf := make([]byte, 8*bloomChunkWords)
+	for start := 0; start < len(b.bits); start += bloomChunkWords {
+		end := min(start+bloomChunkWords, len(b.bits))
+		mu.Lock()
+		for i, word := range b.bits[start:end] {
+			binary.LittleEndian.PutUint64(buf[8*i:], word)
+		}
+		mu.Unlock()
+		if _, err := w.Write(buf[:8*(end-start)]); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// readBloomFilter loads a checkpoint written by writeBloomFilter and its checkpoint time
+func readBloomFilter(path string) (*bloomFilter, time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReaderSize(f, 1<<20)
+	var header bloomFileHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to read header: %w", err)
+	}
+	if string(header.Magic[:]) != bloomFileMagic || header.Version != bloomFileVersion {
+		return nil, time.Time{}, errors.New("not a bloom filter checkpoint")
+	}
+	b := &bloomFilter{bits: make([]uint64, (header.M+63)/64), m: header.M, hashes: int(header.Hashes)}
+	buf := make([]byte, 8*bloomChunkWords)
+	for start := 0; start < len(b.bits); start += bloomChunkWords {
+		end := min(start+bloomChunkWords, len(b.bits))
+		if _, err := io.ReadFull(r, buf[:8*(end-start)]); err != nil {
+			return nil, time.Time{}, fmt.Errorf("truncated checkpoint: %w", err)
+		}
+		for i := range b.bits[start:end] {
+			b.bits[start+i] = binary.LittleEndian.Uint64(buf[8*i:])
+		}
+	}
+	return b, time.Unix(header.Checkpointed, 0), nil
+}
+
+// Deduplicator detects certificates already stored from another log (or earlier in the same log).
+// A bloom filter of stored certificate_sha256 values avoids querying ct_certificates for
+// certificates that are certainly new; bloom hits are confirmed against ct_certificates, unless
+// trustFilter is set and they are taken as duplicates. With a coordinator, certificates not yet in
+// ct_certificates are also claimed in Redis, so replicas ingesting other logs do not all store the
+// same new certificate as first seen. With a filter file, the bloom filter is checkpointed to it
+// and loaded from it at startup, so a restart only reads the certificates recorded since.
+type Deduplicator struct {
+	mu          sync.Mutex // Guards bloom
+	bloom       *bloomFilter
+	coordinator *Coordinator
+	filterFile  string
+	trustFilter bool
+
+	checkpointMu sync.Mutex // Serializes checkpoints
+}
+
+// NewDeduplicator creates a deduplicator and seeds its bloom filter from the checkpoint in
+// filterFile, if any, and ct_certificates. coordinator may be nil, filterFile empty.
+func NewDeduplicator(db *sql.DB, capacity int64, falsePositiveRate float64, coordinator *Coordinator, filterFile string, trustFilter bool) (*Deduplicator, int64, error) {
+	d := &Deduplicator{bloom: newBloomFilter(capacity, falsePositiveRate), coordinator: coordinator, filterFile: filterFile, trustFilter: trustFilter}
+
+	query, args := "SELECT certificate_sha256 FROM ct_certificates", []interface{}(nil)
+	if filterFile != "" {
+		bloom, checkpointed, err := readBloomFilter(filterFile)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			log.Printf("Warning: Ignoring the -dedup checkpoint %s: %v", filterFile, err)
+		case bloom.m != d.bloom.m || bloom.hashes != d.bloom.hashes:
+			log.Printf("Warning: Ignoring the -dedup checkpoint %s, sized for another capacity or false positive rate", filterFile)
+		default:
+			d.bloom = bloom
+			query, args = query+" WHERE inserted_at >= ?", []interface{}{checkpointed.Add(-dedupCheckpointSlack)}
+			log.Printf("Loaded the -dedup checkpoint of %s from %s", checkpointed.UTC(), filterFile)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load ct_certificates: %w", err)
+	}
+	defer rows.Close()
+
+	var loaded int64
+	for rows.Next() {
+		var sha string
+		if err := rows.Scan(&sha); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan certificate hash: %w", err)
+		}
+		if digest, err := hex.DecodeString(sha); err == nil && len(digest) >= 16 {
+			d.bloom.add(digest)
+			loaded++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to read ct_certificates: %w", err)
+	}
+	return d, loaded, nil
+}
+
+// Start checkpoints the bloom filter to the filter file every interval until done is closed
+func (d *Deduplicator) Start(interval time.Duration, done <-chan struct{}) {
+	if d == nil || d.filterFile == "" {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.Checkpoint()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// Checkpoint writes the bloom filter to the filter file, if any
+func (d *Deduplicator) Checkpoint() {
+	if d == nil || d.filterFile == "" {
+		return
+	}
+	d.checkpointMu.Lock()
+	defer d.checkpointMu.Unlock()
+	start := time.Now()
+	if err := writeBloomFilter(d.filterFile, d.bloom, &d.mu, start); err != nil {
+		log.Printf("Warning: Failed to checkpoint the -dedup bloom filter to %s: %v", d.filterFile, err)
+		return
+	}
+	log.Printf("Checkpointed the -dedup bloom filter to %s in %v", d.filterFile, time.Since(start).Round(time.Millisecond))
+}
+
+// markDuplicates sets IsDuplicate on batch entries whose certificate is already stored and strips
+// their raw blobs, returning the entries that are seen for the first time
+func (d *Deduplicator) markDuplicates(db *sql.DB, batch []*CertificateDetails) ([]*CertificateDetails, error) {
+	// Only the bloom filter is guarded; the queries below run unlocked so other inserters are not
+	// held up by them
+	var candidates []string
+	d.mu.Lock()
+	for _, details := range batch {
+		digest, err := hex.DecodeString(details.CertificateSHA256)
+		if err != nil || len(digest) < 16 {
+			continue
+		}
+		if d.bloom.mayContain(digest) {
+			candidates = append(candidates, details.CertificateSHA256)
+		}
+	}
+	d.mu.Unlock()
+
+	// certificate SHA-256 -> entry (log_id, log_index) where it was first stored, empty when only
+	// the bloom filter says so
+	stored := make(map[string]string)
+	if d.trustFilter {
+		for _, sha := range candidates {
+			stored[sha] = ""
+		}
+	} else if len(candidates) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		rows, err := db.QueryContext(ctx, `
+			SELECT certificate_sha256, first_log_id, first_log_index
+			FROM ct_certificates
+			WHERE has(?, certificate_sha256)
+		`, candidates)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query ct_certificates: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var sha, logID string
+			var logIndex int64
+			if err := rows.Scan(&sha, &logID, &logIndex); err != nil {
+				return nil, fmt.Errorf("failed to scan certificate hash: %w", err)
+			}
+			stored[sha] = entryKey(logID, logIndex)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read ct_certificates: %w", err)
+		}
+	}
+
+	// certificate SHA-256 -> entry that claimed it in Redis, for certificates not in ct_certificates
+	claimed := make(map[string]string)
+	if d.coordinator != nil {
+		var unclaimed []*CertificateDetails
+		seen := make(map[string]bool)
+		for _, details := range batch {
+			if _, ok := stored[details.CertificateSHA256]; !ok && !seen[details.CertificateSHA256] {
+				seen[details.CertificateSHA256] = true
+				unclaimed = append(unclaimed, details)
+			}
+		}
+		if len(unclaimed) > 0 {
+			owners, err := d.coordinator.ClaimCertificates(unclaimed)
+			if err != nil {
+				log.Printf("Warning: Failed to claim certificates in redis, deduplicating against ct_certificates only: %v", err)
+			} else {
+				claimed = owners
+			}
+		}
+	}
+
+	var firstSeen []*CertificateDetails
+	for _, details := range batch {
+		first, ok := stored[details.CertificateSHA256]
+		if owner, found := claimed[details.CertificateSHA256]; !ok && found && owner != entryKey(details.LogID, details.LogIndex) {
+			first, ok = owner, true
+		}
+		if !ok {
+			// Later occurrences in the same batch are duplicates of this one
+			stored[details.CertificateSHA256] = entryKey(details.LogID, details.LogIndex)
+			firstSeen = append(firstSeen, details)
+			continue
+		}
+		// Re-ingesting the first occurrence itself must keep its blobs
+		if first == entryKey(details.LogID, details.LogIndex) {
+			continue
+		}
+		details.IsDuplicate = true
+		details.LeafInputBase64 = ""
+		details.ExtraDataBase64 = ""
+		details.RawLeafCertificateDERBase64 = ""
+	}
+	return firstSeen, nil
+}
+
+func entryKey(logID string, logIndex int64) string {
+	return fmt.Sprintf("%s/%d", logID, logIndex)
+}
+
+// recordCertificates adds first-seen certificates to ct_certificates and the bloom filter
+func (d *Deduplicator) recordCertificates(db *sql.DB, firstSeen []*CertificateDetails) error {
+	if len(firstSeen) == 0 {
+		return nil
+	}
+
+	var values []string
+	var args []interface{}
+	for _, details := range firstSeen {
+		values = append(values, "(?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		args = append(args,
+			details.CertificateSHA256,
+			details.EntryType,
+			details.LogID,
+			details.LogIndex,
+			details.EntryTimestamp,
+			details.NotBefore,
+			details.NotAfter,
+			details.SubjectCommonName,
+			details.IssuerID,
+		)
+	}
+
+	query := `
+		INSERT INTO ct_certificates (
+			certificate_sha256, entry_type, first_log_id, first_log_index, first_entry_timestamp,
+			not_before, not_after, subject_common_name, issuer_id
+		) VALUES ` + strings.Join(values, ", ")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to insert %d certificates: %w", len(values), err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, details := range firstSeen {
+		if digest, err := hex.DecodeString(details.CertificateSHA256); err == nil && len(digest) >= 16 {
+			d.bloom.add(digest)
+		}
+	}
+	return nil
+}
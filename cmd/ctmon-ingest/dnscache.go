@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	dnsServerTimeout = 5 * time.Second  // Timeout for connecting to a -dns_servers server
+	dnsLookupTimeout = 10 * time.Second // Timeout for resolving a host
+	dnsNegativeTTL   = 5 * time.Second  // How long a failed lookup is remembered
+)
+
+var metricDNSLookups = newCounter("ctmon_ingest_dns_lookups_total", "Host lookups by the DNS cache, by result (hit, miss, error)")
+
+// dnsCacheEntry is a cached lookup. ready is closed once addrs and err are set, so concurrent
+// lookups of the same host wait for the first one instead of querying the resolver again.
+type dnsCacheEntry struct {
+	ready   chan struct{}
+	addrs   []string
+	err     error
+	expires time.Time
+}
+
+// DNSCache resolves hosts through an in-process cache, so new connections to the log do not
+// re-resolve its host every time
+type DNSCache struct {
+	resolver *net.Resolver
+	ttl      time.Duration
+	dialer   net.Dialer
+
+	mu      sync.Mutex
+	entries map[string]*dnsCacheEntry
+}
+
+// NewDNSCache creates a cache that keeps lookups for ttl. With servers (host or host:port, port
+// 53 by default) queries go to them in turn instead of the system resolver.
+func NewDNSCache(ttl time.Duration, servers []string) *DNSCache {
+	resolver := net.DefaultResolver
+	if len(servers) > 0 {
+		addrs := make([]string, len(servers))
+		for i, server := range servers {
+			if _, _, err := net.SplitHostPort(server); err != nil {
+				server = net.JoinHostPort(server, "53")
+			}
+			addrs[i] = server
+		}
+		var next atomic.Uint64
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				d := net.Dialer{Timeout: dnsServerTimeout}
+				return d.DialContext(ctx, network, addrs[next.Add(1)%uint64(len(addrs))])
+			},
+		}
+	}
+
+	return &DNSCache{
+		resolver: resolver,
+		ttl:      ttl,
+		dialer:   net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+		entries:  make(map[string]*dnsCacheEntry),
+	}
+}
+
+// parseDNSServers splits the comma separated -dns_servers value
+func parseDNSServers(value string) []string {
+	var servers []string
+	for _, server := range strings.Split(value, ",") {
+		if server = strings.TrimSpace(server); server != "" {
+			servers = append(servers, server)
+		}
+	}
+	return servers
+}
+
+// LookupHost returns the addresses of host, from the cache while the last lookup is fresh
+func (c *DNSCache) LookupHost(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	e, ok := c.entries[host]
+	if ok {
+		select {
+		case <-e.ready:
+			if time.Now().Before(e.expires) {
+				c.mu.Unlock()
+				metricDNSLookups.Add(1, "result", "hit")
+				return e.addrs, e.err
+			}
+			ok = false
+		default:
+			// Another lookup of host is in flight
+		}
+	}
+	if !ok {
+		e = &dnsCacheEntry{ready: make(chan struct{})}
+		c.entries[host] = e
+		c.mu.Unlock()
+
+		// Not bound to ctx, as other callers may be waiting for the result
+		lookupCtx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+		e.addrs, e.err = c.resolver.LookupHost(lookupCtx, host)
+		cancel()
+		if e.err != nil {
+			e.expires = time.Now().Add(dnsNegativeTTL)
+			metricDNSLookups.Add(1, "result", "error")
+		} else {
+			e.expires = time.Now().Add(c.ttl)
+			metricDNSLookups.Add(1, "result", "miss")
+		}
+		close(e.ready)
+		return e.addrs, e.err
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-e.ready:
+		metricDNSLookups.Add(1, "result", "hit")
+		return e.addrs, e.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// DialContext can be used as http.Transport.DialContext. It resolves the host through the cache
+// and tries its addresses in turn.
+func (c *DNSCache) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return c.dialer.DialContext(ctx, network, addr)
+	}
+
+	addrs, err := c.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses found for %s", host)
+	}
+
+	var lastErr error
+	for _, ip := range addrs {
+		conn, err := c.dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
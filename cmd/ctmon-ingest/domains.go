@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DomainRow is a single dNSName of a certificate, written to ct_domains
+type DomainRow struct {
+	Domain            string
+	RegistrableDomain string
+	LogID             string
+	LogIndex          int64
+	CertificateSHA256 string
+	NotBefore         time.Time
+	NotAfter          time.Time
+}
+
+// domainRows explodes the dNSNames of a certificate into ct_domains rows. Precert entries have
+// theirs (and the validity) parsed from the TBS, so both entry types are covered.
+func domainRows(details *CertificateDetails) []DomainRow {
+	names := normalizeCertificateNames(details.DNSNames, "")
+	rows := make([]DomainRow, 0, len(names))
+	for _, name := range names {
+		rows = append(rows, DomainRow{
+			Domain:            name.Name,
+			RegistrableDomain: name.RegistrableDomain,
+			LogID:             details.LogID,
+			LogIndex:          details.LogIndex,
+			CertificateSHA256: details.CertificateSHA256,
+			NotBefore:         details.NotBefore,
+			NotAfter:          details.NotAfter,
+		})
+	}
+	return rows
+}
+
+// ingestDomainBatch writes the exploded dNSNames of a batch into ct_domains
+func ingestDomainBatch(db *sql.DB, batch []*CertificateDetails) error {
+	var values []string
+	var args []interface{}
+
+	for _, details := range batch {
+		for _, row := range domainRows(details) {
+			values = append(values, "(?, ?, ?, ?, ?, ?, ?)")
+			args = append(args,
+				row.Domain,
+				row.RegistrableDomain,
+				row.LogID,
+				row.LogIndex,
+				row.CertificateSHA256,
+				row.NotBefore,
+				row.NotAfter,
+			)
+		}
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO ct_domains (
+			domain, registrable_domain, log_id, log_index, certificate_sha256, not_before, not_after
+		) VALUES ` + strings.Join(values, ", ")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to insert %d domain rows: %w", len(values), err)
+	}
+
+	return nil
+}
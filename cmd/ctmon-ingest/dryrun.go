@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const dryRunReportInterval = 10 * time.Second // Interval between -dry_run progress reports
+
+// DryRun replaces the database inserter with -dry_run: entries are fetched, parsed and turned into
+// insert rows as usual, then counted and dropped. It reports the entry rate and the time spent in
+// each stage of the fetch loop. A nil DryRun records nothing.
+type DryRun struct {
+	started time.Time
+	entries atomic.Int64
+	bytes   atomic.Int64
+	fetch   atomic.Int64 // Nanoseconds spent fetching
+	parse   atomic.Int64 // Nanoseconds spent parsing
+	process atomic.Int64 // Nanoseconds spent filtering, encoding and queueing parsed entries
+}
+
+func NewDryRun() *DryRun {
+	return &DryRun{started: time.Now()}
+}
+
+// AddFetch records time spent fetching entries
+func (d *DryRun) AddFetch(elapsed time.Duration) {
+	if d == nil {
+		return
+	}
+	d.fetch.Add(int64(elapsed))
+}
+
+// AddParse records time spent parsing entries
+func (d *DryRun) AddParse(elapsed time.Duration) {
+	if d == nil {
+		return
+	}
+	d.parse.Add(int64(elapsed))
+}
+
+// AddProcess records time spent handling parsed entries
+func (d *DryRun) AddProcess(elapsed time.Duration) {
+	if d == nil {
+		return
+	}
+	d.process.Add(int64(elapsed))
+}
+
+// Consume takes the place of dbInserter: it builds the insert row of every entry and drops it
+func (d *DryRun) Consume(logChan <-chan *CertificateDetails, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for details := range logChan {
+		extractValues(details)
+		d.entries.Add(1)
+		d.bytes.Add(int64(details.insertSize()))
+		releaseCertificateDetails(details)
+	}
+}
+
+// Start logs progress every dryRunReportInterval until done is closed
+func (d *DryRun) Start(done <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(dryRunReportInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.Report()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// Report logs the entries handled so far, their rate and the time spent per stage
+func (d *DryRun) Report() {
+	elapsed := time.Since(d.started)
+	entries := d.entries.Load()
+	log.Printf("Dry run: %d entries in %v (%.1f entries/sec, %.1f MB of insert rows); fetch %v, parse %v, process %v",
+		entries, elapsed.Round(time.Second), float64(entries)/elapsed.Seconds(), float64(d.bytes.Load())/(1<<20),
+		time.Duration(d.fetch.Load()).Round(time.Millisecond),
+		time.Duration(d.parse.Load()).Round(time.Millisecond),
+		time.Duration(d.process.Load()).Round(time.Millisecond))
+}
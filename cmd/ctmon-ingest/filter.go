@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+)
+
+// EntryFilter evaluates a CEL expression against parsed certificate details
+// to decide whether an entry should be stored
+type EntryFilter struct {
+	expression string
+	program    cel.Program
+}
+
+// NewEntryFilter compiles a CEL expression. The expression must evaluate to a bool.
+//
+// Example: sans.exists(s, s.endsWith(".example.com")) && entry_type == "x509_entry"
+func NewEntryFilter(expression string) (*EntryFilter, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("log_id", cel.StringType),
+		cel.Variable("log_index", cel.IntType),
+		cel.Variable("entry_type", cel.StringType),
+		cel.Variable("entry_timestamp", cel.TimestampType),
+		cel.Variable("certificate_sha256", cel.StringType),
+		cel.Variable("tbs_certificate_sha256", cel.StringType),
+		cel.Variable("not_before", cel.TimestampType),
+		cel.Variable("not_after", cel.TimestampType),
+		cel.Variable("subject_common_name", cel.StringType),
+		cel.Variable("subject_organization", cel.ListType(cel.StringType)),
+		cel.Variable("sans", cel.ListType(cel.StringType)),
+		cel.Variable("registrable_domains", cel.ListType(cel.StringType)),
+		cel.Variable("issuer_common_name", cel.StringType),
+		cel.Variable("issuer_organization", cel.ListType(cel.StringType)),
+		cel.Variable("serial_number", cel.StringType),
+		cel.Variable("is_ca", cel.BoolType),
+		cel.Variable("validity_days", cel.IntType),
+		cel.Variable("exceeds_max_validity", cel.BoolType),
+		cel.Variable("has_ip_san", cel.BoolType),
+		cel.Variable("has_onion_san", cel.BoolType),
+		cel.Variable("has_internal_name", cel.BoolType),
+		cel.Variable("weak_key_reason", cel.StringType),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CEL environment: %w", err)
+	}
+
+	ast, issues := env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("failed to compile filter expression: %w", issues.Err())
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("filter expression must evaluate to bool, got %s", ast.OutputType())
+	}
+
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build filter program: %w", err)
+	}
+
+	return &EntryFilter{expression: expression, program: program}, nil
+}
+
+// Match reports whether the entry satisfies the filter. A nil filter matches everything.
+func (f *EntryFilter) Match(details *CertificateDetails) (bool, error) {
+	if f == nil {
+		return true, nil
+	}
+
+	out, _, err := f.program.Eval(map[string]interface{}{
+		"log_id":                 details.LogID,
+		"log_index":              details.LogIndex,
+		"entry_type":             details.EntryType,
+		"entry_timestamp":        details.EntryTimestamp,
+		"certificate_sha256":     details.CertificateSHA256,
+		"tbs_certificate_sha256": details.TBSCertificateSHA256,
+		"not_before":             details.NotBefore,
+		"not_after":              details.NotAfter,
+		"subject_common_name":    details.SubjectCommonName,
+		"subject_organization":   ensureStringSlice(details.SubjectOrganization),
+		"sans":                   ensureStringSlice(details.SubjectAlternativeNames),
+		"registrable_domains":    registrableDomains(details.NormalizedNames),
+		"issuer_common_name":     details.IssuerCommonName,
+		"issuer_organization":    ensureStringSlice(details.IssuerOrganization),
+		"serial_number":          details.SerialNumber,
+		"is_ca":                  details.IsCA,
+		"validity_days":          int64(details.ValidityDays),
+		"exceeds_max_validity":   details.ExceedsMaxValidity,
+		"has_ip_san":             details.HasIPSAN,
+		"has_onion_san":          details.HasOnionSAN,
+		"has_internal_name":      details.HasInternalName,
+		"weak_key_reason":        details.WeakKeyReason,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to evaluate filter %q for index %d: %w", f.expression, details.LogIndex, err)
+	}
+
+	matched, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("filter %q returned non-bool value %v", f.expression, out.Value())
+	}
+	return matched, nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxHealthLatencySamples = 10000 // Latencies kept per endpoint and window for the quantiles
+
+// endpointHealth accumulates the requests to one endpoint of the log within a window
+type endpointHealth struct {
+	requests  uint64
+	outcomes  map[string]uint64 // "ok" or a fetchErrorClass
+	latencies []time.Duration   // Up to maxHealthLatencySamples
+	totalTime time.Duration
+	maxTime   time.Duration
+}
+
+// LogHealth records the outcome and latency of every request to the log, retries included, and
+// writes them to ct_log_health every interval, one row per endpoint (get-entries, get-sth,
+// get-proof-by-hash, other) with the STH current at the time, so the long-term reliability of log
+// operators can be charted. Latency is the time to the response headers. Requests to other hosts
+// (webhooks, revocation checks) are not counted. A nil LogHealth records nothing.
+type LogHealth struct {
+	db     *sql.DB
+	logID  string
+	logURL string
+	sths   *TreeHeadTracker
+
+	mu          sync.Mutex
+	windowStart time.Time
+	endpoints   map[string]*endpointHealth
+}
+
+// NewLogHealth creates a recorder for the log at logURL
+func NewLogHealth(db *sql.DB, logID, logURL string) *LogHealth {
+	if !strings.HasSuffix(logURL, "/") {
+		logURL += "/"
+	}
+	return &LogHealth{
+		db:          db,
+		logID:       logID,
+		logURL:      logURL,
+		windowStart: time.Now().UTC(),
+		endpoints:   make(map[string]*endpointHealth),
+	}
+}
+
+// Transport wraps base so requests to the log are recorded
+func (h *LogHealth) Transport(base http.RoundTripper) http.RoundTripper {
+	if h == nil {
+		return base
+	}
+	return &healthTransport{base: base, health: h}
+}
+
+// Start writes a row per endpoint every interval until done is closed, and a last one then, with
+// the latest STH of sths
+func (h *LogHealth) Start(interval time.Duration, sths *TreeHeadTracker, done <-chan struct{}) {
+	if h == nil {
+		return
+	}
+	h.sths = sths
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h.flush()
+			case <-done:
+				h.flush()
+				return
+			}
+		}
+	}()
+}
+
+// record counts one request
+func (h *LogHealth) record(endpoint, outcome string, latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e := h.endpoints[endpoint]
+	if e == nil {
+		e = &endpointHealth{outcomes: make(map[string]uint64)}
+		h.endpoints[endpoint] = e
+	}
+	e.requests++
+	e.outcomes[outcome]++
+	e.totalTime += latency
+	e.maxTime = max(e.maxTime, latency)
+	if len(e.latencies) < maxHealthLatencySamples {
+		e.latencies = append(e.latencies, latency)
+	}
+}
+
+// flush writes the current window and starts the next one
+func (h *LogHealth) flush() {
+	h.mu.Lock()
+	endpoints, windowStart := h.endpoints, h.windowStart
+	h.endpoints, h.windowStart = make(map[string]*endpointHealth), time.Now().UTC()
+	h.mu.Unlock()
+	if len(endpoints) == 0 {
+		return
+	}
+
+	var treeSize int64
+	var sthTimestamp time.Time
+	if sth := h.sths.Latest(); sth != nil {
+		treeSize = sth.TreeSize
+		sthTimestamp = time.UnixMilli(sth.Timestamp).UTC()
+	}
+
+	query := `INSERT INTO ct_log_health (log_id, log_url, endpoint, window_start, window_end, requests, ok,
+		rate_limited, not_found, proxy_denied, client_errors, server_errors, network_errors,
+		latency_avg_ms, latency_p50_ms, latency_p95_ms, latency_max_ms, sth_tree_size, sth_timestamp) VALUES`
+	var args []interface{}
+	for endpoint, e := range endpoints {
+		if len(args) > 0 {
+			query += ","
+		}
+		query += " (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+		slices.Sort(e.latencies)
+		args = append(args, h.logID, h.logURL, endpoint, windowStart, h.windowStart, e.requests, e.outcomes["ok"],
+			e.outcomes[string(errorClassRateLimited)], e.outcomes[string(errorClassNotFound)],
+			e.outcomes[string(errorClassProxyDenied)], e.outcomes[string(errorClassClientError)],
+			e.outcomes[string(errorClassServerError)], e.outcomes[string(errorClassNetwork)],
+			milliseconds(e.totalTime/time.Duration(e.requests)), milliseconds(latencyQuantile(e.latencies, 0.5)),
+			milliseconds(latencyQuantile(e.latencies, 0.95)), milliseconds(e.maxTime), treeSize, sthTimestamp)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := h.db.ExecContext(ctx, query, args...); err != nil {
+		log.Printf("Warning: Failed to record log health in ct_log_health: %v", err)
+	}
+}
+
+// latencyQuantile returns the q quantile of sorted latencies
+func latencyQuantile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(q*float64(len(sorted)-1))]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// healthEndpoint names the RFC 6962 endpoint of a request path
+func healthEndpoint(path string) string {
+	for _, endpoint := range []string{"get-entries", "get-sth", "get-proof-by-hash"} {
+		if strings.HasSuffix(path, "/ct/v1/"+endpoint) {
+			return endpoint
+		}
+	}
+	return "other"
+}
+
+// healthTransport records the requests to the log of a LogHealth
+type healthTransport struct {
+	base   http.RoundTripper
+	health *LogHealth
+}
+
+func (t *healthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !strings.HasPrefix(req.URL.String(), t.health.logURL) {
+		return t.base.RoundTrip(req)
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	latency := time.Since(start)
+
+	outcome := "ok"
+	switch {
+	case err != nil:
+		outcome = string(errorClassNetwork)
+	case resp.StatusCode >= 300:
+		outcome = string(classifyFetchError(&HTTPStatusError{StatusCode: resp.StatusCode}))
+	}
+	t.health.record(healthEndpoint(req.URL.Path), outcome, latency)
+	return resp, err
+}
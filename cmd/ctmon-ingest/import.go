@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// importFilePattern matches archive files: get-entries responses named after the index of their
+// first entry, optionally followed by the last one, and optionally gzip or zstd compressed
+var importFilePattern = regexp.MustCompile(`^(\d+)(?:-\d+)?\.json(\.gz|\.zst)?$`)
+
+// importFile is a get-entries response in an archive directory
+type importFile struct {
+	path  string
+	start int64
+}
+
+// runImport implements the import subcommand: it loads an archive directory of get-entries JSON
+// responses through the same parsing and insert pipeline as live ingestion, to bootstrap a
+// deployment without fetching the log again
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	logURLFlag := fs.String("log_url", "", "Base URL of the CT log the archive was taken from, used to derive the log ID")
+	dirFlag := fs.String("dir", "", "Directory of get-entries responses named <start>.json or <start>-<end>.json (.gz and .zst compressed files are decoded; Parquet dumps are not supported)")
+	startIndexFlag := fs.Int64("start_index", 0, "Skip files of entries before this log index")
+	storageProfileFlag := fs.String("storage_profile", string(StorageProfileFull), "Columns to store: full, metadata (no raw blobs) or minimal")
+	blobCodecFlag := fs.String("blob_codec", string(BlobCodecNone), "Encoding for raw blob columns: none (base64) or zstd (compressed before insert)")
+	indexDomainsFlag := fs.Bool("index_domains", false, "Also write one row per dNSName into the ct_domains table")
+	linkPrecertsFlag := fs.Bool("link_precerts", false, "Also write precert/final certificate pairs into ct_certificate_links")
+	filterFlag := fs.String("filter", "", "CEL expression selecting which entries to store")
+	hashEmailsFlag := fs.Bool("hash_emails", false, "Store email SANs and common names as HMAC-SHA256 hashes keyed with CTMON_EMAIL_HMAC_KEY instead of plaintext")
+	weakKeyBlacklistsFlag := fs.String("weak_key_blacklists", "", "Comma separated openssl-blacklist files of weak key fingerprints, flagged as debian_weak_key in weak_key_reason")
+	maxClockSkewFlag := fs.Duration("max_clock_skew", 10*time.Minute, "How far an entry timestamp may be ahead of the import time before it is flagged as future in timestamp_anomaly")
+	minTimestampFlag := fs.String("min_timestamp", defaultMinTimestamp, "Entry timestamps before this date (YYYY-MM-DD) are flagged as too_old in timestamp_anomaly")
+	failFastFlag := fs.Bool("fail_fast", false, "Stop on the first entry that fails to parse or is rejected on insert instead of quarantining it in ct_quarantine")
+	parseWorkersFlag := fs.Int("parse_workers", runtime.NumCPU(), "Number of goroutines parsing entries")
+	insertBatchSizeFlag := fs.Int("insert_batch_size", dbBatchSize, "Number of entries per database insert")
+	insertBatchBytesFlag := fs.Int("insert_batch_bytes", dbBatchBytes, "Estimated bytes per database insert")
+	fs.Parse(args)
+
+	if *logURLFlag == "" {
+		log.Fatal("Error: -log_url is required")
+	}
+	if *dirFlag == "" {
+		log.Fatal("Error: -dir is required")
+	}
+	if *startIndexFlag < 0 {
+		log.Fatal("Error: -start_index must not be negative")
+	}
+	if *parseWorkersFlag <= 0 {
+		log.Fatal("Error: -parse_workers must be positive")
+	}
+	if *insertBatchSizeFlag <= 0 {
+		log.Fatal("Error: -insert_batch_size must be positive")
+	}
+	if *insertBatchBytesFlag <= 0 {
+		log.Fatal("Error: -insert_batch_bytes must be positive")
+	}
+
+	parsedLogURL, err := url.Parse(*logURLFlag)
+	if err != nil || (parsedLogURL.Scheme != "http" && parsedLogURL.Scheme != "https") {
+		log.Fatalf("Error: Invalid -log_url: %v", err)
+	}
+	logID := parsedLogURL.Host + parsedLogURL.Path
+
+	storageProfile, err := parseStorageProfile(*storageProfileFlag)
+	if err != nil {
+		log.Fatalf("Error: Invalid -storage_profile: %v", err)
+	}
+	blobCodec, err := parseBlobCodec(*blobCodecFlag)
+	if err != nil {
+		log.Fatalf("Error: Invalid -blob_codec: %v", err)
+	}
+	emailHasher, err := emailHasherForFlag(*hashEmailsFlag, storageProfile)
+	if err != nil {
+		log.Fatalf("Error: Invalid -hash_emails setup: %v", err)
+	}
+	var entryFilter *EntryFilter
+	if *filterFlag != "" {
+		entryFilter, err = NewEntryFilter(*filterFlag)
+		if err != nil {
+			log.Fatalf("Error: Invalid -filter: %v", err)
+		}
+	}
+
+	weakKeys, err := weakKeyDetectorForFlag(*weakKeyBlacklistsFlag)
+	if err != nil {
+		log.Fatalf("Error: Invalid -weak_key_blacklists: %v", err)
+	}
+	timestampCheck, err := NewTimestampCheck(*maxClockSkewFlag, *minTimestampFlag)
+	if err != nil {
+		log.Fatalf("Error: Invalid -max_clock_skew or -min_timestamp: %v", err)
+	}
+
+	files, err := listImportFiles(*dirFlag)
+	if err != nil {
+		log.Fatalf("Error: Invalid -dir: %v", err)
+	}
+	log.Printf("Importing %d files from %s into %s", len(files), *dirFlag, logID)
+
+	db, err := initClickHouse()
+	if err != nil {
+		log.Fatalf("Failed to initialize ClickHouse connection: %v", err)
+	}
+	defer db.Close()
+
+	insertOptions := InsertOptions{
+		IndexDomains: *indexDomainsFlag,
+		LinkPrecerts: *linkPrecertsFlag,
+	}
+	quarantine := NewQuarantine(db, logID, nil)
+	if !*failFastFlag {
+		insertOptions.Quarantine = quarantine
+	}
+	failure := NewFailure()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	done := make(chan struct{})
+
+	logChan := make(chan *CertificateDetails, logChannelBuffer)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go dbInserter(logChan, *insertBatchSizeFlag, *insertBatchBytesFlag, db, insertOptions, &CircuitBreaker{state: "closed"}, nil, failure, done, &wg)
+
+	parserPool := NewParserPool(*parseWorkersFlag, weakKeys)
+	var imported, filtered, quarantined int64
+
+	// send hands an entry to the inserter, returning false once importing has to stop
+	send := func(details *CertificateDetails) bool {
+		select {
+		case logChan <- details:
+			return true
+		case <-failure.Stopped():
+		case <-sigChan:
+			log.Printf("Received shutdown signal, stopping import")
+			close(done)
+		}
+		releaseCertificateDetails(details)
+		return false
+	}
+
+importLoop:
+	for _, file := range files {
+		resp, err := readImportFile(file.path)
+		if err != nil {
+			failure.Fail(fmt.Errorf("failed to read %s: %w", file.path, err))
+			break
+		}
+		if file.start+int64(len(resp.Entries)) <= *startIndexFlag {
+			continue
+		}
+		log.Printf("Importing %s: entries %d to %d", filepath.Base(file.path), file.start, file.start+int64(len(resp.Entries))-1)
+
+		parsed := parserPool.ParseAll(resp.Entries, logID, file.start)
+		for i, rawEntry := range resp.Entries {
+			index := file.start + int64(i)
+			if index < *startIndexFlag {
+				if parsed[i].details != nil {
+					releaseCertificateDetails(parsed[i].details)
+				}
+				continue
+			}
+			details, err := parsed[i].details, parsed[i].err
+			if err != nil {
+				if *failFastFlag {
+					failure.Fail(fmt.Errorf("failed to parse log entry at index %d: %w", index, err))
+					break importLoop
+				}
+				quarantine.Add(index, err, rawEntry)
+				quarantined++
+				continue
+			}
+			timestampCheck.Apply(details)
+
+			matched, err := entryFilter.Match(details)
+			if err != nil {
+				releaseCertificateDetails(details)
+				if *failFastFlag {
+					failure.Fail(fmt.Errorf("failed to evaluate filter at index %d: %w", index, err))
+					break importLoop
+				}
+				quarantine.Add(index, fmt.Errorf("failed to evaluate filter: %w", err), rawEntry)
+				quarantined++
+				continue
+			}
+			if !matched {
+				filtered++
+				releaseCertificateDetails(details)
+				continue
+			}
+			storageProfile.Apply(details)
+			emailHasher.Apply(details)
+			if err := blobCodec.Apply(details); err != nil {
+				releaseCertificateDetails(details)
+				if *failFastFlag {
+					failure.Fail(fmt.Errorf("failed to encode raw blobs at index %d: %w", index, err))
+					break importLoop
+				}
+				quarantine.Add(index, fmt.Errorf("failed to encode raw blobs: %w", err), rawEntry)
+				quarantined++
+				continue
+			}
+
+			if !send(details) {
+				break importLoop
+			}
+			imported++
+		}
+	}
+
+	close(logChan)
+	wg.Wait()
+
+	log.Printf("Import finished. Entries imported: %d, filtered out: %d, quarantined: %d", imported, filtered, quarantined)
+	if err := failure.Err(); err != nil {
+		log.Fatalf("Import stopped: %v", err)
+	}
+}
+
+// listImportFiles returns the archive files in dir, ordered by their first index
+func listImportFiles(dir string) ([]importFile, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []importFile
+	for _, entry := range dirEntries {
+		if entry.IsDir() {
+			continue
+		}
+		match := importFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil && strings.HasSuffix(entry.Name(), ".parquet") {
+			// Parquet dumps flatten entries into columns that differ per dump tool, so they are not
+			// read; convert them to get-entries JSON first
+			return nil, fmt.Errorf("%s is a Parquet file, only get-entries JSON archives are supported", entry.Name())
+		}
+		if match == nil {
+			log.Printf("Warning: Skipping %s, not named <start>.json or <start>-<end>.json", entry.Name())
+			continue
+		}
+		start, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start index in %s: %w", entry.Name(), err)
+		}
+		files = append(files, importFile{path: filepath.Join(dir, entry.Name()), start: start})
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no get-entries files found in %s", dir)
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].start < files[j].start })
+	return files, nil
+}
+
+// readImportFile decodes a get-entries response, decompressing it by its extension
+func readImportFile(path string) (*GetEntriesResponse, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode gzip: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	case strings.HasSuffix(path, ".zst"):
+		zr, err := zstd.NewReader(f, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode zstd: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	var resp GetEntriesResponse
+	if err := json.NewDecoder(r).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to decode get-entries response: %w", err)
+	}
+	return &resp, nil
+}
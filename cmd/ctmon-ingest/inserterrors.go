@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/column"
+)
+
+const (
+	slowInsertRetries    = 8                // Retries of an insert refused by an overloaded or read-only table
+	slowInsertRetryDelay = 15 * time.Second // First delay of those retries, doubled up to maxSlowInsertDelay
+	maxSlowInsertDelay   = 5 * time.Minute
+	minInsertSplitSize   = 100 // Batches hitting the memory limit are split in halves down to this size
+	maxRejectedRows      = 10  // Rows of a batch quarantined for data errors before the error is taken as not caused by rows
+)
+
+var metricInsertErrors = newCounter("ctmon_ingest_insert_errors_total", "Failed database inserts, by ClickHouse error class")
+
+// insertErrorClass groups database insert errors by how they are retried
+type insertErrorClass string
+
+const (
+	insertErrorOverloaded  insertErrorClass = "overloaded"   // Too many parts or simultaneous queries: retried after a much longer backoff while merges catch up
+	insertErrorReadOnly    insertErrorClass = "read_only"    // Read-only table or replica without Keeper or quorum: spooled at once with -spool_dir, else retried like overloaded
+	insertErrorMemoryLimit insertErrorClass = "memory_limit" // Memory limit exceeded: the batch is split in halves, inserted one after the other
+	insertErrorData        insertErrorClass = "data"         // A row the table rejects: the batch is bisected down to the row, which is quarantined
+	insertErrorOther       insertErrorClass = "other"        // Network and other errors: retried with the normal backoff
+)
+
+// ClickHouse error codes, from src/Common/ErrorCodes.cpp
+const (
+	chCannotParseText                   = 6
+	chCannotParseQuotedString           = 26
+	chCannotParseInputAssertionFailed   = 27
+	chCannotParseDate                   = 38
+	chCannotParseDateTime               = 41
+	chTypeMismatch                      = 53
+	chArgumentOutOfBound                = 69
+	chCannotConvertType                 = 70
+	chCannotParseNumber                 = 72
+	chFileDoesntExist                   = 107
+	chIncorrectData                     = 117
+	chTooLargeStringSize                = 131
+	chReadonly                          = 164
+	chTooManySimultaneousQueries        = 202
+	chNoZooKeeper                       = 225
+	chMemoryLimitExceeded               = 241
+	chTableIsReadOnly                   = 242
+	chTooManyParts                      = 252
+	chTooFewLiveReplicas                = 285
+	chUnsatisfiedQuorumForPreviousWrite = 286
+	chValueIsOutOfRangeOfDataType       = 321
+	chCannotInsertNullInOrdinaryColumn  = 349
+	chDecimalOverflow                   = 407
+	chCannotExtractTableStructure       = 636
+	chUnknownElementOfEnum              = 691
+	chKeeperException                   = 999
+)
+
+// clickHouseCodePattern finds the error code in errors of the HTTP interface
+var clickHouseCodePattern = regexp.MustCompile(`(?i)\bcode: (\d+)`)
+
+// clickHouseErrorCode returns the ClickHouse error code of err, or 0 if it has none
+func clickHouseErrorCode(err error) int32 {
+	var exception *clickhouse.Exception
+	if errors.As(err, &exception) {
+		return exception.Code
+	}
+	if m := clickHouseCodePattern.FindStringSubmatch(err.Error()); m != nil {
+		if code, err := strconv.ParseInt(m[1], 10, 32); err == nil {
+			return int32(code)
+		}
+	}
+	return 0
+}
+
+// classifyInsertError returns the class of a failed insert
+func classifyInsertError(err error) insertErrorClass {
+	// Values the driver cannot encode for their column
+	var columnErr *column.Error
+	var converterErr *column.ColumnConverterError
+	if errors.As(err, &columnErr) || errors.As(err, &converterErr) {
+		return insertErrorData
+	}
+	switch clickHouseErrorCode(err) {
+	case chTooManyParts, chTooManySimultaneousQueries:
+		return insertErrorOverloaded
+	case chReadonly, chTableIsReadOnly, chNoZooKeeper, chKeeperException, chTooFewLiveReplicas, chUnsatisfiedQuorumForPreviousWrite:
+		return insertErrorReadOnly
+	case chMemoryLimitExceeded:
+		return insertErrorMemoryLimit
+	case chCannotParseText, chCannotParseQuotedString, chCannotParseInputAssertionFailed, chCannotParseDate,
+		chCannotParseDateTime, chTypeMismatch, chArgumentOutOfBound, chCannotConvertType, chCannotParseNumber,
+		chIncorrectData, chTooLargeStringSize, chValueIsOutOfRangeOfDataType, chCannotInsertNullInOrdinaryColumn,
+		chDecimalOverflow, chUnknownElementOfEnum:
+		return insertErrorData
+	default:
+		return insertErrorOther
+	}
+}
+
+// slowInsertBackoff is the delay before retry attempt of an insert refused by an overloaded or
+// read-only table
+func slowInsertBackoff(attempt int) time.Duration {
+	return min(slowInsertRetryDelay<<attempt, maxSlowInsertDelay)
+}
+
+// isolateRejectedRows inserts a batch that failed with a data error (err) by bisecting it with
+// single insert attempts: halves that insert are done, halves failing with a data error are
+// bisected further, and single rows still failing are passed to reject. It fails on any other
+// error, or once more than maxRejectedRows rows would be rejected, as the error is then likely not
+// caused by the rows (e.g. a schema mismatch); the whole batch is then handled like any failed
+// batch.
+func isolateRejectedRows(batch []*CertificateDetails, err error, insert func([]*CertificateDetails) error, reject func(*CertificateDetails, error)) error {
+	var rejected []*CertificateDetails
+	var reasons []error
+	var bisect func(part []*CertificateDetails, err error) error
+	bisect = func(part []*CertificateDetails, err error) error {
+		if classifyInsertError(err) != insertErrorData {
+			return err
+		}
+		if len(part) == 1 {
+			if len(rejected) == maxRejectedRows {
+				return fmt.Errorf("more than %d rows rejected, last: %w", maxRejectedRows, err)
+			}
+			rejected = append(rejected, part[0])
+			reasons = append(reasons, err)
+			return nil
+		}
+		for _, half := range [][]*CertificateDetails{part[:len(part)/2], part[len(part)/2:]} {
+			if err := insert(half); err != nil {
+				if err := bisect(half, err); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := bisect(batch, err); err != nil {
+		return err
+	}
+	for i, details := range rejected {
+		reject(details, reasons[i])
+	}
+	log.Printf("Inserted %d of %d entries after bisecting the batch, %d rejected", len(batch)-len(rejected), len(batch), len(rejected))
+	return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IntelFormat selects how watch hits are exported to a threat intelligence platform
+type IntelFormat string
+
+const (
+	IntelFormatMISP IntelFormat = "misp" // MISP attributes, POSTed to /attributes/add/<event_id>
+	IntelFormatSTIX IntelFormat = "stix" // STIX 2.1 indicators, POSTed to a TAXII 2.1 collection's objects endpoint
+)
+
+const (
+	intelQueueSize     = 10000            // Watch hits queued for export before hits are dropped
+	intelBatchSize     = 100              // Hits sent per request
+	intelFlushInterval = 10 * time.Second // Longest time a hit waits before being sent
+)
+
+// intelNamespace derives stable STIX identifiers, so re-exporting a hit updates the same indicator
+var intelNamespace = uuid.MustParse("6f0e4e4a-3c2b-5d6b-9a57-6374666d6f6e")
+
+// ParseIntelFormat validates the -intel_export flag value
+func ParseIntelFormat(s string) (IntelFormat, error) {
+	switch f := IntelFormat(s); f {
+	case IntelFormatMISP, IntelFormatSTIX:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown format %q (expected misp or stix)", s)
+}
+
+// intelHit is a watch hit queued for export
+type intelHit struct {
+	hit               WatchHit
+	certificateSHA256 string
+	logID             string
+	logIndex          int64
+	entryTimestamp    time.Time
+	notAfter          time.Time
+}
+
+// IntelExporter pushes watch hits to a MISP instance or TAXII server, authenticating with
+// CTMON_INTEL_TOKEN. Exporting is best effort: hits are dropped rather than slowing down
+// ingestion when the endpoint is unavailable.
+type IntelExporter struct {
+	format IntelFormat
+	url    string
+	token  string
+	client *http.Client
+	queue  chan intelHit
+}
+
+// NewIntelExporter creates an exporter for the given endpoint
+func NewIntelExporter(format IntelFormat, url string) *IntelExporter {
+	return &IntelExporter{
+		format: format,
+		url:    url,
+		token:  os.Getenv("CTMON_INTEL_TOKEN"),
+		client: &http.Client{Timeout: 30 * time.Second},
+		queue:  make(chan intelHit, intelQueueSize),
+	}
+}
+
+// Start sends queued hits in batches until done is closed
+func (e *IntelExporter) Start(done <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(intelFlushInterval)
+		defer ticker.Stop()
+
+		var pending []intelHit
+		flush := func() {
+			if len(pending) == 0 {
+				return
+			}
+			if err := e.send(pending); err != nil {
+				log.Printf("Warning: Failed to export %d watch hits: %v", len(pending), err)
+			}
+			pending = nil
+		}
+
+		for {
+			select {
+			case hit := <-e.queue:
+				pending = append(pending, hit)
+				if len(pending) >= intelBatchSize {
+					flush()
+				}
+			case <-ticker.C:
+				flush()
+			case <-done:
+				flush()
+				return
+			}
+		}
+	}()
+}
+
+// Export queues the watch hits of a certificate. A nil exporter does nothing.
+func (e *IntelExporter) Export(details *CertificateDetails, hits []WatchHit) {
+	if e == nil {
+		return
+	}
+	for _, hit := range hits {
+		// Key hashes are not domain indicators
+		if hit.Rule.Type.isKey() {
+			continue
+		}
+		select {
+		case e.queue <- intelHit{
+			hit:               hit,
+			certificateSHA256: details.CertificateSHA256,
+			logID:             details.LogID,
+			logIndex:          details.LogIndex,
+			entryTimestamp:    details.EntryTimestamp,
+			notAfter:          details.NotAfter,
+		}:
+		default:
+			log.Printf("Warning: Intel export queue is full, dropping watch hit for %s", hit.Name)
+		}
+	}
+}
+
+func (e *IntelExporter) send(hits []intelHit) error {
+	var body interface{}
+	contentType := "application/json"
+	switch e.format {
+	case IntelFormatMISP:
+		body = mispAttributes(hits)
+	case IntelFormatSTIX:
+		body = map[string]interface{}{"objects": stixIndicators(hits)}
+		contentType = "application/taxii+json;version=2.1"
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s export: %w", e.format, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", contentType)
+	if e.token != "" {
+		if e.format == IntelFormatMISP {
+			// MISP expects the bare automation key
+			req.Header.Set("Authorization", e.token)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+e.token)
+		}
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s endpoint returned status %d", e.format, resp.StatusCode)
+	}
+	return nil
+}
+
+// intelDomain is the exported domain of a hit; wildcard labels are not valid indicator values
+func intelDomain(h intelHit) string {
+	return strings.TrimPrefix(h.hit.Name, "*.")
+}
+
+// intelDescription describes why a name was exported
+func intelDescription(h intelHit) string {
+	return fmt.Sprintf("ctmon watch hit: %s rule %q matched %s (certificate %s, log %s index %d)",
+		h.hit.Rule.Type, h.hit.Rule.Pattern, h.hit.Name, h.certificateSHA256, h.logID, h.logIndex)
+}
+
+// mispAttributes converts hits into MISP attributes: the matched domain, flagged for IDS export
+// when it is a lookalike, and the certificate fingerprint
+func mispAttributes(hits []intelHit) []map[string]interface{} {
+	var attributes []map[string]interface{}
+	seenCertificates := make(map[string]bool)
+	for _, h := range hits {
+		comment := intelDescription(h)
+		attributes = append(attributes, map[string]interface{}{
+			"type":       "domain",
+			"category":   "Network activity",
+			"value":      intelDomain(h),
+			"to_ids":     h.hit.Rule.Type == WatchRuleLookalike,
+			"comment":    comment,
+			"first_seen": h.entryTimestamp.UTC().Format(time.RFC3339),
+		})
+		if !seenCertificates[h.certificateSHA256] {
+			seenCertificates[h.certificateSHA256] = true
+			attributes = append(attributes, map[string]interface{}{
+				"type":       "x509-fingerprint-sha256",
+				"category":   "Network activity",
+				"value":      h.certificateSHA256,
+				"to_ids":     false,
+				"comment":    comment,
+				"first_seen": h.entryTimestamp.UTC().Format(time.RFC3339),
+			})
+		}
+	}
+	return attributes
+}
+
+// stixEscaper escapes string literals in STIX patterns
+var stixEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// stixIndicators converts hits into STIX 2.1 domain-name indicators valid until the certificate expires
+func stixIndicators(hits []intelHit) []map[string]interface{} {
+	now := time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+	var objects []map[string]interface{}
+	for _, h := range hits {
+		indicatorType := "anomalous-activity"
+		if h.hit.Rule.Type == WatchRuleLookalike {
+			indicatorType = "malicious-activity"
+		}
+
+		indicator := map[string]interface{}{
+			"type":            "indicator",
+			"spec_version":    "2.1",
+			"id":              "indicator--" + uuid.NewSHA1(intelNamespace, []byte(h.certificateSHA256+"|"+h.hit.Name)).String(),
+			"created":         now,
+			"modified":        now,
+			"name":            fmt.Sprintf("Certificate for %s matching %s watch rule %s", h.hit.Name, h.hit.Rule.Type, h.hit.Rule.Pattern),
+			"description":     intelDescription(h),
+			"indicator_types": []string{indicatorType},
+			"pattern":         fmt.Sprintf("[domain-name:value = '%s']", stixEscaper.Replace(intelDomain(h))),
+			"pattern_type":    "stix",
+			"valid_from":      h.entryTimestamp.UTC().Format("2006-01-02T15:04:05.000Z"),
+			"external_references": []map[string]interface{}{{
+				"source_name": "ctmon",
+				"external_id": h.certificateSHA256,
+				"description": fmt.Sprintf("Certificate Transparency log %s index %d", h.logID, h.logIndex),
+			}},
+		}
+		if h.notAfter.After(h.entryTimestamp) {
+			indicator["valid_until"] = h.notAfter.UTC().Format("2006-01-02T15:04:05.000Z")
+		}
+		objects = append(objects, indicator)
+	}
+	return objects
+}
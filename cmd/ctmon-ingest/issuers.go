@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+	cttls "github.com/google/certificate-transparency-go/tls"
+	ctx509 "github.com/google/certificate-transparency-go/x509"
+)
+
+// issuerSPKICache maps the SHA-256 of an intermediate's DER to the SHA-256 of its SPKI,
+// since the same few thousand intermediates appear in nearly every chain
+var issuerSPKICache sync.Map
+
+// computeIssuerID derives a stable issuer identifier from the issuer DN and SPKI hash
+func computeIssuerID(issuerDN, issuerSPKISHA256 string) uint64 {
+	hash := sha256.Sum256([]byte(issuerDN + "\n" + issuerSPKISHA256))
+	return binary.BigEndian.Uint64(hash[:8])
+}
+
+// spkiSHA256 returns the hex SHA-256 of a DER SubjectPublicKeyInfo, or "" if there is none
+func spkiSHA256(rawSPKI []byte) string {
+	if len(rawSPKI) == 0 {
+		return ""
+	}
+	hash := sha256.Sum256(rawSPKI)
+	return hex.EncodeToString(hash[:])
+}
+
+// chainIssuerSPKIHash returns the hex SHA-256 of the SPKI of the first certificate in an
+// x509_entry's extra_data chain, which is the issuer of the leaf
+func chainIssuerSPKIHash(extraDataBase64 string) (string, error) {
+	extraData, err := base64.StdEncoding.DecodeString(extraDataBase64)
+	if err != nil {
+		return "", fmt.Errorf("failed to base64 decode extra_data: %w", err)
+	}
+
+	var chain ct.CertificateChain
+	if _, err := cttls.Unmarshal(extraData, &chain); err != nil {
+		return "", fmt.Errorf("failed to unmarshal certificate chain: %w", err)
+	}
+	if len(chain.Entries) == 0 {
+		return "", fmt.Errorf("empty certificate chain")
+	}
+
+	issuerDER := chain.Entries[0].Data
+	derHash := sha256.Sum256(issuerDER)
+	if cached, ok := issuerSPKICache.Load(derHash); ok {
+		return cached.(string), nil
+	}
+
+	issuer, err := ctx509.ParseCertificate(issuerDER)
+	if err != nil && issuer == nil {
+		return "", fmt.Errorf("failed to parse issuer certificate: %w", err)
+	}
+	spkiHex := spkiSHA256(issuer.RawSubjectPublicKeyInfo)
+	issuerSPKICache.Store(derHash, spkiHex)
+	return spkiHex, nil
+}
+
+// IssuerRegistry tracks which issuers have been written to ct_issuers and resolves operator names
+type IssuerRegistry struct {
+	mu        sync.Mutex
+	seen      map[uint64]bool
+	operators map[string]string // issuer SPKI SHA-256 (hex) -> operator name
+}
+
+// NewIssuerRegistry creates a registry, optionally loading a CSV of "issuer_spki_sha256,operator"
+// rows (e.g. exported from CCADB) used to name the organization operating each issuer
+func NewIssuerRegistry(operatorsFile string) (*IssuerRegistry, error) {
+	registry := &IssuerRegistry{
+		seen:      make(map[uint64]bool),
+		operators: make(map[string]string),
+	}
+	if operatorsFile == "" {
+		return registry, nil
+	}
+
+	file, err := os.Open(operatorsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open issuer operators file %s: %w", operatorsFile, err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 2
+	reader.Comment = '#'
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read issuer operators file: %w", err)
+		}
+		spki := strings.ToLower(strings.TrimSpace(record[0]))
+		registry.operators[spki] = strings.TrimSpace(record[1])
+	}
+
+	return registry, nil
+}
+
+// operatorName returns the configured operator for an issuer key, falling back to the issuer organization
+func (r *IssuerRegistry) operatorName(details *CertificateDetails) string {
+	if operator, ok := r.operators[details.IssuerSPKISHA256]; ok {
+		return operator
+	}
+	if len(details.IssuerOrganization) > 0 {
+		return details.IssuerOrganization[0]
+	}
+	return details.IssuerCommonName
+}
+
+// ingestIssuerBatch writes issuers not yet seen by this process into ct_issuers
+func ingestIssuerBatch(db *sql.DB, registry *IssuerRegistry, batch []*CertificateDetails) error {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	var values []string
+	var args []interface{}
+	var newIDs []uint64
+	pending := make(map[uint64]bool)
+
+	for _, details := range batch {
+		if details.IssuerID == 0 || registry.seen[details.IssuerID] || pending[details.IssuerID] {
+			continue
+		}
+		pending[details.IssuerID] = true
+		newIDs = append(newIDs, details.IssuerID)
+
+		values = append(values, "(?, ?, ?, ?, ?, ?)")
+		args = append(args,
+			details.IssuerID,
+			details.IssuerDN,
+			details.IssuerSPKISHA256,
+			details.IssuerCommonName,
+			ensureStringSlice(details.IssuerOrganization),
+			registry.operatorName(details),
+		)
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO ct_issuers (
+			issuer_id, issuer_dn, issuer_spki_sha256, issuer_common_name, issuer_organization, operator_name
+		) VALUES ` + strings.Join(values, ", ")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to insert %d issuers: %w", len(values), err)
+	}
+
+	for _, id := range newIDs {
+		registry.seen[id] = true
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// releaseLeaseScript deletes the lease KEYS[1] if it is still held by ARGV[1]
+const releaseLeaseScript = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`
+
+// AcquireLeadership blocks until this replica holds the leader lease of the log, retrying every
+// ttl/3 while another replica leads and calling waiting before each retry. It returns false if a
+// shutdown signal arrives first.
+func (c *Coordinator) AcquireLeadership(ttl time.Duration, sigChan <-chan os.Signal, waiting func()) bool {
+	ttlArg := strconv.FormatInt(ttl.Milliseconds(), 10)
+	lastLeader := ""
+	for {
+		reply, err := c.redis.Do("SET", c.key("leader"), c.replica, "NX", "PX", ttlArg)
+		if err == nil && reply != nil {
+			log.Printf("Acquired leadership of %s as replica %q", c.logID, c.replica)
+			return true
+		}
+		if err != nil {
+			log.Printf("Warning: Failed to acquire leadership of %s: %v", c.logID, err)
+		} else if leader, err := c.redis.Do("GET", c.key("leader")); err == nil && leader != nil && leader != lastLeader {
+			lastLeader, _ = leader.(string)
+			if lastLeader == c.replica {
+				// A previous process with the same -replica_id still holds the lease
+				log.Printf("Waiting for the leader lease of a previous replica %q to expire", c.replica)
+			} else {
+				log.Printf("Standing by: replica %q leads %s", lastLeader, c.logID)
+			}
+		}
+
+		select {
+		case <-time.After(ttl / 3):
+			waiting()
+		case <-sigChan:
+			return false
+		}
+	}
+}
+
+// KeepLeadership renews the leader lease every ttl/3 until done is closed. If the lease is taken
+// over, or cannot be renewed for ttl, ingestion is stopped through failure so that this replica
+// does not tail the log alongside the new leader.
+func (c *Coordinator) KeepLeadership(ttl time.Duration, failure *Failure, done <-chan struct{}) {
+	ttlArg := strconv.FormatInt(ttl.Milliseconds(), 10)
+	go func() {
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		lastRenewed := time.Now()
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+
+			reply, err := c.redis.Do("EVAL", renewLeaseScript, "1", c.key("leader"), c.replica, ttlArg)
+			if err != nil {
+				if time.Since(lastRenewed) >= ttl {
+					failure.Fail(fmt.Errorf("failed to renew leadership of %s for %v: %w", c.logID, ttl, err))
+					return
+				}
+				log.Printf("Warning: Failed to renew leadership of %s: %v", c.logID, err)
+				continue
+			}
+			if renewed, _ := reply.(int64); renewed == 0 {
+				failure.Fail(fmt.Errorf("lost leadership of %s to another replica", c.logID))
+				return
+			}
+			lastRenewed = time.Now()
+		}
+	}()
+}
+
+// ReleaseLeadership deletes the leader lease if this replica still holds it, so a standby replica
+// takes over without waiting for it to expire
+func (c *Coordinator) ReleaseLeadership() {
+	_, err := c.redis.Do("EVAL", releaseLeaseScript, "1", c.key("leader"), c.replica)
+	if err != nil {
+		log.Printf("Warning: Failed to release leadership of %s: %v", c.logID, err)
+		return
+	}
+	log.Printf("Released leadership of %s", c.logID)
+}
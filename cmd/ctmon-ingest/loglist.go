@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var metricLogListState = newGauge("ctmon_ingest_log_list_state", "1 for the state of the log in -log_list (pending, qualified, usable, readonly, retired or rejected)")
+
+const (
+	defaultLogListURL      = "https://www.gstatic.com/ct/log_list/v3/all_logs_list.json"
+	logListRefreshInterval = 6 * time.Hour
+	maxLogListSize         = 16 << 20
+)
+
+// logList is a log list in the v3 schema of the Chrome and Apple lists
+type logList struct {
+	Operators []struct {
+		Name      string       `json:"name"`
+		Logs      []logListLog `json:"logs"`
+		TiledLogs []logListLog `json:"tiled_logs"` // Static CT API logs, not ingested
+	} `json:"operators"`
+}
+
+// logListLog is one RFC 6962 or tiled log of a log list
+type logListLog struct {
+	Description      string                  `json:"description"`
+	LogID            string                  `json:"log_id"`
+	Key              string                  `json:"key"`
+	URL              string                  `json:"url"`
+	SubmissionURL    string                  `json:"submission_url"` // Tiled logs only
+	MonitoringURL    string                  `json:"monitoring_url"` // Tiled logs only
+	MMD              int64                   `json:"mmd"`            // Maximum merge delay in seconds
+	State            map[string]logListState `json:"state"`          // One of pending, qualified, usable, readonly, retired or rejected
+	TemporalInterval *struct {
+		StartInclusive time.Time `json:"start_inclusive"`
+		EndExclusive   time.Time `json:"end_exclusive"`
+	} `json:"temporal_interval"`
+}
+
+type logListState struct {
+	Timestamp     time.Time `json:"timestamp"`
+	FinalTreeHead *struct {
+		SHA256RootHash string `json:"sha256_root_hash"`
+		TreeSize       int64  `json:"tree_size"`
+	} `json:"final_tree_head"` // Only in the readonly state
+}
+
+// stateName returns the name of the log's state, or "" without one
+func (l *logListLog) stateName() string {
+	for name := range l.State {
+		return name
+	}
+	return ""
+}
+
+// fetchLogList reads a log list from an http(s) URL or a file
+func fetchLogList(client *http.Client, source string) (*logList, error) {
+	var data []byte
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, "GET", source, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create log list request: %w", err)
+		}
+		req.Header.Set("User-Agent", userAgent)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch log list: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to fetch log list: status %d", resp.StatusCode)
+		}
+		if data, err = io.ReadAll(io.LimitReader(resp.Body, maxLogListSize)); err != nil {
+			return nil, fmt.Errorf("failed to read log list: %w", err)
+		}
+	} else {
+		var err error
+		if data, err = os.ReadFile(source); err != nil {
+			return nil, err
+		}
+	}
+
+	var list logList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to decode log list: %w", err)
+	}
+	return &list, nil
+}
+
+// normalizeLogURL strips the scheme and trailing slash, so URLs from log lists and flags compare
+func normalizeLogURL(logURL string) string {
+	if i := strings.Index(logURL, "://"); i >= 0 {
+		logURL = logURL[i+3:]
+	}
+	return strings.TrimSuffix(logURL, "/")
+}
+
+// find returns the log served at logURL, or nil
+func (l *logList) find(logURL string) *logListLog {
+	logURL = normalizeLogURL(logURL)
+	for _, operator := range l.Operators {
+		for i := range operator.Logs {
+			if normalizeLogURL(operator.Logs[i].URL) == logURL {
+				return &operator.Logs[i]
+			}
+		}
+	}
+	return nil
+}
+
+// LogShard follows the entry of the ingested log in a log list. A readonly log's final tree head,
+// or for a retired log the tree size seen when the retirement was noticed, bounds fetching; once
+// ingestion reaches it, or the end of the log's temporal interval plus its MMD has passed and the
+// STH is reached, the log is complete and tailing stops instead of polling it forever. A rejected
+// log is not fetched from at all. State changes seen while running are posted to webhookURL. A nil
+// LogShard, or one for a log missing from the list, bounds nothing and is never complete.
+type LogShard struct {
+	client     *http.Client
+	source     string
+	logURL     string
+	logID      string
+	sths       *TreeHeadTracker
+	webhookURL string
+
+	mu         sync.Mutex
+	entry      *logListLog
+	state      string
+	frozenSize int64 // Tree size fetching is bounded by, -1 while the log may grow
+}
+
+// NewLogShard creates a LogShard for the log at logURL in the log list at source
+func NewLogShard(client *http.Client, source, logURL, logID string, sths *TreeHeadTracker, webhookURL string) *LogShard {
+	return &LogShard{client: client, source: source, logURL: logURL, logID: logID, sths: sths, webhookURL: webhookURL, frozenSize: -1}
+}
+
+// Refresh reloads the log list and updates the state of the log
+func (s *LogShard) Refresh() error {
+	if s == nil {
+		return nil
+	}
+	list, err := fetchLogList(s.client, s.source)
+	if err != nil {
+		return err
+	}
+	entry := list.find(s.logURL)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry == nil {
+		if s.entry == nil {
+			log.Printf("Warning: %s is not in the log list %s, its temporal interval and state are not followed", s.logID, s.source)
+		}
+		return nil
+	}
+	if s.entry == nil && entry.TemporalInterval != nil {
+		log.Printf("%s is a temporal shard for certificates expiring from %s to %s",
+			s.logID, entry.TemporalInterval.StartInclusive.UTC(), entry.TemporalInterval.EndExclusive.UTC())
+	}
+	s.entry = entry
+
+	state := entry.stateName()
+	if state != s.state {
+		if s.state == "" {
+			log.Printf("%s is %s in the log list since %s", s.logID, state, entry.State[state].Timestamp.UTC())
+		} else {
+			s.notify(s.state, state, entry.State[state].Timestamp)
+			metricLogListState.Set(0, "log", s.logID, "state", s.state)
+		}
+		metricLogListState.Set(1, "log", s.logID, "state", state)
+		s.state = state
+	}
+	if s.frozenSize < 0 {
+		switch {
+		case entry.State[state].FinalTreeHead != nil:
+			s.frozenSize = entry.State[state].FinalTreeHead.TreeSize
+		case state == "retired" && s.sths.Latest() != nil:
+			s.frozenSize = s.sths.TreeSize()
+		}
+		if s.frozenSize >= 0 {
+			log.Printf("%s is %s, fetching no entries beyond its final tree size %d", s.logID, state, s.frozenSize)
+		}
+	}
+	return nil
+}
+
+// notify logs a state change seen while running and posts it to the webhook
+func (s *LogShard) notify(from, to string, since time.Time) {
+	var mode string
+	switch to {
+	case "readonly", "retired":
+		mode = "finishing the entries up to its final tree size, then stopping"
+	case "rejected":
+		mode = "stopping without fetching further entries"
+	default:
+		mode = "ingestion continues"
+	}
+	text := fmt.Sprintf("%s changed from %s to %s in the log list %s (since %s): %s", s.logID, from, to, s.source, since.UTC(), mode)
+	log.Printf("LOG STATE: %s", text)
+	if s.webhookURL == "" {
+		return
+	}
+	alert := Alert{Alert: "log_state", Status: "firing", Log: s.logID, Text: text, Timestamp: time.Now().UTC()}
+	go func() {
+		if err := postWebhook(s.client, s.webhookURL, alert); err != nil {
+			log.Printf("Warning: Failed to send log state alert to webhook: %v", err)
+		}
+	}()
+}
+
+// Start reloads the log list every logListRefreshInterval until done is closed
+func (s *LogShard) Start(done <-chan struct{}) {
+	if s == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(logListRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.Refresh(); err != nil {
+					log.Printf("Warning: Failed to refresh the log list %s: %v", s.source, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// FinalTreeSize returns the tree size of a readonly or retired log, or -1 while the log may grow
+func (s *LogShard) FinalTreeSize() int64 {
+	if s == nil {
+		return -1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.frozenSize
+}
+
+// Bound returns the tree size to fetch up to given the latest verified one. The final tree head of
+// a readonly log is used as is, so a frozen log whose STH can no longer be fetched is still
+// completed. Nothing is fetched from a rejected log.
+func (s *LogShard) Bound(treeSize int64) int64 {
+	if s == nil {
+		return treeSize
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.state == "rejected":
+		return 0
+	case s.frozenSize < 0:
+		return treeSize
+	case s.entry != nil && s.entry.State[s.state].FinalTreeHead != nil:
+		return s.frozenSize
+	default:
+		return min(treeSize, s.frozenSize)
+	}
+}
+
+// Rejected reports whether the log is rejected in the log list
+func (s *LogShard) Rejected() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state == "rejected"
+}
+
+// Complete reports whether no entries remain to be fetched from nextIndex on, and why
+func (s *LogShard) Complete(nextIndex int64) (bool, string) {
+	if s == nil {
+		return false, ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == "rejected" {
+		return true, "the log was rejected"
+	}
+	if s.frozenSize >= 0 && nextIndex >= s.frozenSize {
+		return true, fmt.Sprintf("the log is %s at tree size %d", s.state, s.frozenSize)
+	}
+	if s.entry == nil || s.entry.TemporalInterval == nil {
+		return false, ""
+	}
+	// Certificates expiring before the end are only accepted before it, and merged within the MMD
+	end := s.entry.TemporalInterval.EndExclusive.Add(time.Duration(s.entry.MMD) * time.Second)
+	if time.Now().After(end) && s.sths.Latest() != nil && nextIndex >= s.sths.TreeSize() {
+		return true, fmt.Sprintf("its temporal interval ended at %s", s.entry.TemporalInterval.EndExclusive.UTC())
+	}
+	return false, ""
+}
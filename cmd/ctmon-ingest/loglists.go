@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const appleLogListURL = "https://valid.apple.com/ct/log_list/current_log_list.json"
+
+// logListSnapshotLog is one log of a fetched log list, flattened for ct_log_lists
+type logListSnapshotLog struct {
+	LogID          string
+	Operator       string
+	Description    string
+	Kind           string // rfc6962 or tiled
+	URL            string // The monitoring URL of a tiled log
+	Key            string
+	MMD            int64
+	State          string
+	StateTimestamp time.Time
+	IntervalStart  time.Time
+	IntervalEnd    time.Time
+	FinalTreeSize  int64
+}
+
+// snapshotLogs flattens the RFC 6962 and tiled logs of a log list
+func (l *logList) snapshotLogs() []logListSnapshotLog {
+	var logs []logListSnapshotLog
+	for _, operator := range l.Operators {
+		for kind, operatorLogs := range map[string][]logListLog{"rfc6962": operator.Logs, "tiled": operator.TiledLogs} {
+			for _, entry := range operatorLogs {
+				state := entry.stateName()
+				s := logListSnapshotLog{
+					LogID:          entry.LogID,
+					Operator:       operator.Name,
+					Description:    entry.Description,
+					Kind:           kind,
+					URL:            entry.URL,
+					Key:            entry.Key,
+					MMD:            entry.MMD,
+					State:          state,
+					StateTimestamp: entry.State[state].Timestamp,
+				}
+				if kind == "tiled" {
+					s.URL = entry.MonitoringURL
+				}
+				if entry.TemporalInterval != nil {
+					s.IntervalStart = entry.TemporalInterval.StartInclusive
+					s.IntervalEnd = entry.TemporalInterval.EndExclusive
+				}
+				if head := entry.State[state].FinalTreeHead; head != nil {
+					s.FinalTreeSize = head.TreeSize
+				}
+				logs = append(logs, s)
+			}
+		}
+	}
+	return logs
+}
+
+// runLogLists implements the loglists subcommand: it fetches the Chrome and Apple log lists,
+// stores every log in ct_log_lists, records state changes (and logs added to or removed from a
+// list) in ct_log_list_states, alerts on them and reports logs whose state differs between the
+// lists
+func runLogLists(args []string) {
+	fs := flag.NewFlagSet("loglists", flag.ExitOnError)
+	chromeFlag := fs.String("chrome_log_list", defaultLogListURL, "Chrome log list (v3 JSON URL or file, empty to skip)")
+	appleFlag := fs.String("apple_log_list", appleLogListURL, "Apple log list (v3 JSON URL or file, empty to skip)")
+	alertWebhookFlag := fs.String("alert_webhook", "", "URL to POST JSON log_state alerts to (alerts are always logged)")
+	intervalFlag := fs.Duration("interval", 0, "Interval between runs (0 runs once)")
+	fs.Parse(args)
+
+	if *intervalFlag < 0 {
+		log.Fatal("Error: -interval must be non-negative")
+	}
+	lists := map[string]string{}
+	if *chromeFlag != "" {
+		lists["chrome"] = *chromeFlag
+	}
+	if *appleFlag != "" {
+		lists["apple"] = *appleFlag
+	}
+	if len(lists) == 0 {
+		log.Fatal("Error: -chrome_log_list or -apple_log_list is required")
+	}
+
+	db, err := initClickHouse()
+	if err != nil {
+		log.Fatalf("Failed to initialize ClickHouse connection: %v", err)
+	}
+	defer db.Close()
+
+	client := &http.Client{Timeout: requestTimeout}
+	for {
+		if err := syncLogLists(db, client, lists, *alertWebhookFlag); err != nil {
+			if *intervalFlag == 0 {
+				log.Fatalf("Error: %v", err)
+			}
+			log.Printf("Warning: Log list sync failed: %v", err)
+		}
+		if *intervalFlag == 0 {
+			return
+		}
+		time.Sleep(*intervalFlag)
+	}
+}
+
+// syncLogLists fetches and records every list, then compares the lists with each other
+func syncLogLists(db *sql.DB, client *http.Client, lists map[string]string, webhookURL string) error {
+	snapshots := make(map[string][]logListSnapshotLog)
+	var failed []string
+	for name, source := range lists {
+		list, err := fetchLogList(client, source)
+		if err != nil {
+			log.Printf("Warning: Failed to fetch the %s log list: %v", name, err)
+			failed = append(failed, name)
+			continue
+		}
+		logs := list.snapshotLogs()
+		if err := recordLogList(db, client, name, logs, webhookURL); err != nil {
+			return fmt.Errorf("failed to record the %s log list: %w", name, err)
+		}
+		snapshots[name] = logs
+		log.Printf("Recorded %d logs of the %s log list", len(logs), name)
+	}
+	reportLogListMismatches(snapshots)
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to fetch the %s log list", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// recordLogList stores the logs of a list and the state changes since the previous run
+func recordLogList(db *sql.DB, client *http.Client, name string, logs []logListSnapshotLog, webhookURL string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	previous := make(map[string]string) // log_id to the last recorded state, "removed" once gone
+	rows, err := db.QueryContext(ctx, `
+		SELECT log_id, argMax(state, observed_at)
+		FROM ct_log_list_states
+		WHERE list = ?
+		GROUP BY log_id`, name)
+	if err != nil {
+		return fmt.Errorf("failed to query previous states: %w", err)
+	}
+	for rows.Next() {
+		var logID, state string
+		if err := rows.Scan(&logID, &state); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan previous state: %w", err)
+		}
+		previous[logID] = state
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read previous states: %w", err)
+	}
+	firstRun := len(previous) == 0
+
+	now := time.Now().UTC()
+	snapshot := insertQuery(`INSERT INTO ct_log_lists (list, log_id, operator, description, kind, url, key, mmd,
+		state, state_timestamp, temporal_interval_start, temporal_interval_end, final_tree_size, fetched_at) VALUES`)
+	history := insertQuery(`INSERT INTO ct_log_list_states (list, log_id, operator, description, url,
+		previous_state, state, state_timestamp, observed_at) VALUES`)
+
+	var alerts []Alert
+	seen := make(map[string]bool)
+	for _, l := range logs {
+		seen[l.LogID] = true
+		snapshot.add(name, l.LogID, l.Operator, l.Description, l.Kind, l.URL, l.Key, l.MMD,
+			l.State, l.StateTimestamp, l.IntervalStart, l.IntervalEnd, l.FinalTreeSize, now)
+		prev, known := previous[l.LogID]
+		if known && prev == l.State {
+			continue
+		}
+		history.add(name, l.LogID, l.Operator, l.Description, l.URL, prev, l.State, l.StateTimestamp, now)
+		if firstRun {
+			continue
+		}
+		text := fmt.Sprintf("%s (%s, %s) changed from %s to %s in the %s log list", l.Description, l.Operator, l.URL, prev, l.State, name)
+		if !known {
+			text = fmt.Sprintf("%s (%s, %s) was added to the %s log list as %s", l.Description, l.Operator, l.URL, name, l.State)
+		}
+		alerts = append(alerts, Alert{Alert: "log_state", Status: "firing", Log: l.URL, Text: text, Timestamp: now})
+	}
+	for logID, prev := range previous {
+		if seen[logID] || prev == "removed" {
+			continue
+		}
+		history.add(name, logID, "", "", "", prev, "removed", now, now)
+		text := fmt.Sprintf("Log %s (%s) was removed from the %s log list", logID, prev, name)
+		alerts = append(alerts, Alert{Alert: "log_state", Status: "firing", Log: logID, Text: text, Timestamp: now})
+	}
+	for _, q := range []*insertRows{snapshot, history} {
+		if err := q.exec(ctx, db); err != nil {
+			return err
+		}
+	}
+
+	for _, alert := range alerts {
+		log.Printf("LOG STATE: %s", alert.Text)
+		if webhookURL == "" {
+			continue
+		}
+		if err := postWebhook(client, webhookURL, alert); err != nil {
+			log.Printf("Warning: Failed to send log state alert to webhook: %v", err)
+		}
+	}
+	return nil
+}
+
+// insertRows builds a multi-row INSERT
+type insertRows struct {
+	query string
+	rows  int
+	args  []interface{}
+}
+
+func insertQuery(query string) *insertRows {
+	return &insertRows{query: query}
+}
+
+func (q *insertRows) add(values ...interface{}) {
+	if q.rows > 0 {
+		q.query += ","
+	}
+	q.query += " (" + strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ") + ")"
+	q.rows++
+	q.args = append(q.args, values...)
+}
+
+// exec runs the INSERT, if any rows were added
+func (q *insertRows) exec(ctx context.Context, db *sql.DB) error {
+	if q.rows == 0 {
+		return nil
+	}
+	_, err := db.ExecContext(ctx, q.query, q.args...)
+	return err
+}
+
+// reportLogListMismatches logs the logs present in several lists with different states
+func reportLogListMismatches(snapshots map[string][]logListSnapshotLog) {
+	states := make(map[string]map[string]string) // log_id to list to state
+	descriptions := make(map[string]string)
+	for name, logs := range snapshots {
+		for _, l := range logs {
+			if states[l.LogID] == nil {
+				states[l.LogID] = make(map[string]string)
+			}
+			states[l.LogID][name] = l.State
+			descriptions[l.LogID] = l.Description
+		}
+	}
+
+	var mismatches []string
+	for logID, byList := range states {
+		if len(byList) < 2 {
+			continue
+		}
+		var parts []string
+		distinct := make(map[string]bool)
+		for name, state := range byList {
+			parts = append(parts, name+" "+state)
+			distinct[state] = true
+		}
+		if len(distinct) > 1 {
+			sort.Strings(parts)
+			mismatches = append(mismatches, fmt.Sprintf("%s: %s", descriptions[logID], strings.Join(parts, ", ")))
+		}
+	}
+	sort.Strings(mismatches)
+	for _, mismatch := range mismatches {
+		log.Printf("Log lists disagree on %s", mismatch)
+	}
+}
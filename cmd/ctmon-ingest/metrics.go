@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metric is a counter or gauge exposed in the Prometheus text format on -metrics_listen. Each
+// combination of label values is a separate series.
+type Metric struct {
+	name string
+	help string
+	kind string // counter or gauge
+
+	mu     sync.Mutex
+	series map[string]float64 // Rendered label set -> value
+}
+
+var (
+	metricsMu       sync.Mutex
+	metricsRegistry []*Metric
+)
+
+func newMetric(kind, name, help string) *Metric {
+	m := &Metric{name: name, help: help, kind: kind, series: make(map[string]float64)}
+	metricsMu.Lock()
+	metricsRegistry = append(metricsRegistry, m)
+	metricsMu.Unlock()
+	return m
+}
+
+// newCounter registers a monotonically increasing metric
+func newCounter(name, help string) *Metric { return newMetric("counter", name, help) }
+
+// newGauge registers a metric that can go up and down
+func newGauge(name, help string) *Metric { return newMetric("gauge", name, help) }
+
+// Set sets the series selected by labels, given as alternating names and values
+func (m *Metric) Set(value float64, labels ...string) {
+	key := renderLabels(labels)
+	m.mu.Lock()
+	m.series[key] = value
+	m.mu.Unlock()
+}
+
+// Add adds delta to the series selected by labels, given as alternating names and values
+func (m *Metric) Add(delta float64, labels ...string) {
+	key := renderLabels(labels)
+	m.mu.Lock()
+	m.series[key] += delta
+	m.mu.Unlock()
+}
+
+func renderLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(labels[i])
+		b.WriteString("=")
+		b.WriteString(strconv.Quote(labels[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// metricsHandler writes all registered metrics in the Prometheus text exposition format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	metricsMu.Lock()
+	metrics := append([]*Metric(nil), metricsRegistry...)
+	metricsMu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, m := range metrics {
+		m.mu.Lock()
+		keys := make([]string, 0, len(m.series))
+		for key := range m.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		if len(keys) > 0 {
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		}
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, key, strconv.FormatFloat(m.series[key], 'g', -1, 64))
+		}
+		m.mu.Unlock()
+	}
+}
+
+// StartMetricsServer serves /metrics on addr in the background
+func StartMetricsServer(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metricsHandler)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Warning: Metrics server on %s failed: %v", addr, err)
+		}
+	}()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+// NormalizedName holds the public-suffix-aware derived forms of a certificate DNS name
+type NormalizedName struct {
+	Name              string `json:"name"`               // Lowercased, without trailing dot
+	RegistrableDomain string `json:"registrable_domain"` // eTLD+1, empty if the name is a public suffix or not a DNS name
+	IsWildcard        bool   `json:"is_wildcard"`        // Leftmost label is "*"
+	Unicode           string `json:"unicode"`            // Punycode-decoded form of the name
+}
+
+// registrableDomain returns the eTLD+1 of a DNS name, ignoring a leading wildcard label.
+// It returns an empty string when the name has no registrable domain (e.g. a bare public suffix).
+func registrableDomain(name string) string {
+	name = strings.TrimSuffix(strings.ToLower(strings.TrimPrefix(name, "*.")), ".")
+	etldPlusOne, err := publicsuffix.EffectiveTLDPlusOne(name)
+	if err != nil {
+		return ""
+	}
+	return etldPlusOne
+}
+
+// normalizeDNSName derives the registrable domain, wildcard flag and Unicode form of a DNS name
+func normalizeDNSName(name string) NormalizedName {
+	name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+
+	normalized := NormalizedName{
+		Name:              name,
+		RegistrableDomain: registrableDomain(name),
+		IsWildcard:        strings.HasPrefix(name, "*."),
+		Unicode:           name,
+	}
+
+	if strings.Contains(name, "xn--") {
+		if unicode, err := idna.ToUnicode(name); err == nil {
+			normalized.Unicode = unicode
+		}
+	}
+
+	return normalized
+}
+
+// looksLikeDNSName reports whether a subject CN should be treated as a host name
+func looksLikeDNSName(cn string) bool {
+	if cn == "" || strings.ContainsAny(cn, " @/:") || !strings.Contains(cn, ".") {
+		return false
+	}
+	return net.ParseIP(cn) == nil
+}
+
+// normalizeCertificateNames normalizes the SAN dNSNames and, if it is a host name, the subject CN
+func normalizeCertificateNames(dnsNames []string, subjectCN string) []NormalizedName {
+	seen := make(map[string]bool, len(dnsNames)+1)
+	names := make([]NormalizedName, 0, len(dnsNames)+1)
+
+	add := func(name string) {
+		normalized := normalizeDNSName(name)
+		if normalized.Name == "" || seen[normalized.Name] {
+			return
+		}
+		seen[normalized.Name] = true
+		names = append(names, normalized)
+	}
+
+	for _, name := range dnsNames {
+		add(name)
+	}
+	if looksLikeDNSName(subjectCN) {
+		add(subjectCN)
+	}
+
+	return names
+}
+
+// registrableDomains returns the distinct non-empty registrable domains of the names
+func registrableDomains(names []NormalizedName) []string {
+	seen := make(map[string]bool, len(names))
+	domains := make([]string, 0, len(names))
+	for _, name := range names {
+		if name.RegistrableDomain == "" || seen[name.RegistrableDomain] {
+			continue
+		}
+		seen[name.RegistrableDomain] = true
+		domains = append(domains, name.RegistrableDomain)
+	}
+	return domains
+}
+
+// isOnionName reports whether a DNS name is a Tor onion service name (RFC 7686)
+func isOnionName(name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	return name == "onion" || strings.HasSuffix(name, ".onion")
+}
+
+// isInternalName reports whether a DNS name cannot be publicly resolved: a single label such as
+// localhost, or a name under a TLD outside the ICANN root such as .local, .corp or .internal.
+// Onion names are reported by isOnionName instead.
+func isInternalName(name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(strings.TrimPrefix(name, "*.")), ".")
+	if name == "" || isOnionName(name) || net.ParseIP(name) != nil {
+		return false
+	}
+	tld := name[strings.LastIndex(name, ".")+1:]
+	if tld == name {
+		return true
+	}
+	_, icann := publicsuffix.PublicSuffix(tld)
+	return !icann
+}
+
+// setNameFlags sets the flags of a certificate naming an IP address (as an iPAddress SAN or a
+// dNSName holding one), an onion service or an internal name
+func setNameFlags(details *CertificateDetails, dnsNames []string, ipAddresses []net.IP) {
+	details.HasIPSAN = len(ipAddresses) > 0
+	for _, name := range dnsNames {
+		switch {
+		case net.ParseIP(strings.TrimSuffix(name, ".")) != nil:
+			details.HasIPSAN = true
+		case isOnionName(name):
+			details.HasOnionSAN = true
+		case isInternalName(name):
+			details.HasInternalName = true
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Output selects where ingested entries are written
+type Output string
+
+const (
+	OutputClickHouse Output = "clickhouse" // Batched inserts into ct_log_entries
+	OutputStdout     Output = "stdout"     // One JSON line per entry, no database needed
+	OutputSQL        Output = "sql"        // SQL script for an embedded SQLite or DuckDB database, no ClickHouse needed
+	OutputSQLite     Output = "sqlite"     // Transactions into the SQLite file -sqlite_file, no ClickHouse needed
+)
+
+// sqlSchema creates the embedded database table written by -output=sql and -output=sqlite. It
+// holds the columns the export subcommand writes by default plus the base64 blobs, in types SQLite
+// and DuckDB share. Arrays are stored as JSON text.
+const sqlSchema = `CREATE TABLE IF NOT EXISTS ct_log_entries (
+	log_id TEXT NOT NULL,
+	log_index BIGINT NOT NULL,
+	entry_timestamp TIMESTAMP NOT NULL,
+	entry_type TEXT NOT NULL,
+	certificate_sha256 TEXT NOT NULL,
+	serial_number TEXT,
+	not_before TIMESTAMP,
+	not_after TIMESTAMP,
+	subject_common_name TEXT,
+	subject_alternative_names TEXT,
+	issuer_common_name TEXT,
+	issuer_organization TEXT,
+	is_ca BOOLEAN NOT NULL,
+	leaf_input TEXT,
+	extra_data TEXT,
+	PRIMARY KEY (log_id, log_index)
+);
+`
+
+// parseOutput validates an -output flag value
+func parseOutput(value string) (Output, error) {
+	switch output := Output(value); output {
+	case OutputClickHouse, OutputStdout, OutputSQL, OutputSQLite:
+		return output, nil
+	default:
+		return "", fmt.Errorf("unknown output %q (expected clickhouse, stdout, sql or sqlite)", value)
+	}
+}
+
+// stdoutWriter takes the place of dbInserter with -output=stdout: it writes every entry to stdout
+// as one JSON line, flushing whenever the channel is empty so consumers see entries promptly. After
+// a failed write ingestion stops and remaining entries are dropped.
+func stdoutWriter(logChan <-chan *CertificateDetails, failure *Failure, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	w := bufio.NewWriterSize(os.Stdout, 1<<20)
+	encoder := json.NewEncoder(w)
+	failed := false
+	for details := range logChan {
+		if !failed {
+			err := encoder.Encode(details)
+			if err == nil && len(logChan) == 0 {
+				err = w.Flush()
+			}
+			if err != nil {
+				failed = true
+				failure.Fail(fmt.Errorf("failed to write entry %d to stdout: %w", details.LogIndex, err))
+			}
+		}
+		releaseCertificateDetails(details)
+	}
+	if !failed {
+		if err := w.Flush(); err != nil {
+			failure.Fail(fmt.Errorf("failed to write to stdout: %w", err))
+		}
+	}
+}
+
+// sqlWriter takes the place of dbInserter with -output=sql: it writes the table schema and then
+// one INSERT OR REPLACE statement per entry to stdout, grouped into transactions of up to batchSize
+// entries that are committed whenever the channel is empty. Piped into the sqlite3 or duckdb shell
+// this fills a local database file, and re-ingesting an index replaces its row.
+func sqlWriter(logChan <-chan *CertificateDetails, batchSize int, failure *Failure, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	w := bufio.NewWriterSize(os.Stdout, 1<<20)
+	w.WriteString(sqlSchema)
+	failed := false
+	inTransaction := false
+	pending := 0
+	for details := range logChan {
+		if failed {
+			releaseCertificateDetails(details)
+			continue
+		}
+		if !inTransaction {
+			w.WriteString("BEGIN;\n")
+			inTransaction = true
+		}
+		writeSQLInsert(w, details)
+		pending++
+
+		var err error
+		if pending >= batchSize || len(logChan) == 0 {
+			w.WriteString("COMMIT;\n")
+			inTransaction = false
+			pending = 0
+			err = w.Flush()
+		}
+		if err != nil {
+			failed = true
+			failure.Fail(fmt.Errorf("failed to write entry %d to stdout: %w", details.LogIndex, err))
+		}
+		releaseCertificateDetails(details)
+	}
+	if !failed {
+		if inTransaction {
+			w.WriteString("COMMIT;\n")
+		}
+		if err := w.Flush(); err != nil {
+			failure.Fail(fmt.Errorf("failed to write to stdout: %w", err))
+		}
+	}
+}
+
+// sqlInsertInto starts the statement writing one row of sqlSchema, replacing the row of a
+// re-ingested index
+const sqlInsertInto = "INSERT OR REPLACE INTO ct_log_entries VALUES "
+
+// sqlRow returns the values of an entry in the column order of sqlSchema: strings, int64, bool,
+// or nil for NULL
+func sqlRow(details *CertificateDetails) []interface{} {
+	entryTimestamp := details.EntryTimestamp
+	if entryTimestamp.IsZero() {
+		entryTimestamp = time.Unix(0, 0) // entry_timestamp is NOT NULL; ClickHouse stores the epoch too
+	}
+	return []interface{}{
+		details.LogID,
+		details.LogIndex,
+		sqlTime(entryTimestamp),
+		details.EntryType,
+		details.CertificateSHA256,
+		sqlNullableString(details.SerialNumber),
+		sqlTime(details.NotBefore),
+		sqlTime(details.NotAfter),
+		sqlNullableString(details.SubjectCommonName),
+		sqlStringArray(details.SubjectAlternativeNames),
+		sqlNullableString(details.IssuerCommonName),
+		sqlStringArray(details.IssuerOrganization),
+		details.IsCA,
+		sqlNullableString(details.LeafInputBase64),
+		sqlNullableString(details.ExtraDataBase64),
+	}
+}
+
+// writeSQLInsert writes the INSERT statement of an entry with the values of sqlRow as literals
+func writeSQLInsert(w *bufio.Writer, details *CertificateDetails) {
+	row := sqlRow(details)
+	values := make([]string, len(row))
+	for i, value := range row {
+		switch value := value.(type) {
+		case nil:
+			values[i] = "NULL"
+		case string:
+			values[i] = sqlString(value)
+		case int64:
+			values[i] = strconv.FormatInt(value, 10)
+		case bool:
+			values[i] = strconv.FormatBool(value)
+		}
+	}
+	w.WriteString(sqlInsertInto + "(")
+	w.WriteString(strings.Join(values, ", "))
+	w.WriteString(");\n")
+}
+
+// sqlString quotes a string literal. NUL bytes are dropped since the database shells end the
+// statement at them.
+func sqlString(s string) string {
+	s = strings.ReplaceAll(s, "\x00", "")
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// sqlNullableString returns s, or nil (NULL) for the empty string
+func sqlNullableString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// sqlStringArray encodes a string slice as JSON text, or nil (NULL) when empty
+func sqlStringArray(values []string) interface{} {
+	if len(values) == 0 {
+		return nil
+	}
+	encoded, _ := json.Marshal(values)
+	return string(encoded)
+}
+
+// sqlTime formats a timestamp in UTC as text both databases read, or nil (NULL) for the zero time
+func sqlTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format("2006-01-02 15:04:05.000")
+}
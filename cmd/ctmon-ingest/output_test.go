@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSQLString(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "", want: "''"},
+		{in: "example.com", want: "'example.com'"},
+		{in: "O'Reilly Media", want: "'O''Reilly Media'"},
+		{in: "''", want: "''''''"},
+		{in: "evil\x00'); DROP TABLE ct_log_entries; --", want: "'evil''); DROP TABLE ct_log_entries; --'"},
+	}
+	for _, tt := range tests {
+		if got := sqlString(tt.in); got != tt.want {
+			t.Errorf("sqlString(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWriteSQLInsert(t *testing.T) {
+	tests := []struct {
+		name    string
+		details CertificateDetails
+		want    string
+	}{
+		{
+			name: "certificate",
+			details: CertificateDetails{
+				LogID:                   "ct.googleapis.com/logs/us1/argon2025h2",
+				LogIndex:                42,
+				EntryTimestamp:          time.Date(2025, 6, 1, 12, 30, 15, 250e6, time.UTC),
+				EntryType:               "x509_entry",
+				CertificateSHA256:       "ab12",
+				SerialNumber:            "0a:0b",
+				NotBefore:               time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC),
+				NotAfter:                time.Date(2025, 8, 30, 0, 0, 0, 0, time.UTC),
+				SubjectCommonName:       "example.com",
+				SubjectAlternativeNames: []string{"example.com", "www.example.com"},
+				IssuerCommonName:        "R11",
+				IssuerOrganization:      []string{"Let's Encrypt"},
+				LeafInputBase64:         "AAAA",
+			},
+			want: `INSERT OR REPLACE INTO ct_log_entries VALUES ('ct.googleapis.com/logs/us1/argon2025h2', 42, '2025-06-01 12:30:15.250', 'x509_entry', 'ab12', '0a:0b', '2025-06-01 00:00:00.000', '2025-08-30 00:00:00.000', 'example.com', '["example.com","www.example.com"]', 'R11', '["Let''s Encrypt"]', false, 'AAAA', NULL);` + "\n",
+		},
+		{
+			name: "entry without timestamp or certificate fields",
+			details: CertificateDetails{
+				LogID:     "log",
+				LogIndex:  7,
+				EntryType: "precert_entry",
+				IsCA:      true,
+			},
+			want: `INSERT OR REPLACE INTO ct_log_entries VALUES ('log', 7, '1970-01-01 00:00:00.000', 'precert_entry', '', NULL, NULL, NULL, NULL, NULL, NULL, NULL, true, NULL, NULL);` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			w := bufio.NewWriter(&out)
+			writeSQLInsert(w, &tt.details)
+			w.Flush()
+			if out.String() != tt.want {
+				t.Errorf("writeSQLInsert() =\n%s\nwant\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestSQLiteInserter(t *testing.T) {
+	db, err := openSQLite(filepath.Join(t.TempDir(), "ct.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	const logID = "ct.googleapis.com/logs/us1/argon2025h2"
+	if next, err := getLatestLogIndex(db, logID); err != nil || next != 0 {
+		t.Fatalf("getLatestLogIndex() of an empty database = %d, %v, want 0", next, err)
+	}
+
+	logChan := make(chan *CertificateDetails, 8)
+	for _, index := range []int64{3, 4, 5} {
+		details := acquireCertificateDetails()
+		details.LogID, details.LogIndex, details.EntryType = logID, index, "x509_entry"
+		details.SubjectCommonName = "first.example.com"
+		if index != 4 { // The zero timestamp of index 4 must not fail the NOT NULL column
+			details.EntryTimestamp = time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+		}
+		logChan <- details
+	}
+	replaced := acquireCertificateDetails()
+	replaced.LogID, replaced.LogIndex, replaced.EntryType = logID, 5, "x509_entry"
+	replaced.SubjectCommonName = "again.example.com"
+	logChan <- replaced
+	close(logChan)
+
+	failure := NewFailure()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	sqliteInserter(logChan, db, 2, failure, &wg)
+	if err := failure.Err(); err != nil {
+		t.Fatalf("sqliteInserter() failed: %v", err)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT count(*) FROM ct_log_entries WHERE log_id = ?", logID).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("stored %d rows, want 3", count)
+	}
+	var name string
+	if err := db.QueryRow("SELECT subject_common_name FROM ct_log_entries WHERE log_index = 5").Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	if name != "again.example.com" {
+		t.Errorf("re-ingested index 5 has subject %q, want the later row", name)
+	}
+	var epoch bool
+	if err := db.QueryRow("SELECT entry_timestamp = '1970-01-01 00:00:00.000' FROM ct_log_entries WHERE log_index = 4").Scan(&epoch); err != nil {
+		t.Fatal(err)
+	}
+	if !epoch {
+		t.Error("entry without timestamp is not stored with the epoch")
+	}
+	if next, err := getLatestLogIndex(db, logID); err != nil || next != 6 {
+		t.Errorf("getLatestLogIndex() = %d, %v, want 6", next, err)
+	}
+}
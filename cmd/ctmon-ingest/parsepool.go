@@ -0,0 +1,47 @@
+package main
+
+import (
+	"sync"
+)
+
+// parseResult is the outcome of parsing one entry of a get-entries response
+type parseResult struct {
+	details *CertificateDetails
+	err     error
+}
+
+// ParserPool parses entries on a fixed set of worker goroutines, so certificate parsing does not
+// bottleneck fetching on multi-core machines. Results keep the order of the input.
+type ParserPool struct {
+	jobs     chan func()
+	weakKeys *WeakKeyDetector
+}
+
+// NewParserPool starts workers parser goroutines, which run for the life of the process. weakKeys
+// may be nil.
+func NewParserPool(workers int, weakKeys *WeakKeyDetector) *ParserPool {
+	p := &ParserPool{jobs: make(chan func(), workers), weakKeys: weakKeys}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range p.jobs {
+				job()
+			}
+		}()
+	}
+	return p
+}
+
+// ParseAll parses the entries of a get-entries response starting at startIndex
+func (p *ParserPool) ParseAll(entries []CTLogResponseEntry, logID string, startIndex int64) []parseResult {
+	results := make([]parseResult, len(entries))
+	var wg sync.WaitGroup
+	wg.Add(len(entries))
+	for i := range entries {
+		p.jobs <- func() {
+			defer wg.Done()
+			results[i].details, results[i].err = parseLogEntry(entries[i], logID, startIndex+int64(i), p.weakKeys)
+		}
+	}
+	wg.Wait()
+	return results
+}
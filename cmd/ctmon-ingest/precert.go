@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+
+	ct "github.com/google/certificate-transparency-go"
+	cttls "github.com/google/certificate-transparency-go/tls"
+	ctx509 "github.com/google/certificate-transparency-go/x509"
+	ctpkix "github.com/google/certificate-transparency-go/x509/pkix"
+	"golang.org/x/crypto/cryptobyte"
+	cbasn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// setCTExtensionFlags sets whether the logged certificate or precert TBS carries the CT poison
+// (which a final certificate must not) and the embedded SCT list, and the serial it shares with
+// its precert or final certificate counterpart: that of every precert, and of final certificates
+// with embedded SCTs. Called once SerialNumber is set.
+func setCTExtensionFlags(details *CertificateDetails, extensions []ctpkix.Extension) {
+	for _, ext := range extensions {
+		switch {
+		case ext.Id.Equal(ctx509.OIDExtensionCTPoison):
+			details.PrecertPoisonPresent = true
+		case ext.Id.Equal(ctx509.OIDExtensionCTSCT):
+			details.HasEmbeddedSCTs = true
+		}
+	}
+	if details.EntryType == "precert_entry" || details.HasEmbeddedSCTs {
+		details.PrecertCorrespondingSerial = details.SerialNumber
+	}
+}
+
+// precertHasPoison reports whether the precertificate submitted with a precert entry (the
+// pre_certificate of its extra_data) carries the CT poison. The logged TBS never does, as RFC 6962
+// has logs remove it.
+func precertHasPoison(extraDataBase64 string) (bool, error) {
+	extraData, err := base64.StdEncoding.DecodeString(extraDataBase64)
+	if err != nil {
+		return false, fmt.Errorf("failed to base64 decode extra_data: %w", err)
+	}
+	var chain ct.PrecertChainEntry
+	if _, err := cttls.Unmarshal(extraData, &chain); err != nil {
+		return false, fmt.Errorf("failed to unmarshal precert chain: %w", err)
+	}
+	return certificateHasExtension(chain.PreCertificate.Data, asn1.ObjectIdentifier(ctx509.OIDExtensionCTPoison))
+}
+
+// certificateHasExtension reports whether a DER certificate has an extension, walking only as
+// far into it as needed instead of parsing it in full
+func certificateHasExtension(der []byte, oid asn1.ObjectIdentifier) (bool, error) {
+	input := cryptobyte.String(der)
+	var cert, tbs, extensions cryptobyte.String
+	var hasExtensions bool
+	if !input.ReadASN1(&cert, cbasn1.SEQUENCE) ||
+		!cert.ReadASN1(&tbs, cbasn1.SEQUENCE) ||
+		!tbs.SkipOptionalASN1(cbasn1.Tag(0).Constructed().ContextSpecific()) || // version
+		!tbs.SkipASN1(cbasn1.INTEGER) || // serialNumber
+		!tbs.SkipASN1(cbasn1.SEQUENCE) || // signature
+		!tbs.SkipASN1(cbasn1.SEQUENCE) || // issuer
+		!tbs.SkipASN1(cbasn1.SEQUENCE) || // validity
+		!tbs.SkipASN1(cbasn1.SEQUENCE) || // subject
+		!tbs.SkipASN1(cbasn1.SEQUENCE) || // subjectPublicKeyInfo
+		!tbs.SkipOptionalASN1(cbasn1.Tag(1).ContextSpecific()) || // issuerUniqueID
+		!tbs.SkipOptionalASN1(cbasn1.Tag(2).ContextSpecific()) || // subjectUniqueID
+		!tbs.ReadOptionalASN1(&extensions, &hasExtensions, cbasn1.Tag(3).Constructed().ContextSpecific()) {
+		return false, fmt.Errorf("malformed certificate")
+	}
+	if !hasExtensions {
+		return false, nil
+	}
+	if !extensions.ReadASN1(&extensions, cbasn1.SEQUENCE) {
+		return false, fmt.Errorf("malformed certificate extensions")
+	}
+	for !extensions.Empty() {
+		var extension cryptobyte.String
+		var id asn1.ObjectIdentifier
+		if !extensions.ReadASN1(&extension, cbasn1.SEQUENCE) || !extension.ReadASN1ObjectIdentifier(&id) {
+			return false, fmt.Errorf("malformed certificate extension")
+		}
+		if id.Equal(oid) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	progressSampleInterval = 5 * time.Second // Interval between samples of the inserted entry count
+	progressWindow         = 5 * time.Minute // Longest window rates are measured over
+)
+
+var (
+	metricEntriesPerSecond = newGauge("ctmon_ingest_entries_per_second", "Entries inserted per second, by window (1m, 5m)")
+	metricBytesIngested    = newCounter("ctmon_ingest_bytes_ingested_total", "Estimated bytes of inserted rows")
+	metricETA              = newGauge("ctmon_ingest_eta_seconds", "Estimated seconds until the next index reaches the tree size at the 5m rate")
+)
+
+// progressSample is the cumulative inserted entry count at a point in time
+type progressSample struct {
+	at      time.Time
+	entries int64
+}
+
+// Progress measures the insert rate over 1m and 5m windows and periodically logs it together with
+// the position in the log and the estimated time to catch up. A nil Progress records nothing.
+type Progress struct {
+	logID    string
+	treeSize func() (int64, error) // Current tree size, read at every report
+	interval time.Duration         // Interval between log lines, 0 to only export metrics
+
+	entries   atomic.Int64
+	bytes     atomic.Int64
+	nextIndex atomic.Int64
+
+	mu      sync.Mutex
+	samples []progressSample // Oldest first, covering progressWindow
+}
+
+// NewProgress creates a progress reporter for a log
+func NewProgress(logID string, treeSize func() (int64, error), interval time.Duration) *Progress {
+	return &Progress{logID: logID, treeSize: treeSize, interval: interval}
+}
+
+// SetNextIndex records the next index the fetcher will request
+func (p *Progress) SetNextIndex(index int64) {
+	if p == nil {
+		return
+	}
+	p.nextIndex.Store(index)
+}
+
+// RecordInsert counts a successfully inserted batch
+func (p *Progress) RecordInsert(batch []*CertificateDetails) {
+	if p == nil {
+		return
+	}
+	size := 0
+	for _, details := range batch {
+		size += details.insertSize()
+	}
+	p.entries.Add(int64(len(batch)))
+	p.bytes.Add(int64(size))
+	metricBytesIngested.Add(float64(size), "log", p.logID)
+}
+
+// Start samples the insert rate every progressSampleInterval and logs progress every interval
+// until done is closed
+func (p *Progress) Start(done <-chan struct{}) {
+	if p == nil {
+		return
+	}
+	p.sample(time.Now())
+
+	go func() {
+		sampleTicker := time.NewTicker(progressSampleInterval)
+		defer sampleTicker.Stop()
+		var reportTicker <-chan time.Time
+		if p.interval > 0 {
+			t := time.NewTicker(p.interval)
+			defer t.Stop()
+			reportTicker = t.C
+		}
+
+		for {
+			select {
+			case now := <-sampleTicker.C:
+				p.sample(now)
+			case <-reportTicker:
+				p.report()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// sample appends the current count, drops samples older than progressWindow and updates the rate
+// metrics
+func (p *Progress) sample(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.samples = append(p.samples, progressSample{at: now, entries: p.entries.Load()})
+	for len(p.samples) > 1 && now.Sub(p.samples[1].at) >= progressWindow {
+		p.samples = p.samples[1:]
+	}
+	metricEntriesPerSecond.Set(p.rateLocked(time.Minute), "log", p.logID, "window", "1m")
+	metricEntriesPerSecond.Set(p.rateLocked(progressWindow), "log", p.logID, "window", "5m")
+}
+
+// rateLocked returns the entries per second over the window, or over the samples available if
+// they cover less
+func (p *Progress) rateLocked(window time.Duration) float64 {
+	if len(p.samples) < 2 {
+		return 0
+	}
+	last := p.samples[len(p.samples)-1]
+	first := p.samples[0]
+	for _, s := range p.samples {
+		if last.at.Sub(s.at) <= window {
+			first = s
+			break
+		}
+	}
+	elapsed := last.at.Sub(first.at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(last.entries-first.entries) / elapsed
+}
+
+func (p *Progress) report() {
+	p.mu.Lock()
+	rate1m, rate5m := p.rateLocked(time.Minute), p.rateLocked(progressWindow)
+	p.mu.Unlock()
+
+	nextIndex := p.nextIndex.Load()
+	treeSize, err := p.treeSize()
+	if err != nil {
+		log.Printf("Progress: index %d, %.1f entries/sec (1m), %.1f entries/sec (5m), %.1f MB ingested (tree size unavailable: %v)",
+			nextIndex, rate1m, rate5m, float64(p.bytes.Load())/(1<<20), err)
+		return
+	}
+
+	remaining := max(treeSize-nextIndex, 0)
+	eta := "caught up"
+	switch {
+	case remaining > 0 && rate5m > 0:
+		seconds := float64(remaining) / rate5m
+		metricETA.Set(seconds, "log", p.logID)
+		eta = (time.Duration(seconds) * time.Second).String()
+	case remaining > 0:
+		eta = "unknown"
+	default:
+		metricETA.Set(0, "log", p.logID)
+	}
+	log.Printf("Progress: index %d of %d (%.2f%%, %d remaining), %.1f entries/sec (1m), %.1f entries/sec (5m), %.1f MB ingested, ETA %s",
+		nextIndex, treeSize, 100*float64(min(nextIndex, treeSize))/float64(max(treeSize, 1)), remaining,
+		rate1m, rate5m, float64(p.bytes.Load())/(1<<20), eta)
+}
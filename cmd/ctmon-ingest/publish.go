@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+const publishQueueSize = 16 // Inserted batches queued for publishing before batches are dropped
+
+// StreamEvent is the event published to the ctmon-api stream broker for each inserted entry
+type StreamEvent struct {
+	Source             string    `json:"source"`
+	ID                 string    `json:"id"`
+	LogID              string    `json:"log_id"`
+	LogIndex           int64     `json:"log_index"`
+	Timestamp          time.Time `json:"timestamp"`
+	EntryType          string    `json:"entry_type,omitempty"`
+	SubjectCommonName  string    `json:"subject_common_name,omitempty"`
+	Domains            []string  `json:"domains,omitempty"`
+	IssuerCommonName   string    `json:"issuer_common_name,omitempty"`
+	IssuerOrganization []string  `json:"issuer_organization,omitempty"`
+}
+
+// EventPublisher posts inserted batches to the ctmon-api publish endpoint. Publishing is best
+// effort: batches are dropped rather than slowing down ingestion when the API is unavailable.
+type EventPublisher struct {
+	url    string
+	token  string
+	client *http.Client
+	queue  chan []StreamEvent
+}
+
+// NewEventPublisher creates a publisher for the given endpoint, authenticating with CTMON_PUBLISH_TOKEN
+func NewEventPublisher(url string) *EventPublisher {
+	return &EventPublisher{
+		url:    url,
+		token:  os.Getenv("CTMON_PUBLISH_TOKEN"),
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan []StreamEvent, publishQueueSize),
+	}
+}
+
+// Start sends queued batches until done is closed
+func (p *EventPublisher) Start(done <-chan struct{}) {
+	go func() {
+		for {
+			select {
+			case events := <-p.queue:
+				if err := p.send(events); err != nil {
+					log.Printf("Warning: Failed to publish %d events: %v", len(events), err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// Publish queues an inserted batch. A nil publisher does nothing.
+func (p *EventPublisher) Publish(batch []*CertificateDetails) {
+	if p == nil || len(batch) == 0 {
+		return
+	}
+
+	events := make([]StreamEvent, 0, len(batch))
+	for _, details := range batch {
+		events = append(events, StreamEvent{
+			Source:             "ct",
+			ID:                 details.CertificateSHA256,
+			LogID:              details.LogID,
+			LogIndex:           details.LogIndex,
+			Timestamp:          details.EntryTimestamp,
+			EntryType:          details.EntryType,
+			SubjectCommonName:  details.SubjectCommonName,
+			Domains:            details.DNSNames,
+			IssuerCommonName:   details.IssuerCommonName,
+			IssuerOrganization: details.IssuerOrganization,
+		})
+	}
+
+	select {
+	case p.queue <- events:
+	default:
+		log.Printf("Warning: Publish queue is full, dropping %d events", len(events))
+	}
+}
+
+func (p *EventPublisher) send(events []StreamEvent) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return fmt.Errorf("failed to marshal events: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("publish endpoint returned status %d", resp.StatusCode)
+	}
+	return nil
+}
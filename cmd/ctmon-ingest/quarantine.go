@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+var metricQuarantined = newCounter("ctmon_ingest_entries_quarantined_total", "Entries written to ct_quarantine instead of ct_log_entries")
+
+// Quarantine records log entries that could not be processed in ct_quarantine, together with the
+// raw entry, so they can be inspected and re-ingested instead of silently skipped
+type Quarantine struct {
+	db    *sql.DB
+	logID string
+	run   *IngestRun // Counts quarantined entries as errors of the run
+}
+
+// NewQuarantine creates a quarantine for the entries of a log. Without db (-dry_run) entries are
+// only logged.
+func NewQuarantine(db *sql.DB, logID string, run *IngestRun) *Quarantine {
+	return &Quarantine{db: db, logID: logID, run: run}
+}
+
+// Add quarantines the entry at index. The raw entry is logged when it cannot be stored, so it is
+// never lost.
+func (q *Quarantine) Add(index int64, reason error, raw interface{}) {
+	metricQuarantined.Add(1, "log", q.logID)
+	q.run.RecordError()
+
+	rawJSON, err := json.Marshal(raw)
+	if err != nil {
+		rawJSON = []byte(fmt.Sprintf("%q", fmt.Sprint(raw)))
+	}
+	if q.db == nil {
+		log.Printf("Quarantined entry %d of %s (not stored, no database): %v. Raw entry: %s", index, q.logID, reason, rawJSON)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err = q.db.ExecContext(ctx, `
+		INSERT INTO ct_quarantine (log_id, log_index, reason, raw_entry, quarantined_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		q.logID, index, reason.Error(), string(rawJSON), time.Now().UTC())
+	if err != nil {
+		log.Printf("Warning: Failed to quarantine entry %d of %s (%v): %v. Raw entry: %s", index, q.logID, reason, err, rawJSON)
+		return
+	}
+	log.Printf("Quarantined entry %d of %s: %v", index, q.logID, reason)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	redactedValue       = "[redacted]"     // Replaces a redacted identity in the columns it was found in
+	redactMutationLimit = 60 * time.Minute // Timeout of each count and mutation, which wait for completion
+)
+
+// redaction is one rewrite of a table, matching the rows that contain the identity by where.
+// Mutations either update the columns in set (so the entry itself is kept) or, when set is empty,
+// delete the rows, for tables keyed by the identity.
+type redaction struct {
+	table string
+	where string
+	set   []string
+}
+
+// redactions are the rewrites of the CT, Rekor and subscription tables, in order. {ids} is replaced
+// with the array of identity forms matched; each mutation only sees the columns as they were before
+// it, so the raw blobs are cleared of the entries whose parsed columns are rewritten.
+var redactions = []redaction{
+	{
+		table: "ct_log_entries",
+		where: "hasAny(subject_alternative_names, {ids}) OR has({ids}, subject_common_name) OR multiSearchAnyCaseInsensitive(subject_dn, {ids})",
+		set: []string{
+			"subject_alternative_names = arrayMap(name -> if(has({ids}, name), '" + redactedValue + "', name), subject_alternative_names)",
+			"subject_common_name = if(has({ids}, subject_common_name), '" + redactedValue + "', subject_common_name)",
+			"subject_dn = if(multiSearchAnyCaseInsensitive(subject_dn, {ids}), '" + redactedValue + "', subject_dn)",
+			"leaf_input = ''",
+			"extra_data = ''",
+			"leaf_certificate_der = ''",
+		},
+	},
+	// name_rev is part of the sorting key, so rows reached by the identity are deleted
+	{table: "ct_log_entries_by_name", where: "has(arrayMap(id -> reverse(id), {ids}), name_rev)"},
+	{
+		table: "ct_log_entries_by_name",
+		where: "has({ids}, subject_common_name)",
+		set:   []string{"subject_common_name = '" + redactedValue + "'"},
+	},
+	{
+		table: "rekor_log_entries",
+		where: "hasAny(x509_sans, {ids}) OR has({ids}, x509_subject_cn) OR has({ids}, pgp_signer_email) OR has({ids}, signer_identity) OR multiSearchAnyCaseInsensitive(x509_subject_dn, {ids}) OR multiSearchAnyCaseInsensitive(pgp_signer_user_id, {ids})",
+		set: []string{
+			"x509_sans = arrayMap(name -> if(has({ids}, name), '" + redactedValue + "', name), x509_sans)",
+			"x509_subject_cn = if(has({ids}, x509_subject_cn), '" + redactedValue + "', x509_subject_cn)",
+			"x509_subject_dn = if(multiSearchAnyCaseInsensitive(x509_subject_dn, {ids}), '" + redactedValue + "', x509_subject_dn)",
+			"pgp_signer_email = if(has({ids}, pgp_signer_email), '" + redactedValue + "', pgp_signer_email)",
+			"pgp_signer_user_id = if(multiSearchAnyCaseInsensitive(pgp_signer_user_id, {ids}), '" + redactedValue + "', pgp_signer_user_id)",
+			"signer_identity = if(has({ids}, signer_identity), '" + redactedValue + "', signer_identity)",
+			"x509_extensions = ''",
+			"body = ''",
+		},
+	},
+	{table: "rekor_identities", where: "has({ids}, identity)"},
+	// Matches before the subscriptions they belong to, which are found through the identity
+	{
+		table: "subscription_matches",
+		where: "subscription_id IN (SELECT subscription_id FROM subscriptions WHERE has({ids}, email) OR has({ids}, value)) OR multiSearchAnyCaseInsensitive(summary, {ids})",
+	},
+	{table: "subscriptions", where: "has({ids}, email) OR has({ids}, value)"},
+}
+
+// runRedact implements the redact subcommand: it erases an email address or Sigstore identity from
+// the CT, Rekor and subscription tables, rewriting the identity columns of the entries that contain
+// it and clearing their raw blobs, and records the erasure in the redactions table
+func runRedact(args []string) {
+	fs := flag.NewFlagSet("redact", flag.ExitOnError)
+	identityFlag := fs.String("identity", "", "Email address or identity to erase (required)")
+	reasonFlag := fs.String("reason", "", "Reason recorded in the redactions table (e.g. a ticket reference)")
+	operatorFlag := fs.String("operator", os.Getenv("USER"), "Operator recorded in the redactions table")
+	dryRunFlag := fs.Bool("dry_run", false, "Only count the rows that would be rewritten")
+	fs.Parse(args)
+
+	identity := strings.TrimSpace(*identityFlag)
+	if identity == "" {
+		log.Fatal("Error: -identity is required")
+	}
+	if *operatorFlag == "" {
+		log.Fatal("Error: -operator is required when $USER is not set")
+	}
+
+	// Entries may hold the identity as given, lowercased, or hashed by -hash_emails
+	ids := []string{identity}
+	if lower := strings.ToLower(identity); lower != identity {
+		ids = append(ids, lower)
+	}
+	if key := os.Getenv(emailHMACKeyEnv); key != "" && isEmailAddress(identity) {
+		hasher, err := NewEmailHasher(key)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		ids = append(ids, hasher.Hash(identity))
+	}
+
+	db, err := initClickHouse()
+	if err != nil {
+		log.Fatalf("Failed to initialize ClickHouse connection: %v", err)
+	}
+	defer db.Close()
+
+	hash := sha256.Sum256([]byte(strings.ToLower(identity)))
+	redactionID := uuid.NewString()
+	start := time.Now()
+	var total uint64
+	for _, r := range redactions {
+		where := strings.ReplaceAll(r.where, "{ids}", "?")
+		matched, err := countRedaction(db, r.table, where, ids)
+		if err != nil {
+			log.Fatalf("Error: Failed to count rows of %s: %v", r.table, err)
+		}
+		action := "update"
+		if len(r.set) == 0 {
+			action = "delete"
+		}
+		log.Printf("%s: %d rows to %s", r.table, matched, action)
+		total += matched
+		if *dryRunFlag || matched == 0 {
+			continue
+		}
+
+		if err := applyRedaction(db, r, ids); err != nil {
+			log.Fatalf("Error: Failed to redact %s: %v", r.table, err)
+		}
+		_, err = db.Exec(`
+			INSERT INTO redactions (redaction_id, identity_sha256, operator, reason, table_name, action, rows, redacted_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			redactionID, hex.EncodeToString(hash[:]), *operatorFlag, *reasonFlag, r.table, action, matched, time.Now())
+		if err != nil {
+			log.Fatalf("Error: Failed to record redaction of %s: %v", r.table, err)
+		}
+	}
+
+	if *dryRunFlag {
+		log.Printf("Dry run: %d rows would be redacted", total)
+		return
+	}
+	log.Printf("Redacted %d rows in %v (redaction %s)", total, time.Since(start).Round(time.Millisecond), redactionID)
+	log.Printf("Note: ct_quarantine and rekor_quarantine keep undecodable responses as received and are not searched")
+}
+
+// countRedaction returns the number of rows of table matching where
+func countRedaction(db *sql.DB, table, where string, ids []string) (uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redactMutationLimit)
+	defer cancel()
+	args := make([]interface{}, strings.Count(where, "?"))
+	for i := range args {
+		args[i] = ids
+	}
+	var count uint64
+	err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT count() FROM %s WHERE %s", table, where), args...).Scan(&count)
+	return count, err
+}
+
+// applyRedaction runs the mutation of a redaction and waits for it to complete, so the identity
+// is gone once the redaction is recorded
+func applyRedaction(db *sql.DB, r redaction, ids []string) error {
+	query := fmt.Sprintf("ALTER TABLE %s DELETE WHERE %s", r.table, r.where)
+	if len(r.set) > 0 {
+		query = fmt.Sprintf("ALTER TABLE %s UPDATE %s WHERE %s", r.table, strings.Join(r.set, ", "), r.where)
+	}
+	query = strings.ReplaceAll(query, "{ids}", "?")
+
+	ctx, cancel := context.WithTimeout(context.Background(), redactMutationLimit)
+	defer cancel()
+	ctx = clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{
+		"mutations_sync": 2,
+	}))
+	args := make([]interface{}, strings.Count(query, "?"))
+	for i := range args {
+		args[i] = ids
+	}
+	_, err := db.ExecContext(ctx, query, args...)
+	return err
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const redisTimeout = 5 * time.Second // Dial and round trip timeout of Redis commands
+
+// redisError is an error reply from the Redis server
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// RedisClient is a minimal RESP2 client for the few commands the coordination store needs. It
+// keeps a single connection, serializes commands on it and redials after any error.
+type RedisClient struct {
+	addr       string
+	serverName string // TLS server name with rediss://, empty for plain TCP
+	username   string
+	password   string
+	db         int
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewRedisClient connects to a redis:// or rediss:// (TLS) URL of the form
+// redis://[[user]:password@]host[:port][/db]
+func NewRedisClient(rawURL string) (*RedisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("unsupported scheme %q (expected redis or rediss)", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("missing host")
+	}
+
+	c := &RedisClient{addr: u.Host}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.Scheme == "rediss" {
+		c.serverName = u.Hostname()
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil || c.db < 0 {
+			return nil, fmt.Errorf("invalid database number %q", db)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.connectLocked(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *RedisClient) connectLocked() error {
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	var conn net.Conn
+	var err error
+	if c.serverName != "" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.addr, &tls.Config{ServerName: c.serverName})
+	} else {
+		conn, err = dialer.Dial("tcp", c.addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to redis at %s: %w", c.addr, err)
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+
+	var setup [][]string
+	if c.password != "" {
+		if c.username != "" {
+			setup = append(setup, []string{"AUTH", c.username, c.password})
+		} else {
+			setup = append(setup, []string{"AUTH", c.password})
+		}
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	if len(setup) == 0 {
+		return nil
+	}
+	replies, err := c.roundTripLocked(setup)
+	if err == nil {
+		for _, reply := range replies {
+			if replyErr, ok := reply.(redisError); ok {
+				err = replyErr
+				break
+			}
+		}
+	}
+	if err != nil {
+		c.conn.Close()
+		c.conn = nil
+		return err
+	}
+	return nil
+}
+
+// Do runs a single command and returns its reply: a string, an int64, nil or a []interface{} of
+// those. An error reply is returned as the error.
+func (c *RedisClient) Do(args ...string) (interface{}, error) {
+	replies, err := c.Pipeline([][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	if replyErr, ok := replies[0].(redisError); ok {
+		return nil, replyErr
+	}
+	return replies[0], nil
+}
+
+// Pipeline sends the commands in one round trip and returns their replies in order. Error
+// replies are returned in the slice as redisError values rather than as the error.
+func (c *RedisClient) Pipeline(commands [][]string) ([]interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		if err := c.connectLocked(); err != nil {
+			return nil, err
+		}
+	}
+	replies, err := c.roundTripLocked(commands)
+	if err != nil {
+		// The connection may hold a partial reply, so start over on the next command
+		c.conn.Close()
+		c.conn = nil
+		return nil, err
+	}
+	return replies, nil
+}
+
+// Close closes the connection
+func (c *RedisClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+func (c *RedisClient) roundTripLocked(commands [][]string) ([]interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(redisTimeout))
+
+	var buf bytes.Buffer
+	for _, args := range commands {
+		fmt.Fprintf(&buf, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if _, err := c.conn.Write(buf.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to send redis command: %w", err)
+	}
+
+	replies := make([]interface{}, len(commands))
+	for i := range replies {
+		reply, err := readRedisReply(c.reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read redis reply: %w", err)
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+// readRedisReply reads one RESP2 reply
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed reply %q", line)
+	}
+	payload := line[1 : len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return payload, nil
+	case '-':
+		return redisError(payload), nil
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("malformed bulk length %q", payload)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("malformed array length %q", payload)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readRedisReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("malformed reply %q", line)
+	}
+}
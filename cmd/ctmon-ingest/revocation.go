@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	revocationStatusGood    = "good"
+	revocationStatusRevoked = "revoked"
+	revocationStatusUnknown = "unknown"
+
+	maxCRLSize = 64 << 20 // Largest CRL downloaded (some public CAs publish CRLs of tens of MB)
+)
+
+// revocationTarget is a watched certificate whose revocation status is polled
+type revocationTarget struct {
+	certificateSHA256 string
+	logID             string
+	logIndex          int64
+	leaf              *x509.Certificate
+	issuer            *x509.Certificate
+	lastStatus        string
+}
+
+// revocationResult is the outcome of a single OCSP or CRL check
+type revocationResult struct {
+	status    string
+	revokedAt time.Time
+	reason    int
+	source    string // "ocsp" or "crl"
+	sourceURL string
+}
+
+// cachedCRL is a parsed CRL kept until its nextUpdate
+type cachedCRL struct {
+	list    *x509.RevocationList
+	revoked map[string]x509.RevocationListEntry // serial (formatSerialNumber) -> entry
+}
+
+// RevocationChecker periodically checks the CRL/OCSP status of certificates that matched
+// watch rules and records status transitions in ct_revocations
+type RevocationChecker struct {
+	db       *sql.DB
+	client   *http.Client
+	interval time.Duration
+
+	mu      sync.Mutex
+	targets map[string]*revocationTarget // certificate SHA-256 -> target
+	crls    map[string]*cachedCRL        // distribution point URL -> CRL
+}
+
+// NewRevocationChecker creates a checker that polls tracked certificates every interval
+func NewRevocationChecker(db *sql.DB, client *http.Client, interval time.Duration) *RevocationChecker {
+	return &RevocationChecker{
+		db:       db,
+		client:   client,
+		interval: interval,
+		targets:  make(map[string]*revocationTarget),
+		crls:     make(map[string]*cachedCRL),
+	}
+}
+
+// Track starts polling the revocation status of a certificate. It must be called before the
+// storage profile drops the raw blobs, since the leaf and issuer are taken from the logged chain.
+func (rc *RevocationChecker) Track(details *CertificateDetails) error {
+	rc.mu.Lock()
+	_, tracked := rc.targets[details.CertificateSHA256]
+	rc.mu.Unlock()
+	if tracked {
+		return nil
+	}
+
+	leafDER, chain, err := decodeLoggedChain(details.EntryType, details.RawLeafCertificateDERBase64, details.ExtraDataBase64)
+	if err != nil {
+		return err
+	}
+	if len(chain) == 0 {
+		return fmt.Errorf("no issuer in logged chain")
+	}
+
+	// Precertificates carry a critical poison extension, which the standard library tolerates
+	leaf, err := x509.ParseCertificate(leafDER)
+	if err != nil {
+		return fmt.Errorf("failed to parse leaf certificate: %w", err)
+	}
+	issuer, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse issuer certificate: %w", err)
+	}
+	if len(leaf.OCSPServer) == 0 && len(leaf.CRLDistributionPoints) == 0 {
+		return fmt.Errorf("certificate has no OCSP responder or CRL distribution point")
+	}
+
+	rc.mu.Lock()
+	rc.targets[details.CertificateSHA256] = &revocationTarget{
+		certificateSHA256: details.CertificateSHA256,
+		logID:             details.LogID,
+		logIndex:          details.LogIndex,
+		leaf:              leaf,
+		issuer:            issuer,
+	}
+	rc.mu.Unlock()
+	return nil
+}
+
+// Start runs the polling loop until done is closed
+func (rc *RevocationChecker) Start(done <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(rc.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				rc.checkAll()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// checkAll checks every tracked certificate, dropping those that have expired
+func (rc *RevocationChecker) checkAll() {
+	rc.mu.Lock()
+	targets := make([]*revocationTarget, 0, len(rc.targets))
+	for key, target := range rc.targets {
+		if time.Now().After(target.leaf.NotAfter) {
+			delete(rc.targets, key)
+			continue
+		}
+		targets = append(targets, target)
+	}
+	rc.mu.Unlock()
+
+	for _, target := range targets {
+		result, err := rc.check(target)
+		if err != nil {
+			log.Printf("Warning: Revocation check failed for certificate %s: %v", target.certificateSHA256, err)
+			continue
+		}
+		if result.status == target.lastStatus {
+			continue
+		}
+		if err := rc.recordTransition(target, result); err != nil {
+			log.Printf("Warning: Failed to record revocation status for certificate %s: %v", target.certificateSHA256, err)
+			continue
+		}
+		if result.status == revocationStatusRevoked {
+			log.Printf("REVOKED: certificate %s (log %s index %d) revoked at %s according to %s",
+				target.certificateSHA256, target.logID, target.logIndex, result.revokedAt.UTC(), result.sourceURL)
+		}
+		target.lastStatus = result.status
+	}
+}
+
+// check queries the OCSP responders first and falls back to the CRL distribution points
+func (rc *RevocationChecker) check(target *revocationTarget) (*revocationResult, error) {
+	var lastErr error
+	for _, responder := range target.leaf.OCSPServer {
+		result, err := rc.checkOCSP(target, responder)
+		if err == nil && result.status != revocationStatusUnknown {
+			return result, nil
+		}
+		lastErr = err
+	}
+	for _, distributionPoint := range target.leaf.CRLDistributionPoints {
+		result, err := rc.checkCRL(target, distributionPoint)
+		if err == nil {
+			return result, nil
+		}
+		lastErr = err
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return &revocationResult{status: revocationStatusUnknown}, nil
+}
+
+func (rc *RevocationChecker) checkOCSP(target *revocationTarget, responder string) (*revocationResult, error) {
+	request, err := ocsp.CreateRequest(target.leaf, target.issuer, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OCSP request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, responder, bytes.NewReader(request))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OCSP HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("OCSP request to %s failed: %w", responder, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder %s returned status %d", responder, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OCSP response: %w", err)
+	}
+
+	// ParseResponseForCert verifies the response signature against the issuer
+	parsed, err := ocsp.ParseResponseForCert(body, target.leaf, target.issuer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OCSP response from %s: %w", responder, err)
+	}
+
+	result := &revocationResult{source: "ocsp", sourceURL: responder}
+	switch parsed.Status {
+	case ocsp.Good:
+		result.status = revocationStatusGood
+	case ocsp.Revoked:
+		result.status = revocationStatusRevoked
+		result.revokedAt = parsed.RevokedAt
+		result.reason = parsed.RevocationReason
+	default:
+		result.status = revocationStatusUnknown
+	}
+	return result, nil
+}
+
+func (rc *RevocationChecker) checkCRL(target *revocationTarget, distributionPoint string) (*revocationResult, error) {
+	crl, err := rc.fetchCRL(distributionPoint, target.issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &revocationResult{status: revocationStatusGood, source: "crl", sourceURL: distributionPoint}
+	if entry, ok := crl.revoked[formatSerialNumber(target.leaf.SerialNumber)]; ok {
+		result.status = revocationStatusRevoked
+		result.revokedAt = entry.RevocationTime
+		result.reason = entry.ReasonCode
+	}
+	return result, nil
+}
+
+// fetchCRL returns the CRL at a distribution point, re-downloading it once its nextUpdate has passed
+func (rc *RevocationChecker) fetchCRL(distributionPoint string, issuer *x509.Certificate) (*cachedCRL, error) {
+	rc.mu.Lock()
+	cached, ok := rc.crls[distributionPoint]
+	rc.mu.Unlock()
+	if ok && time.Now().Before(cached.list.NextUpdate) {
+		return cached, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, distributionPoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CRL request: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("CRL request to %s failed: %w", distributionPoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CRL distribution point %s returned status %d", distributionPoint, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCRLSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CRL: %w", err)
+	}
+
+	list, err := x509.ParseRevocationList(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CRL from %s: %w", distributionPoint, err)
+	}
+	if err := list.CheckSignatureFrom(issuer); err != nil {
+		return nil, fmt.Errorf("CRL from %s is not signed by the issuer: %w", distributionPoint, err)
+	}
+
+	crl := &cachedCRL{list: list, revoked: make(map[string]x509.RevocationListEntry, len(list.RevokedCertificateEntries))}
+	for _, entry := range list.RevokedCertificateEntries {
+		crl.revoked[formatSerialNumber(entry.SerialNumber)] = entry
+	}
+
+	rc.mu.Lock()
+	rc.crls[distributionPoint] = crl
+	rc.mu.Unlock()
+	return crl, nil
+}
+
+// recordTransition writes a status change into ct_revocations
+func (rc *RevocationChecker) recordTransition(target *revocationTarget, result *revocationResult) error {
+	var revokedAt interface{}
+	var reason interface{}
+	if result.status == revocationStatusRevoked {
+		revokedAt = result.revokedAt
+		reason = uint8(result.reason)
+	}
+
+	query := `
+		INSERT INTO ct_revocations (
+			certificate_sha256, serial_number, log_id, log_index, checked_at,
+			previous_status, status, revocation_time, revocation_reason, source, source_url
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := rc.db.ExecContext(ctx, query,
+		target.certificateSHA256,
+		formatSerialNumber(target.leaf.SerialNumber),
+		target.logID,
+		target.logIndex,
+		time.Now().UTC(),
+		nullableString(target.lastStatus),
+		result.status,
+		revokedAt,
+		reason,
+		result.source,
+		result.sourceURL,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert revocation status: %w", err)
+	}
+	return nil
+}
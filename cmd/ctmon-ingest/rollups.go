@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/routing-cafe/ctmon/internal/rollup"
+)
+
+var ctRollups = []rollup.Rollup{
+	{
+		View:  "ct_daily_issuer_stats_mv",
+		Table: "ct_daily_issuer_stats",
+		TableDDL: `(
+			day Date,
+			issuer_org LowCardinality(String) COMMENT 'First Issuer Organization (O)',
+			issuer_common_name LowCardinality(String),
+			entry_type Enum8('x509_entry' = 0, 'precert_entry' = 1),
+			entries SimpleAggregateFunction(sum, UInt64) COMMENT 'Log entries, counting each log a certificate was submitted to',
+			certificates AggregateFunction(uniq, FixedString(64)) COMMENT 'Distinct certificate_sha256, read with uniqMerge(certificates)'
+		)
+		ENGINE = AggregatingMergeTree()
+		ORDER BY (day, issuer_org, issuer_common_name, entry_type)`,
+		Source: "ct_log_entries",
+		Select: `SELECT
+			toDate(entry_timestamp) AS day,
+			arrayElement(issuer_organization, 1) AS issuer_org,
+			issuer_common_name,
+			entry_type,
+			toUInt64(count()) AS entries,
+			uniqState(certificate_sha256) AS certificates
+		FROM {source}
+		GROUP BY day, issuer_org, issuer_common_name, entry_type`,
+	},
+	{
+		View:  "ct_domain_first_seen_mv",
+		Table: "ct_domain_first_seen",
+		TableDDL: `(
+			registrable_domain String CODEC(ZSTD(1)),
+			first_seen SimpleAggregateFunction(min, DateTime) COMMENT 'Earliest entry_timestamp of a certificate for the domain'
+		)
+		ENGINE = AggregatingMergeTree()
+		ORDER BY registrable_domain`,
+		Source: "ct_log_entries",
+		Select: `SELECT
+			registrable_domain,
+			min(entry_timestamp) AS first_seen
+		FROM {source}
+		ARRAY JOIN dns_names.registrable_domain AS registrable_domain
+		WHERE registrable_domain != ''
+		GROUP BY registrable_domain`,
+	},
+	{
+		View:    "ct_daily_new_domains",
+		Refresh: "REFRESH EVERY 1 HOUR",
+		Source:  "ct_domain_first_seen",
+		Select: `SELECT toDate(first_seen) AS day, count() AS new_domains
+		FROM (
+			SELECT registrable_domain, min(first_seen) AS first_seen
+			FROM {source}
+			GROUP BY registrable_domain
+		)
+		GROUP BY day ORDER BY day`,
+	},
+}
+
+// runRollups implements the rollups subcommand: it creates missing rollups, backfilling new ones
+// from existing rows, and with -rebuild drops and recreates all of them
+func runRollups(args []string, rollups []rollup.Rollup) {
+	fs := flag.NewFlagSet("rollups", flag.ExitOnError)
+	rebuildFlag := fs.Bool("rebuild", false, "Drop and recreate all rollups, then backfill them")
+	backfillFlag := fs.Bool("backfill", false, "Backfill existing rollups from the source tables (rows may be counted twice)")
+	fs.Parse(args)
+
+	db, err := initClickHouse()
+	if err != nil {
+		log.Fatalf("Failed to initialize ClickHouse connection: %v", err)
+	}
+	defer db.Close()
+
+	if err := rollup.Run(db, rollups, *rebuildFlag, *backfillFlag); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// entryTable describes an entry table whose layout the schema subcommand sets
+type entryTable struct {
+	name       string
+	logColumn  string   // Column of the log log_index is within
+	timeColumn string   // DateTime the entry was logged at
+	rawColumns []string // Raw blob columns -raw_ttl_days clears
+	optimized  map[string]columnStorage
+}
+
+// columnStorage is the type and codec of a column in the optimized variant, empty to keep the
+// basic one. Types of key and skip index columns are kept, so the basic schema can be migrated.
+type columnStorage struct {
+	Type  string
+	Codec string
+}
+
+// Codecs of the optimized variant
+const (
+	codecIndex     = "Delta, ZSTD(1)"       // Log indexes and times growing with them
+	codecInsertion = "DoubleDelta, ZSTD(1)" // Insertion times, growing steadily
+	codecSmallInt  = "T64, ZSTD(1)"         // Integers far below their type's range
+	codecText      = "ZSTD(1)"
+)
+
+// entryTables are the tables the schema options apply to
+var entryTables = []entryTable{
+	{
+		name: "ct_log_entries", logColumn: "log_id", timeColumn: "entry_timestamp",
+		rawColumns: []string{"leaf_input", "extra_data", "leaf_certificate_der"},
+		optimized: map[string]columnStorage{
+			"log_index":                  {Codec: codecIndex},
+			"retrieval_timestamp":        {Codec: codecInsertion},
+			"entry_timestamp":            {Codec: codecIndex},
+			"audit_path_tree_size":       {Codec: codecSmallInt},
+			"validity_days":              {Codec: codecSmallInt},
+			"subject_dn":                 {Codec: codecText},
+			"subject_country":            {Type: "Array(LowCardinality(String))"},
+			"issuer_dn":                  {Type: "LowCardinality(String)"},
+			"issuer_organization":        {Type: "Array(LowCardinality(String))"},
+			"issuer_organizational_unit": {Type: "Array(LowCardinality(String))"},
+			"issuer_country":             {Type: "Array(LowCardinality(String))"},
+			"issuer_locality":            {Type: "Array(LowCardinality(String))"},
+			"issuer_province":            {Type: "Array(LowCardinality(String))"},
+			"issuer_spki_sha256":         {Type: "LowCardinality(String)"},
+			"authority_key_identifier":   {Type: "LowCardinality(String)"},
+			"subject_alternative_names":  {Codec: codecText},
+			"crl_distribution_points":    {Type: "Array(LowCardinality(String))"},
+			"ocsp_responders":            {Type: "Array(LowCardinality(String))"},
+			"fetch_peer_addr":            {Type: "LowCardinality(Nullable(String))"},
+			"fetch_local_addr":           {Type: "LowCardinality(Nullable(String))"},
+		},
+	},
+	{
+		name: "rekor_log_entries", logColumn: "tree_id", timeColumn: "integrated_time",
+		rawColumns: []string{"body"},
+		optimized: map[string]columnStorage{
+			"log_index":                       {Codec: codecIndex},
+			"retrieval_timestamp":             {Codec: codecInsertion},
+			"integrated_time":                 {Codec: codecIndex},
+			"inclusion_proof_tree_size":       {Codec: codecSmallInt},
+			"log_id":                          {Type: "LowCardinality(String)"},
+			"api_version":                     {Type: "LowCardinality(String)"},
+			"signed_entry_timestamp":          {Codec: codecText},
+			"inclusion_proof_hashes":          {Codec: codecText},
+			"inclusion_proof_checkpoint":      {Codec: codecText},
+			"x509_subject_country":            {Type: "Array(LowCardinality(String))"},
+			"x509_issuer_dn":                  {Type: "LowCardinality(String)"},
+			"x509_issuer_organization":        {Type: "Array(LowCardinality(String))"},
+			"x509_issuer_ou":                  {Type: "Array(LowCardinality(String))"},
+			"x509_issuer_country":             {Type: "Array(LowCardinality(String))"},
+			"x509_sct_log_ids":                {Type: "Array(LowCardinality(String))"},
+			"x509_issuing_certificate_sha256": {Type: "LowCardinality(String)"},
+			"fetch_peer_addr":                 {Type: "LowCardinality(Nullable(String))"},
+			"fetch_local_addr":                {Type: "LowCardinality(Nullable(String))"},
+		},
+	},
+}
+
+// schemaOptions are the layout choices of the entry tables
+type schemaOptions struct {
+	Variant     string // basic (as in schema.sql) or optimized
+	PartitionBy string // default (as in schema.sql), entry_month or none
+	OrderBy     string // index or time
+	RawTTLDays  int    // 0 keeps the raw blobs
+}
+
+// runSchema implements the schema subcommand: it prints schema.sql with the column storage,
+// partitioning, sorting key and raw blob TTLs of the entry tables set by flags, or executes it
+// with -apply, so a deployment gets a layout fitting its queries and retention without editing
+// the SQL. With -migrate it outputs the statements moving existing tables to the optimized
+// variant instead.
+func runSchema(args []string) {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	fileFlag := fs.String("file", "schema.sql", "Schema file to render")
+	variantFlag := fs.String("variant", "basic", "Column storage of the entry tables: basic (as in the file) or optimized (LowCardinality issuer and address columns, Delta/DoubleDelta/T64 codecs for indexes, times and counts, ZSTD for text)")
+	migrateFlag := fs.Bool("migrate", false, "Instead of the schema, output the ALTER TABLE statements turning existing basic entry tables into the optimized variant")
+	partitionByFlag := fs.String("partition_by", "default", "Partitioning of ct_log_entries and rekor_log_entries: default (month of certificate expiry for CT, of integration for Rekor), entry_month (month of entry_timestamp or integrated_time) or none")
+	orderByFlag := fs.String("order_by", "index", "Sorting key of the entry tables: index (log, log_index), best for resuming and index ranges, or time (log, day, log_index), best for time range queries")
+	rawTTLDaysFlag := fs.Int("raw_ttl_days", 0, "Days after which the raw blob columns of the entry tables (leaf_input, extra_data, leaf_certificate_der, body) are cleared (0 keeps them)")
+	applyFlag := fs.Bool("apply", false, "Execute the statements against ClickHouse instead of printing them")
+	fs.Parse(args)
+
+	options := schemaOptions{Variant: *variantFlag, PartitionBy: *partitionByFlag, OrderBy: *orderByFlag, RawTTLDays: *rawTTLDaysFlag}
+	if options.Variant != "basic" && options.Variant != "optimized" {
+		log.Fatalf("Error: Invalid -variant %q (expected basic or optimized)", options.Variant)
+	}
+	switch options.PartitionBy {
+	case "default", "entry_month", "none":
+	default:
+		log.Fatalf("Error: Invalid -partition_by %q (expected default, entry_month or none)", options.PartitionBy)
+	}
+	if options.OrderBy != "index" && options.OrderBy != "time" {
+		log.Fatalf("Error: Invalid -order_by %q (expected index or time)", options.OrderBy)
+	}
+	if options.RawTTLDays < 0 {
+		log.Fatal("Error: -raw_ttl_days must not be negative")
+	}
+
+	data, err := os.ReadFile(*fileFlag)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	var schema string
+	if *migrateFlag {
+		schema, err = optimizeMigration(string(data))
+	} else {
+		schema, err = renderSchema(string(data), options)
+	}
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if !*applyFlag {
+		fmt.Print(schema)
+		return
+	}
+
+	db, err := initClickHouse()
+	if err != nil {
+		log.Fatalf("Failed to initialize ClickHouse connection: %v", err)
+	}
+	defer db.Close()
+	statements := splitStatements(schema)
+	for i, statement := range statements {
+		if _, err := db.ExecContext(context.Background(), statement); err != nil {
+			log.Fatalf("Statement %d of %d failed: %v\n%s", i+1, len(statements), err, statement)
+		}
+	}
+	log.Printf("Applied %d statements of %s", len(statements), *fileFlag)
+}
+
+// renderSchema rewrites the CREATE TABLE statements of the entry tables in schema for options
+func renderSchema(schema string, options schemaOptions) (string, error) {
+	lines := strings.Split(schema, "\n")
+	for _, table := range entryTables {
+		start, end := tableLines(lines, table.name)
+		if start < 0 {
+			return "", fmt.Errorf("no CREATE TABLE %s in the schema", table.name)
+		}
+		var rewritten []string
+		optimized := 0
+		for _, line := range lines[start:end] {
+			if options.Variant == "optimized" {
+				var ok bool
+				if line, ok = withColumnStorage(line, table.optimized); ok {
+					optimized++
+				}
+			}
+			switch {
+			case strings.HasPrefix(line, "PARTITION BY "):
+				switch options.PartitionBy {
+				case "entry_month":
+					line = "PARTITION BY toYYYYMM(" + table.timeColumn + ") -- Partition by month the entry was logged"
+				case "none":
+					continue
+				}
+			case strings.HasPrefix(line, "ORDER BY ") && options.OrderBy == "time":
+				line = "ORDER BY (" + table.logColumn + ", toDate(" + table.timeColumn + "), log_index) -- Sorted by day for time range queries"
+			case options.RawTTLDays > 0:
+				line = withColumnTTL(line, table.rawColumns, fmt.Sprintf("%s + INTERVAL %d DAY", table.timeColumn, options.RawTTLDays))
+			}
+			rewritten = append(rewritten, line)
+		}
+		if options.Variant == "optimized" && optimized != len(table.optimized) {
+			return "", fmt.Errorf("only %d of the %d optimized columns of %s are in the schema", optimized, len(table.optimized), table.name)
+		}
+		lines = append(lines[:start], append(rewritten, lines[end:]...)...)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// tableLines returns the range of lines of the CREATE TABLE statement of table, up to the line
+// ending it with a semicolon, or -1 if there is none
+func tableLines(lines []string, table string) (start, end int) {
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "CREATE TABLE "+table || strings.TrimSpace(line) == "CREATE TABLE "+table+" (" {
+			for j := i + 1; j < len(lines); j++ {
+				statement, _, _ := strings.Cut(lines[j], "--")
+				if strings.HasSuffix(strings.TrimSpace(statement), ";") {
+					return i, j + 1
+				}
+			}
+		}
+	}
+	return -1, -1
+}
+
+// withColumnTTL appends a TTL clause to the definition of any of columns on line
+func withColumnTTL(line string, columns []string, ttl string) string {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !strings.HasSuffix(line, ",") {
+		return line
+	}
+	for _, column := range columns {
+		if fields[0] == column {
+			return strings.TrimSuffix(line, ",") + " TTL " + ttl + ","
+		}
+	}
+	return line
+}
+
+// withColumnStorage sets the type and codec of storage on line if it defines one of its columns,
+// and reports whether it did
+func withColumnStorage(line string, storage map[string]columnStorage) (string, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !strings.HasSuffix(line, ",") {
+		return line, false
+	}
+	column, ok := storage[fields[0]]
+	if !ok {
+		return line, false
+	}
+	indent := line[:strings.Index(line, fields[0])]
+	definition := strings.TrimSuffix(strings.TrimPrefix(line, indent+fields[0]+" "+fields[1]), ",")
+	if column.Type != "" {
+		fields[1] = column.Type
+	}
+	if column.Codec != "" {
+		if i := strings.Index(definition, " CODEC("); i >= 0 {
+			depth, j := 0, i+len(" CODEC")
+			for ; j < len(definition); j++ {
+				if definition[j] == '(' {
+					depth++
+				} else if definition[j] == ')' {
+					if depth--; depth == 0 {
+						break
+					}
+				}
+			}
+			definition = definition[:i] + definition[min(j+1, len(definition)):]
+		}
+		definition += " CODEC(" + column.Codec + ")"
+	}
+	return indent + fields[0] + " " + fields[1] + definition + ",", true
+}
+
+// optimizeMigration returns the ALTER TABLE statements turning the entry tables of the basic schema
+// into the optimized variant: one per table, modifying every optimized column. ClickHouse rewrites
+// the columns in the background as a mutation (see system.mutations).
+func optimizeMigration(schema string) (string, error) {
+	lines := strings.Split(schema, "\n")
+	var statements []string
+	for _, table := range entryTables {
+		start, end := tableLines(lines, table.name)
+		if start < 0 {
+			return "", fmt.Errorf("no CREATE TABLE %s in the schema", table.name)
+		}
+		var modifications []string
+		for _, line := range lines[start:end] {
+			if line, ok := withColumnStorage(line, table.optimized); ok {
+				modifications = append(modifications, "    MODIFY COLUMN "+strings.TrimSuffix(strings.TrimSpace(line), ","))
+			}
+		}
+		if len(modifications) != len(table.optimized) {
+			return "", fmt.Errorf("only %d of the %d optimized columns of %s are in the schema", len(modifications), len(table.optimized), table.name)
+		}
+		statements = append(statements, "ALTER TABLE "+table.name+"\n"+strings.Join(modifications, ",\n")+";\n")
+	}
+	return strings.Join(statements, "\n"), nil
+}
+
+// splitStatements splits SQL into its statements, on semicolons outside quotes and comments
+func splitStatements(sql string) []string {
+	var statements []string
+	var current strings.Builder
+	inQuote, inComment, hasCode := false, false, false
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case inComment:
+			if c == '\n' {
+				inComment = false
+			}
+		case inQuote:
+			current.WriteByte(c)
+			if c == '\\' && i+1 < len(sql) {
+				i++
+				current.WriteByte(sql[i])
+			} else if c == '\'' {
+				inQuote = false
+			}
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			inComment = true
+		case c == ';':
+			if hasCode {
+				statements = append(statements, strings.TrimSpace(current.String()))
+			}
+			current.Reset()
+			hasCode = false
+		default:
+			if c == '\'' {
+				inQuote = true
+			}
+			if c != ' ' && c != '\n' && c != '\t' && c != '\r' {
+				hasCode = true
+			}
+			current.WriteByte(c)
+		}
+	}
+	if hasCode {
+		statements = append(statements, strings.TrimSpace(current.String()))
+	}
+	return statements
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const spoolReplayInterval = 1 * time.Minute // Interval between attempts to insert spooled batches
+
+var metricSpooledBatches = newGauge("ctmon_ingest_spooled_batches", "Batches waiting in -spool_dir to be replayed")
+
+// Spool keeps batches that could not be inserted as JSON files in a directory and replays them
+// once the database accepts inserts again. Files are written atomically, so a crash never leaves
+// a partial batch behind.
+type Spool struct {
+	dir    string
+	insert func([]*CertificateDetails) error
+
+	mu sync.Mutex // Serializes replays
+}
+
+// NewSpool creates the spool directory if needed. insert is used to replay spooled batches.
+func NewSpool(dir string, insert func([]*CertificateDetails) error) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	return &Spool{dir: dir, insert: insert}, nil
+}
+
+// Write stores a batch in the spool
+func (s *Spool) Write(batch []*CertificateDetails) error {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("failed to marshal batch: %w", err)
+	}
+
+	// Names sort in the order batches were spooled
+	name := fmt.Sprintf("batch-%d-%d.json", time.Now().UnixNano(), batch[0].LogIndex)
+	tmp := filepath.Join(s.dir, "."+name+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write spool file: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write spool file: %w", err)
+	}
+	log.Printf("Spooled batch of %d entries to %s", len(batch), filepath.Join(s.dir, name))
+	s.updateMetric()
+	return nil
+}
+
+// Start replays spooled batches immediately and then every spoolReplayInterval until done is closed
+func (s *Spool) Start(done <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(spoolReplayInterval)
+		defer ticker.Stop()
+		for {
+			if err := s.Replay(); err != nil {
+				log.Printf("Warning: Failed to replay spooled batches: %v", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// Replay inserts spooled batches oldest first and removes them, stopping at the first failure
+func (s *Spool) Replay() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.updateMetric()
+
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		var batch []*CertificateDetails
+		if err := json.Unmarshal(data, &batch); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+		if err := s.insert(batch); err != nil {
+			return fmt.Errorf("failed to insert %s: %w", file, err)
+		}
+		if err := os.Remove(file); err != nil {
+			return fmt.Errorf("failed to remove replayed %s: %w", file, err)
+		}
+		log.Printf("Replayed spooled batch of %d entries from %s", len(batch), file)
+	}
+	return nil
+}
+
+// files returns the spooled batch files, oldest first
+func (s *Spool) files() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list spool directory: %w", err)
+	}
+	var files []string
+	for _, entry := range entries {
+		if name := entry.Name(); strings.HasPrefix(name, "batch-") && strings.HasSuffix(name, ".json") {
+			files = append(files, filepath.Join(s.dir, name))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (s *Spool) updateMetric() {
+	if files, err := s.files(); err == nil {
+		metricSpooledBatches.Set(float64(len(files)), "dir", s.dir)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite" // Pure Go driver "sqlite", so the binary still builds without cgo
+)
+
+// openSQLite opens the SQLite database file of -output=sqlite, creating it and its table if
+// missing. Writes go through one connection, as SQLite allows a single writer.
+func openSQLite(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	// WAL lets the sqlite3 shell and notebooks read the file while entries are written
+	for _, statement := range []string{"PRAGMA journal_mode = WAL", "PRAGMA busy_timeout = 10000", sqlSchema} {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to set up %s: %w", path, err)
+		}
+	}
+	return db, nil
+}
+
+// sqliteInserter takes the place of dbInserter with -output=sqlite: it writes entries to the
+// SQLite database in transactions of up to batchSize entries, committed whenever the channel is
+// empty. Re-ingesting an index replaces its row. After a failed write ingestion stops and
+// remaining entries are dropped; they are fetched again on resumption from MAX(log_index).
+func sqliteInserter(logChan <-chan *CertificateDetails, db *sql.DB, batchSize int, failure *Failure, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(sqlRow(&CertificateDetails{}))), ", ")
+	insert, err := db.Prepare(sqlInsertInto + "(" + placeholders + ")")
+	failed := err != nil
+	if failed {
+		failure.Fail(fmt.Errorf("failed to prepare SQLite insert: %w", err))
+	}
+
+	var tx *sql.Tx
+	var stmt *sql.Stmt // insert within tx
+	pending := 0
+	for details := range logChan {
+		if failed {
+			releaseCertificateDetails(details)
+			continue
+		}
+		if tx == nil {
+			if tx, err = db.Begin(); err != nil {
+				err = fmt.Errorf("failed to begin SQLite transaction: %w", err)
+			} else {
+				stmt = tx.Stmt(insert)
+			}
+		}
+		if err == nil {
+			if _, err = stmt.Exec(sqlRow(details)...); err != nil {
+				err = fmt.Errorf("failed to write entry %d to SQLite: %w", details.LogIndex, err)
+			}
+		}
+		if err == nil {
+			pending++
+			if pending >= batchSize || len(logChan) == 0 {
+				if err = tx.Commit(); err != nil {
+					err = fmt.Errorf("failed to commit entries up to %d to SQLite: %w", details.LogIndex, err)
+				}
+				tx, pending = nil, 0
+			}
+		}
+		if err != nil {
+			if tx != nil {
+				tx.Rollback()
+				tx = nil
+			}
+			failed = true
+			failure.Fail(err)
+		}
+		releaseCertificateDetails(details)
+	}
+	if tx != nil {
+		if err := tx.Commit(); err != nil {
+			failure.Fail(fmt.Errorf("failed to commit entries to SQLite: %w", err))
+		}
+	}
+	if insert != nil {
+		insert.Close()
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// statusScanEntries bounds the index range scanned to measure the recent ingestion rate
+const statusScanEntries = 10_000_000
+
+// LogStatus is the ingestion progress of one log, as reported by the status subcommand
+type LogStatus struct {
+	Log              string     `json:"log"`
+	TreeSize         int64      `json:"tree_size"`
+	NextIndex        int64      `json:"next_index"`
+	Lag              int64      `json:"lag"`
+	LastInsert       *time.Time `json:"last_insert,omitempty"`
+	EntriesPerSecond float64    `json:"entries_per_second"`
+	ETASeconds       *int64     `json:"eta_seconds,omitempty"` // Unset when caught up or not progressing
+	Error            string     `json:"error,omitempty"`
+}
+
+// runStatus implements the status subcommand: it compares the latest stored index of each log
+// against its current STH and prints the lag and estimated catch-up time
+func runStatus(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	logURLsFlag := fs.String("log_url", "", "Comma-separated CT log URLs to report on (default: every log in ct_log_stats_by_log_id)")
+	jsonFlag := fs.Bool("json", false, "Print JSON instead of a table")
+	windowFlag := fs.Duration("window", time.Hour, "Window over which the ingestion rate is measured")
+	fs.Parse(args)
+
+	if *windowFlag <= 0 {
+		log.Fatal("Error: -window must be positive")
+	}
+
+	db, err := initClickHouse()
+	if err != nil {
+		log.Fatalf("Failed to initialize ClickHouse connection: %v", err)
+	}
+	defer db.Close()
+
+	var logURLs []string
+	for _, logURL := range strings.Split(*logURLsFlag, ",") {
+		if logURL = strings.TrimSpace(logURL); logURL != "" {
+			logURLs = append(logURLs, logURL)
+		}
+	}
+	if len(logURLs) == 0 {
+		logURLs, err = knownLogURLs(db)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+
+	client := &http.Client{Timeout: requestTimeout}
+	var statuses []LogStatus
+	failed := false
+	for _, logURL := range logURLs {
+		status := ctLogStatus(db, client, logURL, *windowFlag)
+		if status.Error != "" {
+			failed = true
+		}
+		statuses = append(statuses, status)
+	}
+
+	printStatus(statuses, *jsonFlag)
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// knownLogURLs returns the URLs of all logs with stored entries, derived from their log IDs
+func knownLogURLs(db *sql.DB) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "SELECT log_id FROM ct_log_stats_by_log_id ORDER BY log_id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list logs: %w", err)
+	}
+	defer rows.Close()
+
+	var logURLs []string
+	for rows.Next() {
+		var logID string
+		if err := rows.Scan(&logID); err != nil {
+			return nil, fmt.Errorf("failed to scan log ID: %w", err)
+		}
+		logURLs = append(logURLs, "https://"+logID)
+	}
+	return logURLs, rows.Err()
+}
+
+func ctLogStatus(db *sql.DB, client *http.Client, logURL string, window time.Duration) LogStatus {
+	status := LogStatus{Log: logURL}
+
+	parsedLogURL, err := url.Parse(logURL)
+	if err != nil {
+		status.Error = fmt.Sprintf("invalid log URL: %v", err)
+		return status
+	}
+	logID := parsedLogURL.Host + parsedLogURL.Path
+
+	sth, err := fetchSTH(client, logURL)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.TreeSize = sth.TreeSize
+
+	status.NextIndex, err = getLatestLogIndex(db, logID)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Lag = max(status.TreeSize-status.NextIndex, 0)
+
+	if err := recentIngestion(db, "ct_log_entries", "log_id", logID, status.NextIndex, window, &status); err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// recentIngestion fills in the last insert time, the ingestion rate over window and the ETA.
+// Only the most recent statusScanEntries indexes are scanned.
+func recentIngestion(db *sql.DB, table, logColumn, logID string, nextIndex int64, window time.Duration, status *LogStatus) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		SELECT max(retrieval_timestamp), countIf(retrieval_timestamp >= now() - toIntervalSecond(?))
+		FROM %s
+		WHERE %s = ? AND log_index >= ?`, table, logColumn)
+
+	var lastInsert time.Time
+	var recent uint64
+	err := db.QueryRowContext(ctx, query, int64(window.Seconds()), logID, max(nextIndex-statusScanEntries, 0)).Scan(&lastInsert, &recent)
+	if err != nil {
+		return fmt.Errorf("failed to measure ingestion rate: %w", err)
+	}
+
+	if !lastInsert.IsZero() && lastInsert.Unix() > 0 {
+		lastInsert = lastInsert.UTC()
+		status.LastInsert = &lastInsert
+	}
+	status.EntriesPerSecond = float64(recent) / window.Seconds()
+	if status.Lag > 0 && status.EntriesPerSecond > 0 {
+		eta := int64(float64(status.Lag) / status.EntriesPerSecond)
+		status.ETASeconds = &eta
+	}
+	return nil
+}
+
+func printStatus(statuses []LogStatus, asJSON bool) {
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(statuses)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LOG\tTREE SIZE\tNEXT INDEX\tLAG\tLAST INSERT\tRATE (/s)\tETA")
+	for _, s := range statuses {
+		if s.Error != "" {
+			fmt.Fprintf(w, "%s\terror: %s\n", s.Log, s.Error)
+			continue
+		}
+		lastInsert := "never"
+		if s.LastInsert != nil {
+			lastInsert = fmt.Sprintf("%s ago", time.Since(*s.LastInsert).Round(time.Second))
+		}
+		eta := "-"
+		switch {
+		case s.Lag == 0:
+			eta = "caught up"
+		case s.ETASeconds != nil:
+			eta = (time.Duration(*s.ETASeconds) * time.Second).String()
+		case s.Lag > 0:
+			eta = "stalled"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\t%.1f\t%s\n", s.Log, s.TreeSize, s.NextIndex, s.Lag, lastInsert, s.EntriesPerSecond, eta)
+	}
+	w.Flush()
+}
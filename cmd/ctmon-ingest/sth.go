@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+)
+
+var (
+	metricSTHAge           = newGauge("ctmon_ingest_sth_age_seconds", "Seconds between the timestamp of the latest verified STH and its last refresh")
+	metricSTHRefreshErrors = newCounter("ctmon_ingest_sth_refresh_errors_total", "STH refreshes that failed or were rejected, by reason (fetch, signature, inconsistent)")
+)
+
+// TreeHeadTracker keeps the latest verified STH of a log, refreshing it on a schedule. An STH is
+// rejected if its signature does not verify against the log's public key (when known), if its
+// tree size or timestamp go backwards, or if it reports a different root hash for the same tree
+// size. The tree size of the latest verified STH bounds the get-entries ranges, and the lag behind
+// it is exported as metrics.
+type TreeHeadTracker struct {
+	logID    string
+	logURL   string
+	client   *http.Client
+	verifier *ct.SignatureVerifier // nil when the public key is unknown
+
+	nextIndex atomic.Int64
+
+	mu     sync.Mutex // Serializes refreshes
+	latest atomic.Pointer[STHResponse]
+}
+
+// NewTreeHeadTracker creates a tracker for a log. publicKey is the base64 DER public key of the log
+// as published in the log lists, or empty to skip signature verification.
+func NewTreeHeadTracker(logID, logURL string, client *http.Client, publicKey string) (*TreeHeadTracker, error) {
+	t := &TreeHeadTracker{logID: logID, logURL: logURL, client: client}
+	if publicKey != "" {
+		pk, err := ct.PublicKeyFromB64(publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log public key: %w", err)
+		}
+		if t.verifier, err = ct.NewSignatureVerifier(pk); err != nil {
+			return nil, fmt.Errorf("unsupported log public key: %w", err)
+		}
+	}
+	return t, nil
+}
+
+// Latest returns the latest verified STH, or nil before the first successful refresh
+func (t *TreeHeadTracker) Latest() *STHResponse {
+	return t.latest.Load()
+}
+
+// TreeSize returns the tree size of the latest verified STH, or 0 before the first successful
+// refresh
+func (t *TreeHeadTracker) TreeSize() int64 {
+	if sth := t.latest.Load(); sth != nil {
+		return sth.TreeSize
+	}
+	return 0
+}
+
+// SetNextIndex records the next index the fetcher will request and updates the lag
+func (t *TreeHeadTracker) SetNextIndex(index int64) {
+	t.nextIndex.Store(index)
+	t.updateLag()
+}
+
+// Refresh fetches the STH and makes it the latest if it verifies and is newer. A rejected STH
+// leaves the latest unchanged and returns the reason.
+func (t *TreeHeadTracker) Refresh() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sth, err := fetchSTH(t.client, t.logURL)
+	if err != nil {
+		metricSTHRefreshErrors.Add(1, "log", t.logID, "reason", "fetch")
+		return err
+	}
+	if err := t.verifySignature(sth); err != nil {
+		metricSTHRefreshErrors.Add(1, "log", t.logID, "reason", "signature")
+		return fmt.Errorf("STH of tree size %d does not verify: %w", sth.TreeSize, err)
+	}
+	if prev := t.latest.Load(); prev != nil {
+		if err := checkSTHConsistency(prev, sth); err != nil {
+			metricSTHRefreshErrors.Add(1, "log", t.logID, "reason", "inconsistent")
+			return err
+		}
+		if sth.TreeSize == prev.TreeSize && sth.Timestamp <= prev.Timestamp {
+			t.updateAge(prev)
+			return nil
+		}
+	}
+
+	t.latest.Store(sth)
+	metricTreeSize.Set(float64(sth.TreeSize), "log", t.logID)
+	t.updateAge(sth)
+	t.updateLag()
+	return nil
+}
+
+// Start refreshes the STH every interval until done is closed
+func (t *TreeHeadTracker) Start(interval time.Duration, done <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := t.Refresh(); err != nil {
+					log.Printf("Warning: Failed to refresh the STH of %s: %v", t.logID, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+func (t *TreeHeadTracker) verifySignature(sth *STHResponse) error {
+	if t.verifier == nil {
+		return nil
+	}
+	head := ct.SignedTreeHead{
+		Version:   ct.V1,
+		TreeSize:  uint64(sth.TreeSize),
+		Timestamp: uint64(sth.Timestamp),
+	}
+	if err := head.SHA256RootHash.FromBase64String(sth.SHA256RootHash); err != nil {
+		return fmt.Errorf("invalid root hash: %w", err)
+	}
+	if err := head.TreeHeadSignature.FromBase64String(sth.TreeHeadSignature); err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	return t.verifier.VerifySTHSignature(head)
+}
+
+// checkSTHConsistency rejects an STH that moves backwards from prev or forks it at the same size
+func checkSTHConsistency(prev, sth *STHResponse) error {
+	switch {
+	case sth.TreeSize < prev.TreeSize:
+		return fmt.Errorf("tree size shrank from %d to %d", prev.TreeSize, sth.TreeSize)
+	case sth.Timestamp < prev.Timestamp:
+		return fmt.Errorf("STH timestamp went back from %d to %d", prev.Timestamp, sth.Timestamp)
+	case sth.TreeSize == prev.TreeSize && sth.SHA256RootHash != prev.SHA256RootHash:
+		return fmt.Errorf("root hash of tree size %d changed from %s to %s", sth.TreeSize, prev.SHA256RootHash, sth.SHA256RootHash)
+	}
+	return nil
+}
+
+func (t *TreeHeadTracker) updateAge(sth *STHResponse) {
+	age := time.Since(time.UnixMilli(sth.Timestamp)).Seconds()
+	metricSTHAge.Set(max(age, 0), "log", t.logID)
+}
+
+func (t *TreeHeadTracker) updateLag() {
+	if sth := t.latest.Load(); sth != nil {
+		metricLag.Set(float64(max(sth.TreeSize-t.nextIndex.Load(), 0)), "log", t.logID)
+	}
+}
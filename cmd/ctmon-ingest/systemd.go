@@ -0,0 +1,104 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// SystemdNotifier speaks the sd_notify protocol of Type=notify units: it reports READY=1 once
+// ingestion starts and STOPPING=1 on shutdown. When the unit sets WatchdogSec=, it sends WATCHDOG=1
+// every half interval as long as the fetch loop went through an iteration within the interval,
+// so systemd restarts an ingester hung on a dead proxy or a wedged database connection. Without
+// NOTIFY_SOCKET the notifier is nil and does nothing.
+type SystemdNotifier struct {
+	addr     *net.UnixAddr
+	interval time.Duration // WATCHDOG_USEC, 0 without the watchdog
+	lastBeat atomic.Int64  // Unix nanoseconds of the last fetch loop iteration
+}
+
+// NewSystemdNotifier returns a notifier for the socket in NOTIFY_SOCKET, or nil if it is unset
+func NewSystemdNotifier() *SystemdNotifier {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if strings.HasPrefix(socket, "@") {
+		// Abstract namespace socket
+		socket = "\x00" + socket[1:]
+	}
+	n := &SystemdNotifier{addr: &net.UnixAddr{Name: socket, Net: "unixgram"}}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if pid := os.Getenv("WATCHDOG_PID"); err == nil && usec > 0 && (pid == "" || pid == strconv.Itoa(os.Getpid())) {
+		n.interval = time.Duration(usec) * time.Microsecond
+	}
+	n.Beat()
+	return n
+}
+
+func (n *SystemdNotifier) notify(state string) {
+	conn, err := net.DialUnix("unixgram", nil, n.addr)
+	if err != nil {
+		log.Printf("Warning: Failed to notify systemd of %s: %v", state, err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Printf("Warning: Failed to notify systemd of %s: %v", state, err)
+	}
+}
+
+// Ready reports that startup is complete and, with the watchdog enabled, pings it until done is
+// closed
+func (n *SystemdNotifier) Ready(done <-chan struct{}) {
+	if n == nil {
+		return
+	}
+	n.Beat()
+	n.notify("READY=1")
+	if n.interval == 0 {
+		return
+	}
+	log.Printf("systemd watchdog enabled: pinging every %v while the fetch loop makes progress", n.interval/2)
+
+	go func() {
+		ticker := time.NewTicker(n.interval / 2)
+		defer ticker.Stop()
+		stalled := false
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+			idle := time.Since(time.Unix(0, n.lastBeat.Load()))
+			if idle < n.interval {
+				n.notify("WATCHDOG=1")
+				stalled = false
+			} else if !stalled {
+				log.Printf("Error: The fetch loop made no progress for %v, withholding systemd watchdog pings", idle.Round(time.Second))
+				stalled = true
+			}
+		}
+	}()
+}
+
+// Beat records that the fetch loop is making progress
+func (n *SystemdNotifier) Beat() {
+	if n == nil {
+		return
+	}
+	n.lastBeat.Store(time.Now().UnixNano())
+}
+
+// Stopping reports that shutdown has begun
+func (n *SystemdNotifier) Stopping() {
+	if n == nil {
+		return
+	}
+	n.notify("STOPPING=1")
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Values of timestamp_anomaly
+const (
+	timestampFuture = "future"  // Later than the retrieval time by more than the allowed clock skew
+	timestampTooOld = "too_old" // Earlier than the first plausible timestamp
+)
+
+// defaultMinTimestamp precedes the first CT logs (RFC 6962 was published in June 2013)
+const defaultMinTimestamp = "2013-01-01"
+
+var metricTimestampAnomalies = newCounter("ctmon_ingest_timestamp_anomalies_total", "Entries with an implausible entry timestamp, by kind (future, too_old)")
+
+// TimestampCheck flags entries whose timestamp is later than their retrieval time by more than
+// maxSkew, or earlier than minTimestamp, in timestamp_anomaly
+type TimestampCheck struct {
+	maxSkew      time.Duration
+	minTimestamp time.Time
+}
+
+// NewTimestampCheck creates a check for -max_clock_skew and -min_timestamp (YYYY-MM-DD, UTC)
+func NewTimestampCheck(maxSkew time.Duration, minTimestamp string) (*TimestampCheck, error) {
+	if maxSkew < 0 {
+		return nil, fmt.Errorf("clock skew must not be negative")
+	}
+	earliest, err := time.Parse(time.DateOnly, minTimestamp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid minimum timestamp %q (expected YYYY-MM-DD): %w", minTimestamp, err)
+	}
+	return &TimestampCheck{maxSkew: maxSkew, minTimestamp: earliest}, nil
+}
+
+// Apply sets TimestampAnomaly of an entry and counts it in the metric
+func (c *TimestampCheck) Apply(details *CertificateDetails) {
+	switch {
+	case details.EntryTimestamp.After(details.RetrievalTimestamp.Add(c.maxSkew)):
+		details.TimestampAnomaly = timestampFuture
+	case details.EntryTimestamp.Before(c.minTimestamp):
+		details.TimestampAnomaly = timestampTooOld
+	default:
+		return
+	}
+	metricTimestampAnomalies.Add(1, "log", details.LogID, "kind", details.TimestampAnomaly)
+}
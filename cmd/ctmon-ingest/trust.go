@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+	cttls "github.com/google/certificate-transparency-go/tls"
+	ctx509 "github.com/google/certificate-transparency-go/x509"
+)
+
+// rootStoresRefreshInterval is how often bundles from -root_stores_dir are reloaded
+const rootStoresRefreshInterval = 24 * time.Hour
+
+// rootProgramNames lists the root programs evaluated for every chain, in column order
+var rootProgramNames = []string{"mozilla", "chrome", "apple"}
+
+// embeddedRootStores holds the root bundles shipped with the binary. Programs without an
+// embedded bundle are only evaluated when a bundle is supplied via -root_stores_dir.
+//
+//go:embed rootstores/*.pem
+var embeddedRootStores embed.FS
+
+// RootStores holds the root pools of each root program and caches chain evaluations
+type RootStores struct {
+	mu    sync.RWMutex
+	dir   string
+	pools map[string]*ctx509.CertPool
+
+	chainCache sync.Map // SHA-256 of the concatenated chain DER -> map[string]bool
+}
+
+// LoadRootStores loads the embedded root bundles and overrides them with <dir>/<program>.pem
+func LoadRootStores(dir string) (*RootStores, error) {
+	rs := &RootStores{dir: dir}
+	if err := rs.reload(); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+func (rs *RootStores) reload() error {
+	pools := make(map[string]*ctx509.CertPool)
+	for _, program := range rootProgramNames {
+		var pemData []byte
+		if rs.dir != "" {
+			data, err := os.ReadFile(filepath.Join(rs.dir, program+".pem"))
+			if err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to read %s root store: %w", program, err)
+			}
+			pemData = data
+		}
+		if pemData == nil {
+			data, err := embeddedRootStores.ReadFile("rootstores/" + program + ".pem")
+			if err != nil {
+				continue
+			}
+			pemData = data
+		}
+
+		pool := ctx509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemData) {
+			return fmt.Errorf("no certificates found in %s root store", program)
+		}
+		pools[program] = pool
+	}
+
+	rs.mu.Lock()
+	rs.pools = pools
+	rs.mu.Unlock()
+
+	// Cached evaluations are only valid for the pools they were computed against
+	rs.chainCache.Range(func(key, _ interface{}) bool {
+		rs.chainCache.Delete(key)
+		return true
+	})
+	return nil
+}
+
+// StartRefresh periodically reloads the bundles from -root_stores_dir
+func (rs *RootStores) StartRefresh(interval time.Duration, done <-chan struct{}) {
+	if rs.dir == "" {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := rs.reload(); err != nil {
+					log.Printf("Warning: Failed to refresh root stores from %s: %v", rs.dir, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// Programs returns the names of the root programs with a loaded bundle
+func (rs *RootStores) Programs() []string {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	var programs []string
+	for _, program := range rootProgramNames {
+		if rs.pools[program] != nil {
+			programs = append(programs, program)
+		}
+	}
+	return programs
+}
+
+// Evaluate sets the per-program trust flags of a parsed entry from its logged chain. The leaf is the
+// x509_entry certificate or the precertificate; flags stay nil for programs without a loaded bundle.
+func (rs *RootStores) Evaluate(details *CertificateDetails) error {
+	leafDER, chain, err := decodeLoggedChain(details.EntryType, details.RawLeafCertificateDERBase64, details.ExtraDataBase64)
+	if err != nil {
+		return err
+	}
+
+	leaf, err := ctx509.ParseCertificate(leafDER)
+	if leaf == nil {
+		return fmt.Errorf("failed to parse leaf certificate: %w", err)
+	}
+
+	trusted := make(map[string]bool)
+	if len(chain) > 0 {
+		issuer, err := ctx509.ParseCertificate(chain[0])
+		if issuer == nil {
+			return fmt.Errorf("failed to parse issuer certificate: %w", err)
+		}
+		if leaf.CheckSignatureFrom(issuer) == nil {
+			trusted = rs.evaluateChain(chain)
+		}
+	}
+
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	flags := map[string]**bool{
+		"mozilla": &details.TrustedMozilla,
+		"chrome":  &details.TrustedChrome,
+		"apple":   &details.TrustedApple,
+	}
+	for program, flag := range flags {
+		if rs.pools[program] == nil {
+			continue
+		}
+		value := trusted[program]
+		*flag = &value
+	}
+	return nil
+}
+
+// evaluateChain verifies the issuer chain (without the leaf) against every program, caching the result
+func (rs *RootStores) evaluateChain(chain [][]byte) map[string]bool {
+	hasher := sha256.New()
+	for _, der := range chain {
+		hasher.Write(der)
+	}
+	var key [sha256.Size]byte
+	copy(key[:], hasher.Sum(nil))
+	if cached, ok := rs.chainCache.Load(key); ok {
+		return cached.(map[string]bool)
+	}
+
+	issuer, _ := ctx509.ParseCertificate(chain[0])
+	intermediates := ctx509.NewCertPool()
+	for _, der := range chain[1:] {
+		if cert, _ := ctx509.ParseCertificate(der); cert != nil {
+			intermediates.AddCert(cert)
+		}
+	}
+
+	trusted := make(map[string]bool)
+	rs.mu.RLock()
+	for program, pool := range rs.pools {
+		_, err := issuer.Verify(ctx509.VerifyOptions{
+			Roots:                          pool,
+			Intermediates:                  intermediates,
+			DisableTimeChecks:              true,
+			DisableCriticalExtensionChecks: true,
+			DisableEKUChecks:               true,
+			KeyUsages:                      []ctx509.ExtKeyUsage{ctx509.ExtKeyUsageAny},
+		})
+		trusted[program] = err == nil
+	}
+	rs.mu.RUnlock()
+
+	rs.chainCache.Store(key, trusted)
+	return trusted
+}
+
+// decodeLoggedChain extracts the leaf DER and issuer chain from a log entry's raw data
+func decodeLoggedChain(entryType, leafDERBase64, extraDataBase64 string) ([]byte, [][]byte, error) {
+	extraData, err := base64.StdEncoding.DecodeString(extraDataBase64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to base64 decode extra_data: %w", err)
+	}
+
+	var leafDER []byte
+	var entries []ct.ASN1Cert
+	switch entryType {
+	case "x509_entry":
+		leafDER, err = base64.StdEncoding.DecodeString(leafDERBase64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to base64 decode leaf certificate: %w", err)
+		}
+		var chain ct.CertificateChain
+		if _, err := cttls.Unmarshal(extraData, &chain); err != nil {
+			return nil, nil, fmt.Errorf("failed to unmarshal certificate chain: %w", err)
+		}
+		entries = chain.Entries
+	case "precert_entry":
+		var precertChain ct.PrecertChainEntry
+		if _, err := cttls.Unmarshal(extraData, &precertChain); err != nil {
+			return nil, nil, fmt.Errorf("failed to unmarshal precert chain entry: %w", err)
+		}
+		leafDER = precertChain.PreCertificate.Data
+		entries = precertChain.CertificateChain
+	default:
+		return nil, nil, fmt.Errorf("unknown entry type %q", entryType)
+	}
+
+	chain := make([][]byte, 0, len(entries))
+	for _, entry := range entries {
+		chain = append(chain, entry.Data)
+	}
+	return leafDER, chain, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import "time"
+
+// maxLeafValidityDays is the longest validity the CA/Browser Forum Baseline Requirements allow for
+// TLS server certificates issued since September 2020
+const maxLeafValidityDays = 398
+
+// validityBucket is a validity_bucket value with the longest validity it holds
+type validityBucket struct {
+	name    string
+	maxDays int64
+}
+
+// validityBuckets follow the CA/Browser Forum limits: short-lived certificates (no revocation
+// information required) and the validity caps phased in from 2026 to 2029 (SC-081)
+var validityBuckets = []validityBucket{
+	{"short_lived", 7},
+	{"47d", 47},
+	{"100d", 100},
+	{"200d", 200},
+	{"398d", maxLeafValidityDays},
+}
+
+// overMaxValidityBucket holds certificates valid for longer than maxLeafValidityDays
+const overMaxValidityBucket = "over_398d"
+
+// setValidity sets the validity in days of a certificate, counting both notBefore and notAfter as
+// RFC 5280 does, its bucket, and whether a leaf certificate exceeds maxLeafValidityDays. A
+// validity ending before it starts is left unclassified.
+func setValidity(details *CertificateDetails, notBefore, notAfter time.Time, isCA bool) {
+	validity := notAfter.Sub(notBefore)
+	if validity < 0 {
+		return
+	}
+	// The period includes the second of notAfter, so it always ends within the next day
+	days := int64(validity/(24*time.Hour)) + 1
+	details.ValidityDays = uint32(days)
+	details.ValidityBucket = overMaxValidityBucket
+	for _, bucket := range validityBuckets {
+		if days <= bucket.maxDays {
+			details.ValidityBucket = bucket.name
+			break
+		}
+	}
+	details.ExceedsMaxValidity = !isCA && days > maxLeafValidityDays
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// IndexRange is an inclusive range of log indexes
+type IndexRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// LogCounts reconciles the stored indexes of one log against its tree size, as reported by the
+// verify-counts subcommand
+type LogCounts struct {
+	Log              string       `json:"log"`
+	TreeSize         int64        `json:"tree_size"`
+	DistinctIndexes  int64        `json:"distinct_indexes"`
+	Missing          int64        `json:"missing"`
+	MissingRanges    []IndexRange `json:"missing_ranges,omitempty"`
+	RangesTruncated  bool         `json:"ranges_truncated,omitempty"` // More than -max_ranges ranges are missing
+	DuplicateIndexes int64        `json:"duplicate_indexes"`          // Indexes stored more than once (until merged)
+	DuplicateRows    int64        `json:"duplicate_rows"`             // Rows beyond the first of each index
+	Error            string       `json:"error,omitempty"`
+}
+
+// runVerifyCounts implements the verify-counts subcommand: it compares the distinct stored indexes
+// of each log against its current STH and prints the missing ranges and duplicate counts. It exits
+// non-zero if any index is missing, so it can be run from cron.
+func runVerifyCounts(args []string) {
+	fs := flag.NewFlagSet("verify-counts", flag.ExitOnError)
+	logURLsFlag := fs.String("log_url", "", "Comma-separated CT log URLs to verify (default: every log in ct_log_stats_by_log_id)")
+	jsonFlag := fs.Bool("json", false, "Print JSON instead of a table")
+	maxRangesFlag := fs.Int("max_ranges", 100, "Maximum number of missing ranges listed per log")
+	fs.Parse(args)
+
+	if *maxRangesFlag < 0 {
+		log.Fatal("Error: -max_ranges must not be negative")
+	}
+
+	db, err := initClickHouse()
+	if err != nil {
+		log.Fatalf("Failed to initialize ClickHouse connection: %v", err)
+	}
+	defer db.Close()
+
+	var logURLs []string
+	for _, logURL := range strings.Split(*logURLsFlag, ",") {
+		if logURL = strings.TrimSpace(logURL); logURL != "" {
+			logURLs = append(logURLs, logURL)
+		}
+	}
+	if len(logURLs) == 0 {
+		logURLs, err = knownLogURLs(db)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+
+	client := &http.Client{Timeout: requestTimeout}
+	var counts []LogCounts
+	failed := false
+	for _, logURL := range logURLs {
+		c := LogCounts{Log: logURL}
+		if err := ctLogCounts(db, client, logURL, *maxRangesFlag, &c); err != nil {
+			c.Error = err.Error()
+		}
+		if c.Error != "" || c.Missing > 0 {
+			failed = true
+		}
+		counts = append(counts, c)
+	}
+
+	printCounts(counts, *jsonFlag)
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func ctLogCounts(db *sql.DB, client *http.Client, logURL string, maxRanges int, counts *LogCounts) error {
+	parsedLogURL, err := url.Parse(logURL)
+	if err != nil {
+		return fmt.Errorf("invalid log URL: %w", err)
+	}
+	logID := parsedLogURL.Host + parsedLogURL.Path
+
+	sth, err := fetchSTH(client, logURL)
+	if err != nil {
+		return err
+	}
+	counts.TreeSize = sth.TreeSize
+	return countIndexes(db, "ct_log_entries", "log_id", logID, maxRanges, counts)
+}
+
+// countIndexes fills in the distinct, missing and duplicate index counts of the log below
+// counts.TreeSize, listing up to maxRanges missing ranges
+func countIndexes(db *sql.DB, table, logColumn, logID string, maxRanges int, counts *LogCounts) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		SELECT toInt64(count()), toInt64(countIf(c > 1)), toInt64(sum(c) - count())
+		FROM (
+			SELECT log_index, count() AS c
+			FROM %s
+			WHERE %s = ? AND log_index < ?
+			GROUP BY log_index
+		)`, table, logColumn)
+	err := db.QueryRowContext(ctx, query, logID, counts.TreeSize).Scan(&counts.DistinctIndexes, &counts.DuplicateIndexes, &counts.DuplicateRows)
+	if err != nil {
+		return fmt.Errorf("failed to count indexes: %w", err)
+	}
+	counts.Missing = counts.TreeSize - counts.DistinctIndexes
+	if counts.Missing == 0 || maxRanges == 0 {
+		counts.RangesTruncated = counts.Missing > 0
+		return nil
+	}
+
+	// A stored index more than one past the previous one ends a missing range. The first index is
+	// compared against -1 and the tree size is appended, to find missing heads and tails too.
+	query = fmt.Sprintf(`
+		SELECT prev + 1, log_index - 1
+		FROM (
+			SELECT log_index, lagInFrame(log_index, 1, toInt64(-1)) OVER (ORDER BY log_index ROWS BETWEEN 1 PRECEDING AND CURRENT ROW) AS prev
+			FROM (
+				SELECT DISTINCT toInt64(log_index) AS log_index
+				FROM %s
+				WHERE %s = ? AND log_index < ?
+				UNION ALL
+				SELECT toInt64(?)
+			)
+		)
+		WHERE log_index > prev + 1
+		ORDER BY log_index
+		LIMIT ?`, table, logColumn)
+	rows, err := db.QueryContext(ctx, query, logID, counts.TreeSize, counts.TreeSize, maxRanges+1)
+	if err != nil {
+		return fmt.Errorf("failed to find missing ranges: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r IndexRange
+		if err := rows.Scan(&r.Start, &r.End); err != nil {
+			return fmt.Errorf("failed to scan missing range: %w", err)
+		}
+		if len(counts.MissingRanges) == maxRanges {
+			counts.RangesTruncated = true
+			break
+		}
+		counts.MissingRanges = append(counts.MissingRanges, r)
+	}
+	return rows.Err()
+}
+
+func printCounts(counts []LogCounts, asJSON bool) {
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(counts)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LOG\tTREE SIZE\tSTORED\tMISSING\tDUPLICATE INDEXES\tDUPLICATE ROWS\tMISSING RANGES")
+	for _, c := range counts {
+		if c.Error != "" {
+			fmt.Fprintf(w, "%s\terror: %s\n", c.Log, c.Error)
+			continue
+		}
+		ranges := make([]string, len(c.MissingRanges))
+		for i, r := range c.MissingRanges {
+			if r.Start == r.End {
+				ranges[i] = fmt.Sprint(r.Start)
+			} else {
+				ranges[i] = fmt.Sprintf("%d-%d", r.Start, r.End)
+			}
+		}
+		if c.RangesTruncated {
+			ranges = append(ranges, "...")
+		}
+		if len(ranges) == 0 {
+			ranges = append(ranges, "-")
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%s\n", c.Log, c.TreeSize, c.DistinctIndexes, c.Missing, c.DuplicateIndexes, c.DuplicateRows, strings.Join(ranges, ","))
+	}
+	w.Flush()
+}
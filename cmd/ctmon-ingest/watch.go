@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/idna"
+)
+
+// WatchRuleType determines how a watch rule pattern is compared against certificate names
+type WatchRuleType string
+
+const (
+	WatchRuleExact     WatchRuleType = "exact"      // Name equals the pattern
+	WatchRuleSuffix    WatchRuleType = "suffix"     // Name equals the pattern or is a subdomain of it
+	WatchRuleLookalike WatchRuleType = "lookalike"  // Name is a homograph of the pattern (or of a subdomain of it)
+	WatchRuleSPKI      WatchRuleType = "spki"       // Certificate key is the pattern, a SHA-256 (hex) of a SubjectPublicKeyInfo
+	WatchRuleIssuerKey WatchRuleType = "issuer_key" // Issuing CA key is the pattern, as in the precert issuer key hash
+)
+
+// isKey reports whether rules of the type match a key hash rather than certificate names
+func (t WatchRuleType) isKey() bool {
+	return t == WatchRuleSPKI || t == WatchRuleIssuerKey
+}
+
+// sha256Hex matches the key hashes of spki and issuer_key rules
+var sha256Hex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// WatchRule is a single entry of the watch rules file
+type WatchRule struct {
+	Type     WatchRuleType
+	Pattern  string
+	skeleton string
+}
+
+// WatchHit records a certificate name or key hash that matched a watch rule
+type WatchHit struct {
+	Rule WatchRule
+	Name string
+}
+
+// WatchEngine matches parsed certificates against a set of watch rules
+type WatchEngine struct {
+	rules []WatchRule
+}
+
+// LoadWatchRules reads watch rules from a file. Each non-empty line has the form
+// "<exact|suffix|lookalike> <domain>" or "<spki|issuer_key> <hex SHA-256>"; lines starting with #
+// are ignored.
+func LoadWatchRules(filename string) (*WatchEngine, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open watch rules file %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	var rules []WatchRule
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid watch rule on line %d: %s (expected \"<type> <domain>\")", lineNum, line)
+		}
+
+		rule := WatchRule{
+			Type:    WatchRuleType(fields[0]),
+			Pattern: normalizeDNSName(fields[1]).Name,
+		}
+		switch rule.Type {
+		case WatchRuleExact, WatchRuleSuffix:
+		case WatchRuleSPKI, WatchRuleIssuerKey:
+			if !sha256Hex.MatchString(rule.Pattern) {
+				return nil, fmt.Errorf("invalid %s watch rule on line %d: %q is not a hex SHA-256", rule.Type, lineNum, fields[1])
+			}
+		case WatchRuleLookalike:
+			rule.skeleton = confusableSkeleton(rule.Pattern)
+		default:
+			return nil, fmt.Errorf("unknown watch rule type %q on line %d", fields[0], lineNum)
+		}
+		rules = append(rules, rule)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading watch rules file: %w", err)
+	}
+
+	return &WatchEngine{rules: rules}, nil
+}
+
+// Len returns the number of loaded rules
+func (w *WatchEngine) Len() int {
+	if w == nil {
+		return 0
+	}
+	return len(w.rules)
+}
+
+// Match returns every (rule, name) pair of the certificate that matches a watch rule
+func (w *WatchEngine) Match(details *CertificateDetails) []WatchHit {
+	if w == nil || len(w.rules) == 0 {
+		return nil
+	}
+
+	var hits []WatchHit
+	for _, rule := range w.rules {
+		if rule.Type == WatchRuleSPKI && details.SubjectSPKISHA256 == rule.Pattern ||
+			rule.Type == WatchRuleIssuerKey && details.IssuerSPKISHA256 == rule.Pattern {
+			hits = append(hits, WatchHit{Rule: rule, Name: rule.Pattern})
+		}
+	}
+
+	names := details.DNSNames
+	if looksLikeDNSName(details.SubjectCommonName) {
+		names = append(names[:len(names):len(names)], details.SubjectCommonName)
+	}
+
+	seen := make(map[string]bool, len(names))
+	for _, raw := range names {
+		if seen[raw] {
+			continue
+		}
+		seen[raw] = true
+		name := normalizeDNSName(raw)
+
+		for _, rule := range w.rules {
+			if !rule.Type.isKey() && rule.matches(name, raw) {
+				hits = append(hits, WatchHit{Rule: rule, Name: name.Name})
+			}
+		}
+	}
+	return hits
+}
+
+// matches compares a rule against a name. The raw, case-preserved name is used for
+// lookalike rules since uppercase letters can be confusable (e.g. I and l).
+func (r WatchRule) matches(name NormalizedName, raw string) bool {
+	host := strings.TrimPrefix(name.Name, "*.")
+
+	switch r.Type {
+	case WatchRuleExact:
+		return host == r.Pattern
+	case WatchRuleSuffix:
+		return host == r.Pattern || strings.HasSuffix(host, "."+r.Pattern)
+	case WatchRuleLookalike:
+		// The genuine domain and its subdomains are not lookalikes of themselves
+		if host == r.Pattern || strings.HasSuffix(host, "."+r.Pattern) {
+			return false
+		}
+		skeleton := confusableSkeleton(strings.TrimPrefix(strings.TrimSuffix(strings.TrimSpace(raw), "."), "*."))
+		return skeleton == r.skeleton || strings.HasSuffix(skeleton, "."+r.skeleton)
+	}
+	return false
+}
+
+// confusableSkeleton maps a domain name to a skeleton in the spirit of Unicode TR39, so that
+// visually confusable names (e.g. "pаypal.com" with a Cyrillic а, or "paypa1.com") share a skeleton.
+// Only a curated subset of the confusables table covering Latin lookalikes is used.
+func confusableSkeleton(name string) string {
+	if strings.Contains(name, "xn--") {
+		if unicode, err := idna.ToUnicode(name); err == nil {
+			name = unicode
+		}
+	}
+
+	var b strings.Builder
+	for _, r := range name {
+		if mapped, ok := confusables[r]; ok {
+			b.WriteString(mapped)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+
+	skeleton := strings.ToLower(b.String())
+	for _, pair := range multiCharConfusables {
+		skeleton = strings.ReplaceAll(skeleton, pair[0], pair[1])
+	}
+	return skeleton
+}
+
+// multiCharConfusables are ASCII sequences that render like a single letter
+var multiCharConfusables = [][2]string{
+	{"rn", "m"},
+	{"vv", "w"},
+	{"cl", "d"},
+}
+
+// confusables maps individual runes to their Latin skeleton. Uppercase I is mapped to l
+// before lowercasing, which is what makes "paypaI.com" confusable with "paypal.com".
+var confusables = map[rune]string{
+	'0': "o", '1': "l", 'I': "l", '|': "l", '5': "s", '$': "s",
+	// Cyrillic
+	'а': "a", 'в': "b", 'е': "e", 'ё': "e", 'һ': "h", 'і': "i", 'ї': "i", 'ј': "j", 'к': "k",
+	'ӏ': "l", 'м': "m", 'н': "h", 'о': "o", 'р': "p", 'с': "c", 'ѕ': "s", 'т': "t", 'у': "y",
+	'х': "x", 'ԁ': "d", 'ԛ': "q", 'ԝ': "w", 'ɡ': "g", 'ь': "b", 'п': "n",
+	'А': "a", 'В': "b", 'Е': "e", 'К': "k", 'М': "m", 'Н': "h", 'О': "o", 'Р': "p", 'С': "c",
+	'Т': "t", 'Х': "x", 'Ѕ': "s", 'І': "l", 'Ј': "j",
+	// Greek
+	'α': "a", 'β': "b", 'ε': "e", 'η': "n", 'ι': "i", 'κ': "k", 'ν': "v", 'ο': "o", 'ρ': "p",
+	'τ': "t", 'υ': "u", 'χ': "x", 'ω': "w",
+	'Α': "a", 'Β': "b", 'Ε': "e", 'Η': "h", 'Ι': "l", 'Κ': "k", 'Μ': "m", 'Ν': "n", 'Ο': "o",
+	'Ρ': "p", 'Τ': "t", 'Υ': "y", 'Χ': "x", 'Ζ': "z",
+	// Latin with diacritics and other lookalikes
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ą': "a",
+	'ç': "c", 'ć': "c", 'č': "c", 'ď': "d", 'đ': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ę': "e", 'ė': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ı': "i", 'ł': "l", 'ľ': "l",
+	'ñ': "n", 'ń': "n", 'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o",
+	'ŕ': "r", 'ś': "s", 'š': "s", 'ş': "s", 'ť': "t", 'ù': "u", 'ú': "u", 'û': "u", 'ü': "u",
+	'ū': "u", 'ý': "y", 'ÿ': "y", 'ź': "z", 'ż': "z", 'ž': "z",
+}
+
+// keyWatchAlert is the alert posted to -alert_webhook when a certificate matches an spki or
+// issuer_key rule, since issuance by a compromised or distrusted key needs attention at once
+func keyWatchAlert(details *CertificateDetails, hit WatchHit) Alert {
+	text := fmt.Sprintf("Certificate %s for %q was issued with watched key %s", details.CertificateSHA256, details.SubjectCommonName, hit.Name)
+	if hit.Rule.Type == WatchRuleIssuerKey {
+		text = fmt.Sprintf("Watched CA key %s (%s) issued certificate %s for %q", hit.Name, details.IssuerDN, details.CertificateSHA256, details.SubjectCommonName)
+	}
+	return Alert{
+		Alert:     "key_watch",
+		Status:    "firing",
+		Log:       details.LogID,
+		Text:      fmt.Sprintf("%s (log %s index %d)", text, details.LogID, details.LogIndex),
+		Timestamp: time.Now().UTC(),
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const watchdogInterval = 1 * time.Minute // Interval between checks for lag and stall alerts
+
+var (
+	metricEntriesInserted = newCounter("ctmon_ingest_entries_inserted_total", "Entries inserted into ct_log_entries")
+	metricLastInsert      = newGauge("ctmon_ingest_last_insert_timestamp_seconds", "Unix time of the last successful batch insert")
+	metricNextIndex       = newGauge("ctmon_ingest_next_index", "Next log index to fetch")
+	metricTreeSize        = newGauge("ctmon_ingest_tree_size", "Tree size of the latest STH")
+	metricLag             = newGauge("ctmon_ingest_lag_entries", "Entries between the next index and the tree size")
+	metricAlertFiring     = newGauge("ctmon_ingest_alert_firing", "1 while the lag or stall alert is firing")
+)
+
+// Alert is the JSON body posted to -alert_webhook when an alert fires or resolves. The text field
+// makes the payload usable as-is with Slack-compatible incoming webhooks.
+type Alert struct {
+	Alert      string     `json:"alert"`  // lag, stall, key_watch, log_state or usage_quota
+	Status     string     `json:"status"` // firing or resolved
+	Log        string     `json:"log"`
+	Text       string     `json:"text"`
+	Lag        int64      `json:"lag"`
+	Threshold  string     `json:"threshold"`
+	LastInsert *time.Time `json:"last_insert,omitempty"`
+	Timestamp  time.Time  `json:"timestamp"`
+}
+
+// Watchdog tracks ingestion progress, exports it as metrics and alerts when the lag behind the
+// latest verified STH exceeds maxLag or no batch has been inserted for stallAfter
+type Watchdog struct {
+	logID      string
+	sths       *TreeHeadTracker
+	client     *http.Client  // Posts alerts to webhookURL
+	maxLag     int64         // 0 disables the lag alert
+	stallAfter time.Duration // 0 disables the stall alert
+	webhookURL string        // Alerts are only logged when empty
+
+	nextIndex  atomic.Int64
+	lastInsert atomic.Int64 // Unix nanoseconds
+	firing     map[string]bool
+}
+
+// NewWatchdog creates a watchdog for a log. Stalls are measured from the time it is created.
+func NewWatchdog(logID string, sths *TreeHeadTracker, client *http.Client, maxLag int64, stallAfter time.Duration, webhookURL string) *Watchdog {
+	w := &Watchdog{
+		logID:      logID,
+		sths:       sths,
+		client:     client,
+		maxLag:     maxLag,
+		stallAfter: stallAfter,
+		webhookURL: webhookURL,
+		firing:     make(map[string]bool),
+	}
+	w.lastInsert.Store(time.Now().UnixNano())
+	return w
+}
+
+// SetNextIndex records the next index the fetcher will request. A nil watchdog does nothing.
+func (w *Watchdog) SetNextIndex(index int64) {
+	if w == nil {
+		return
+	}
+	w.nextIndex.Store(index)
+	metricNextIndex.Set(float64(index), "log", w.logID)
+}
+
+// RecordInsert records a successfully inserted batch. A nil watchdog does nothing.
+func (w *Watchdog) RecordInsert(batch []*CertificateDetails) {
+	if w == nil {
+		return
+	}
+	now := time.Now()
+	w.lastInsert.Store(now.UnixNano())
+	metricEntriesInserted.Add(float64(len(batch)), "log", w.logID)
+	metricLastInsert.Set(float64(now.Unix()), "log", w.logID)
+}
+
+// Start checks the lag and stall conditions every watchdogInterval until done is closed
+func (w *Watchdog) Start(done <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(watchdogInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				w.check()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+func (w *Watchdog) check() {
+	lastInsert := time.Unix(0, w.lastInsert.Load()).UTC()
+
+	var lag int64
+	if w.sths.Latest() != nil {
+		lag = max(w.sths.TreeSize()-w.nextIndex.Load(), 0)
+		w.transition(Alert{
+			Alert:      "lag",
+			Log:        w.logID,
+			Lag:        lag,
+			Threshold:  fmt.Sprintf("%d entries", w.maxLag),
+			LastInsert: &lastInsert,
+			Text:       fmt.Sprintf("Ingestion of %s is %d entries behind the STH (threshold %d)", w.logID, lag, w.maxLag),
+		}, w.maxLag > 0 && lag > w.maxLag)
+	}
+
+	sinceInsert := time.Since(lastInsert)
+	w.transition(Alert{
+		Alert:      "stall",
+		Log:        w.logID,
+		Lag:        lag,
+		Threshold:  w.stallAfter.String(),
+		LastInsert: &lastInsert,
+		Text:       fmt.Sprintf("No batch of %s has been inserted for %v (threshold %v)", w.logID, sinceInsert.Round(time.Second), w.stallAfter),
+	}, w.stallAfter > 0 && sinceInsert > w.stallAfter)
+}
+
+// transition sends an alert when its condition starts or stops holding
+func (w *Watchdog) transition(alert Alert, firing bool) {
+	if firing == w.firing[alert.Alert] {
+		return
+	}
+	w.firing[alert.Alert] = firing
+
+	alert.Status = "resolved"
+	value := 0.0
+	if firing {
+		alert.Status = "firing"
+		value = 1
+	}
+	metricAlertFiring.Set(value, "log", w.logID, "alert", alert.Alert)
+	alert.Timestamp = time.Now().UTC()
+	if !firing {
+		alert.Text = fmt.Sprintf("%s alert for %s resolved", alert.Alert, w.logID)
+	}
+
+	log.Printf("ALERT %s: %s", alert.Status, alert.Text)
+	if w.webhookURL != "" {
+		if err := postWebhook(w.client, w.webhookURL, alert); err != nil {
+			log.Printf("Warning: Failed to send alert to webhook: %v", err)
+		}
+	}
+}
+
+// postWebhook posts a JSON payload to an alert webhook
+func postWebhook(client *http.Client, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
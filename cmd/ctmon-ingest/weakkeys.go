@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Values of weak_key_reason, in the order they are checked
+const (
+	weakKeyDebian        = "debian_weak_key" // Generated by the Debian OpenSSL PRNG bug (CVE-2008-0166)
+	weakKeyROCA          = "roca"            // Generated by the Infineon library (CVE-2017-15361)
+	weakKeySharedModulus = "shared_modulus"  // Modulus seen with another public exponent
+	weakKeySmallRSA      = "rsa_small"       // RSA modulus under minRSABits
+)
+
+const (
+	minRSABits            = 2048
+	sharedModulusCapacity = 250_000 // Moduli remembered for shared_modulus before starting over
+)
+
+// rocaPrimes are the small primes of the ROCA fingerprint: moduli generated by the vulnerable
+// library are congruent to a power of 65537 modulo each of them
+var rocaPrimes = []int64{3, 5, 7, 11, 13, 17, 19, 23, 29, 31, 37, 41, 43, 47, 53, 59, 61, 67, 71, 73, 79,
+	83, 89, 97, 101, 103, 107, 109, 113, 127, 131, 137, 139, 149, 151, 157, 163, 167}
+
+// rocaResidues holds, for each of rocaPrimes, which residues are powers of 65537
+var rocaResidues = func() [][]bool {
+	residues := make([][]bool, len(rocaPrimes))
+	for i, p := range rocaPrimes {
+		residues[i] = make([]bool, p)
+		generator := 65537 % p
+		for x := int64(1); !residues[i][x]; x = x * generator % p {
+			residues[i][x] = true
+		}
+	}
+	return residues
+}()
+
+// WeakKeyDetector recognizes weak RSA keys of certificates: keys on a Debian weak key blacklist,
+// ROCA keys, keys whose modulus was already seen with another exponent, and keys below
+// minRSABits. It is safe for concurrent use by the parse workers.
+type WeakKeyDetector struct {
+	debian map[string]bool // Last 20 hex digits of SHA-1("Modulus=<HEX>\n"), as in openssl-blacklist
+
+	mu       sync.Mutex
+	exponent map[[32]byte]int // Public exponent by SHA-256 of the modulus
+}
+
+// NewWeakKeyDetector creates a detector. blacklistFiles are openssl-blacklist files (one
+// fingerprint per line, # comments), such as the Debian blacklist.RSA-<bits> lists.
+func NewWeakKeyDetector(blacklistFiles []string) (*WeakKeyDetector, error) {
+	d := &WeakKeyDetector{debian: make(map[string]bool), exponent: make(map[[32]byte]int)}
+	for _, filename := range blacklistFiles {
+		if err := d.loadBlacklist(filename); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// weakKeyDetectorForFlag creates the detector for -weak_key_blacklists, a comma separated list of
+// blacklist files
+func weakKeyDetectorForFlag(blacklists string) (*WeakKeyDetector, error) {
+	var files []string
+	for _, filename := range strings.Split(blacklists, ",") {
+		if filename = strings.TrimSpace(filename); filename != "" {
+			files = append(files, filename)
+		}
+	}
+	return NewWeakKeyDetector(files)
+}
+
+func (d *WeakKeyDetector) loadBlacklist(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("failed to open weak key blacklist %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := hex.DecodeString(line); err != nil || len(line) != 20 {
+			return fmt.Errorf("invalid fingerprint on line %d of %s: %q", lineNum, filename, line)
+		}
+		d.debian[line] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading weak key blacklist %s: %w", filename, err)
+	}
+	return nil
+}
+
+// Len returns the number of blacklisted fingerprints
+func (d *WeakKeyDetector) Len() int {
+	if d == nil {
+		return 0
+	}
+	return len(d.debian)
+}
+
+// Check returns why a public key is weak, or "" if it is not known to be. A nil detector only
+// checks the key size.
+func (d *WeakKeyDetector) Check(publicKey interface{}) string {
+	key, ok := publicKey.(*rsa.PublicKey)
+	if !ok || key.N == nil {
+		return ""
+	}
+	if d != nil {
+		if len(d.debian) > 0 && d.debian[debianFingerprint(key.N)] {
+			return weakKeyDebian
+		}
+		if isROCAModulus(key.N) {
+			return weakKeyROCA
+		}
+		if d.sharesModulus(key) {
+			return weakKeySharedModulus
+		}
+	}
+	if key.N.BitLen() < minRSABits {
+		return weakKeySmallRSA
+	}
+	return ""
+}
+
+// sharesModulus records the exponent of a modulus and reports whether it was seen with another
+func (d *WeakKeyDetector) sharesModulus(key *rsa.PublicKey) bool {
+	hash := sha256.Sum256(key.N.Bytes())
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if exponent, ok := d.exponent[hash]; ok {
+		return exponent != key.E
+	}
+	if len(d.exponent) >= sharedModulusCapacity {
+		clear(d.exponent)
+	}
+	d.exponent[hash] = key.E
+	return false
+}
+
+// debianFingerprint returns the openssl-blacklist fingerprint of an RSA modulus
+func debianFingerprint(n *big.Int) string {
+	hash := sha1.Sum([]byte("Modulus=" + strings.ToUpper(n.Text(16)) + "\n"))
+	return hex.EncodeToString(hash[:])[20:]
+}
+
+// isROCAModulus reports whether a modulus has the ROCA fingerprint (Nemec et al., 2017)
+func isROCAModulus(n *big.Int) bool {
+	var p, r big.Int
+	for i, prime := range rocaPrimes {
+		r.Mod(n, p.SetInt64(prime))
+		if !rocaResidues[i][r.Int64()] {
+			return false
+		}
+	}
+	return true
+}
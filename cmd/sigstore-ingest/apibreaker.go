@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	apiBreakerLimit    = 10               // Consecutive server errors or timeouts that open the breaker
+	apiBreakerCooldown = 30 * time.Second // Time an open breaker waits before letting a probe through
+)
+
+// Breakers of the Rekor endpoints the ingester fetches from
+var (
+	logInfoBreaker  = NewAPIBreaker("/api/v1/log")
+	entryBreaker    = NewAPIBreaker("/api/v1/log/entries")
+	retrieveBreaker = NewAPIBreaker("/api/v1/log/entries/retrieve")
+)
+
+var (
+	metricAPIBreakerState       = newGauge("sigstore_ingest_api_breaker_state", "State of the upstream API circuit breaker: 0 closed, 1 half-open, 2 open")
+	metricAPIBreakerOpened      = newCounter("sigstore_ingest_api_breaker_opened_total", "Times the upstream API circuit breaker opened")
+	metricAPIBreakerWaitSeconds = newCounter("sigstore_ingest_api_breaker_wait_seconds_total", "Time requests waited for the upstream API circuit breaker")
+)
+
+// HTTPStatusError is a non-200 response from an upstream API
+type HTTPStatusError struct {
+	Request    string // What was requested, e.g. "STH request"
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("%s failed with status %s: %s", e.Request, e.Status, e.Body)
+}
+
+// isAPIOutage reports whether err indicates the upstream API itself is failing: a 5xx response,
+// a timeout or a broken connection. Other errors (4xx, rate limiting, bad payloads) mean the API
+// is up.
+func isAPIOutage(err error) bool {
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// APIBreaker is a circuit breaker for one upstream API endpoint. After apiBreakerLimit consecutive
+// outage errors it opens and requests wait instead of burning retries at full concurrency; after
+// apiBreakerCooldown a single probe request is let through (half-open), which closes the breaker
+// on success or opens it again on failure. A nil APIBreaker lets every request through.
+type APIBreaker struct {
+	endpoint string
+
+	mu       sync.Mutex
+	state    string // "closed", "open" or "half-open", as in CircuitBreaker
+	failures int
+	openedAt time.Time
+	probing  bool // A half-open probe is in flight
+}
+
+// NewAPIBreaker creates a closed breaker for the endpoint, which labels its metrics
+func NewAPIBreaker(endpoint string) *APIBreaker {
+	b := &APIBreaker{endpoint: endpoint, state: "closed"}
+	b.setStateLocked("closed")
+	return b
+}
+
+func (b *APIBreaker) setStateLocked(state string) {
+	b.state = state
+	value := map[string]float64{"closed": 0, "half-open": 1, "open": 2}[state]
+	metricAPIBreakerState.Set(value, "endpoint", b.endpoint)
+}
+
+// Wait blocks while the breaker is open, or while another request probes the half-open breaker
+func (b *APIBreaker) Wait() {
+	if b == nil {
+		return
+	}
+	started := time.Now()
+	logged := false
+	for {
+		b.mu.Lock()
+		var delay time.Duration
+		switch {
+		case b.state == "closed":
+		case b.state == "open" && time.Since(b.openedAt) >= apiBreakerCooldown:
+			b.setStateLocked("half-open")
+			b.probing = true
+			log.Printf("API circuit breaker for %s half-open, probing", b.endpoint)
+		case b.state == "half-open" && !b.probing:
+			b.probing = true
+		case b.state == "open":
+			delay = apiBreakerCooldown - time.Since(b.openedAt)
+		default:
+			delay = time.Second // Wait for the probe in flight
+		}
+		b.mu.Unlock()
+
+		if delay <= 0 {
+			if waited := time.Since(started); logged || waited > time.Second {
+				metricAPIBreakerWaitSeconds.Add(waited.Seconds(), "endpoint", b.endpoint)
+			}
+			return
+		}
+		if !logged {
+			log.Printf("API circuit breaker for %s is open, waiting", b.endpoint)
+			logged = true
+		}
+		time.Sleep(min(delay, time.Second))
+	}
+}
+
+// Record updates the breaker with the result of a request let through by Wait
+func (b *APIBreaker) Record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wasProbe := b.state == "half-open" && b.probing
+	if wasProbe {
+		b.probing = false
+	}
+	if err == nil || !isAPIOutage(err) {
+		b.failures = 0
+		if b.state != "closed" {
+			log.Printf("API circuit breaker for %s closed", b.endpoint)
+			b.setStateLocked("closed")
+		}
+		return
+	}
+
+	b.failures++
+	if wasProbe || (b.state == "closed" && b.failures >= apiBreakerLimit) {
+		b.openedAt = time.Now()
+		b.setStateLocked("open")
+		metricAPIBreakerOpened.Add(1, "endpoint", b.endpoint)
+		log.Printf("API circuit breaker for %s opened after %d consecutive failures (last: %v), waiting %v", b.endpoint, b.failures, err, apiBreakerCooldown)
+	}
+}
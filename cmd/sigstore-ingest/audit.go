@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Audit result statuses
+const (
+	AuditOK       = "ok"       // The stored body is included in the tree at its index
+	AuditMismatch = "mismatch" // The log does not prove the stored body at its index
+	AuditSkipped  = "skipped"  // The entry was stored without its raw body
+	AuditError    = "error"    // The entry could not be checked
+)
+
+// AuditResult is the outcome of auditing one stored entry
+type AuditResult struct {
+	LogIndex    int64  `json:"log_index"`
+	EntryUUID   string `json:"entry_uuid"`
+	Status      string `json:"status"`
+	Detail      string `json:"detail,omitempty"`
+	Discrepancy string `json:"discrepancy,omitempty"` // Kind of disagreement with the log, recorded in rekor_discrepancies
+}
+
+// AuditReport summarizes an audit run. Only entries that are not ok are listed.
+type AuditReport struct {
+	TreeID     string        `json:"tree_id"`
+	TreeSize   int64         `json:"tree_size"`
+	Start      int64         `json:"start"`
+	End        int64         `json:"end"`
+	Checked    int           `json:"checked"`
+	OK         int           `json:"ok"`
+	Mismatches int           `json:"mismatches"`
+	Skipped    int           `json:"skipped"`
+	Errors     int           `json:"errors"`
+	Failures   []AuditResult `json:"failures,omitempty"`
+}
+
+// auditJob is a stored entry waiting to be audited
+type auditJob struct {
+	logIndex  int64
+	entryUUID string
+	body      string
+	blobCodec string
+}
+
+// runAudit implements the audit subcommand: for every stored entry of a tree in an index range, or
+// a random sample of them, it recomputes the leaf hash from the stored body and verifies it against
+// a fresh inclusion proof from Rekor, proving the mirror matches the log
+func runAudit(args []string) {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	treeIDFlag := fs.String("tree_id", "", "Tree to audit (default: the active tree)")
+	startFlag := fs.Int64("start", 0, "First tree-local log index to audit")
+	endFlag := fs.Int64("end", -1, "Tree-local log index to stop before (use -1 for the current tree size)")
+	sampleFlag := fs.Int("sample", 0, "Audit a random sample of this many stored entries in the range (0 audits all)")
+	concurrencyFlag := fs.Int("concurrency", 4, "Number of concurrent entry requests")
+	maxRequestsPerSecFlag := fs.Float64("max_requests_per_sec", 10, "Maximum entry requests per second (0 for unlimited)")
+	jsonFlag := fs.Bool("json", false, "Print JSON instead of a table")
+	fs.Parse(args)
+
+	if *startFlag < 0 {
+		log.Fatal("Error: -start must not be negative")
+	}
+	if *sampleFlag < 0 {
+		log.Fatal("Error: -sample must not be negative")
+	}
+	if *concurrencyFlag <= 0 {
+		log.Fatal("Error: -concurrency must be positive")
+	}
+	if *maxRequestsPerSecFlag < 0 {
+		log.Fatal("Error: -max_requests_per_sec must not be negative")
+	}
+
+	db, err := initClickHouse()
+	if err != nil {
+		log.Fatalf("Failed to initialize ClickHouse connection: %v", err)
+	}
+	defer db.Close()
+
+	client := &http.Client{Timeout: requestTimeout}
+	logInfo, err := fetchLogInfo(client)
+	if err != nil {
+		log.Fatalf("Failed to fetch log info: %v", err)
+	}
+
+	tree := InactiveShardInfo{TreeID: logInfo.TreeID, TreeSize: logInfo.TreeSize}
+	if *treeIDFlag != "" && *treeIDFlag != logInfo.TreeID {
+		found := false
+		for _, shard := range logInfo.InactiveShards {
+			if shard.TreeID == *treeIDFlag {
+				tree, found = shard, true
+				break
+			}
+		}
+		if !found {
+			log.Fatalf("Error: -tree_id %s is neither the active tree nor an inactive shard", *treeIDFlag)
+		}
+	}
+
+	// Entries past the checkpoint cannot be proven yet
+	end := tree.TreeSize
+	if *endFlag >= 0 {
+		end = min(*endFlag, tree.TreeSize)
+	}
+	report := AuditReport{TreeID: tree.TreeID, TreeSize: tree.TreeSize, Start: *startFlag, End: end}
+	log.Printf("Auditing tree %s entries [%d, %d)", tree.TreeID, *startFlag, end)
+
+	limiter := NewPolitenessLimiter(*maxRequestsPerSecFlag, 0, nil)
+	jobs := make(chan auditJob, *concurrencyFlag)
+	results := make(chan AuditResult, *concurrencyFlag)
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrencyFlag; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				limiter.Wait(0)
+				results <- auditEntry(client, tree.TreeID, job)
+			}
+		}()
+	}
+
+	var queryErr error
+	go func() {
+		queryErr = queryAuditEntries(db, tree.TreeID, *startFlag, end, *sampleFlag, jobs)
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	for result := range results {
+		report.Checked++
+		if report.Checked%10000 == 0 {
+			log.Printf("Audited %d entries", report.Checked)
+		}
+		switch result.Status {
+		case AuditOK:
+			report.OK++
+			continue
+		case AuditMismatch:
+			report.Mismatches++
+		case AuditSkipped:
+			report.Skipped++
+		case AuditError:
+			report.Errors++
+		}
+		report.Failures = append(report.Failures, result)
+	}
+	if queryErr != nil {
+		log.Fatalf("Failed to read stored entries: %v", queryErr)
+	}
+	if err := recordDiscrepancies(db, tree.TreeID, discrepancySourceAudit, report.Failures); err != nil {
+		log.Printf("Warning: Failed to record discrepancies: %v", err)
+	}
+
+	printAuditReport(report, *jsonFlag)
+	if report.Mismatches > 0 || report.Errors > 0 {
+		os.Exit(1)
+	}
+}
+
+// queryAuditEntries sends the stored entries of the tree in [start, end) to jobs, in index order
+// or as a random sample of sample entries
+func queryAuditEntries(db *sql.DB, treeID string, start, end int64, sample int, jobs chan<- auditJob) error {
+	query := `
+		SELECT log_index, entry_uuid, body, blob_codec
+		FROM rekor_log_entries
+		WHERE tree_id = ? AND log_index >= ? AND log_index < ?`
+	args := []interface{}{treeID, start, end}
+	if sample > 0 {
+		query += " ORDER BY rand() LIMIT 1 BY log_index LIMIT ?"
+		args = append(args, sample)
+	} else {
+		query += " ORDER BY log_index LIMIT 1 BY log_index"
+	}
+
+	rows, err := db.QueryContext(context.Background(), query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query rekor_log_entries: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var job auditJob
+		if err := rows.Scan(&job.logIndex, &job.entryUUID, &job.body, &job.blobCodec); err != nil {
+			return fmt.Errorf("failed to scan entry: %w", err)
+		}
+		jobs <- job
+	}
+	return rows.Err()
+}
+
+// auditEntry verifies one stored entry against the inclusion proof Rekor currently serves for it
+func auditEntry(client *http.Client, treeID string, job auditJob) AuditResult {
+	result := AuditResult{LogIndex: job.logIndex, EntryUUID: job.entryUUID, Status: AuditError}
+	if job.body == "" {
+		result.Status = AuditSkipped
+		result.Detail = "no body stored"
+		return result
+	}
+
+	body, err := decodeStoredBlob(job.body, job.blobCodec)
+	if err != nil {
+		result.Detail = fmt.Sprintf("failed to decode body: %v", err)
+		return result
+	}
+	leafHash := merkleLeafHash(body)
+
+	// The last 64 hex characters of an entry UUID are its leaf hash
+	if len(job.entryUUID) < 64 || !strings.EqualFold(job.entryUUID[len(job.entryUUID)-64:], hex.EncodeToString(leafHash)) {
+		result.Status = AuditMismatch
+		result.Detail = "stored body does not hash to the entry UUID"
+		return result
+	}
+
+	entry, err := fetchLogEntryByUUID(client, job.entryUUID)
+	if err != nil {
+		if errors.Is(err, errEntryNotFound) {
+			result.Status = AuditMismatch
+			result.Discrepancy = discrepancyMissing
+		}
+		result.Detail = err.Error()
+		return result
+	}
+	if liveBody, err := base64.StdEncoding.DecodeString(entry.Body); err != nil || !bytes.Equal(liveBody, body) {
+		result.Status = AuditMismatch
+		result.Discrepancy = discrepancyBodyChanged
+		result.Detail = "log serves a different body for the entry UUID"
+		return result
+	}
+	if entry.Verification == nil || entry.Verification.InclusionProof == nil {
+		result.Detail = errMissingInclusionProof.Error()
+		return result
+	}
+	proof := entry.Verification.InclusionProof
+	if err := validateCheckpointTreeID(proof.Checkpoint, treeID); err != nil {
+		result.Status = AuditMismatch
+		result.Discrepancy = discrepancyMoved
+		result.Detail = err.Error()
+		return result
+	}
+	if proof.LogIndex != job.logIndex {
+		result.Status = AuditMismatch
+		result.Discrepancy = discrepancyMoved
+		result.Detail = fmt.Sprintf("log has the entry at index %d", proof.LogIndex)
+		return result
+	}
+
+	rootHash, err := hex.DecodeString(proof.RootHash)
+	if err != nil {
+		result.Detail = fmt.Sprintf("failed to decode root hash: %v", err)
+		return result
+	}
+	hashes := make([][]byte, len(proof.Hashes))
+	for i, node := range proof.Hashes {
+		if hashes[i], err = hex.DecodeString(node); err != nil {
+			result.Detail = fmt.Sprintf("failed to decode inclusion proof: %v", err)
+			return result
+		}
+	}
+	if err := verifyInclusion(proof.LogIndex, proof.TreeSize, leafHash, hashes, rootHash); err != nil {
+		result.Status = AuditMismatch
+		result.Discrepancy = discrepancyProofMismatch
+		result.Detail = err.Error()
+		return result
+	}
+
+	result.Status = AuditOK
+	return result
+}
+
+// errEntryNotFound is returned by fetchLogEntryByUUID when Rekor does not know the entry
+var errEntryNotFound = errors.New("entry not found in log")
+
+// fetchLogEntryByUUID fetches a single entry with its current inclusion proof
+func fetchLogEntryByUUID(client *http.Client, entryUUID string) (*RekorLogEntry, error) {
+	apiURL := fmt.Sprintf("%s/api/v1/log/entries/%s", rekorBaseURL, url.PathEscape(entryUUID))
+
+	var lastErr error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(calculateBackoffDelay(attempt - 1))
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("User-Agent", userAgent)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			cancel()
+			lastErr = fmt.Errorf("failed to get entry from %s: %w", apiURL, err)
+			continue
+		}
+		bodyBytes, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		cancel()
+		if err != nil {
+			lastErr = fmt.Errorf("failed to read entry response: %w", err)
+			continue
+		}
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			// Response is a single object with the entry UUID as key
+			var response map[string]RekorLogEntry
+			if err := json.Unmarshal(bodyBytes, &response); err != nil {
+				return nil, fmt.Errorf("failed to decode entry response: %w", err)
+			}
+			for _, entry := range response {
+				return &entry, nil
+			}
+			return nil, errEntryNotFound
+		case http.StatusNotFound:
+			return nil, errEntryNotFound
+		default:
+			lastErr = fmt.Errorf("entry request failed with status %s: %s", resp.Status, string(bodyBytes))
+		}
+	}
+	return nil, lastErr
+}
+
+// merkleLeafHash returns the RFC 6962 hash of a leaf
+func merkleLeafHash(leaf []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	h.Write(leaf)
+	return h.Sum(nil)
+}
+
+// merkleNodeHash returns the RFC 6962 hash of an interior node
+func merkleNodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// verifyInclusion checks an RFC 9162 (section 2.1.3.2) inclusion proof of the leaf at index in a
+// tree of treeSize leaves with the given root hash
+func verifyInclusion(index, treeSize int64, leafHash []byte, proof [][]byte, rootHash []byte) error {
+	if index < 0 || index >= treeSize {
+		return fmt.Errorf("index %d is outside the tree of size %d", index, treeSize)
+	}
+
+	fn, sn := index, treeSize-1
+	r := leafHash
+	for _, p := range proof {
+		if sn == 0 {
+			return fmt.Errorf("inclusion proof has %d extra nodes", len(proof))
+		}
+		if fn&1 == 1 || fn == sn {
+			r = merkleNodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = merkleNodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return fmt.Errorf("inclusion proof is too short")
+	}
+	if !bytes.Equal(r, rootHash) {
+		return fmt.Errorf("inclusion proof does not match the root hash")
+	}
+	return nil
+}
+
+func printAuditReport(report AuditReport, asJSON bool) {
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+		return
+	}
+
+	if len(report.Failures) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "LOG INDEX\tENTRY UUID\tSTATUS\tDETAIL")
+		for _, f := range report.Failures {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", f.LogIndex, f.EntryUUID, f.Status, f.Detail)
+		}
+		w.Flush()
+		fmt.Println()
+	}
+	fmt.Printf("tree %s [%d, %d) at tree size %d: %d checked, %d ok, %d mismatches, %d skipped, %d errors\n",
+		report.TreeID, report.Start, report.End, report.TreeSize, report.Checked, report.OK, report.Mismatches, report.Skipped, report.Errors)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	autoTuneWindow        = 10 * time.Second       // Minimum time between adjustments
+	autoTuneMinRequests   = 5                      // Requests a window needs before it is judged
+	autoTuneMaxErrorRate  = 0.05                   // Share of rate limited, server and network errors above which load is cut
+	autoTuneLatencyFactor = 3                      // Without -auto_tune_max_latency, the p90 latency is compared with this many times the best window median
+	autoTuneMinPace       = 100 * time.Millisecond // First pause between chunks once concurrency is down to 1
+	autoTuneMaxPace       = 10 * time.Second       // Cap of the doubling pause between chunks
+	autoTuneTargetSlack   = 1.1                    // Rate over the target above which concurrency is lowered again
+)
+
+// autoTuner is set from -auto_tune before fetching starts
+var autoTuner *AutoTuner
+
+var (
+	metricAutoTuneConcurrency = newGauge("sigstore_ingest_auto_tune_concurrency", "Concurrent batch fetches chosen by -auto_tune")
+	metricAutoTunePace        = newGauge("sigstore_ingest_auto_tune_pace_seconds", "Pause between fetch chunks chosen by -auto_tune")
+	metricAutoTuneRate        = newGauge("sigstore_ingest_auto_tune_requests_per_second", "Requests per second to Rekor over the last -auto_tune window")
+)
+
+// AutoTuner is an AIMD controller of the fetch concurrency and the pause between chunks. Every
+// window it looks at the request attempts made (latency and errors): when the error rate or the
+// p90 latency shows the log is struggling, it halves the concurrency, and once that is 1 doubles
+// the pause between chunks instead. Otherwise, while the achieved requests per second are below
+// the target (or without a target), it first halves the pause, then adds one concurrent batch up
+// to the ceiling; well above the target it removes one. A nil AutoTuner leaves the concurrency at
+// the ceiling and does not pause.
+type AutoTuner struct {
+	ceiling    int
+	target     float64       // Requests per second, 0 for as many as the log sustains
+	maxLatency time.Duration // 0 to derive it from the best window median
+
+	mu          sync.Mutex
+	concurrency int
+	pace        time.Duration
+	windowStart time.Time
+	latencies   []time.Duration
+	errors      int
+	bestMedian  time.Duration
+}
+
+// NewAutoTuner creates a tuner starting at a quarter of ceiling concurrent batches
+func NewAutoTuner(ceiling int, target float64, maxLatency time.Duration) *AutoTuner {
+	t := &AutoTuner{
+		ceiling:     ceiling,
+		target:      target,
+		maxLatency:  maxLatency,
+		concurrency: max(ceiling/4, 1),
+		windowStart: time.Now(),
+	}
+	metricAutoTuneConcurrency.Set(float64(t.concurrency))
+	return t
+}
+
+// Record counts one request attempt with its latency and error (nil on success)
+func (t *AutoTuner) Record(latency time.Duration, err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.latencies = append(t.latencies, latency)
+	if err != nil {
+		switch classifyFetchError(err) {
+		case errorClassRateLimited, errorClassServerError, errorClassNetwork:
+			t.errors++
+		}
+	}
+}
+
+// Concurrency returns the number of concurrent batches to fetch, at most limit
+func (t *AutoTuner) Concurrency(limit int) int {
+	if t == nil {
+		return limit
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return min(t.concurrency, limit)
+}
+
+// Pace is called before each chunk: it adjusts the controller once a window has passed and waits
+// the current pause. It returns false if done is closed while waiting.
+func (t *AutoTuner) Pace(done <-chan struct{}) bool {
+	if t == nil {
+		return true
+	}
+	t.mu.Lock()
+	if time.Since(t.windowStart) >= autoTuneWindow && len(t.latencies) >= autoTuneMinRequests {
+		t.adjust()
+	}
+	pace := t.pace
+	t.mu.Unlock()
+	if pace == 0 {
+		return true
+	}
+
+	select {
+	case <-time.After(pace):
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// adjust judges the window and starts the next one
+func (t *AutoTuner) adjust() {
+	elapsed := time.Since(t.windowStart)
+	requests := len(t.latencies)
+	rate := float64(requests) / elapsed.Seconds()
+	errorRate := float64(t.errors) / float64(requests)
+	slices.Sort(t.latencies)
+	median := t.latencies[requests/2]
+	p90 := t.latencies[requests*9/10]
+	if t.bestMedian == 0 || median < t.bestMedian {
+		t.bestMedian = median
+	}
+	maxLatency := t.maxLatency
+	if maxLatency == 0 {
+		maxLatency = autoTuneLatencyFactor * t.bestMedian
+	}
+	t.latencies, t.errors, t.windowStart = t.latencies[:0], 0, time.Now()
+	metricAutoTuneRate.Set(rate)
+
+	concurrency, pace := t.concurrency, t.pace
+	var reason string
+	switch {
+	case errorRate > autoTuneMaxErrorRate || p90 > maxLatency:
+		// Multiplicative decrease
+		reason = "backing off"
+		if concurrency > 1 {
+			concurrency = max(concurrency/2, 1)
+		} else {
+			pace = min(max(2*pace, autoTuneMinPace), autoTuneMaxPace)
+		}
+	case t.target > 0 && rate > t.target*autoTuneTargetSlack:
+		reason = "above target"
+		if concurrency > 1 {
+			concurrency--
+		} else {
+			pace = min(max(2*pace, autoTuneMinPace), autoTuneMaxPace)
+		}
+	case t.target == 0 || rate < t.target:
+		// Additive increase, once the pause is gone
+		reason = "below target"
+		if pace > 0 {
+			if pace /= 2; pace < autoTuneMinPace {
+				pace = 0
+			}
+		} else if concurrency < t.ceiling {
+			concurrency++
+		}
+	}
+	if concurrency != t.concurrency || pace != t.pace {
+		log.Printf("Auto-tune (%s at %.1f req/s, %.1f%% errors, p90 latency %v of max %v): concurrency %d -> %d, pause between chunks %v -> %v",
+			reason, rate, 100*errorRate, p90.Round(time.Millisecond), maxLatency.Round(time.Millisecond), t.concurrency, concurrency, t.pace, pace)
+	}
+	t.concurrency, t.pace = concurrency, pace
+	metricAutoTuneConcurrency.Set(float64(concurrency))
+	metricAutoTunePace.Set(pace.Seconds())
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	backpressureHighWatermark = 0.8                   // Channel fill above which -adaptive_fetch slows the fetcher down
+	backpressureLowWatermark  = 0.5                   // Channel fill below which the fetch delay is reduced again
+	backpressureMinDelay      = 50 * time.Millisecond // First fetch delay once the high watermark is reached
+	backpressureMaxDelay      = 5 * time.Second       // Cap of the doubling fetch delay
+	backpressureSampleEvery   = 1 * time.Second       // Interval between channel depth samples
+)
+
+var (
+	metricChannelDepth    = newGauge("sigstore_ingest_channel_depth", "Entries buffered between the fetcher and the inserter")
+	metricChannelCapacity = newGauge("sigstore_ingest_channel_capacity", "Capacity of the channel between the fetcher and the inserter (-channel_buffer)")
+	metricBlockedSeconds  = newCounter("sigstore_ingest_fetcher_blocked_seconds_total", "Time the fetcher spent waiting for room in the full channel")
+	metricFetchDelay      = newGauge("sigstore_ingest_fetch_delay_seconds", "Delay added before each fetch by -adaptive_fetch")
+)
+
+// Backpressure bounds the entries buffered between the fetcher and the inserter. Sends wait while
+// the channel is full; with adaptive throttling the fetcher also slows down before it gets there.
+type Backpressure struct {
+	ch       chan *RekorLogEntryDetails
+	adaptive bool
+	delay    time.Duration // Current fetch delay, only used by the fetcher
+}
+
+// NewBackpressure wraps the channel the inserter reads from
+func NewBackpressure(ch chan *RekorLogEntryDetails, adaptive bool) *Backpressure {
+	metricChannelCapacity.Set(float64(cap(ch)))
+	return &Backpressure{ch: ch, adaptive: adaptive}
+}
+
+// Send queues an entry for insertion, waiting while the channel is full. It returns false if done
+// is closed first.
+func (b *Backpressure) Send(details *RekorLogEntryDetails, done <-chan struct{}) bool {
+	select {
+	case b.ch <- details:
+		return true
+	case <-done:
+		return false
+	default:
+	}
+
+	start := time.Now()
+	defer func() { metricBlockedSeconds.Add(time.Since(start).Seconds()) }()
+	select {
+	case b.ch <- details:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// Throttle is called before each fetch. With adaptive throttling it doubles the fetch delay while
+// the channel is above the high watermark and halves it once the channel drains below the low
+// watermark. It returns false if done is closed while waiting.
+func (b *Backpressure) Throttle(done <-chan struct{}) bool {
+	if !b.adaptive {
+		return true
+	}
+
+	fill := float64(len(b.ch)) / float64(cap(b.ch))
+	switch {
+	case fill >= backpressureHighWatermark:
+		b.delay = min(max(2*b.delay, backpressureMinDelay), backpressureMaxDelay)
+	case fill < backpressureLowWatermark:
+		if b.delay /= 2; b.delay < backpressureMinDelay {
+			b.delay = 0
+		}
+	}
+	metricFetchDelay.Set(b.delay.Seconds())
+	if b.delay == 0 {
+		return true
+	}
+
+	select {
+	case <-time.After(b.delay):
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// Start samples the channel depth every backpressureSampleEvery until done is closed
+func (b *Backpressure) Start(done <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(backpressureSampleEvery)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				metricChannelDepth.Set(float64(len(b.ch)))
+			case <-done:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"log"
+)
+
+// parseCertificateChain handles the certificates following the leaf in the PEM blob of an entry
+// (rest, after the leaf block): it sets the SHA-256 of each and that of the one that issued the
+// leaf, the Fulcio intermediate for Fulcio certificates. Blocks that are not certificates are
+// skipped.
+func parseCertificateChain(leaf *x509.Certificate, rest []byte, details *RekorLogEntryDetails) {
+	for {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			return
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			log.Printf("Warning: Failed to parse chain certificate of %s: %v", details.X509CertificateSHA256, err)
+			continue
+		}
+		hash := sha256.Sum256(cert.Raw)
+		details.X509ChainSHA256 = append(details.X509ChainSHA256, hex.EncodeToString(hash[:]))
+		if details.X509IssuingCertificateSHA256 == "" && bytes.Equal(leaf.RawIssuer, cert.RawSubject) && leaf.CheckSignatureFrom(cert) == nil {
+			details.X509IssuingCertificateSHA256 = hex.EncodeToString(hash[:])
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// fetchAcceptEncoding is sent with Rekor requests when compressed fetching is enabled. Entry
+// bodies are base64 encoded JSON and compress well.
+const fetchAcceptEncoding = "zstd, gzip"
+
+// compressedFetch is set from -compressed_fetch before any client is created
+var compressedFetch = true
+
+var (
+	metricWireBytes    = newCounter("sigstore_ingest_http_wire_bytes_total", "Response bytes received from Rekor, before decompression")
+	metricDecodedBytes = newCounter("sigstore_ingest_http_decoded_bytes_total", "Response bytes after decompression")
+)
+
+// compressingTransport requests zstd or gzip compressed responses and decodes them, so callers
+// read plain bodies. Wire and decoded sizes are counted per encoding.
+type compressingTransport struct {
+	base http.RoundTripper
+}
+
+func newCompressingTransport(base http.RoundTripper) http.RoundTripper {
+	return &compressingTransport{base: base}
+}
+
+func (t *compressingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Accept-Encoding") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Accept-Encoding", fetchAcceptEncoding)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding == "" {
+		encoding = "identity"
+	}
+	wire := &countingReader{r: resp.Body, metric: metricWireBytes, encoding: encoding}
+
+	var decoded io.ReadCloser
+	switch encoding {
+	case "identity":
+		decoded = wire
+	case "gzip":
+		gz, err := gzip.NewReader(wire)
+		if err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to decode gzip response: %w", err)
+		}
+		decoded = &decodedBody{Reader: gz, close: func() error { gz.Close(); return wire.Close() }}
+	case "zstd":
+		zr, err := zstd.NewReader(wire, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to decode zstd response: %w", err)
+		}
+		decoded = &decodedBody{Reader: zr, close: func() error { zr.Close(); return wire.Close() }}
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("unsupported response Content-Encoding %q", encoding)
+	}
+
+	if encoding != "identity" {
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		resp.Uncompressed = true
+	}
+	resp.Body = &countingReader{r: decoded, metric: metricDecodedBytes, encoding: encoding}
+	return resp, nil
+}
+
+// countingReader adds the bytes read to a counter labelled with the response encoding
+type countingReader struct {
+	r        io.ReadCloser
+	metric   *Metric
+	encoding string
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.metric.Add(float64(n), "encoding", c.encoding)
+	}
+	return n, err
+}
+
+func (c *countingReader) Close() error {
+	return c.r.Close()
+}
+
+// decodedBody closes both the decoder and the underlying response body
+type decodedBody struct {
+	io.Reader
+	close func() error
+}
+
+func (d *decodedBody) Close() error {
+	return d.close()
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+)
+
+const correlateQueryTimeout = 10 * time.Minute // Timeout of each correlation query
+
+// correlationMatch is one way a Fulcio CT log entry and a Rekor entry are recognized as the same
+// certificate, by an expression over each table. Matches run in order, so for a pair found by
+// several of them the stronger, later one is kept.
+type correlationMatch struct {
+	matchType string
+	ctKey     string // Over ct_log_entries
+	rekorKey  string // Over rekor_log_entries
+}
+
+var correlationMatches = []correlationMatch{
+	// Serials are hex in ct_log_entries and decimal in rekor_log_entries
+	{"serial", "serial_number", "lower(hex(toUInt256OrZero(x509_serial_number)))"},
+	// The precert logged by Fulcio against the final certificate with its SCTs used in Rekor
+	{"precert_tbs", "ifNull(toString(precert_tbs_sha256), '')", "x509_precert_tbs_sha256"},
+	{"certificate_sha256", "toString(certificate_sha256)", "x509_certificate_sha256"},
+}
+
+// runCorrelate implements the correlate subcommand: it links the certificates of the Fulcio CT log
+// in ct_log_entries (ingested by ctmon-ingest) with the Rekor entries signed with them into
+// sigstore_certificate_links, and records in sigstore_certificate_orphans the certificates found
+// in CT but used in no Rekor entry and the Fulcio certificates used in Rekor but missing from CT.
+// Orphans that are linked by a later run are marked resolved.
+func runCorrelate(args []string) {
+	fs := flag.NewFlagSet("correlate", flag.ExitOnError)
+	ctLogFlag := fs.String("ct_log", "ctfe.sigstore.dev/2022", "log_id of the Fulcio CT log in ct_log_entries")
+	fulcioOrgFlag := fs.String("fulcio_issuer_org", "sigstore.dev", "Issuer organization of Fulcio certificates in rekor_log_entries, which are expected in the CT log")
+	lookbackFlag := fs.Duration("lookback", 24*time.Hour, "Window of entries checked by each run, ending -grace ago")
+	graceFlag := fs.Duration("grace", time.Hour, "How recent entries are left to the next run, as their counterpart may not be ingested yet")
+	intervalFlag := fs.Duration("interval", 0, "Interval between runs (0 runs once)")
+	fs.Parse(args)
+
+	if *lookbackFlag <= 0 || *graceFlag < 0 || *intervalFlag < 0 {
+		log.Fatal("Error: -lookback must be positive, -grace and -interval non-negative")
+	}
+
+	db, err := initClickHouse()
+	if err != nil {
+		log.Fatalf("Failed to initialize ClickHouse connection: %v", err)
+	}
+	defer db.Close()
+
+	for {
+		to := time.Now().Add(-*graceFlag).Truncate(time.Second)
+		from := to.Add(-*lookbackFlag)
+		if err := correlate(db, *ctLogFlag, *fulcioOrgFlag, from, to, *graceFlag); err != nil {
+			if *intervalFlag == 0 {
+				log.Fatalf("Error: %v", err)
+			}
+			log.Printf("Warning: Correlation failed: %v", err)
+		}
+		if *intervalFlag == 0 {
+			return
+		}
+		time.Sleep(*intervalFlag)
+	}
+}
+
+// correlate links the entries of both logs from slack before from to to+slack, so pairs straddling
+// the window are found, then records the orphans among the entries from from to to
+func correlate(db *sql.DB, ctLog, fulcioOrg string, from, to time.Time, slack time.Duration) error {
+	start := time.Now()
+	linkFrom, linkTo := from.Add(-slack), to.Add(slack)
+
+	for _, match := range correlationMatches {
+		query := fmt.Sprintf(`
+			INSERT INTO sigstore_certificate_links (ct_log_id, ct_log_index, ct_certificate_sha256, rekor_tree_id, rekor_log_index,
+				rekor_entry_uuid, rekor_certificate_sha256, serial_number, match_type, ct_entry_timestamp, rekor_integrated_time)
+			SELECT c.log_id, c.log_index, c.certificate_sha256, r.tree_id, r.log_index,
+				r.entry_uuid, r.x509_certificate_sha256, c.serial_number, ?, c.entry_timestamp, r.integrated_time
+			FROM (
+				SELECT log_id, log_index, certificate_sha256, serial_number, entry_timestamp, %s AS match_key
+				FROM ct_log_entries
+				WHERE log_id = ? AND entry_timestamp >= ? AND entry_timestamp < ?
+			) AS c
+			INNER JOIN (
+				SELECT tree_id, log_index, entry_uuid, x509_certificate_sha256, integrated_time, %s AS match_key
+				FROM rekor_log_entries
+				WHERE integrated_time >= ? AND integrated_time < ? AND x509_certificate_sha256 != ''
+			) AS r ON c.match_key = r.match_key
+			WHERE c.match_key != ''`, match.ctKey, match.rekorKey)
+		if err := execCorrelation(db, query, match.matchType, ctLog, linkFrom, linkTo, linkFrom, linkTo); err != nil {
+			return fmt.Errorf("failed to link certificates by %s: %w", match.matchType, err)
+		}
+	}
+
+	// Both sides write the unlinked entries as orphans, and the linked entries that are still
+	// unresolved orphans from an earlier run as resolved
+	ctOrphans := `
+		INSERT INTO sigstore_certificate_orphans (side, certificate_sha256, serial_number, log_id, log_index, seen_at, resolved)
+		SELECT 'ct_only', certificate_sha256, serial_number, log_id, log_index, entry_timestamp, linked
+		FROM (
+			SELECT certificate_sha256, serial_number, log_id, log_index, entry_timestamp,
+				log_index IN (SELECT ct_log_index FROM sigstore_certificate_links WHERE ct_log_id = ? AND ct_entry_timestamp >= ?) AS linked
+			FROM ct_log_entries
+			WHERE log_id = ? AND entry_timestamp >= ? AND entry_timestamp < ?
+		)
+		WHERE NOT linked OR log_index IN (
+			SELECT log_index FROM sigstore_certificate_orphans FINAL
+			WHERE side = 'ct_only' AND log_id = ? AND NOT resolved)`
+	if err := execCorrelation(db, ctOrphans, ctLog, linkFrom, ctLog, from, to, ctLog); err != nil {
+		return fmt.Errorf("failed to record CT orphans: %w", err)
+	}
+
+	rekorOrphans := `
+		INSERT INTO sigstore_certificate_orphans (side, certificate_sha256, serial_number, log_id, log_index, seen_at, resolved)
+		SELECT 'rekor_only', x509_certificate_sha256, lower(hex(toUInt256OrZero(x509_serial_number))), tree_id, log_index, integrated_time, linked
+		FROM (
+			SELECT x509_certificate_sha256, x509_serial_number, tree_id, log_index, integrated_time,
+				(tree_id, log_index) IN (SELECT rekor_tree_id, rekor_log_index FROM sigstore_certificate_links WHERE rekor_integrated_time >= ?) AS linked
+			FROM rekor_log_entries
+			WHERE integrated_time >= ? AND integrated_time < ? AND has(x509_issuer_organization, ?)
+		)
+		WHERE NOT linked OR (tree_id, log_index) IN (
+			SELECT log_id, log_index FROM sigstore_certificate_orphans FINAL
+			WHERE side = 'rekor_only' AND NOT resolved)`
+	if err := execCorrelation(db, rekorOrphans, linkFrom, from, to, fulcioOrg); err != nil {
+		return fmt.Errorf("failed to record Rekor orphans: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), correlateQueryTimeout)
+	defer cancel()
+	var links, ctOnly, rekorOnly uint64
+	err := db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT count() FROM sigstore_certificate_links FINAL WHERE ct_log_id = ? AND ct_entry_timestamp >= ? AND ct_entry_timestamp < ?),
+			(SELECT count() FROM sigstore_certificate_orphans FINAL WHERE side = 'ct_only' AND NOT resolved AND seen_at >= ? AND seen_at < ?),
+			(SELECT count() FROM sigstore_certificate_orphans FINAL WHERE side = 'rekor_only' AND NOT resolved AND seen_at >= ? AND seen_at < ?)`,
+		ctLog, from, to, from, to, from, to).Scan(&links, &ctOnly, &rekorOnly)
+	if err != nil {
+		return fmt.Errorf("failed to count correlation results: %w", err)
+	}
+	log.Printf("Correlated %s with Rekor from %s to %s in %v: %d links, %d certificates only in CT, %d only in Rekor",
+		ctLog, from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339), time.Since(start).Round(time.Millisecond),
+		links, ctOnly, rekorOnly)
+	return nil
+}
+
+func execCorrelation(db *sql.DB, query string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), correlateQueryTimeout)
+	defer cancel()
+	_, err := db.ExecContext(ctx, query, args...)
+	return err
+}
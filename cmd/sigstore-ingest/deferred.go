@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+const (
+	deferredRetryDelay    = 15 * time.Second // Delay before the first re-fetch of an entry without inclusion proof
+	deferredMaxRetryDelay = 10 * time.Minute // Cap of the doubling delay between re-fetches
+	deferredMaxAttempts   = 8                // Fetches of an entry before it is quarantined
+)
+
+// errMissingInclusionProof is returned by parseRekorEntry for entries served before their
+// inclusion proof is available, which happens for freshly appended entries
+var errMissingInclusionProof = errors.New("inclusion proof not available yet")
+
+var metricDeferredEntries = newGauge("sigstore_ingest_deferred_entries", "Entries waiting to be re-fetched because their inclusion proof was not available")
+
+// deferredEntry is an entry waiting to be re-fetched
+type deferredEntry struct {
+	globalIndex int64
+	uuid        string
+	entry       RekorLogEntry // Latest response, quarantined if the proof never shows up
+	attempts    int
+	due         time.Time
+}
+
+// DeferredQueue holds entries that were served without an inclusion proof, so they are fetched
+// again after a delay instead of being dropped. It is only used by the fetch goroutine.
+type DeferredQueue struct {
+	entries map[int64]*deferredEntry // Global index -> entry
+}
+
+func NewDeferredQueue() *DeferredQueue {
+	return &DeferredQueue{entries: make(map[int64]*deferredEntry)}
+}
+
+// Add schedules a re-fetch of the entry at globalIndex, doubling the delay on every attempt. It
+// returns false and forgets the entry once deferredMaxAttempts fetches have been made.
+func (q *DeferredQueue) Add(globalIndex int64, uuid string, entry RekorLogEntry) bool {
+	e, ok := q.entries[globalIndex]
+	if !ok {
+		e = &deferredEntry{globalIndex: globalIndex}
+		q.entries[globalIndex] = e
+	}
+	e.uuid = uuid
+	e.entry = entry
+	e.attempts++
+	if e.attempts >= deferredMaxAttempts {
+		q.Remove(globalIndex)
+		return false
+	}
+
+	delay := deferredRetryDelay << (e.attempts - 1)
+	if delay > deferredMaxRetryDelay {
+		delay = deferredMaxRetryDelay
+	}
+	e.due = time.Now().Add(delay)
+	metricDeferredEntries.Set(float64(len(q.entries)))
+	return true
+}
+
+// Remove forgets the entry at globalIndex, if queued
+func (q *DeferredQueue) Remove(globalIndex int64) {
+	delete(q.entries, globalIndex)
+	metricDeferredEntries.Set(float64(len(q.entries)))
+}
+
+// Due returns the entries whose re-fetch is due, in index order. They stay queued until removed
+// or added again.
+func (q *DeferredQueue) Due(now time.Time) []*deferredEntry {
+	var due []*deferredEntry
+	for _, e := range q.entries {
+		if !now.Before(e.due) {
+			due = append(due, e)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].globalIndex < due[j].globalIndex })
+	return due
+}
+
+// NextDue returns the earliest time a queued entry is due, or false if the queue is empty
+func (q *DeferredQueue) NextDue() (time.Time, bool) {
+	var next time.Time
+	for _, e := range q.entries {
+		if next.IsZero() || e.due.Before(next) {
+			next = e.due
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// Len returns the number of queued entries
+func (q *DeferredQueue) Len() int {
+	return len(q.entries)
+}
+
+// Drain removes and returns all queued entries, in index order
+func (q *DeferredQueue) Drain() []*deferredEntry {
+	var all []*deferredEntry
+	for _, e := range q.entries {
+		all = append(all, e)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].globalIndex < all[j].globalIndex })
+	q.entries = make(map[int64]*deferredEntry)
+	metricDeferredEntries.Set(0)
+	return all
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Kinds of discrepancies between a stored entry and what Rekor serves for it now
+const (
+	discrepancyMissing       = "missing"        // Rekor no longer returns the entry (tombstoned or purged)
+	discrepancyBodyChanged   = "body_changed"   // Rekor returns another body for the entry UUID
+	discrepancyMoved         = "moved"          // Rekor places the entry at another index or tree
+	discrepancyProofMismatch = "proof_mismatch" // The inclusion proof Rekor serves does not verify
+)
+
+// Sources of discrepancy events
+const (
+	discrepancySourceAudit     = "audit"     // The audit subcommand
+	discrepancySourceResampler = "resampler" // The -resample_interval sampler of a running ingester
+)
+
+var (
+	metricResampled     = newCounter("sigstore_ingest_resampled_entries_total", "Stored entries re-fetched by the resampler, by audit status")
+	metricDiscrepancies = newCounter("sigstore_ingest_discrepancies_total", "Stored entries Rekor no longer serves as stored, by kind")
+)
+
+// recordDiscrepancies writes the audit results that found the log disagreeing with a stored
+// entry to rekor_discrepancies
+func recordDiscrepancies(db *sql.DB, treeID, source string, results []AuditResult) error {
+	var values []string
+	var args []interface{}
+	now := time.Now().UTC()
+	for _, result := range results {
+		if result.Discrepancy == "" {
+			continue
+		}
+		values = append(values, "(?, ?, ?, ?, ?, ?, ?)")
+		args = append(args, treeID, result.LogIndex, result.EntryUUID, result.Discrepancy, result.Detail, source, now)
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO rekor_discrepancies (tree_id, log_index, entry_uuid, kind, detail, source, detected_at)
+		VALUES `+strings.Join(values, ", "), args...)
+	if err != nil {
+		return fmt.Errorf("failed to insert into rekor_discrepancies: %w", err)
+	}
+	return nil
+}
+
+// Resampler periodically re-fetches a random sample of the stored entries of a tree and audits
+// them against the log, recording entries that disappeared or changed in rekor_discrepancies. It
+// provides tamper evidence for entries long after their inclusion proofs were first checked.
+type Resampler struct {
+	db       *sql.DB
+	client   *http.Client
+	treeID   string
+	interval time.Duration
+	size     int
+}
+
+// NewResampler creates a resampler auditing size entries of the tree every interval
+func NewResampler(db *sql.DB, client *http.Client, treeID string, interval time.Duration, size int) *Resampler {
+	return &Resampler{db: db, client: client, treeID: treeID, interval: interval, size: size}
+}
+
+// Start resamples every interval until done is closed
+func (r *Resampler) Start(done <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := r.resample(done); err != nil {
+					log.Printf("Warning: Failed to resample stored entries: %v", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+func (r *Resampler) resample(done <-chan struct{}) error {
+	jobs, err := r.sample()
+	if err != nil {
+		return err
+	}
+
+	var results []AuditResult
+	for _, job := range jobs {
+		select {
+		case <-done:
+			return nil
+		default:
+		}
+		result := auditEntry(r.client, r.treeID, job)
+		metricResampled.Add(1, "status", result.Status)
+		if result.Discrepancy == "" {
+			continue
+		}
+		metricDiscrepancies.Add(1, "kind", result.Discrepancy)
+		log.Printf("DISCREPANCY %s: entry %s at index %d of tree %s: %s", result.Discrepancy, result.EntryUUID, result.LogIndex, r.treeID, result.Detail)
+		results = append(results, result)
+	}
+	if len(results) == 0 {
+		return nil
+	}
+	return recordDiscrepancies(r.db, r.treeID, discrepancySourceResampler, results)
+}
+
+// sample reads the stored entries at up to size random indexes of the tree. Indexes that were
+// filtered out or not ingested yet are skipped, so rounds may audit fewer entries.
+func (r *Resampler) sample() ([]auditJob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var maxIndex int64
+	err := r.db.QueryRowContext(ctx, "SELECT toInt64(max(log_index)) FROM rekor_log_entries WHERE tree_id = ?", r.treeID).Scan(&maxIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the stored index range: %w", err)
+	}
+
+	indexes := make([]string, r.size)
+	for i := range indexes {
+		indexes[i] = fmt.Sprint(rand.Int64N(maxIndex + 1))
+	}
+	rows, err := r.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT log_index, entry_uuid, body, blob_codec
+		FROM rekor_log_entries
+		WHERE tree_id = ? AND log_index IN (%s) AND body != ''
+		LIMIT 1 BY log_index`, strings.Join(indexes, ",")), r.treeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sampled entries: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []auditJob
+	for rows.Next() {
+		var job auditJob
+		if err := rows.Scan(&job.logIndex, &job.entryUUID, &job.body, &job.blobCodec); err != nil {
+			return nil, fmt.Errorf("failed to scan entry: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultExportColumns are the rekor_log_entries columns exported unless -columns is set
+const defaultExportColumns = "tree_id,log_index,entry_uuid,integrated_time,kind,signature_format,data_hash_algorithm," +
+	"data_hash_value,x509_certificate_sha256,x509_subject_cn,x509_issuer_cn,x509_sans,pgp_signer_email"
+
+// exportColumnPattern matches the column names accepted by -columns
+var exportColumnPattern = regexp.MustCompile(`^[a-z][a-z0-9_.]*$`)
+
+// runExport implements the export subcommand: it streams the rekor_log_entries rows matching the
+// filters to stdout or a file as JSON lines or CSV, so the data can be used without SQL access
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	treeIDFlag := fs.String("tree_id", "", "Only export entries of this tree")
+	startFlag := fs.Int64("start", -1, "First tree-local log index to export (use -1 for no lower bound)")
+	endFlag := fs.Int64("end", -1, "Tree-local log index to stop before (use -1 for no upper bound)")
+	sinceFlag := fs.String("since", "", "Only export entries integrated at or after this time (YYYY-MM-DD or RFC 3339)")
+	untilFlag := fs.String("until", "", "Only export entries integrated before this time (YYYY-MM-DD or RFC 3339)")
+	identityFlag := fs.String("identity", "", "Only export entries signed by this certificate SAN or PGP signer email (hashed with CTMON_EMAIL_HMAC_KEY when set)")
+	columnsFlag := fs.String("columns", defaultExportColumns, "Comma-separated rekor_log_entries columns to export")
+	formatFlag := fs.String("format", "jsonl", "Output format: jsonl or csv")
+	outputFlag := fs.String("output", "-", "File to write to (- for stdout)")
+	limitFlag := fs.Int("limit", 0, "Maximum number of rows to export (0 for no limit)")
+	fs.Parse(args)
+
+	if *formatFlag != "jsonl" && *formatFlag != "csv" {
+		log.Fatalf("Error: Invalid -format %q (expected jsonl or csv)", *formatFlag)
+	}
+	if *limitFlag < 0 {
+		log.Fatal("Error: -limit must not be negative")
+	}
+	if (*startFlag >= 0 || *endFlag >= 0) && *treeIDFlag == "" {
+		log.Fatal("Error: -start and -end require -tree_id")
+	}
+	columns, err := parseExportColumns(*columnsFlag)
+	if err != nil {
+		log.Fatalf("Error: Invalid -columns: %v", err)
+	}
+
+	var conditions []string
+	var queryArgs []interface{}
+	if *treeIDFlag != "" {
+		conditions = append(conditions, "tree_id = ?")
+		queryArgs = append(queryArgs, *treeIDFlag)
+	}
+	if *startFlag >= 0 {
+		conditions = append(conditions, "log_index >= ?")
+		queryArgs = append(queryArgs, *startFlag)
+	}
+	if *endFlag >= 0 {
+		conditions = append(conditions, "log_index < ?")
+		queryArgs = append(queryArgs, *endFlag)
+	}
+	if *sinceFlag != "" {
+		since, err := parseExportTime(*sinceFlag)
+		if err != nil {
+			log.Fatalf("Error: Invalid -since: %v", err)
+		}
+		conditions = append(conditions, "integrated_time >= ?")
+		queryArgs = append(queryArgs, since)
+	}
+	if *untilFlag != "" {
+		until, err := parseExportTime(*untilFlag)
+		if err != nil {
+			log.Fatalf("Error: Invalid -until: %v", err)
+		}
+		conditions = append(conditions, "integrated_time < ?")
+		queryArgs = append(queryArgs, until)
+	}
+	if *identityFlag != "" {
+		conditions = append(conditions, "(has(x509_sans, ?) OR pgp_signer_email = ?)")
+		identity := *identityFlag
+		if key := os.Getenv(emailHMACKeyEnv); key != "" && isEmailAddress(identity) {
+			hasher, err := NewEmailHasher(key)
+			if err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			identity = hasher.Hash(identity)
+		}
+		queryArgs = append(queryArgs, identity, identity)
+	}
+
+	query := "SELECT " + strings.Join(columns, ", ") + " FROM rekor_log_entries"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY tree_id, log_index LIMIT 1 BY tree_id, log_index"
+	if *limitFlag > 0 {
+		query += " LIMIT ?"
+		queryArgs = append(queryArgs, *limitFlag)
+	}
+
+	db, err := initClickHouse()
+	if err != nil {
+		log.Fatalf("Failed to initialize ClickHouse connection: %v", err)
+	}
+	defer db.Close()
+
+	out := os.Stdout
+	if *outputFlag != "-" {
+		out, err = os.Create(*outputFlag)
+		if err != nil {
+			log.Fatalf("Error: Failed to create %s: %v", *outputFlag, err)
+		}
+	}
+
+	rows, err := db.QueryContext(context.Background(), query, queryArgs...)
+	if err != nil {
+		log.Fatalf("Failed to query rekor_log_entries: %v", err)
+	}
+	exported, err := exportRows(rows, *formatFlag, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatalf("Export failed after %d rows: %v", exported, err)
+	}
+	log.Printf("Exported %d rows", exported)
+}
+
+// parseExportColumns splits and validates a -columns value
+func parseExportColumns(value string) ([]string, error) {
+	var columns []string
+	for _, column := range strings.Split(value, ",") {
+		column = strings.TrimSpace(column)
+		if column == "" {
+			continue
+		}
+		if !exportColumnPattern.MatchString(column) {
+			return nil, fmt.Errorf("invalid column name %q", column)
+		}
+		columns = append(columns, column)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("no columns")
+	}
+	return columns, nil
+}
+
+// parseExportTime parses a -since or -until value as a date or an RFC 3339 timestamp
+func parseExportTime(value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// exportRows writes the rows as JSON lines or CSV with a header, closing rows, and returns the
+// number of rows written
+func exportRows(rows *sql.Rows, format string, out io.Writer) (int, error) {
+	defer rows.Close()
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get column types: %w", err)
+	}
+	names := make([]string, len(columnTypes))
+	values := make([]interface{}, len(columnTypes))
+	for i, ct := range columnTypes {
+		names[i] = ct.Name()
+		values[i] = reflect.New(ct.ScanType()).Interface()
+	}
+
+	w := bufio.NewWriterSize(out, 1<<20)
+	var csvWriter *csv.Writer
+	if format == "csv" {
+		csvWriter = csv.NewWriter(w)
+		if err := csvWriter.Write(names); err != nil {
+			return 0, err
+		}
+	}
+
+	exported := 0
+	record := make([]string, len(values))
+	for rows.Next() {
+		if err := rows.Scan(values...); err != nil {
+			return exported, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		if csvWriter != nil {
+			for i, v := range values {
+				record[i] = csvValue(reflect.ValueOf(v).Elem())
+			}
+			if err := csvWriter.Write(record); err != nil {
+				return exported, err
+			}
+		} else {
+			w.WriteByte('{')
+			for i, v := range values {
+				if i > 0 {
+					w.WriteByte(',')
+				}
+				key, _ := json.Marshal(names[i])
+				value, err := json.Marshal(v)
+				if err != nil {
+					return exported, fmt.Errorf("failed to encode %s: %w", names[i], err)
+				}
+				w.Write(key)
+				w.WriteByte(':')
+				w.Write(value)
+			}
+			w.WriteString("}\n")
+		}
+		exported++
+	}
+	if err := rows.Err(); err != nil {
+		return exported, err
+	}
+
+	if csvWriter != nil {
+		csvWriter.Flush()
+		if err := csvWriter.Error(); err != nil {
+			return exported, err
+		}
+	}
+	return exported, w.Flush()
+}
+
+// csvValue formats a scanned value for CSV: NULL as empty, times as RFC 3339 and arrays as JSON
+func csvValue(v reflect.Value) string {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	switch value := v.Interface().(type) {
+	case time.Time:
+		return value.UTC().Format(time.RFC3339)
+	case string:
+		return value
+	}
+	if v.Kind() == reflect.Slice || v.Kind() == reflect.Map {
+		encoded, _ := json.Marshal(v.Interface())
+		return string(encoded)
+	}
+	return fmt.Sprint(v.Interface())
+}
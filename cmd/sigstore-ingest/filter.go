@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+)
+
+// EntryFilter evaluates a CEL expression against parsed Rekor entry details
+// to decide whether an entry should be stored
+type EntryFilter struct {
+	expression string
+	program    cel.Program
+}
+
+// NewEntryFilter compiles a CEL expression. The expression must evaluate to a bool.
+//
+// Example: kind == "hashedrekord" && x509_sans.exists(s, s.startsWith("https://github.com/sigstore/"))
+func NewEntryFilter(expression string) (*EntryFilter, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("tree_id", cel.StringType),
+		cel.Variable("log_index", cel.IntType),
+		cel.Variable("entry_uuid", cel.StringType),
+		cel.Variable("integrated_time", cel.TimestampType),
+		cel.Variable("kind", cel.StringType),
+		cel.Variable("api_version", cel.StringType),
+		cel.Variable("signature_format", cel.StringType),
+		cel.Variable("data_hash_algorithm", cel.StringType),
+		cel.Variable("data_hash_value", cel.StringType),
+		cel.Variable("data_url", cel.StringType),
+		cel.Variable("x509_certificate_sha256", cel.StringType),
+		cel.Variable("x509_subject_cn", cel.StringType),
+		cel.Variable("x509_issuer_cn", cel.StringType),
+		cel.Variable("x509_sans", cel.ListType(cel.StringType)),
+		cel.Variable("pgp_public_key_fingerprint", cel.StringType),
+		cel.Variable("pgp_signer_email", cel.StringType),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CEL environment: %w", err)
+	}
+
+	ast, issues := env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("failed to compile filter expression: %w", issues.Err())
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("filter expression must evaluate to bool, got %s", ast.OutputType())
+	}
+
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build filter program: %w", err)
+	}
+
+	return &EntryFilter{expression: expression, program: program}, nil
+}
+
+// Match reports whether the entry satisfies the filter. A nil filter matches everything.
+func (f *EntryFilter) Match(details *RekorLogEntryDetails) (bool, error) {
+	if f == nil {
+		return true, nil
+	}
+
+	out, _, err := f.program.Eval(map[string]interface{}{
+		"tree_id":                    details.TreeID,
+		"log_index":                  details.LogIndex,
+		"entry_uuid":                 details.EntryUUID,
+		"integrated_time":            details.IntegratedTime,
+		"kind":                       details.Kind,
+		"api_version":                details.APIVersion,
+		"signature_format":           details.SignatureFormat,
+		"data_hash_algorithm":        details.DataHashAlgorithm,
+		"data_hash_value":            details.DataHashValue,
+		"data_url":                   details.DataURL,
+		"x509_certificate_sha256":    details.X509CertificateSHA256,
+		"x509_subject_cn":            details.X509SubjectCN,
+		"x509_issuer_cn":             details.X509IssuerCN,
+		"x509_sans":                  ensureStringSlice(details.X509SANs),
+		"pgp_public_key_fingerprint": details.PGPPublicKeyFingerprint,
+		"pgp_signer_email":           details.PGPSignerEmail,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to evaluate filter %q for entry %s: %w", f.expression, details.EntryUUID, err)
+	}
+
+	matched, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("filter %q returned non-bool value %v", f.expression, out.Value())
+	}
+	return matched, nil
+}
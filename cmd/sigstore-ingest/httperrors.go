@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// maxServerErrorRetries caps the retries of a request failing with 5xx responses, below maxRetries
+// since a log that keeps failing is better left to the API circuit breaker
+const maxServerErrorRetries = 3
+
+var metricFetchErrors = newCounter("sigstore_ingest_fetch_errors_total", "Failed requests to Rekor, by error class")
+
+// fetchErrorClass groups upstream request errors by how they are retried
+type fetchErrorClass string
+
+const (
+	errorClassRateLimited fetchErrorClass = "rate_limited" // 429: retried after a longer backoff, lowering the concurrency
+	errorClassNotFound    fetchErrorClass = "not_found"    // 404, 410: not retried
+	errorClassProxyDenied fetchErrorClass = "proxy_denied" // 403, 407: retried through another proxy without delay
+	errorClassServerError fetchErrorClass = "server_error" // 5xx: retried at most maxServerErrorRetries times
+	errorClassClientError fetchErrorClass = "client_error" // Other 4xx: not retried
+	errorClassNetwork     fetchErrorClass = "network"      // Timeouts, connection and decoding errors: retried
+)
+
+// classifyFetchError returns the class of a failed upstream request
+func classifyFetchError(err error) fetchErrorClass {
+	var statusErr *HTTPStatusError
+	if !errors.As(err, &statusErr) {
+		return errorClassNetwork
+	}
+	switch code := statusErr.StatusCode; {
+	case code == http.StatusTooManyRequests:
+		return errorClassRateLimited
+	case code == http.StatusNotFound || code == http.StatusGone:
+		return errorClassNotFound
+	case code == http.StatusForbidden || code == http.StatusProxyAuthRequired:
+		return errorClassProxyDenied
+	case code >= 500:
+		return errorClassServerError
+	default:
+		return errorClassClientError
+	}
+}
+
+// retryable reports whether a request failing with this class is worth retrying
+func (c fetchErrorClass) retryable() bool {
+	switch c {
+	case errorClassNotFound, errorClassClientError:
+		return false
+	}
+	return true
+}
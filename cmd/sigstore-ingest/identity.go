@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"strings"
+)
+
+// Fulcio certificate extensions (https://github.com/sigstore/fulcio/blob/main/docs/oid-info.md)
+var (
+	oidFulcioIssuerV1       = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}  // Raw string, deprecated
+	oidFulcioIssuerV2       = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}  // DER UTF8String
+	oidFulcioBuildSignerURI = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 9}  // Workflow that signed, with its ref
+	oidFulcioSourceRepoURI  = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 12} // Repository the build ran for
+	oidFulcioGitHubRepoV1   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 5}  // Raw string, deprecated
+)
+
+const githubURLPrefix = "https://github.com/"
+
+// parseFulcioIdentity sets the OIDC issuer of a Fulcio certificate and, for GitHub Actions, the
+// repository (owner/name) and the workflow (owner/name/path of the workflow file, which differs
+// from the repository for reusable workflows)
+func parseFulcioIdentity(cert *x509.Certificate, details *RekorLogEntryDetails) {
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(oidFulcioIssuerV2):
+			if issuer := derUTF8String(ext.Value); issuer != "" {
+				details.OIDCIssuer = issuer
+			}
+		case ext.Id.Equal(oidFulcioIssuerV1):
+			if details.OIDCIssuer == "" {
+				details.OIDCIssuer = string(ext.Value)
+			}
+		case ext.Id.Equal(oidFulcioSourceRepoURI):
+			if repository, ok := strings.CutPrefix(derUTF8String(ext.Value), githubURLPrefix); ok {
+				details.GitHubRepository = repository
+			}
+		case ext.Id.Equal(oidFulcioGitHubRepoV1):
+			if details.GitHubRepository == "" {
+				details.GitHubRepository = string(ext.Value)
+			}
+		case ext.Id.Equal(oidFulcioBuildSignerURI):
+			if workflow, ok := strings.CutPrefix(derUTF8String(ext.Value), githubURLPrefix); ok {
+				workflow, _, _ = strings.Cut(workflow, "@")
+				details.GitHubWorkflow = workflow
+			}
+		}
+	}
+}
+
+// derUTF8String decodes a DER string extension value, or returns "" if it is not one
+func derUTF8String(value []byte) string {
+	var s string
+	if rest, err := asn1.Unmarshal(value, &s); err != nil || len(rest) > 0 {
+		return ""
+	}
+	return s
+}
+
+// setSignerIdentity sets the identity an entry was signed by: the email or, for workload
+// identities, the URI SAN of its certificate, or the email of its PGP signer
+func setSignerIdentity(details *RekorLogEntryDetails) {
+	for _, san := range details.X509SANs {
+		if isEmailAddress(san) {
+			details.SignerIdentity = san
+			return
+		}
+	}
+	for _, san := range details.X509SANs {
+		if strings.Contains(san, "://") {
+			details.SignerIdentity = san
+			return
+		}
+	}
+	details.SignerIdentity = details.PGPSignerEmail
+}
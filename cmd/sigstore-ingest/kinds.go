@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var metricRawOnlyEntries = newCounter("sigstore_ingest_raw_only_entries_total", "Entries stored without parsing their spec because of -kinds or -skip_kinds, by kind")
+
+// rekorKinds are the entry kinds Rekor accepts
+var rekorKinds = []string{"alpine", "cose", "dsse", "hashedrekord", "helm", "intoto", "jar", "rekord", "rfc3161", "rpm", "tuf"}
+
+// KindSet selects the entry kinds whose spec is parsed (signature, data hash, certificate, PGP
+// key, attestation, OCI reference, signer). Entries of other kinds only get their common fields
+// (kind, API version, times, inclusion proof) and the raw body, which costs far less CPU. A nil
+// KindSet parses every kind.
+type KindSet struct {
+	kinds   map[string]bool
+	include bool // kinds lists the parsed kinds, otherwise the skipped ones
+}
+
+// ParseKindSet builds the set for -kinds and -skip_kinds (comma separated, at most one of them)
+func ParseKindSet(kinds, skipKinds string) (*KindSet, error) {
+	if kinds != "" && skipKinds != "" {
+		return nil, fmt.Errorf("cannot specify both -kinds and -skip_kinds")
+	}
+	list, include := kinds, true
+	if skipKinds != "" {
+		list, include = skipKinds, false
+	}
+	if list == "" {
+		return nil, nil
+	}
+
+	s := &KindSet{kinds: make(map[string]bool), include: include}
+	for _, kind := range strings.Split(list, ",") {
+		kind = strings.ToLower(strings.TrimSpace(kind))
+		if kind == "" {
+			continue
+		}
+		if i := sort.SearchStrings(rekorKinds, kind); i == len(rekorKinds) || rekorKinds[i] != kind {
+			return nil, fmt.Errorf("unknown kind %q (known: %s)", kind, strings.Join(rekorKinds, ", "))
+		}
+		s.kinds[kind] = true
+	}
+	if len(s.kinds) == 0 {
+		return nil, fmt.Errorf("no kinds given")
+	}
+	return s, nil
+}
+
+// Deep reports whether the spec of entries of kind is parsed
+func (s *KindSet) Deep(kind string) bool {
+	if s == nil {
+		return true
+	}
+	return s.kinds[kind] == s.include
+}
+
+// String describes the set for the startup log
+func (s *KindSet) String() string {
+	var kinds []string
+	for kind := range s.kinds {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	if s.include {
+		return "parsing only " + strings.Join(kinds, ", ")
+	}
+	return "storing " + strings.Join(kinds, ", ") + " raw only"
+}
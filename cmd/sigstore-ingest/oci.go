@@ -0,0 +1,98 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	defaultOCIRegistry  = "docker.io" // Registry of references without a registry host, as in docker pull
+	ociAnnotationsDepth = 4           // How deep annotations are looked for in an entry spec
+)
+
+var (
+	// ociReference matches registry/repository[:tag]@digest, optionally behind a scheme such as oci://
+	ociReference = regexp.MustCompile(`^(?:[a-z][a-z0-9+.-]*://)?((?:[a-zA-Z0-9-]+\.)*[a-zA-Z0-9-]+(?::[0-9]+)?/)?([a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*)(?::[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127})?@(sha256:[a-f0-9]{64}|sha512:[a-f0-9]{128})$`)
+	// ociRegistryURL matches registry API URLs of manifests and blobs
+	ociRegistryURL = regexp.MustCompile(`^https?://([^/]+)/v2/(.+)/(?:manifests|blobs)/(sha256:[a-f0-9]{64}|sha512:[a-f0-9]{128})$`)
+)
+
+// parseOCIReference sets the registry, repository and digest of the container image an entry
+// signs, as referenced by its data URL or by an annotation value in its spec (as cosign records
+// for images). The first reference found is kept.
+func parseOCIReference(spec *rekorSpec, details *RekorLogEntryDetails) {
+	candidates := append([]string{details.DataURL}, spec.annotationValues()...)
+	for _, candidate := range candidates {
+		if registry, repository, digest, ok := splitOCIReference(candidate); ok {
+			details.OCIRegistry = registry
+			details.OCIRepository = repository
+			details.OCIDigest = digest
+			return
+		}
+	}
+}
+
+// collectAnnotationValues appends the string values of every "annotations" object in v
+func collectAnnotationValues(v interface{}, depth int, values []string) []string {
+	if depth < 0 {
+		return values
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, key := range sortedKeys(v) {
+			if annotations, ok := v[key].(map[string]interface{}); ok && key == "annotations" {
+				for _, name := range sortedKeys(annotations) {
+					if s, ok := annotations[name].(string); ok {
+						values = append(values, s)
+					}
+				}
+				continue
+			}
+			values = collectAnnotationValues(v[key], depth-1, values)
+		}
+	case []interface{}:
+		for _, child := range v {
+			values = collectAnnotationValues(child, depth-1, values)
+		}
+	}
+	return values
+}
+
+// splitOCIReference parses an image reference pinned to a digest, or a registry API URL of a
+// manifest or blob, normalizing references without a registry host as docker pull does
+func splitOCIReference(ref string) (registry, repository, digest string, ok bool) {
+	ref = strings.TrimSpace(ref)
+	if m := ociRegistryURL.FindStringSubmatch(ref); m != nil {
+		return strings.ToLower(m[1]), m[2], m[3], true
+	}
+	m := ociReference.FindStringSubmatch(ref)
+	if m == nil {
+		return "", "", "", false
+	}
+	registry, repository, digest = strings.TrimSuffix(m[1], "/"), m[2], m[3]
+
+	// Like docker, the first component is a registry only if it looks like a host
+	if registry != "" && !strings.ContainsAny(registry, ".:") && registry != "localhost" {
+		repository = registry + "/" + repository
+		registry = ""
+	}
+	if registry == "" {
+		registry = defaultOCIRegistry
+		if !strings.Contains(repository, "/") {
+			repository = "library/" + repository
+		}
+	}
+	return strings.ToLower(registry), repository, digest, true
+}
+
+// sortedKeys returns the keys of m in order, so the first reference found does not depend on map
+// iteration order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
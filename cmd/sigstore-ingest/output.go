@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Output selects where ingested entries are written
+type Output string
+
+const (
+	OutputClickHouse Output = "clickhouse" // Batched inserts into rekor_log_entries
+	OutputStdout     Output = "stdout"     // One JSON line per entry, no database needed
+)
+
+// parseOutput validates an -output flag value
+func parseOutput(value string) (Output, error) {
+	switch output := Output(value); output {
+	case OutputClickHouse, OutputStdout:
+		return output, nil
+	default:
+		return "", fmt.Errorf("unknown output %q (expected clickhouse or stdout)", value)
+	}
+}
+
+// stdoutWriter takes the place of dbInserter with -output=stdout: it writes every entry to stdout
+// as one JSON line, flushing whenever the channel is empty so consumers see entries promptly. After
+// a failed write ingestion stops and remaining entries are dropped.
+func stdoutWriter(logChan <-chan *RekorLogEntryDetails, failure *Failure, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	w := bufio.NewWriterSize(os.Stdout, 1<<20)
+	encoder := json.NewEncoder(w)
+	failed := false
+	for details := range logChan {
+		if !failed {
+			err := encoder.Encode(details)
+			if err == nil && len(logChan) == 0 {
+				err = w.Flush()
+			}
+			if err != nil {
+				failed = true
+				failure.Fail(fmt.Errorf("failed to write entry %d to stdout: %w", details.LogIndex, err))
+			}
+		}
+		releaseRekorDetails(details)
+	}
+	if !failed {
+		if err := w.Flush(); err != nil {
+			failure.Fail(fmt.Errorf("failed to write to stdout: %w", err))
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Package ecosystems recognized in attestations
+const (
+	ecosystemNPM  = "npm"
+	ecosystemPyPI = "pypi"
+)
+
+// predicatePyPIPublish is the predicate type of PyPI publish attestations (PEP 740)
+const predicatePyPIPublish = "https://docs.pypi.org/attestations/publish/v1"
+
+var (
+	// pypiWheel matches {name}-{version}(-{build})?-{python}-{abi}-{platform}.whl (PEP 427)
+	pypiWheel = regexp.MustCompile(`^([A-Za-z0-9](?:[A-Za-z0-9._]*[A-Za-z0-9])?)-([^-]+)(?:-[0-9][^-]*)?-[^-]+-[^-]+-[^-]+\.whl$`)
+	// pypiSdist matches {name}-{version}.tar.gz (PEP 625) and legacy .zip source distributions
+	pypiSdist = regexp.MustCompile(`^(.+)-([0-9][^-]*)\.(?:tar\.gz|zip)$`)
+	// pypiNameSeparators matches the runs of separators PEP 503 normalizes to a single dash
+	pypiNameSeparators = regexp.MustCompile(`[-_.]+`)
+)
+
+// inTotoStatement holds the fields of an in-toto statement identifying what was attested
+type inTotoStatement struct {
+	PredicateType string `json:"predicateType"`
+	Subject       []struct {
+		Name string `json:"name"`
+	} `json:"subject"`
+}
+
+// parsePackageAttestation recognizes npm provenance and PyPI publish attestations in intoto and
+// dsse entries, from the attestation Rekor stored with the entry or the envelope in its spec, and
+// sets the predicate type and the ecosystem, name and version of the published package
+func parsePackageAttestation(spec *rekorSpec, attestation map[string]interface{}, details *RekorLogEntryDetails) {
+	statement := attestationStatement(spec, attestation)
+	if statement == nil {
+		return
+	}
+	details.AttestationPredicateType = statement.PredicateType
+
+	for _, subject := range statement.Subject {
+		ecosystem, name, version := packageFromSubject(subject.Name, statement.PredicateType)
+		if ecosystem != "" {
+			details.PackageEcosystem = ecosystem
+			details.PackageName = name
+			details.PackageVersion = version
+			return
+		}
+	}
+}
+
+// attestationStatement decodes the in-toto statement of an entry, or returns nil if the entry
+// does not carry it
+func attestationStatement(spec *rekorSpec, attestation map[string]interface{}) *inTotoStatement {
+	var payload []byte
+	if data, ok := attestation["data"].(string); ok {
+		payload, _ = base64.StdEncoding.DecodeString(data)
+	}
+	if payload == nil && spec.EnvelopePayload != "" {
+		payload, _ = base64.StdEncoding.DecodeString(spec.EnvelopePayload)
+	}
+	if payload == nil {
+		return nil
+	}
+	var statement inTotoStatement
+	if err := json.Unmarshal(payload, &statement); err != nil || statement.PredicateType == "" {
+		return nil
+	}
+	return &statement
+}
+
+// packageFromSubject returns the ecosystem, name and version of the package an attestation
+// subject names: a pkg:npm or pkg:pypi purl, or a PyPI distribution filename when the predicate
+// is a PyPI publish attestation or SLSA provenance
+func packageFromSubject(subject, predicateType string) (ecosystem, name, version string) {
+	if purl, ok := strings.CutPrefix(subject, "pkg:"); ok {
+		kind, rest, _ := strings.Cut(purl, "/")
+		rest, _, _ = strings.Cut(rest, "?")
+		at := strings.LastIndex(rest, "@")
+		if at <= 0 {
+			return "", "", ""
+		}
+		name, err := url.PathUnescape(rest[:at])
+		if err != nil {
+			return "", "", ""
+		}
+		switch kind {
+		case ecosystemNPM:
+			return ecosystemNPM, name, rest[at+1:]
+		case ecosystemPyPI:
+			return ecosystemPyPI, normalizePyPIName(name), rest[at+1:]
+		}
+		return "", "", ""
+	}
+
+	if predicateType != predicatePyPIPublish && !strings.HasPrefix(predicateType, "https://slsa.dev/provenance/") {
+		return "", "", ""
+	}
+	for _, pattern := range []*regexp.Regexp{pypiWheel, pypiSdist} {
+		if m := pattern.FindStringSubmatch(subject); m != nil {
+			return ecosystemPyPI, normalizePyPIName(m[1]), m[2]
+		}
+	}
+	return "", "", ""
+}
+
+// normalizePyPIName normalizes a project name as PyPI does (PEP 503)
+func normalizePyPIName(name string) string {
+	return strings.ToLower(pypiNameSeparators.ReplaceAllString(name, "-"))
+}
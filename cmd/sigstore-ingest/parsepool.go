@@ -0,0 +1,47 @@
+package main
+
+import (
+	"sync"
+)
+
+// parseResult is the outcome of parsing one fetched entry
+type parseResult struct {
+	details *RekorLogEntryDetails
+	err     error
+}
+
+// ParserPool parses entries on a fixed set of worker goroutines, so body, certificate and PGP
+// parsing does not bottleneck fetching on multi-core machines. Results keep the order of the input.
+type ParserPool struct {
+	jobs  chan func()
+	kinds *KindSet
+}
+
+// NewParserPool starts workers parser goroutines, which run for the life of the process, parsing
+// the spec of the kinds in kinds
+func NewParserPool(workers int, kinds *KindSet) *ParserPool {
+	p := &ParserPool{jobs: make(chan func(), workers), kinds: kinds}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range p.jobs {
+				job()
+			}
+		}()
+	}
+	return p
+}
+
+// ParseAll parses the fetched entries of a tree
+func (p *ParserPool) ParseAll(entries []FetchedEntry, treeID string) []parseResult {
+	results := make([]parseResult, len(entries))
+	var wg sync.WaitGroup
+	wg.Add(len(entries))
+	for i := range entries {
+		p.jobs <- func() {
+			defer wg.Done()
+			results[i].details, results[i].err = parseRekorEntry(entries[i].UUID, entries[i].Entry, treeID, p.kinds)
+		}
+	}
+	wg.Wait()
+	return results
+}
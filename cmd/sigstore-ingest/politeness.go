@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// politeness is set from -max_requests_per_sec, -max_entries_per_sec and the hourly and daily
+// budgets before fetching starts
+var politeness *PolitenessLimiter
+
+var metricPolitenessWait = newCounter("sigstore_ingest_politeness_wait_seconds_total", "Time spent waiting for the -max_requests_per_sec and -max_entries_per_sec budgets")
+
+// tokenBucket refills at rate tokens per second up to one second worth of tokens. Takes larger
+// than the bucket go into debt, which later takes wait for.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := max(rate, 1)
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// take removes n tokens and returns how long the caller has to wait before using them
+func (b *tokenBucket) take(n float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, b.burst)
+	b.last = now
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// PolitenessLimiter caps the requests and entries per second fetched from Rekor across all
+// concurrent batches and proxies. A nil limiter does not limit.
+type PolitenessLimiter struct {
+	requests *tokenBucket
+	entries  *tokenBucket
+	budget   *RequestBudget // Hourly and daily budgets, waited for first
+}
+
+// NewPolitenessLimiter returns nil if both budgets are 0 (unlimited) and there is no budget
+func NewPolitenessLimiter(requestsPerSec, entriesPerSec float64, budget *RequestBudget) *PolitenessLimiter {
+	if requestsPerSec <= 0 && entriesPerSec <= 0 && budget == nil {
+		return nil
+	}
+	l := &PolitenessLimiter{budget: budget}
+	if requestsPerSec > 0 {
+		l.requests = newTokenBucket(requestsPerSec)
+	}
+	if entriesPerSec > 0 {
+		l.entries = newTokenBucket(entriesPerSec)
+	}
+	return l
+}
+
+// Wait blocks until a request for the given number of entries fits the budgets
+func (l *PolitenessLimiter) Wait(entries int) {
+	if l == nil {
+		return
+	}
+	l.budget.Wait()
+	var wait time.Duration
+	if l.requests != nil {
+		wait = l.requests.take(1)
+	}
+	if l.entries != nil && entries > 0 {
+		wait = max(wait, l.entries.take(float64(entries)))
+	}
+	if wait > 0 {
+		metricPolitenessWait.Add(wait.Seconds())
+		time.Sleep(wait)
+	}
+}
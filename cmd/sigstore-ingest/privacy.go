@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+const (
+	emailHMACKeyEnv   = "CTMON_EMAIL_HMAC_KEY" // Key of the email hashes, shared by the ingesters and the API
+	minEmailHMACKey   = 16                     // Shortest accepted key, in bytes
+	hashedEmailPrefix = "hmac:"                // Prefix of stored email hashes
+)
+
+// EmailHasher replaces email addresses with their keyed hash, hmac:<hex HMAC-SHA256 of the
+// lowercased address>, so deployments that must not store them in plaintext can still find the
+// entries of a known address by hashing it with the same key. A nil EmailHasher leaves addresses
+// unchanged.
+type EmailHasher struct {
+	key []byte
+}
+
+// NewEmailHasher creates a hasher with the given key
+func NewEmailHasher(key string) (*EmailHasher, error) {
+	if len(key) < minEmailHMACKey {
+		return nil, fmt.Errorf("%s must be at least %d bytes", emailHMACKeyEnv, minEmailHMACKey)
+	}
+	return &EmailHasher{key: []byte(key)}, nil
+}
+
+// Hash returns the keyed hash of an email address
+func (h *EmailHasher) Hash(email string) string {
+	if h == nil || email == "" || strings.HasPrefix(email, hashedEmailPrefix) {
+		return email
+	}
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(strings.ToLower(email)))
+	return hashedEmailPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// hashEmails returns names with the email addresses among them hashed
+func (h *EmailHasher) hashEmails(names []string) []string {
+	if h == nil {
+		return names
+	}
+	for i, name := range names {
+		if isEmailAddress(name) {
+			names[i] = h.Hash(name)
+		}
+	}
+	return names
+}
+
+// isEmailAddress reports whether a name (SAN, common name or identity) is an email address
+// rather than a host name or URI
+func isEmailAddress(name string) bool {
+	at := strings.LastIndex(name, "@")
+	return at > 0 && at < len(name)-1 && !strings.Contains(name, "://") && !strings.ContainsAny(name, " <>/")
+}
+
+// Apply hashes the email SANs and an email common name of the certificate (Fulcio identities),
+// and the PGP signer email, also within the signer user ID
+func (h *EmailHasher) Apply(details *RekorLogEntryDetails) {
+	if h == nil {
+		return
+	}
+	details.X509SANs = h.hashEmails(details.X509SANs)
+	if isEmailAddress(details.X509SubjectCN) {
+		details.X509SubjectCN = h.Hash(details.X509SubjectCN)
+	}
+	if details.PGPSignerEmail != "" {
+		details.PGPSignerUserID = strings.ReplaceAll(details.PGPSignerUserID, details.PGPSignerEmail, h.Hash(details.PGPSignerEmail))
+		details.PGPSignerEmail = h.Hash(details.PGPSignerEmail)
+	}
+	if isEmailAddress(details.SignerIdentity) {
+		details.SignerIdentity = h.Hash(details.SignerIdentity)
+	}
+}
+
+// emailHasherForFlag returns the hasher for -hash_emails keyed with CTMON_EMAIL_HMAC_KEY, or nil
+// when it is off
+func emailHasherForFlag(enabled bool, profile StorageProfile) (*EmailHasher, error) {
+	if !enabled {
+		return nil, nil
+	}
+	hasher, err := NewEmailHasher(os.Getenv(emailHMACKeyEnv))
+	if err != nil {
+		return nil, err
+	}
+	if profile == StorageProfileFull {
+		log.Printf("Warning: -hash_emails with -storage_profile=full: raw blobs still contain email addresses in plaintext")
+	}
+	log.Printf("Email addresses will be stored as keyed hashes")
+	return hasher, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http/httptrace"
+	"time"
+)
+
+// FetchProvenance describes how a Rekor response was fetched. With -record_provenance it is
+// stored with every entry of the response, to debug data discrepancies.
+type FetchProvenance struct {
+	PeerAddr  string        `json:"peer_addr"`  // Proxy the request went through, or Rekor for direct requests
+	LocalAddr string        `json:"local_addr"` // Egress IP of the connection
+	Latency   time.Duration `json:"latency"`    // Duration of the successful request
+	Retries   int           `json:"retries"`    // Failed attempts before it
+}
+
+// withProvenanceTrace records the addresses of the connection used by requests made with the
+// returned context in prov. A nil prov records nothing.
+func withProvenanceTrace(ctx context.Context, prov *FetchProvenance) context.Context {
+	if prov == nil {
+		return ctx
+	}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			prov.PeerAddr = info.Conn.RemoteAddr().String()
+			prov.LocalAddr = info.Conn.LocalAddr().String()
+			if host, _, err := net.SplitHostPort(prov.LocalAddr); err == nil {
+				prov.LocalAddr = host
+			}
+		},
+	})
+}
+
+// provenanceColumns returns the fetch_* column values, all NULL when provenance was not recorded
+func provenanceColumns(prov *FetchProvenance) []interface{} {
+	if prov == nil {
+		return []interface{}{nil, nil, nil, nil}
+	}
+	return []interface{}{
+		nullableString(prov.PeerAddr),
+		nullableString(prov.LocalAddr),
+		uint32(min(prov.Latency.Milliseconds(), 1<<32-1)),
+		uint8(min(prov.Retries, 255)),
+	}
+}
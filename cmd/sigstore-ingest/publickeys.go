@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"log"
+	"math/big"
+	"strings"
+)
+
+// Types of the signing keys indexed in rekor_public_keys
+const (
+	publicKeyTypePGP  = "pgp"
+	publicKeyTypeSSH  = "ssh"
+	publicKeyTypeX509 = "x509"
+)
+
+// Values of public_key_algorithm, named as in the PGP and x509 columns
+const (
+	keyAlgorithmRSA   = "RSA"
+	keyAlgorithmDSA   = "DSA"
+	keyAlgorithmECDSA = "ECDSA"
+	keyAlgorithmECDH  = "ECDH"
+	keyAlgorithmEdDSA = "EdDSA"
+)
+
+// Values of public_key_curve for Edwards curves, whose keys always have 256 bits
+const (
+	curveEd25519    = "Ed25519"
+	curveCurve25519 = "Curve25519"
+)
+
+// setPublicKeyFingerprint sets the type, fingerprint and algorithm of the key an entry was signed
+// with: the PGP fingerprint, the OpenSSH SHA256 fingerprint of an ssh key, or the SHA-256 of the
+// SubjectPublicKeyInfo of an x509 certificate or PEM public key (set by parseX509Certificate for
+// hashedrekord, x509 rekord, intoto and dsse entries). Minisign keys, which are always Ed25519,
+// only get their algorithm. It runs after the kind specific parsers.
+func setPublicKeyFingerprint(spec *rekorSpec, details *RekorLogEntryDetails) {
+	if details.PublicKeyFingerprint != "" {
+		return
+	}
+	if details.PGPPublicKeyFingerprint != "" {
+		details.PublicKeyType = publicKeyTypePGP
+		details.PublicKeyFingerprint = strings.ToLower(details.PGPPublicKeyFingerprint)
+		details.PublicKeyAlgorithm = details.PGPKeyAlgorithm
+		details.PublicKeyCurve = details.PGPKeyCurve
+		details.PublicKeySize = details.PGPKeySize
+		return
+	}
+
+	switch spec.SignatureFormat {
+	case "minisign":
+		details.PublicKeyAlgorithm, details.PublicKeyCurve, details.PublicKeySize = keyAlgorithmEdDSA, curveEd25519, 256
+		return
+	case "ssh":
+	default:
+		return
+	}
+	keyBytes, err := base64.StdEncoding.DecodeString(spec.PublicKeyContent)
+	if err != nil {
+		return
+	}
+	blob := sshKeyBlob(string(keyBytes))
+	if blob == nil {
+		return
+	}
+	details.PublicKeyType = publicKeyTypeSSH
+	details.PublicKeyFingerprint = sshFingerprint(blob)
+	details.PublicKeyAlgorithm, details.PublicKeyCurve, details.PublicKeySize = sshKeyAlgorithm(blob)
+}
+
+// sshKeyBlob returns the wire encoded key of an authorized_keys line ("<type> <base64 key>
+// [comment]"), or nil if it is malformed
+func sshKeyBlob(authorizedKey string) []byte {
+	fields := strings.Fields(authorizedKey)
+	if len(fields) < 2 {
+		return nil
+	}
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil || len(blob) == 0 {
+		return nil
+	}
+	return blob
+}
+
+// sshFingerprint returns the fingerprint of a wire encoded ssh key as ssh-keygen -l prints it,
+// SHA256:<unpadded base64>
+func sshFingerprint(blob []byte) string {
+	hash := sha256.Sum256(blob)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(hash[:])
+}
+
+// sshKeyAlgorithm returns the algorithm, curve and size in bits of a wire encoded ssh key
+// (RFC 4253, RFC 5656, RFC 8709 and the OpenSSH security key types), or empty values for
+// unknown or malformed keys
+func sshKeyAlgorithm(blob []byte) (algorithm, curve string, size int) {
+	keyType, rest, ok := sshString(blob)
+	if !ok {
+		return "", "", 0
+	}
+	switch string(keyType) {
+	case "ssh-rsa":
+		if _, rest, ok = sshString(rest); !ok { // Public exponent
+			return "", "", 0
+		}
+		if n, _, ok := sshString(rest); ok {
+			return keyAlgorithmRSA, "", new(big.Int).SetBytes(n).BitLen()
+		}
+	case "ssh-dss":
+		if p, _, ok := sshString(rest); ok {
+			return keyAlgorithmDSA, "", new(big.Int).SetBytes(p).BitLen()
+		}
+	case "ssh-ed25519", "sk-ssh-ed25519@openssh.com":
+		return keyAlgorithmEdDSA, curveEd25519, 256
+	case "ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521", "sk-ecdsa-sha2-nistp256@openssh.com":
+		curveID, _, ok := sshString(rest)
+		if !ok {
+			return "", "", 0
+		}
+		switch string(curveID) {
+		case "nistp256":
+			return keyAlgorithmECDSA, elliptic.P256().Params().Name, 256
+		case "nistp384":
+			return keyAlgorithmECDSA, elliptic.P384().Params().Name, 384
+		case "nistp521":
+			return keyAlgorithmECDSA, elliptic.P521().Params().Name, 521
+		}
+	}
+	return "", "", 0
+}
+
+// sshString reads a length prefixed string (RFC 4251 section 5) from the start of data
+func sshString(data []byte) (value []byte, rest []byte, ok bool) {
+	if len(data) < 4 {
+		return nil, nil, false
+	}
+	length := binary.BigEndian.Uint32(data)
+	if uint64(length) > uint64(len(data)-4) {
+		return nil, nil, false
+	}
+	return data[4 : 4+length], data[4+length:], true
+}
+
+// x509KeyAlgorithm returns the algorithm, curve and size in bits of a certificate public key, or
+// empty values for unsupported keys
+func x509KeyAlgorithm(publicKey interface{}) (algorithm, curve string, size int) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return keyAlgorithmRSA, "", key.N.BitLen()
+	case *dsa.PublicKey:
+		return keyAlgorithmDSA, "", key.P.BitLen()
+	case *ecdsa.PublicKey:
+		return keyAlgorithmECDSA, key.Curve.Params().Name, key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return keyAlgorithmEdDSA, curveEd25519, 256
+	}
+	return "", "", 0
+}
+
+// pgpCurves are the curves of PGP ECDH, ECDSA and EdDSA keys by the DER encoded OID in the key
+// packet (RFC 4880bis section 9.2), with their size in bits
+var pgpCurves = map[string]struct {
+	name string
+	size int
+}{
+	"\x2a\x86\x48\xce\x3d\x03\x01\x07":         {"P-256", 256},
+	"\x2b\x81\x04\x00\x22":                     {"P-384", 384},
+	"\x2b\x81\x04\x00\x23":                     {"P-521", 521},
+	"\x2b\x81\x04\x00\x0a":                     {"secp256k1", 256},
+	"\x2b\x24\x03\x03\x02\x08\x01\x01\x07":     {"brainpoolP256r1", 256},
+	"\x2b\x24\x03\x03\x02\x08\x01\x01\x0b":     {"brainpoolP384r1", 384},
+	"\x2b\x24\x03\x03\x02\x08\x01\x01\x0d":     {"brainpoolP512r1", 512},
+	"\x2b\x06\x01\x04\x01\xda\x47\x0f\x01":     {curveEd25519, 256},
+	"\x2b\x06\x01\x04\x01\x97\x55\x01\x05\x01": {curveCurve25519, 256},
+}
+
+// pgpCurve returns the curve and size of an elliptic curve key packet from the OID following its
+// algorithm octet, or empty values for unknown curves
+func pgpCurve(keyMaterial []byte) (string, int) {
+	if len(keyMaterial) < 1 || len(keyMaterial) < 1+int(keyMaterial[0]) {
+		return "", 0
+	}
+	curve, ok := pgpCurves[string(keyMaterial[1:1+int(keyMaterial[0])])]
+	if !ok {
+		return "", 0
+	}
+	return curve.name, curve.size
+}
+
+// parsePKIXPublicKey sets the signing key of a hashedrekord entry signed with a bare public key
+// (a DER SubjectPublicKeyInfo) rather than a certificate. Its fingerprint is computed as for
+// certificates, so a key is the same whether or not it was certified.
+func parsePKIXPublicKey(der []byte, details *RekorLogEntryDetails) {
+	publicKey, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		log.Printf("Warning: Failed to parse PEM public key: %v", err)
+		return
+	}
+	hash := sha256.Sum256(der)
+	details.PublicKeyType = publicKeyTypeX509
+	details.PublicKeyFingerprint = hex.EncodeToString(hash[:])
+	details.PublicKeyAlgorithm, details.PublicKeyCurve, details.PublicKeySize = x509KeyAlgorithm(publicKey)
+}
+
+// spkiFingerprint returns the SHA-256 (hex) of the SubjectPublicKeyInfo of a certificate, which
+// identifies its key across certificates
+func spkiFingerprint(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(hash[:])
+}
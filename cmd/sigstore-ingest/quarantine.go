@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+var metricQuarantined = newCounter("sigstore_ingest_entries_quarantined_total", "Entries written to rekor_quarantine instead of rekor_log_entries")
+
+// Quarantine records Rekor entries that could not be processed in rekor_quarantine, together with
+// the raw API response, so they can be inspected and re-ingested instead of silently skipped
+type Quarantine struct {
+	db  *sql.DB
+	run *IngestRun // Counts quarantined entries as errors of the run
+}
+
+// NewQuarantine creates a quarantine. Without db (-dry_run) entries are only logged.
+func NewQuarantine(db *sql.DB, run *IngestRun) *Quarantine {
+	return &Quarantine{db: db, run: run}
+}
+
+// Add quarantines the entry with the given global index and UUID. The raw entry is logged when it
+// cannot be stored, so it is never lost.
+func (q *Quarantine) Add(treeID string, globalIndex int64, uuid string, reason error, raw interface{}) {
+	metricQuarantined.Add(1)
+	q.run.RecordError()
+
+	rawJSON, err := json.Marshal(raw)
+	if err != nil {
+		rawJSON = []byte(fmt.Sprintf("%q", fmt.Sprint(raw)))
+	}
+	if q.db == nil {
+		log.Printf("Quarantined entry UUID %s at index %d (not stored, no database): %v. Raw entry: %s", uuid, globalIndex, reason, rawJSON)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err = q.db.ExecContext(ctx, `
+		INSERT INTO rekor_quarantine (tree_id, global_index, entry_uuid, reason, raw_entry, quarantined_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		treeID, globalIndex, uuid, reason.Error(), string(rawJSON), time.Now().UTC())
+	if err != nil {
+		log.Printf("Warning: Failed to quarantine entry UUID %s at index %d (%v): %v. Raw entry: %s", uuid, globalIndex, reason, err, rawJSON)
+		return
+	}
+	log.Printf("Quarantined entry UUID %s at index %d: %v", uuid, globalIndex, reason)
+}
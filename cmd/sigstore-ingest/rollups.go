@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/routing-cafe/ctmon/internal/rollup"
+)
+
+var rekorRollups = []rollup.Rollup{
+	{
+		View:  "rekor_daily_kind_stats_mv",
+		Table: "rekor_daily_kind_stats",
+		TableDDL: `(
+			day Date,
+			kind LowCardinality(String),
+			entries SimpleAggregateFunction(sum, UInt64)
+		)
+		ENGINE = AggregatingMergeTree()
+		ORDER BY (day, kind)`,
+		Source: "rekor_log_entries",
+		Select: `SELECT
+			toDate(integrated_time) AS day,
+			kind,
+			toUInt64(count()) AS entries
+		FROM {source}
+		GROUP BY day, kind`,
+	},
+	{
+		View:  "rekor_daily_key_algorithm_stats_mv",
+		Table: "rekor_daily_key_algorithm_stats",
+		TableDDL: `(
+			day Date,
+			kind LowCardinality(String),
+			signature_format LowCardinality(String),
+			public_key_algorithm LowCardinality(String),
+			public_key_curve LowCardinality(String),
+			public_key_size UInt16,
+			entries SimpleAggregateFunction(sum, UInt64)
+		)
+		ENGINE = AggregatingMergeTree()
+		ORDER BY (day, kind, signature_format, public_key_algorithm, public_key_curve, public_key_size)`,
+		Source: "rekor_log_entries",
+		Select: `SELECT
+			toDate(integrated_time) AS day,
+			kind,
+			signature_format,
+			public_key_algorithm,
+			public_key_curve,
+			public_key_size,
+			toUInt64(count()) AS entries
+		FROM {source}
+		WHERE public_key_algorithm != ''
+		GROUP BY day, kind, signature_format, public_key_algorithm, public_key_curve, public_key_size`,
+	},
+	{
+		View:  "rekor_daily_kind_mix_mv",
+		Table: "rekor_daily_kind_mix",
+		TableDDL: `(
+			day Date,
+			kind LowCardinality(String),
+			signature_format LowCardinality(String),
+			public_key_algorithm LowCardinality(String) COMMENT 'Empty for unparsed entries and keys that failed to parse (minisign keys are EdDSA)',
+			oidc_issuer LowCardinality(String) COMMENT 'Empty for entries without a Fulcio certificate, and for intoto and dsse entries ingested before their verifier certificates were parsed',
+			entries SimpleAggregateFunction(sum, UInt64)
+		)
+		ENGINE = AggregatingMergeTree()
+		ORDER BY (day, kind, signature_format, public_key_algorithm, oidc_issuer)`,
+		Source: "rekor_log_entries",
+		Select: `SELECT
+			toDate(integrated_time) AS day,
+			kind,
+			signature_format,
+			public_key_algorithm,
+			oidc_issuer,
+			toUInt64(count()) AS entries
+		FROM {source}
+		GROUP BY day, kind, signature_format, public_key_algorithm, oidc_issuer`,
+	},
+}
+
+// runRollups implements the rollups subcommand: it creates missing rollups, backfilling new ones
+// from existing rows, and with -rebuild drops and recreates all of them
+func runRollups(args []string, rollups []rollup.Rollup) {
+	fs := flag.NewFlagSet("rollups", flag.ExitOnError)
+	rebuildFlag := fs.Bool("rebuild", false, "Drop and recreate all rollups, then backfill them")
+	backfillFlag := fs.Bool("backfill", false, "Backfill existing rollups from the source tables (rows may be counted twice)")
+	fs.Parse(args)
+
+	db, err := initClickHouse()
+	if err != nil {
+		log.Fatalf("Failed to initialize ClickHouse connection: %v", err)
+	}
+	defer db.Close()
+
+	if err := rollup.Run(db, rollups, *rebuildFlag, *backfillFlag); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
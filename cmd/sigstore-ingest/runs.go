@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const ingestRunUpdateInterval = 1 * time.Minute // Interval between progress updates of the ingest_runs row
+
+// secretFlags are recorded in ingest_runs as set but without their value
+var secretFlags = map[string]bool{
+	"alert_webhook":  true,
+	"publish_url":    true,
+	"proxy_list_url": true,
+}
+
+// IngestRun records an ingestion run in ingest_runs when it starts, every ingestRunUpdateInterval
+// and when it ends, so operators can audit what was ingested when and by which build. Rows are
+// replaced by run_id. A nil run records nothing.
+type IngestRun struct {
+	db        *sql.DB
+	runID     string
+	binary    string
+	version   string
+	hostname  string
+	flags     string
+	logID     string // Tree ID
+	startedAt time.Time
+
+	mu         sync.Mutex // Serializes writes
+	startIndex int64
+	nextIndex  atomic.Int64
+	processed  atomic.Uint64
+	errors     atomic.Uint64
+}
+
+// NewIngestRun creates a run for the Rekor tree, capturing the build version and the flags set on the
+// command line. Call it after flag.Parse.
+func NewIngestRun(db *sql.DB, binary, treeID string) *IngestRun {
+	flags := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		if secretFlags[f.Name] {
+			flags[f.Name] = "<redacted>"
+		} else {
+			flags[f.Name] = f.Value.String()
+		}
+	})
+	flagsJSON, _ := json.Marshal(flags)
+	hostname, _ := os.Hostname()
+
+	return &IngestRun{
+		db:        db,
+		runID:     uuid.NewString(),
+		binary:    binary,
+		version:   buildVersion(),
+		hostname:  hostname,
+		flags:     string(flagsJSON),
+		logID:     treeID,
+		startedAt: time.Now().UTC(),
+	}
+}
+
+// buildVersion returns the module version and VCS revision embedded by the Go toolchain
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := info.Main.Version
+	var revision, modified string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			if setting.Value == "true" {
+				modified = "-dirty"
+			}
+		}
+	}
+	if revision != "" {
+		version += " " + revision + modified
+	}
+	return version
+}
+
+// Start records the run as running from startIndex and keeps the row up to date until done is
+// closed
+func (r *IngestRun) Start(startIndex int64, done <-chan struct{}) {
+	if r == nil {
+		return
+	}
+	r.startIndex = startIndex
+	r.nextIndex.Store(startIndex)
+	r.write("running", nil)
+	log.Printf("Recording run %s in ingest_runs", r.runID)
+
+	go func() {
+		ticker := time.NewTicker(ingestRunUpdateInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.write("running", nil)
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// SetNextIndex records the next global index the fetcher will request
+func (r *IngestRun) SetNextIndex(index int64) {
+	if r == nil {
+		return
+	}
+	r.nextIndex.Store(index)
+}
+
+// RecordInsert counts inserted entries
+func (r *IngestRun) RecordInsert(entries int) {
+	if r == nil {
+		return
+	}
+	r.processed.Add(uint64(entries))
+}
+
+// RecordError counts a quarantined entry or a batch that failed to insert
+func (r *IngestRun) RecordError() {
+	if r == nil {
+		return
+	}
+	r.errors.Add(1)
+}
+
+// Finish records the end of the run, as failed if err is set
+func (r *IngestRun) Finish(err error) {
+	if r == nil {
+		return
+	}
+	if err != nil {
+		r.write("failed", err)
+	} else {
+		r.write("stopped", nil)
+	}
+}
+
+func (r *IngestRun) write(status string, runErr error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var errText string
+	if runErr != nil {
+		errText = runErr.Error()
+	}
+	now := time.Now().UTC()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO ingest_runs (run_id, binary, version, hostname, flags, log_id, start_index, next_index,
+			entries_processed, errors, status, error, started_at, updated_at, duration_seconds)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.runID, r.binary, r.version, r.hostname, r.flags, r.logID, r.startIndex, r.nextIndex.Load(),
+		r.processed.Load(), r.errors.Load(), status, errText, r.startedAt, now, now.Sub(r.startedAt).Seconds())
+	if err != nil {
+		log.Printf("Warning: Failed to record run %s in ingest_runs: %v", r.runID, err)
+	}
+}
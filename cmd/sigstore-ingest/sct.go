@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+	cttls "github.com/google/certificate-transparency-go/tls"
+	ctx509 "github.com/google/certificate-transparency-go/x509"
+)
+
+// oidEmbeddedSCTList is the X.509v3 extension holding the SCTs of a certificate issued from a
+// precertificate (RFC 6962 s3.3)
+var oidEmbeddedSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// parseEmbeddedSCTs extracts the log ID and timestamp of each SCT embedded in a certificate, as
+// Fulcio embeds those of its CT log (CTFE), and the SHA-256 of the TBS with the SCT list removed,
+// which equals precert_tbs_sha256 of the precertificate in ct_log_entries. Certificates without
+// embedded SCTs are left untouched.
+func parseEmbeddedSCTs(cert *x509.Certificate, details *RekorLogEntryDetails) error {
+	var extValue []byte
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidEmbeddedSCTList) {
+			extValue = ext.Value
+			break
+		}
+	}
+	if extValue == nil {
+		return nil
+	}
+
+	if precertTBS, err := ctx509.RemoveSCTList(cert.RawTBSCertificate); err == nil {
+		hash := sha256.Sum256(precertTBS)
+		details.X509PrecertTBSSHA256 = hex.EncodeToString(hash[:])
+	}
+
+	var listBytes []byte
+	if rest, err := asn1.Unmarshal(extValue, &listBytes); err != nil || len(rest) > 0 {
+		return fmt.Errorf("malformed SCT list extension")
+	}
+	var list ctx509.SignedCertificateTimestampList
+	if rest, err := cttls.Unmarshal(listBytes, &list); err != nil || len(rest) > 0 {
+		return fmt.Errorf("malformed SCT list: %v", err)
+	}
+	for _, serialized := range list.SCTList {
+		var sct ct.SignedCertificateTimestamp
+		if rest, err := cttls.Unmarshal(serialized.Val, &sct); err != nil || len(rest) > 0 {
+			return fmt.Errorf("malformed SCT: %v", err)
+		}
+		details.X509SCTLogIDs = append(details.X509SCTLogIDs, hex.EncodeToString(sct.LogID.KeyID[:]))
+		details.X509SCTTimestamps = append(details.X509SCTTimestamps, time.UnixMilli(int64(sct.Timestamp)).UTC())
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var metricSpecErrors = newCounter("sigstore_ingest_spec_errors_total", "Entries whose spec could not be decoded, by kind, API version and reason (unknown_kind, unknown_version, invalid)")
+
+var (
+	errUnknownSpecKind    = errors.New("unknown kind")
+	errUnknownSpecVersion = errors.New("unknown API version")
+)
+
+// rekorSpec holds the fields of an entry spec the parsers use, decoded from the typed spec of
+// the entry's kind and API version. Fields a kind does not have are empty.
+type rekorSpec struct {
+	SignatureFormat   string // pgp, minisign, ssh or x509 (rekord)
+	SignatureContent  string // Base64
+	PublicKeyContent  string // Base64: a PEM certificate or public key, an armored PGP key, an ssh or minisign key; of the first signature of intoto and dsse entries
+	DataHashAlgorithm string
+	DataHashValue     string
+	DataURL           string
+	EnvelopePayload   string // Base64 payload of the DSSE envelope of intoto and dsse entries
+
+	raw json.RawMessage // For the annotations walk of parseOCIReference
+}
+
+// specDecoder decodes the spec of one kind and API version
+type specDecoder func(raw json.RawMessage) (*rekorSpec, error)
+
+// specDecoders holds the decoder of every known kind and API version. Kinds without kind specific
+// parsing only have their spec checked to be an object.
+var specDecoders = map[string]map[string]specDecoder{
+	"hashedrekord": {"0.0.1": decodeHashedRekordV001},
+	"rekord":       {"0.0.1": decodeRekordV001},
+	"intoto":       {"0.0.1": decodeInTotoV001, "0.0.2": decodeInTotoV002},
+	"dsse":         {"0.0.1": decodeDSSEV001},
+	"alpine":       {"0.0.1": decodeOpaqueSpec},
+	"cose":         {"0.0.1": decodeOpaqueSpec},
+	"helm":         {"0.0.1": decodeOpaqueSpec},
+	"jar":          {"0.0.1": decodeOpaqueSpec},
+	"rfc3161":      {"0.0.1": decodeOpaqueSpec},
+	"rpm":          {"0.0.1": decodeOpaqueSpec},
+	"tuf":          {"0.0.1": decodeOpaqueSpec},
+}
+
+// decodeRekorSpec decodes the spec of an entry of kind and apiVersion. It returns nil without
+// error for an entry without spec.
+func decodeRekorSpec(kind, apiVersion string, raw json.RawMessage) (*rekorSpec, error) {
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+	versions, ok := specDecoders[kind]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", errUnknownSpecKind, kind)
+	}
+	decode, ok := versions[apiVersion]
+	if !ok {
+		known := make([]string, 0, len(versions))
+		for version := range versions {
+			known = append(known, version)
+		}
+		sort.Strings(known)
+		return nil, fmt.Errorf("%w %q of %s (known: %s)", errUnknownSpecVersion, apiVersion, kind, strings.Join(known, ", "))
+	}
+	spec, err := decode(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %s spec: %w", kind, apiVersion, err)
+	}
+	spec.raw = raw
+	return spec, nil
+}
+
+// specErrorReason returns the reason label of sigstore_ingest_spec_errors_total for err
+func specErrorReason(err error) string {
+	switch {
+	case errors.Is(err, errUnknownSpecKind):
+		return "unknown_kind"
+	case errors.Is(err, errUnknownSpecVersion):
+		return "unknown_version"
+	default:
+		return "invalid"
+	}
+}
+
+// specHash is a hash in a spec
+type specHash struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"value"`
+}
+
+// specContent is a key or signature in a spec, stored inline
+type specContent struct {
+	Content string `json:"content"`
+}
+
+// dsseEnvelope is the part of a DSSE envelope attestations and signing keys are read from
+type dsseEnvelope struct {
+	PayloadType string `json:"payloadType"`
+	Payload     string `json:"payload"` // Base64, absent from envelopes canonicalized by Rekor
+	Signatures  []struct {
+		PublicKey string `json:"publicKey"` // Base64 PEM certificate or public key, in intoto 0.0.2 envelopes
+	} `json:"signatures"`
+}
+
+// hashedRekordV001 is the spec of hashedrekord 0.0.1 entries
+type hashedRekordV001 struct {
+	Signature struct {
+		Content   string      `json:"content"`
+		PublicKey specContent `json:"publicKey"` // PEM certificate or public key
+	} `json:"signature"`
+	Data struct {
+		Hash specHash `json:"hash"`
+	} `json:"data"`
+}
+
+func decodeHashedRekordV001(raw json.RawMessage) (*rekorSpec, error) {
+	var s hashedRekordV001
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, err
+	}
+	return &rekorSpec{
+		SignatureContent:  s.Signature.Content,
+		PublicKeyContent:  s.Signature.PublicKey.Content,
+		DataHashAlgorithm: s.Data.Hash.Algorithm,
+		DataHashValue:     s.Data.Hash.Value,
+	}, nil
+}
+
+// rekordV001 is the spec of rekord 0.0.1 entries
+type rekordV001 struct {
+	Signature struct {
+		Format    string      `json:"format"`
+		Content   string      `json:"content"`
+		PublicKey specContent `json:"publicKey"`
+	} `json:"signature"`
+	Data struct {
+		Hash specHash `json:"hash"`
+		URL  string   `json:"url"` // Set by entries of early Rekor versions, which fetched the data
+	} `json:"data"`
+}
+
+func decodeRekordV001(raw json.RawMessage) (*rekorSpec, error) {
+	var s rekordV001
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, err
+	}
+	return &rekorSpec{
+		SignatureFormat:   s.Signature.Format,
+		SignatureContent:  s.Signature.Content,
+		PublicKeyContent:  s.Signature.PublicKey.Content,
+		DataHashAlgorithm: s.Data.Hash.Algorithm,
+		DataHashValue:     s.Data.Hash.Value,
+		DataURL:           s.Data.URL,
+	}, nil
+}
+
+// inTotoV001 is the spec of intoto 0.0.1 entries, whose envelope is a JSON string
+type inTotoV001 struct {
+	Content struct {
+		Envelope string `json:"envelope"`
+	} `json:"content"`
+	PublicKey string `json:"publicKey"`
+}
+
+func decodeInTotoV001(raw json.RawMessage) (*rekorSpec, error) {
+	var s inTotoV001
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, err
+	}
+	return &rekorSpec{PublicKeyContent: s.PublicKey, EnvelopePayload: envelopeStringPayload(s.Content.Envelope)}, nil
+}
+
+// inTotoV002 is the spec of intoto 0.0.2 entries, whose envelope is an object
+type inTotoV002 struct {
+	Content struct {
+		Envelope *dsseEnvelope `json:"envelope"`
+	} `json:"content"`
+}
+
+func decodeInTotoV002(raw json.RawMessage) (*rekorSpec, error) {
+	var s inTotoV002
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, err
+	}
+	spec := &rekorSpec{}
+	if envelope := s.Content.Envelope; envelope != nil {
+		spec.EnvelopePayload = envelope.Payload
+		if len(envelope.Signatures) > 0 {
+			spec.PublicKeyContent = envelope.Signatures[0].PublicKey
+		}
+	}
+	return spec, nil
+}
+
+// dsseV001 is the spec of dsse 0.0.1 entries. Rekor stores the envelope hashes and signatures;
+// the proposed envelope is only there in entries as submitted.
+type dsseV001 struct {
+	ProposedContent struct {
+		Envelope  string   `json:"envelope"`
+		Verifiers []string `json:"verifiers"` // Base64 PEM certificates or public keys
+	} `json:"proposedContent"`
+	Signatures []struct {
+		Verifier string `json:"verifier"` // Base64 PEM certificate or public key
+	} `json:"signatures"`
+}
+
+func decodeDSSEV001(raw json.RawMessage) (*rekorSpec, error) {
+	var s dsseV001
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, err
+	}
+	spec := &rekorSpec{EnvelopePayload: envelopeStringPayload(s.ProposedContent.Envelope)}
+	switch {
+	case len(s.Signatures) > 0:
+		spec.PublicKeyContent = s.Signatures[0].Verifier
+	case len(s.ProposedContent.Verifiers) > 0:
+		spec.PublicKeyContent = s.ProposedContent.Verifiers[0]
+	}
+	return spec, nil
+}
+
+// decodeOpaqueSpec checks the spec of a kind without kind specific parsing is an object
+func decodeOpaqueSpec(raw json.RawMessage) (*rekorSpec, error) {
+	var s map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, err
+	}
+	return &rekorSpec{}, nil
+}
+
+// envelopeStringPayload returns the payload of a DSSE envelope given as a JSON string, or empty
+// if it is malformed
+func envelopeStringPayload(envelope string) string {
+	if envelope == "" {
+		return ""
+	}
+	var e dsseEnvelope
+	if json.Unmarshal([]byte(envelope), &e) != nil {
+		return ""
+	}
+	return e.Payload
+}
+
+// annotationValues returns the string values of the annotations objects in the spec (as cosign
+// records for images), in key order
+func (s *rekorSpec) annotationValues() []string {
+	// Most specs have none, which spares decoding the spec once more
+	if !bytes.Contains(s.raw, []byte(`"annotations"`)) {
+		return nil
+	}
+	var v interface{}
+	if json.Unmarshal(s.raw, &v) != nil {
+		return nil
+	}
+	return collectAnnotationValues(v, ociAnnotationsDepth, nil)
+}
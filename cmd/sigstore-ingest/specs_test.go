@@ -0,0 +1,361 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Entry bodies as Rekor returns them (base64 decoded), shortened where the content is opaque
+const (
+	hashedRekordBody = `{"apiVersion":"0.0.1","kind":"hashedrekord","spec":{"data":{"hash":{"algorithm":"sha256","value":"b1a5d8c5b3e4f0b1d3a1c2e9f8a7b6c5d4e3f2a1b0c9d8e7f6a5b4c3d2e1f0a9"}},"signature":{"content":"MEUCIQDx3jL0nQyGyLx0Q2xwIvPqJgN4GqQhMZrYKw3ZQ8bHxwIgW8S5JQ1lFkqN1yq8i3tHn8t1kqkP6Xgk0u8nY2oH9Cg=","publicKey":{"content":"LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0tCk1JSUM=="}}}}`
+	rekordBody       = `{"apiVersion":"0.0.1","kind":"rekord","spec":{"data":{"hash":{"algorithm":"sha256","value":"4c1c8f7d6a5e3b2a1f0e9d8c7b6a5f4e3d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a"},"url":"https://example.com/artifact.tar.gz"},"signature":{"content":"iQEzBAABCAAdFiEE","format":"pgp","publicKey":{"content":"LS0tLS1CRUdJTiBQR1AgUFVCTElDIEtFWSBCTE9DSy0tLS0t"}}}}`
+	inTotoV001Body   = `{"apiVersion":"0.0.1","kind":"intoto","spec":{"content":{"envelope":"{\"payloadType\":\"application/vnd.in-toto+json\",\"payload\":\"eyJfdHlwZSI6Imh0dHBzOi8vaW4tdG90by5pby9TdGF0ZW1lbnQvdjAuMSJ9\",\"signatures\":[{\"sig\":\"MEQCIF\"}]}","hash":{"algorithm":"sha256","value":"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"}},"publicKey":"LS0tLS1CRUdJTiBQVUJMSUMgS0VZLS0tLS0="}}`
+	inTotoV002Body   = `{"apiVersion":"0.0.2","kind":"intoto","spec":{"content":{"envelope":{"payloadType":"application/vnd.in-toto+json","payload":"eyJfdHlwZSI6Imh0dHBzOi8vaW4tdG90by5pby9TdGF0ZW1lbnQvdjEifQ==","signatures":[{"publicKey":"LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0t","sig":"TUVRQ0lG"}]},"hash":{"algorithm":"sha256","value":"2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"},"payloadHash":{"algorithm":"sha256","value":"fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9"}}}}`
+	dsseBody         = `{"apiVersion":"0.0.1","kind":"dsse","spec":{"proposedContent":{"envelope":"{\"payloadType\":\"application/vnd.in-toto+json\",\"payload\":\"eyJfdHlwZSI6Imh0dHBzOi8vaW4tdG90by5pby9TdGF0ZW1lbnQvdjEifQ==\",\"signatures\":[{\"sig\":\"MEUCIQ\"}]}","verifiers":["LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0t"]}}}`
+	dsseStoredBody   = `{"apiVersion":"0.0.1","kind":"dsse","spec":{"envelopeHash":{"algorithm":"sha256","value":"b5bb9d8014a0f9b1d61e21e796d78dccdf1352f23cd32812f4850b878ae4944c"},"payloadHash":{"algorithm":"sha256","value":"7d865e959b2466918c9863afca942d0fb89d7c9ac0c99bafc3749504ded97730"},"signatures":[{"signature":"MEUCIQ","verifier":"LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0t"}]}}`
+	rpmBody          = `{"apiVersion":"0.0.1","kind":"rpm","spec":{"package":{"hash":{"algorithm":"sha256","value":"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}},"publicKey":{"content":"LS0tLS1CRUdJTiBQR1AgUFVCTElDIEtFWSBCTE9DSy0tLS0t"}}}`
+)
+
+func TestDecodeRekorSpec(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want rekorSpec
+	}{
+		{
+			name: "hashedrekord 0.0.1",
+			body: hashedRekordBody,
+			want: rekorSpec{
+				SignatureContent:  "MEUCIQDx3jL0nQyGyLx0Q2xwIvPqJgN4GqQhMZrYKw3ZQ8bHxwIgW8S5JQ1lFkqN1yq8i3tHn8t1kqkP6Xgk0u8nY2oH9Cg=",
+				PublicKeyContent:  "LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0tCk1JSUM==",
+				DataHashAlgorithm: "sha256",
+				DataHashValue:     "b1a5d8c5b3e4f0b1d3a1c2e9f8a7b6c5d4e3f2a1b0c9d8e7f6a5b4c3d2e1f0a9",
+			},
+		},
+		{
+			name: "rekord 0.0.1",
+			body: rekordBody,
+			want: rekorSpec{
+				SignatureFormat:   "pgp",
+				SignatureContent:  "iQEzBAABCAAdFiEE",
+				PublicKeyContent:  "LS0tLS1CRUdJTiBQR1AgUFVCTElDIEtFWSBCTE9DSy0tLS0t",
+				DataHashAlgorithm: "sha256",
+				DataHashValue:     "4c1c8f7d6a5e3b2a1f0e9d8c7b6a5f4e3d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a",
+				DataURL:           "https://example.com/artifact.tar.gz",
+			},
+		},
+		{
+			name: "intoto 0.0.1",
+			body: inTotoV001Body,
+			want: rekorSpec{
+				PublicKeyContent: "LS0tLS1CRUdJTiBQVUJMSUMgS0VZLS0tLS0=",
+				EnvelopePayload:  "eyJfdHlwZSI6Imh0dHBzOi8vaW4tdG90by5pby9TdGF0ZW1lbnQvdjAuMSJ9",
+			},
+		},
+		{
+			name: "intoto 0.0.2",
+			body: inTotoV002Body,
+			want: rekorSpec{
+				PublicKeyContent: "LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0t",
+				EnvelopePayload:  "eyJfdHlwZSI6Imh0dHBzOi8vaW4tdG90by5pby9TdGF0ZW1lbnQvdjEifQ==",
+			},
+		},
+		{
+			name: "dsse 0.0.1 as submitted",
+			body: dsseBody,
+			want: rekorSpec{
+				PublicKeyContent: "LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0t",
+				EnvelopePayload:  "eyJfdHlwZSI6Imh0dHBzOi8vaW4tdG90by5pby9TdGF0ZW1lbnQvdjEifQ==",
+			},
+		},
+		{
+			name: "dsse 0.0.1 as stored",
+			body: dsseStoredBody,
+			want: rekorSpec{PublicKeyContent: "LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0t"},
+		},
+		{
+			name: "intoto 0.0.1 with malformed envelope",
+			body: `{"apiVersion":"0.0.1","kind":"intoto","spec":{"content":{"envelope":"not json"},"publicKey":"a2V5"}}`,
+			want: rekorSpec{PublicKeyContent: "a2V5"},
+		},
+		{
+			name: "rpm 0.0.1 without kind specific parsing",
+			body: rpmBody,
+			want: rekorSpec{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body RekorEntryBody
+			if err := json.Unmarshal([]byte(tt.body), &body); err != nil {
+				t.Fatalf("Failed to decode body: %v", err)
+			}
+			spec, err := decodeRekorSpec(body.Kind, body.APIVersion, body.Spec)
+			if err != nil {
+				t.Fatalf("decodeRekorSpec() error = %v", err)
+			}
+			if spec == nil {
+				t.Fatal("decodeRekorSpec() = nil")
+			}
+			got := *spec
+			got.raw = nil
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeRekorSpec() = %+v, want %+v", got, tt.want)
+			}
+			if string(spec.raw) != string(body.Spec) {
+				t.Errorf("decodeRekorSpec() raw = %s, want %s", spec.raw, body.Spec)
+			}
+		})
+	}
+}
+
+func TestDecodeRekorSpecErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		kind       string
+		apiVersion string
+		spec       string
+		wantErr    error
+		wantReason string
+	}{
+		{
+			name:       "unknown hashedrekord version",
+			kind:       "hashedrekord",
+			apiVersion: "0.0.2",
+			spec:       `{"data":{"hash":{"algorithm":"sha256","value":"00"}}}`,
+			wantErr:    errUnknownSpecVersion,
+			wantReason: "unknown_version",
+		},
+		{
+			name:       "unknown intoto version",
+			kind:       "intoto",
+			apiVersion: "0.0.3",
+			spec:       `{"content":{}}`,
+			wantErr:    errUnknownSpecVersion,
+			wantReason: "unknown_version",
+		},
+		{
+			name:       "unknown kind",
+			kind:       "sbom",
+			apiVersion: "0.0.1",
+			spec:       `{}`,
+			wantErr:    errUnknownSpecKind,
+			wantReason: "unknown_kind",
+		},
+		{
+			name:       "hashedrekord spec of the wrong shape",
+			kind:       "hashedrekord",
+			apiVersion: "0.0.1",
+			spec:       `{"signature":{"content":42}}`,
+			wantReason: "invalid",
+		},
+		{
+			name:       "intoto 0.0.2 envelope given as string",
+			kind:       "intoto",
+			apiVersion: "0.0.2",
+			spec:       `{"content":{"envelope":"{}"}}`,
+			wantReason: "invalid",
+		},
+		{
+			name:       "opaque spec not an object",
+			kind:       "tuf",
+			apiVersion: "0.0.1",
+			spec:       `["metadata"]`,
+			wantReason: "invalid",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := decodeRekorSpec(tt.kind, tt.apiVersion, json.RawMessage(tt.spec))
+			if err == nil {
+				t.Fatalf("decodeRekorSpec() = %+v, want error", spec)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("decodeRekorSpec() error = %v, want %v", err, tt.wantErr)
+			}
+			if reason := specErrorReason(err); reason != tt.wantReason {
+				t.Errorf("specErrorReason() = %q, want %q", reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestDecodeRekorSpecWithoutSpec(t *testing.T) {
+	for _, raw := range []string{"", "null"} {
+		spec, err := decodeRekorSpec("hashedrekord", "0.0.1", json.RawMessage(raw))
+		if spec != nil || err != nil {
+			t.Errorf("decodeRekorSpec(%q) = %+v, %v, want nil, nil", raw, spec, err)
+		}
+	}
+}
+
+// testFulcioCertificate returns a base64 PEM certificate like those Fulcio issues for keyless
+// signing (ECDSA P-256, email SAN, issuer extension) and the SHA-256 of its SPKI
+func testFulcioCertificate(t *testing.T, email, issuer string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuerValue, err := asn1.MarshalWithParams(issuer, "utf8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		Issuer:          pkix.Name{CommonName: "sigstore-intermediate", Organization: []string{"sigstore.dev"}},
+		NotBefore:       time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC),
+		NotAfter:        time.Date(2025, 6, 1, 12, 10, 0, 0, time.UTC),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		EmailAddresses:  []string{email},
+		ExtraExtensions: []pkix.Extension{{Id: oidFulcioIssuerV2, Value: issuerValue}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return base64.StdEncoding.EncodeToString(certPEM), spkiFingerprint(cert)
+}
+
+// testRSAPublicKey returns a base64 PEM RSA public key of bits and the SHA-256 of its SPKI
+func testRSAPublicKey(t *testing.T, bits int) (string, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256(der)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	return base64.StdEncoding.EncodeToString(keyPEM), hex.EncodeToString(hash[:])
+}
+
+func TestParseRekorEntrySigningKey(t *testing.T) {
+	const (
+		treeID = "1193050959916656506"
+		email  = "maintainer@example.com"
+		issuer = "https://github.com/login/oauth"
+	)
+	cert, certFingerprint := testFulcioCertificate(t, email, issuer)
+	rsaKey, rsaFingerprint := testRSAPublicKey(t, 2048)
+	envelope := func(key string) string {
+		e, _ := json.Marshal(map[string]interface{}{
+			"payloadType": "application/vnd.in-toto+json",
+			"payload":     "eyJfdHlwZSI6Imh0dHBzOi8vaW4tdG90by5pby9TdGF0ZW1lbnQvdjEifQ==",
+			"signatures":  []map[string]string{{"sig": "MEUCIQ", "publicKey": key}},
+		})
+		return string(e)
+	}
+
+	type signer struct {
+		keyType, fingerprint, algorithm, curve string
+		size                                   int
+		identity, oidcIssuer                   string
+	}
+	fulcio := signer{publicKeyTypeX509, certFingerprint, keyAlgorithmECDSA, "P-256", 256, email, issuer}
+	tests := []struct {
+		name string
+		body string
+		want signer
+	}{
+		{
+			name: "hashedrekord 0.0.1 certificate",
+			body: fmt.Sprintf(`{"apiVersion":"0.0.1","kind":"hashedrekord","spec":{"data":{"hash":{"algorithm":"sha256","value":"00"}},"signature":{"content":"MEUCIQ","publicKey":{"content":%q}}}}`, cert),
+			want: fulcio,
+		},
+		{
+			name: "rekord 0.0.1 x509 certificate",
+			body: fmt.Sprintf(`{"apiVersion":"0.0.1","kind":"rekord","spec":{"data":{"hash":{"algorithm":"sha256","value":"00"}},"signature":{"content":"MEUCIQ","format":"x509","publicKey":{"content":%q}}}}`, cert),
+			want: fulcio,
+		},
+		{
+			name: "rekord 0.0.1 x509 public key",
+			body: fmt.Sprintf(`{"apiVersion":"0.0.1","kind":"rekord","spec":{"data":{"hash":{"algorithm":"sha256","value":"00"}},"signature":{"content":"MEUCIQ","format":"x509","publicKey":{"content":%q}}}}`, rsaKey),
+			want: signer{keyType: publicKeyTypeX509, fingerprint: rsaFingerprint, algorithm: keyAlgorithmRSA, size: 2048},
+		},
+		{
+			name: "intoto 0.0.1 certificate",
+			body: fmt.Sprintf(`{"apiVersion":"0.0.1","kind":"intoto","spec":{"content":{"envelope":%q},"publicKey":%q}}`, envelope(""), cert),
+			want: fulcio,
+		},
+		{
+			name: "intoto 0.0.2 envelope signature certificate",
+			body: fmt.Sprintf(`{"apiVersion":"0.0.2","kind":"intoto","spec":{"content":{"envelope":%s}}}`, envelope(cert)),
+			want: fulcio,
+		},
+		{
+			name: "dsse 0.0.1 stored verifier certificate",
+			body: fmt.Sprintf(`{"apiVersion":"0.0.1","kind":"dsse","spec":{"payloadHash":{"algorithm":"sha256","value":"00"},"signatures":[{"signature":"MEUCIQ","verifier":%q}]}}`, cert),
+			want: fulcio,
+		},
+		{
+			name: "dsse 0.0.1 proposed verifier certificate",
+			body: fmt.Sprintf(`{"apiVersion":"0.0.1","kind":"dsse","spec":{"proposedContent":{"envelope":%q,"verifiers":[%q]}}}`, envelope(""), cert),
+			want: fulcio,
+		},
+		{
+			name: "dsse 0.0.1 verifier public key",
+			body: fmt.Sprintf(`{"apiVersion":"0.0.1","kind":"dsse","spec":{"signatures":[{"signature":"MEUCIQ","verifier":%q}]}}`, rsaKey),
+			want: signer{keyType: publicKeyTypeX509, fingerprint: rsaFingerprint, algorithm: keyAlgorithmRSA, size: 2048},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := RekorLogEntry{
+				Body:           base64.StdEncoding.EncodeToString([]byte(tt.body)),
+				IntegratedTime: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC).Unix(),
+				Verification: &VerificationInfo{InclusionProof: &InclusionProof{
+					LogIndex:   42,
+					TreeSize:   43,
+					Checkpoint: "rekor.sigstore.dev - " + treeID + "\n43\nAAAA\n",
+				}},
+			}
+			details, err := parseRekorEntry("24296fb24b8ad77a", entry, treeID, nil)
+			if err != nil {
+				t.Fatalf("parseRekorEntry() error = %v", err)
+			}
+			defer releaseRekorDetails(details)
+
+			got := signer{
+				keyType:     details.PublicKeyType,
+				fingerprint: details.PublicKeyFingerprint,
+				algorithm:   details.PublicKeyAlgorithm,
+				curve:       details.PublicKeyCurve,
+				size:        details.PublicKeySize,
+				identity:    details.SignerIdentity,
+				oidcIssuer:  details.OIDCIssuer,
+			}
+			if got != tt.want {
+				t.Errorf("signer = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
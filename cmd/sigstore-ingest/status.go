@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// statusScanEntries bounds the index range scanned to measure the recent ingestion rate
+const statusScanEntries = 10_000_000
+
+// LogStatus is the ingestion progress of one log, as reported by the status subcommand
+type LogStatus struct {
+	Log              string     `json:"log"`
+	TreeSize         int64      `json:"tree_size"`
+	NextIndex        int64      `json:"next_index"`
+	Lag              int64      `json:"lag"`
+	LastInsert       *time.Time `json:"last_insert,omitempty"`
+	EntriesPerSecond float64    `json:"entries_per_second"`
+	ETASeconds       *int64     `json:"eta_seconds,omitempty"` // Unset when caught up or not progressing
+	Error            string     `json:"error,omitempty"`
+}
+
+// runStatus implements the status subcommand: it compares the latest stored index of the active
+// Rekor tree and each inactive shard against the current checkpoint and prints the lag and
+// estimated catch-up time
+func runStatus(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	jsonFlag := fs.Bool("json", false, "Print JSON instead of a table")
+	windowFlag := fs.Duration("window", time.Hour, "Window over which the ingestion rate is measured")
+	fs.Parse(args)
+
+	if *windowFlag <= 0 {
+		log.Fatal("Error: -window must be positive")
+	}
+
+	db, err := initClickHouse()
+	if err != nil {
+		log.Fatalf("Failed to initialize ClickHouse connection: %v", err)
+	}
+	defer db.Close()
+
+	logInfo, err := fetchLogInfo(&http.Client{Timeout: requestTimeout})
+	if err != nil {
+		log.Fatalf("Failed to fetch log info: %v", err)
+	}
+
+	trees := []InactiveShardInfo{{TreeID: logInfo.TreeID, TreeSize: logInfo.TreeSize}}
+	trees = append(trees, logInfo.InactiveShards...)
+
+	var statuses []LogStatus
+	failed := false
+	for _, tree := range trees {
+		status := LogStatus{Log: "tree " + tree.TreeID, TreeSize: tree.TreeSize}
+		status.NextIndex, err = getLatestLogIndex(db, tree.TreeID)
+		if err == nil {
+			status.Lag = max(status.TreeSize-status.NextIndex, 0)
+			err = recentIngestion(db, "rekor_log_entries", "tree_id", tree.TreeID, status.NextIndex, *windowFlag, &status)
+		}
+		if err != nil {
+			status.Error = err.Error()
+			failed = true
+		}
+		statuses = append(statuses, status)
+	}
+
+	printStatus(statuses, *jsonFlag)
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// recentIngestion fills in the last insert time, the ingestion rate over window and the ETA.
+// Only the most recent statusScanEntries indexes are scanned.
+func recentIngestion(db *sql.DB, table, logColumn, logID string, nextIndex int64, window time.Duration, status *LogStatus) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		SELECT max(retrieval_timestamp), countIf(retrieval_timestamp >= now() - toIntervalSecond(?))
+		FROM %s
+		WHERE %s = ? AND log_index >= ?`, table, logColumn)
+
+	var lastInsert time.Time
+	var recent uint64
+	err := db.QueryRowContext(ctx, query, int64(window.Seconds()), logID, max(nextIndex-statusScanEntries, 0)).Scan(&lastInsert, &recent)
+	if err != nil {
+		return fmt.Errorf("failed to measure ingestion rate: %w", err)
+	}
+
+	if !lastInsert.IsZero() && lastInsert.Unix() > 0 {
+		lastInsert = lastInsert.UTC()
+		status.LastInsert = &lastInsert
+	}
+	status.EntriesPerSecond = float64(recent) / window.Seconds()
+	if status.Lag > 0 && status.EntriesPerSecond > 0 {
+		eta := int64(float64(status.Lag) / status.EntriesPerSecond)
+		status.ETASeconds = &eta
+	}
+	return nil
+}
+
+func printStatus(statuses []LogStatus, asJSON bool) {
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(statuses)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LOG\tTREE SIZE\tNEXT INDEX\tLAG\tLAST INSERT\tRATE (/s)\tETA")
+	for _, s := range statuses {
+		if s.Error != "" {
+			fmt.Fprintf(w, "%s\terror: %s\n", s.Log, s.Error)
+			continue
+		}
+		lastInsert := "never"
+		if s.LastInsert != nil {
+			lastInsert = fmt.Sprintf("%s ago", time.Since(*s.LastInsert).Round(time.Second))
+		}
+		eta := "-"
+		switch {
+		case s.Lag == 0:
+			eta = "caught up"
+		case s.ETASeconds != nil:
+			eta = (time.Duration(*s.ETASeconds) * time.Second).String()
+		case s.Lag > 0:
+			eta = "stalled"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\t%.1f\t%s\n", s.Log, s.TreeSize, s.NextIndex, s.Lag, lastInsert, s.EntriesPerSecond, eta)
+	}
+	w.Flush()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Values of timestamp_anomaly
+const (
+	timestampFuture = "future"  // Later than the retrieval time by more than the allowed clock skew
+	timestampTooOld = "too_old" // Earlier than the first plausible timestamp
+)
+
+// defaultMinTimestamp precedes the public Rekor instance, launched in 2021
+const defaultMinTimestamp = "2020-01-01"
+
+var metricTimestampAnomalies = newCounter("sigstore_ingest_timestamp_anomalies_total", "Entries with an implausible integrated time, by kind (future, too_old)")
+
+// TimestampCheck flags entries whose integrated time is later than their retrieval time by more
+// than maxSkew, or earlier than minTimestamp, in timestamp_anomaly
+type TimestampCheck struct {
+	maxSkew      time.Duration
+	minTimestamp time.Time
+}
+
+// NewTimestampCheck creates a check for -max_clock_skew and -min_timestamp (YYYY-MM-DD, UTC)
+func NewTimestampCheck(maxSkew time.Duration, minTimestamp string) (*TimestampCheck, error) {
+	if maxSkew < 0 {
+		return nil, fmt.Errorf("clock skew must not be negative")
+	}
+	earliest, err := time.Parse(time.DateOnly, minTimestamp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid minimum timestamp %q (expected YYYY-MM-DD): %w", minTimestamp, err)
+	}
+	return &TimestampCheck{maxSkew: maxSkew, minTimestamp: earliest}, nil
+}
+
+// Apply sets TimestampAnomaly of an entry and counts it in the metric
+func (c *TimestampCheck) Apply(details *RekorLogEntryDetails) {
+	switch {
+	case details.IntegratedTime.After(details.RetrievalTimestamp.Add(c.maxSkew)):
+		details.TimestampAnomaly = timestampFuture
+	case details.IntegratedTime.Before(c.minTimestamp):
+		details.TimestampAnomaly = timestampTooOld
+	default:
+		return
+	}
+	metricTimestampAnomalies.Add(1, "tree", details.TreeID, "kind", details.TimestampAnomaly)
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const usageFlushInterval = time.Minute // Interval between writes of the usage counts to ingest_usage
+
+// usage is set before the first HTTP client is created, so every proxy's client counts its requests
+var usage *UsageTracker
+
+var (
+	metricUsageRequests      = newCounter("sigstore_ingest_usage_requests_total", "Requests to the log, retries included, by proxy (direct without)")
+	metricUsageBytes         = newCounter("sigstore_ingest_usage_bytes_total", "Response body bytes received from the log on the wire (before decompression), by proxy")
+	metricUsageDayRequests   = newGauge("sigstore_ingest_usage_day_requests", "Requests to the log in the current UTC day, by proxy, earlier runs recorded in ingest_usage included")
+	metricUsageDayBytes      = newGauge("sigstore_ingest_usage_day_bytes", "Response body bytes received from the log in the current UTC day, by proxy, earlier runs recorded in ingest_usage included")
+	metricUsageQuotaExceeded = newGauge("sigstore_ingest_usage_quota_exceeded", "1 once the response bytes of the current UTC day reached -daily_byte_quota")
+)
+
+// Actions of -daily_byte_quota_action
+const (
+	quotaActionAlert = "alert"
+	quotaActionStop  = "stop"
+)
+
+// usageCounts are the requests and response bytes of one day through one proxy
+type usageCounts struct {
+	requests uint64
+	bytes    uint64
+}
+
+// usageKey selects the counts of one day and proxy
+type usageKey struct {
+	day   string // UTC, YYYY-MM-DD
+	proxy string
+}
+
+// UsageTracker counts the requests to the log and the response bytes received from it, per proxy
+// and UTC day, so operators paying for proxy bandwidth can budget it. The counts are exported as
+// metrics and added to ingest_usage every usageFlushInterval; the totals of the day start from
+// those of earlier runs there. Once the bytes of the day reach the quota an alert is logged and
+// posted to the webhook, and with the stop action ingestion is stopped. A nil UsageTracker
+// records nothing.
+type UsageTracker struct {
+	db         *sql.DB
+	binary     string
+	logURL     string
+	quota      uint64 // Response bytes per UTC day, 0 is unlimited
+	stop       bool   // Stop ingestion at the quota rather than only alerting
+	client     *http.Client
+	webhookURL string
+
+	mu       sync.Mutex
+	day      string
+	today    map[string]*usageCounts   // Totals of the day per proxy, earlier runs included
+	pending  map[usageKey]*usageCounts // Not yet written to ingest_usage
+	exceeded bool
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// NewUsageTracker creates a tracker for the requests of binary to the log at logURL, loading the
+// totals of the day from ingest_usage if db is set
+func NewUsageTracker(db *sql.DB, binary, logURL string, quota uint64, action, webhookURL string) *UsageTracker {
+	if !strings.HasSuffix(logURL, "/") {
+		logURL += "/"
+	}
+	t := &UsageTracker{
+		db:         db,
+		binary:     binary,
+		logURL:     logURL,
+		quota:      quota,
+		stop:       action == quotaActionStop,
+		client:     &http.Client{Timeout: requestTimeout},
+		webhookURL: webhookURL,
+		day:        time.Now().UTC().Format(time.DateOnly),
+		today:      make(map[string]*usageCounts),
+		pending:    make(map[usageKey]*usageCounts),
+		stopped:    make(chan struct{}),
+	}
+	if db != nil {
+		if err := t.load(); err != nil {
+			log.Printf("Warning: Failed to load the usage of the day from ingest_usage, counting from zero: %v", err)
+		}
+	}
+	var bytes uint64
+	for proxy, counts := range t.today {
+		metricUsageDayRequests.Set(float64(counts.requests), "log_url", t.logURL, "proxy", proxy)
+		metricUsageDayBytes.Set(float64(counts.bytes), "log_url", t.logURL, "proxy", proxy)
+		bytes += counts.bytes
+	}
+	if t.quota > 0 && bytes >= t.quota {
+		t.exceeded = true
+		go t.quotaExceeded(bytes)
+	}
+	return t
+}
+
+// load reads the totals of the day of the log from ingest_usage
+func (t *UsageTracker) load() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	rows, err := t.db.QueryContext(ctx, "SELECT proxy, sum(requests), sum(bytes) FROM ingest_usage WHERE log_url = ? AND day = ? GROUP BY proxy", t.logURL, t.day)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var proxy string
+		counts := &usageCounts{}
+		if err := rows.Scan(&proxy, &counts.requests, &counts.bytes); err != nil {
+			return err
+		}
+		t.today[proxy] = counts
+	}
+	return rows.Err()
+}
+
+// Transport wraps base so requests to the log through proxy (getClientKey) are counted
+func (t *UsageTracker) Transport(base http.RoundTripper, proxy string) http.RoundTripper {
+	if t == nil {
+		return base
+	}
+	return &usageTransport{base: base, usage: t, proxy: proxy}
+}
+
+// Start writes the counts to ingest_usage every usageFlushInterval until done is closed, and a
+// last time then
+func (t *UsageTracker) Start(done <-chan struct{}) {
+	if t == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(usageFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.record("", 0, 0)
+				t.flush()
+			case <-done:
+				t.flush()
+				return
+			}
+		}
+	}()
+}
+
+// DayBytes returns the response bytes of the current UTC day over all proxies
+func (t *UsageTracker) DayBytes() uint64 {
+	if t == nil {
+		return 0
+	}
+	t.record("", 0, 0)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var bytes uint64
+	for _, counts := range t.today {
+		bytes += counts.bytes
+	}
+	return bytes
+}
+
+// Stopped is closed once the quota is reached with the stop action
+func (t *UsageTracker) Stopped() <-chan struct{} {
+	if t == nil {
+		return nil
+	}
+	return t.stopped
+}
+
+// record adds requests and bytes to the counts of the day of proxy, moving to the next day first
+// at UTC midnight. An empty proxy only checks for the next day.
+func (t *UsageTracker) record(proxy string, requests, bytes uint64) {
+	day := time.Now().UTC().Format(time.DateOnly)
+
+	t.mu.Lock()
+	if day != t.day {
+		for proxy, counts := range t.today {
+			log.Printf("Usage of %s through %s on %s: %d requests, %d response bytes", t.logURL, proxy, t.day, counts.requests, counts.bytes)
+			metricUsageDayRequests.Set(0, "log_url", t.logURL, "proxy", proxy)
+			metricUsageDayBytes.Set(0, "log_url", t.logURL, "proxy", proxy)
+		}
+		if t.exceeded {
+			log.Printf("Daily byte quota of %s reset for %s", t.logURL, day)
+			metricUsageQuotaExceeded.Set(0, "log_url", t.logURL)
+		}
+		t.day, t.today, t.exceeded = day, make(map[string]*usageCounts), false
+	}
+	if proxy == "" {
+		t.mu.Unlock()
+		return
+	}
+
+	counts := t.today[proxy]
+	if counts == nil {
+		counts = &usageCounts{}
+		t.today[proxy] = counts
+	}
+	counts.requests += requests
+	counts.bytes += bytes
+	key := usageKey{day: day, proxy: proxy}
+	if t.pending[key] == nil {
+		t.pending[key] = &usageCounts{}
+	}
+	t.pending[key].requests += requests
+	t.pending[key].bytes += bytes
+	metricUsageDayRequests.Set(float64(counts.requests), "log_url", t.logURL, "proxy", proxy)
+	metricUsageDayBytes.Set(float64(counts.bytes), "log_url", t.logURL, "proxy", proxy)
+
+	var total uint64
+	exceeded := false
+	if t.quota > 0 && !t.exceeded {
+		for _, c := range t.today {
+			total += c.bytes
+		}
+		exceeded = total >= t.quota
+		t.exceeded = exceeded
+	}
+	t.mu.Unlock()
+
+	metricUsageRequests.Add(float64(requests), "log_url", t.logURL, "proxy", proxy)
+	metricUsageBytes.Add(float64(bytes), "log_url", t.logURL, "proxy", proxy)
+	if exceeded {
+		go t.quotaExceeded(total)
+	}
+}
+
+// quotaExceeded alerts that the bytes of the day reached the quota and stops ingestion with the
+// stop action
+func (t *UsageTracker) quotaExceeded(bytes uint64) {
+	metricUsageQuotaExceeded.Set(1, "log_url", t.logURL)
+	text := fmt.Sprintf("%s received %d response bytes from %s today, reaching -daily_byte_quota (%d)", t.binary, bytes, t.logURL, t.quota)
+	if t.stop {
+		text += "; stopping ingestion"
+	}
+	log.Printf("ALERT firing: %s", text)
+	if t.webhookURL != "" {
+		alert := Alert{Alert: "usage_quota", Status: "firing", Log: t.logURL, Text: text, Threshold: fmt.Sprintf("%d bytes", t.quota), Timestamp: time.Now().UTC()}
+		if err := postWebhook(t.client, t.webhookURL, alert); err != nil {
+			log.Printf("Warning: Failed to send alert to webhook: %v", err)
+		}
+	}
+	if t.stop {
+		t.stopOnce.Do(func() { close(t.stopped) })
+	}
+}
+
+// flush adds the counts since the last flush to ingest_usage
+func (t *UsageTracker) flush() {
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = make(map[usageKey]*usageCounts)
+	t.mu.Unlock()
+	if t.db == nil || len(pending) == 0 {
+		return
+	}
+
+	query := "INSERT INTO ingest_usage (binary, log_url, proxy, day, requests, bytes) VALUES"
+	var args []interface{}
+	for key, counts := range pending {
+		if len(args) > 0 {
+			query += ","
+		}
+		query += " (?, ?, ?, ?, ?, ?)"
+		args = append(args, t.binary, t.logURL, key.proxy, key.day, counts.requests, counts.bytes)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := t.db.ExecContext(ctx, query, args...); err != nil {
+		log.Printf("Warning: Failed to record usage in ingest_usage: %v", err)
+	}
+}
+
+// usageTransport counts the requests to the log of a UsageTracker and the bytes of their
+// responses as they are read
+type usageTransport struct {
+	base  http.RoundTripper
+	usage *UsageTracker
+	proxy string
+}
+
+func (t *usageTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !strings.HasPrefix(req.URL.String(), t.usage.logURL) {
+		return t.base.RoundTrip(req)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	t.usage.record(t.proxy, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &usageBody{r: resp.Body, usage: t.usage, proxy: t.proxy}
+	return resp, nil
+}
+
+// usageBody counts the bytes read from a response body
+type usageBody struct {
+	r     io.ReadCloser
+	usage *UsageTracker
+	proxy string
+}
+
+func (b *usageBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if n > 0 {
+		b.usage.record(b.proxy, 0, uint64(n))
+	}
+	return n, err
+}
+
+func (b *usageBody) Close() error {
+	return b.r.Close()
+}
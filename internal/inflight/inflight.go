@@ -0,0 +1,199 @@
+// Package inflight tracks log index ranges fetched by an ingester but not inserted yet, so a
+// restart re-fetches exactly those ranges.
+package inflight
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const checkpointInterval = 5 * time.Second // Interval between writes of the file
+
+// Range is an inclusive range of log indexes
+type Range struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// pendingRange is a fetched range with entries neither inserted nor spooled yet
+type pendingRange struct {
+	Range
+	pending int64
+}
+
+// State is the content of the file (-inflight_file of the ingesters)
+type State struct {
+	LogID     string    `json:"log_id"`     // Log the indexes belong to, e.g. a CT log URL or Rekor base URL
+	NextIndex int64     `json:"next_index"` // Index after the last fetched entry
+	InFlight  []Range   `json:"in_flight"`  // Fetched ranges not fully inserted, in index order
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Tracker keeps the ranges fetched but not yet inserted in a file, so a restart re-fetches exactly
+// those ranges and then continues after the last fetched index, instead of resuming from the
+// highest stored index, which skips ranges whose batches failed while later ones were inserted.
+// Spooled batches count as inserted, as the spool replays them itself. A nil Tracker tracks
+// nothing.
+type Tracker struct {
+	path  string
+	logID string
+
+	mu        sync.Mutex
+	ranges    []*pendingRange // In index order
+	nextIndex int64
+	dirty     bool
+}
+
+// New creates a tracker writing to path and returns the state left there by the previous run of
+// the same log, or nil if there is none
+func New(path, logID string) (*Tracker, *State, error) {
+	t := &Tracker{path: path, logID: logID}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	if state.LogID != logID {
+		return nil, nil, fmt.Errorf("%s tracks %s, not %s", path, state.LogID, logID)
+	}
+	return t, &state, nil
+}
+
+// Fetched registers a fetched range whose entries are all pending until counted as inserted or
+// skipped
+func (t *Tracker) Fetched(start, end int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r := &pendingRange{Range: Range{Start: start, End: end}, pending: end - start + 1}
+	i := sort.Search(len(t.ranges), func(i int) bool { return t.ranges[i].Start > start })
+	t.ranges = append(t.ranges, nil)
+	copy(t.ranges[i+1:], t.ranges[i:])
+	t.ranges[i] = r
+	t.nextIndex = max(t.nextIndex, end+1)
+	t.dirty = true
+}
+
+// Skipped counts entries from start that will not be inserted (filtered out, quarantined or
+// failing to encode)
+func (t *Tracker) Skipped(start, entries int64) {
+	if t == nil || entries == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.done(start, entries)
+}
+
+// RecordInsert counts the entries of an inserted or spooled batch, index returning the log index
+// of an entry. It is a function rather than a method as methods cannot have type parameters.
+func RecordInsert[E any](t *Tracker, batch []E, index func(E) int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, entry := range batch {
+		t.done(index(entry), 1)
+	}
+}
+
+// done counts entries of the range holding index and drops the range once none is pending
+func (t *Tracker) done(index, entries int64) {
+	i := sort.Search(len(t.ranges), func(i int) bool { return t.ranges[i].End >= index })
+	if i == len(t.ranges) || t.ranges[i].Start > index {
+		return
+	}
+	if t.ranges[i].pending -= entries; t.ranges[i].pending <= 0 {
+		t.ranges = append(t.ranges[:i], t.ranges[i+1:]...)
+	}
+	t.dirty = true
+}
+
+// Start writes the file every checkpointInterval while it changes, until done is closed
+func (t *Tracker) Start(done <-chan struct{}) {
+	if t == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(checkpointInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.Checkpoint()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// Checkpoint writes the file if the ranges changed since the last write
+func (t *Tracker) Checkpoint() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if !t.dirty {
+		t.mu.Unlock()
+		return
+	}
+	state := State{LogID: t.logID, NextIndex: t.nextIndex, InFlight: []Range{}, UpdatedAt: time.Now().UTC()}
+	for _, r := range t.ranges {
+		state.InFlight = append(state.InFlight, r.Range)
+	}
+	t.dirty = false
+	t.mu.Unlock()
+
+	if err := writeFileAtomic(t.path, state); err != nil {
+		log.Printf("Warning: Failed to write %s: %v", t.path, err)
+		t.mu.Lock()
+		t.dirty = true
+		t.mu.Unlock()
+	}
+}
+
+// SetNextIndex records where fetching starts, before anything is fetched
+func (t *Tracker) SetNextIndex(index int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextIndex = max(t.nextIndex, index)
+	t.dirty = true
+}
+
+// writeFileAtomic writes v as JSON to a temporary file renamed over path
+func writeFileAtomic(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+// Package rollup maintains the materialized views of the rollups subcommand of the ingesters.
+package rollup
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Rollup is a materialized view maintained by the rollups subcommand, so dashboards read
+// pre-aggregated rows instead of scanning the raw tables
+type Rollup struct {
+	View     string // Materialized view name
+	Table    string // Target table populated on insert; empty for refreshable views
+	TableDDL string // Columns, engine and ORDER BY of Table
+	Refresh  string // REFRESH clause of refreshable views, which keep their result in memory
+	Source   string // Table the view selects from, backfilled partition by partition
+	Select   string // SELECT run on every insert into Source (or on every refresh), reading FROM {source}
+}
+
+// query returns the rollup's SELECT reading from the given table expression
+func (r Rollup) query(source string) string {
+	return strings.ReplaceAll(r.Select, "{source}", source)
+}
+
+// Run creates missing rollups, backfilling new ones from existing rows, and with rebuild drops and
+// recreates all of them. With backfill, existing rollups are backfilled too.
+func Run(db *sql.DB, rollups []Rollup, rebuild, backfill bool) error {
+	for _, rollup := range rollups {
+		created, err := Ensure(db, rollup, rebuild)
+		if err != nil {
+			return err
+		}
+		if rollup.Table == "" || !(created || backfill) {
+			continue
+		}
+		if err := Backfill(db, rollup); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ensure creates the target table and view of a rollup if they do not exist, and reports
+// whether the view was created
+func Ensure(db *sql.DB, rollup Rollup, rebuild bool) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if rebuild {
+		if _, err := db.ExecContext(ctx, "DROP VIEW IF EXISTS "+rollup.View); err != nil {
+			return false, fmt.Errorf("failed to drop %s: %w", rollup.View, err)
+		}
+		if rollup.Table != "" {
+			if _, err := db.ExecContext(ctx, "DROP TABLE IF EXISTS "+rollup.Table); err != nil {
+				return false, fmt.Errorf("failed to drop %s: %w", rollup.Table, err)
+			}
+		}
+	}
+
+	if rollup.Table != "" {
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s %s", rollup.Table, rollup.TableDDL)); err != nil {
+			return false, fmt.Errorf("failed to create %s: %w", rollup.Table, err)
+		}
+	}
+
+	var exists uint8
+	err := db.QueryRowContext(ctx, "SELECT count() > 0 FROM system.tables WHERE database = currentDatabase() AND name = ?", rollup.View).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up %s: %w", rollup.View, err)
+	}
+	if exists == 1 {
+		log.Printf("Rollup %s is up to date", rollup.View)
+		return false, nil
+	}
+
+	ddl := fmt.Sprintf("CREATE MATERIALIZED VIEW %s TO %s AS %s", rollup.View, rollup.Table, rollup.query(rollup.Source))
+	if rollup.Table == "" {
+		ddl = fmt.Sprintf("CREATE MATERIALIZED VIEW %s %s ENGINE = Memory AS %s", rollup.View, rollup.Refresh, rollup.query(rollup.Source))
+	}
+	if _, err := db.ExecContext(ctx, ddl); err != nil {
+		return false, fmt.Errorf("failed to create %s: %w", rollup.View, err)
+	}
+	log.Printf("Created rollup %s", rollup.View)
+	return true, nil
+}
+
+// Backfill inserts the rollup of existing rows, one source partition at a time to bound
+// memory use. Rows inserted by running ingesters while the backfill runs are counted twice.
+func Backfill(db *sql.DB, rollup Rollup) error {
+	rows, err := db.Query(`
+		SELECT DISTINCT partition_id
+		FROM system.parts
+		WHERE database = currentDatabase() AND table = ? AND active
+		ORDER BY partition_id`, rollup.Source)
+	if err != nil {
+		return fmt.Errorf("failed to list partitions of %s: %w", rollup.Source, err)
+	}
+	var partitions []string
+	for rows.Next() {
+		var partition string
+		if err := rows.Scan(&partition); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan partition: %w", err)
+		}
+		partitions = append(partitions, partition)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list partitions of %s: %w", rollup.Source, err)
+	}
+
+	// The view's SELECT, restricted to one partition of the source table
+	query := fmt.Sprintf("INSERT INTO %s %s", rollup.Table,
+		rollup.query(fmt.Sprintf("(SELECT * FROM %s WHERE _partition_id = ?)", rollup.Source)))
+
+	for i, partition := range partitions {
+		start := time.Now()
+		if _, err := db.Exec(query, partition); err != nil {
+			return fmt.Errorf("failed to backfill %s from partition %s: %w", rollup.Table, partition, err)
+		}
+		log.Printf("Backfilled %s from %s partition %s (%d/%d) in %v",
+			rollup.Table, rollup.Source, partition, i+1, len(partitions), time.Since(start).Round(time.Millisecond))
+	}
+	return nil
+}
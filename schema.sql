@@ -7,9 +7,16 @@ CREATE TABLE ct_log_entries
 
     -- Raw CT Log Data (as returned by the get-entries endpoint)
     leaf_input String COMMENT 'Base64 encoded MerkleTreeLeaf structure from the log entry' CODEC(ZSTD(1)),
+    extra_data String DEFAULT '' COMMENT 'Base64 encoded extra_data (certificate chain) from the log entry, empty unless stored with the full profile' CODEC(ZSTD(1)),
+    leaf_certificate_der String DEFAULT '' COMMENT 'Base64 encoded DER of the leaf certificate (x509_entry) or TBSCertificate (precert_entry), empty unless stored with the full profile' CODEC(ZSTD(1)),
+    blob_codec LowCardinality(String) DEFAULT '' COMMENT 'Encoding of leaf_input, extra_data and leaf_certificate_der: empty for base64, zstd for zstd-compressed raw bytes',
+    leaf_hash FixedString(64) DEFAULT '' COMMENT 'RFC 6962 leaf hash (hex SHA-256 of 0x00 || leaf_input), kept in every storage profile',
+    audit_path Array(String) COMMENT 'Audit path (hex hashes, leaf to root) verified at retrieval, empty unless ingested with -record_audit_path' CODEC(ZSTD(1)),
+    audit_path_tree_size UInt64 DEFAULT 0 COMMENT 'Tree size of the STH audit_path leads to the root of',
 
     -- Parsed from MerkleTreeLeaf -> TimestampedEntry
     entry_timestamp DateTime COMMENT 'Timestamp from the TimestampedEntry (milliseconds since epoch, converted to DateTime)',
+    timestamp_anomaly LowCardinality(String) DEFAULT '' COMMENT 'future (ahead of retrieval_timestamp by more than -max_clock_skew), too_old (before -min_timestamp), or empty',
     entry_type Enum8('x509_entry' = 0, 'precert_entry' = 1) COMMENT 'Type of log entry (X.509 certificate or Precertificate)',
 
     -- Core Certificate Identifiers (parsed from leaf_input)
@@ -38,15 +45,32 @@ CREATE TABLE ct_log_entries
     issuer_country Array(String) COMMENT 'Issuer Country (C)',
     issuer_locality Array(String) COMMENT 'Issuer Locality (L)',
     issuer_province Array(String) COMMENT 'Issuer State/Province (ST)',
+    subject_spki_sha256 String DEFAULT '' COMMENT 'SHA-256 (hex) of the certificate SubjectPublicKeyInfo',
+    issuer_spki_sha256 String DEFAULT '' COMMENT 'SHA-256 (hex) of the issuer SubjectPublicKeyInfo, from the chain or the precert issuer key hash',
+    issuer_id UInt64 DEFAULT 0 COMMENT 'Key into ct_issuers, derived from issuer_dn and issuer_spki_sha256 (0 if unknown)',
 
     -- Other Key Parsed Certificate Fields
     serial_number String COMMENT 'Certificate serial number (hex string)',
     subject_alternative_names Array(String) COMMENT 'Array of Subject Alternative Names (DNS, IP, etc.)',
+    dns_names Nested(
+        name String,
+        registrable_domain String,
+        is_wildcard UInt8,
+        unicode String
+    ) COMMENT 'Normalized SAN dNSNames and host-name CN: lowercased name, eTLD+1, wildcard flag and punycode-decoded form',
     signature_algorithm LowCardinality(String) COMMENT 'Signature algorithm of the certificate',
     subject_public_key_algorithm LowCardinality(String) COMMENT 'Algorithm of the subject public key',
     subject_public_key_length UInt16 COMMENT 'Length of the subject public key (e.g., 2048, 256)',
 
     is_ca UInt8 COMMENT 'Boolean (0 or 1) indicating if the certificate is a CA',
+    validity_days UInt32 DEFAULT 0 COMMENT 'Validity period in days, notBefore to notAfter inclusive and rounded up (0 if unknown)',
+    validity_bucket LowCardinality(String) DEFAULT '' COMMENT 'short_lived (<= 7 days), 47d, 100d, 200d, 398d or over_398d: the shortest CA/Browser Forum validity limit the certificate fits in',
+    exceeds_max_validity UInt8 DEFAULT 0 COMMENT 'Boolean (0 or 1) set for non-CA certificates valid for more than 398 days, the Baseline Requirements maximum',
+    has_ip_san UInt8 DEFAULT 0 COMMENT 'Boolean (0 or 1) indicating an iPAddress SAN, or a dNSName holding an IP address',
+    has_onion_san UInt8 DEFAULT 0 COMMENT 'Boolean (0 or 1) indicating a dNSName under .onion',
+    has_internal_name UInt8 DEFAULT 0 COMMENT 'Boolean (0 or 1) indicating a single-label dNSName or one under a TLD outside the ICANN root (e.g. .local, .corp)',
+    weak_key_reason LowCardinality(String) DEFAULT '' COMMENT 'Why the certificate key is weak: debian_weak_key, roca, shared_modulus, rsa_small, or empty',
+    is_duplicate UInt8 DEFAULT 0 COMMENT 'Boolean (0 or 1) set by -dedup when the certificate was already stored from another entry; raw blobs are not stored for duplicates',
     basic_constraints_path_len Nullable(UInt8) COMMENT 'Path length constraint for CA certificates',
 
     key_usage Array(LowCardinality(String)) COMMENT 'Parsed key usage extensions (e.g., digitalSignature, keyCertSign)',
@@ -60,7 +84,21 @@ CREATE TABLE ct_log_entries
 
     -- Precertificate Specific Fields (parsed from leaf_input or extra_data)
     precert_issuer_key_hash Nullable(FixedString(64)) COMMENT 'SHA-256 hash (hex) of the issuer public key (for Precertificate entries)',
-    precert_poison_extension_present UInt8 DEFAULT 0 COMMENT 'Boolean (0 or 1) indicating if the X.509v3 Precertificate Poison extension is present',
+    precert_tbs_sha256 Nullable(FixedString(64)) COMMENT 'SHA-256 hash (hex) of the precert TBSCertificate; for final certificates, of the TBS with the SCT list removed',
+    precert_poison_extension_present UInt8 DEFAULT 0 COMMENT 'Boolean (0 or 1) indicating if the X.509v3 Precertificate Poison extension is present (on the precertificate in extra_data for Precertificate entries, which should always have it; on the certificate for X509 entries, which never should)',
+    has_embedded_scts UInt8 DEFAULT 0 COMMENT 'Boolean (0 or 1) indicating if the logged certificate carries an embedded SCT list (which a precert TBS never should)',
+    precert_corresponding_serial String DEFAULT '' COMMENT 'Serial number (hex) shared with the corresponding precert or final certificate: set for Precertificate entries and for X509 entries with embedded SCTs',
+
+    -- Root Store Trust (populated with -evaluate_trust, NULL when not evaluated for that program)
+    trusted_mozilla Nullable(UInt8) COMMENT 'Boolean (0 or 1) indicating if the logged chain leads to a Mozilla NSS root',
+    trusted_chrome Nullable(UInt8) COMMENT 'Boolean (0 or 1) indicating if the logged chain leads to a Chrome Root Store root',
+    trusted_apple Nullable(UInt8) COMMENT 'Boolean (0 or 1) indicating if the logged chain leads to an Apple root',
+
+    -- Fetch provenance, NULL unless ingested with -record_provenance
+    fetch_peer_addr Nullable(String) COMMENT 'Address the log was fetched from',
+    fetch_local_addr Nullable(String) COMMENT 'Egress IP of the connection',
+    fetch_latency_ms Nullable(UInt32) COMMENT 'Duration of the request that returned the entry',
+    fetch_retries Nullable(UInt8) COMMENT 'Failed attempts before that request',
 
     -- PROJECTION for SAN lookups (optional, for performance)
     -- PROJECTION san_projection (
@@ -77,14 +115,17 @@ CREATE TABLE ct_log_entries
     INDEX idx_subject_cn subject_common_name TYPE bloom_filter GRANULARITY 1,
     INDEX idx_issuer_cn issuer_common_name TYPE bloom_filter GRANULARITY 1,
     INDEX idx_sans subject_alternative_names TYPE bloom_filter GRANULARITY 4, -- For has(subject_alternative_names, 'value')
+    INDEX idx_registrable_domains dns_names.registrable_domain TYPE bloom_filter GRANULARITY 4, -- For has(dns_names.registrable_domain, 'value')
     INDEX idx_serial serial_number TYPE bloom_filter GRANULARITY 1,
+    INDEX idx_precert_tbs precert_tbs_sha256 TYPE bloom_filter GRANULARITY 1,
     INDEX idx_not_after not_after TYPE minmax,
+    INDEX idx_leaf_hash leaf_hash TYPE bloom_filter GRANULARITY 1,
     INDEX idx_entry_timestamp entry_timestamp TYPE minmax
 )
 ENGINE = ReplacingMergeTree()
 PARTITION BY toYYYYMM(not_after) -- Partition by month of certificate expiry
 ORDER BY (log_id, log_index) -- Primary sorting order
-SETTINGS storage_policy = 's3_policy', index_granularity = 8192;
+SETTINGS storage_policy = 's3_policy', index_granularity = 8192, non_replicated_deduplication_window = 1000; -- Honour insert_deduplication_token on non-replicated tables
 
 CREATE TABLE ct_log_entries_by_name
 (
@@ -140,6 +181,120 @@ SELECT
 FROM ct_log_entries
 WHERE certificate_sha256 != '';
 
+-- One row per certificate dNSName, written by ctmon-ingest -index_domains for both x509_entry and precert_entry
+-- entries (precert names come from the TBS), so precert-only issuance is covered
+CREATE TABLE ct_domains
+(
+    domain String COMMENT 'Lowercased dNSName from the certificate SAN extension' CODEC(ZSTD(1)),
+    registrable_domain String COMMENT 'eTLD+1 of the domain according to the public suffix list' CODEC(ZSTD(1)),
+    log_id LowCardinality(String),
+    log_index UInt64 CODEC(ZSTD(1)),
+    certificate_sha256 FixedString(64),
+    not_before DateTime CODEC(ZSTD(1)),
+    not_after DateTime CODEC(ZSTD(1))
+)
+ENGINE = ReplacingMergeTree()
+ORDER BY (registrable_domain, domain, certificate_sha256, log_id, log_index)
+SETTINGS storage_policy = 's3_policy', index_granularity = 8192;
+
+-- Issuer dimension table, written by ctmon-ingest -index_issuers
+CREATE TABLE ct_issuers
+(
+    issuer_id UInt64 COMMENT 'First 8 bytes of SHA-256(issuer_dn, issuer_spki_sha256)',
+    issuer_dn String COMMENT 'Full Issuer Distinguished Name',
+    issuer_spki_sha256 String COMMENT 'SHA-256 (hex) of the issuer SubjectPublicKeyInfo',
+    issuer_common_name String COMMENT 'Issuer Common Name (CN)',
+    issuer_organization Array(String) COMMENT 'Issuer Organization (O)',
+    operator_name LowCardinality(String) COMMENT 'CA operator (from -issuer_operators, e.g. CCADB CA Owner), falling back to the issuer organization'
+)
+ENGINE = ReplacingMergeTree()
+ORDER BY issuer_id;
+
+-- Unique certificates across all logs, written by ctmon-ingest -dedup
+-- Cross-log analytics can count rows here, or filter ct_log_entries on is_duplicate = 0, instead of DISTINCT certificate_sha256
+CREATE TABLE ct_certificates
+(
+    certificate_sha256 FixedString(64),
+    entry_type LowCardinality(String) COMMENT 'x509_entry or precert_entry',
+    first_log_id LowCardinality(String) COMMENT 'Log of the entry stored with raw blobs',
+    first_log_index UInt64,
+    first_entry_timestamp DateTime,
+    not_before DateTime CODEC(ZSTD(1)),
+    not_after DateTime CODEC(ZSTD(1)),
+    subject_common_name String CODEC(ZSTD(1)),
+    issuer_id UInt64 COMMENT 'Key into ct_issuers',
+    inserted_at DateTime DEFAULT now() COMMENT 'When the row was recorded, read to catch a -dedup_filter_file checkpoint up'
+)
+ENGINE = ReplacingMergeTree()
+ORDER BY certificate_sha256
+SETTINGS storage_policy = 's3_policy', index_granularity = 8192;
+
+-- Precertificate / final certificate pairs, written by ctmon-ingest -link_precerts
+-- Precerts whose final certificate was never logged:
+--   SELECT certificate_sha256, log_id, log_index FROM ct_log_entries
+--   WHERE entry_type = 'precert_entry'
+--     AND certificate_sha256 NOT IN (SELECT precert_sha256 FROM ct_certificate_links)
+CREATE TABLE ct_certificate_links
+(
+    precert_sha256 FixedString(64) COMMENT 'certificate_sha256 of the precert_entry',
+    final_certificate_sha256 FixedString(64) COMMENT 'certificate_sha256 of the x509_entry',
+    match_type LowCardinality(String) COMMENT 'tbs (TBS without SCT list equals precert TBS) or serial_issuer (same serial and issuer_id)',
+    precert_log_id LowCardinality(String),
+    precert_log_index UInt64,
+    precert_timestamp DateTime COMMENT 'CT log entry timestamp of the precert',
+    final_log_id LowCardinality(String),
+    final_log_index UInt64,
+    final_timestamp DateTime COMMENT 'CT log entry timestamp of the final certificate'
+)
+ENGINE = ReplacingMergeTree()
+ORDER BY (precert_sha256, final_certificate_sha256)
+SETTINGS storage_policy = 's3_policy', index_granularity = 8192;
+
+-- Revocation status transitions of watched certificates, written by ctmon-ingest -check_revocation
+CREATE TABLE ct_revocations
+(
+    certificate_sha256 FixedString(64),
+    serial_number String COMMENT 'Certificate serial number (hex string)',
+    log_id LowCardinality(String),
+    log_index UInt64,
+    checked_at DateTime COMMENT 'Time of the check that observed the new status',
+    previous_status LowCardinality(Nullable(String)) COMMENT 'Status before the transition, NULL for the first check',
+    status LowCardinality(String) COMMENT 'good, revoked or unknown',
+    revocation_time Nullable(DateTime) COMMENT 'Revocation time reported by the CA',
+    revocation_reason Nullable(UInt8) COMMENT 'RFC 5280 CRLReason code',
+    source LowCardinality(String) COMMENT 'ocsp or crl',
+    source_url String COMMENT 'OCSP responder or CRL distribution point that was queried'
+)
+ENGINE = MergeTree()
+ORDER BY (certificate_sha256, checked_at);
+
+-- Entries ctmon-ingest could not parse, with the raw get-entries item, instead of skipping them
+-- Issuance spikes flagged by ctmon-ingest -detect_anomalies
+CREATE TABLE ct_anomalies
+(
+    log_id LowCardinality(String),
+    kind LowCardinality(String) COMMENT 'domain_burst (certificates for one registrable domain) or issuer_spike (entries of one issuer)',
+    subject String COMMENT 'Registrable domain or issuer DN',
+    window_start DateTime COMMENT 'Hour of entry timestamp the entries were counted in',
+    entry_count UInt64 COMMENT 'Entries counted when the anomaly was flagged',
+    baseline Float64 COMMENT 'Usual hourly entries of the issuer, 0 for domain bursts',
+    threshold Float64,
+    detected_at DateTime64(3)
+)
+ENGINE = MergeTree()
+ORDER BY (log_id, kind, window_start, subject);
+
+CREATE TABLE ct_quarantine
+(
+    log_id LowCardinality(String),
+    log_index UInt64,
+    reason String COMMENT 'Error that caused the entry to be quarantined',
+    raw_entry String COMMENT 'get-entries item as JSON (leaf_input, extra_data), or the parsed row as JSON for entries rejected on insert' CODEC(ZSTD(3)),
+    quarantined_at DateTime64(3)
+)
+ENGINE = ReplacingMergeTree(quarantined_at)
+ORDER BY (log_id, log_index);
+
 -- Sigstore Rekor Log Entries Table
 CREATE TABLE rekor_log_entries
 (
@@ -151,7 +306,9 @@ CREATE TABLE rekor_log_entries
     
     -- Raw Rekor Entry Data
     body String COMMENT 'Base64 encoded entry body from Rekor API' CODEC(ZSTD(1)),
+    blob_codec LowCardinality(String) DEFAULT '' COMMENT 'Encoding of body: empty for base64, zstd for zstd-compressed raw bytes',
     integrated_time DateTime COMMENT 'Timestamp when entry was integrated into the log',
+    timestamp_anomaly LowCardinality(String) DEFAULT '' COMMENT 'future (ahead of retrieval_timestamp by more than -max_clock_skew), too_old (before -min_timestamp), or empty',
     log_id String COMMENT 'SHA256 hash of DER-encoded public key for the log',
     
     -- Parsed Entry Content (from decoded body)
@@ -172,19 +329,27 @@ CREATE TABLE rekor_log_entries
     
     -- Verification Information
     signed_entry_timestamp String COMMENT 'Base64 encoded signed entry timestamp' CODEC(ZSTD(1)),
+    inclusion_proof_hashes Array(String) COMMENT 'Audit path of the inclusion proof served at retrieval (hex hashes, leaf to root), empty unless stored with the full profile' CODEC(ZSTD(1)),
+    inclusion_proof_root_hash String DEFAULT '' COMMENT 'Root hash (hex) the inclusion proof leads to',
+    inclusion_proof_tree_size UInt64 DEFAULT 0 COMMENT 'Tree size of the inclusion proof',
+    inclusion_proof_checkpoint String DEFAULT '' COMMENT 'Signed checkpoint (note format) of the inclusion proof tree size' CODEC(ZSTD(1)),
     
     -- Entry Type Specific Fields (nullable for non-applicable types)
     
-    -- X509 Certificate Fields (for hashedrekord entries with x509 certificates)
+    -- X509 Certificate Fields (for hashedrekord, x509 rekord, intoto and dsse entries with x509 certificates)
     x509_certificate_sha256 String COMMENT 'SHA256 hash of the certificate (hex)',
     x509_subject_dn String COMMENT 'Subject Distinguished Name',
     x509_subject_cn String COMMENT 'Subject Common Name',
     x509_subject_organization Array(String) COMMENT 'Subject Organization',
     x509_subject_ou Array(String) COMMENT 'Subject Organizational Unit',
+    x509_subject_country Array(String) COMMENT 'Subject Country (C)',
+    x509_subject_province Array(String) COMMENT 'Subject State/Province (ST)',
+    x509_subject_locality Array(String) COMMENT 'Subject Locality (L)',
     x509_issuer_dn String COMMENT 'Issuer Distinguished Name',
     x509_issuer_cn String COMMENT 'Issuer Common Name',
     x509_issuer_organization Array(String) COMMENT 'Issuer Organization',
     x509_issuer_ou Array(String) COMMENT 'Issuer Organizational Unit',
+    x509_issuer_country Array(String) COMMENT 'Issuer Country (C)',
     x509_serial_number String COMMENT 'Certificate serial number',
     x509_not_before DateTime COMMENT 'Certificate validity start',
     x509_not_after DateTime COMMENT 'Certificate validity end',
@@ -196,6 +361,11 @@ CREATE TABLE rekor_log_entries
     x509_key_usage Array(LowCardinality(String)) COMMENT 'Key usage extensions',
     x509_extended_key_usage Array(LowCardinality(String)) COMMENT 'Extended key usage',
     x509_extensions String COMMENT 'All X509v3 extensions as JSON' CODEC(ZSTD(1)),
+    x509_sct_log_ids Array(String) COMMENT 'Log IDs (hex SHA-256 of the log key) of the SCTs embedded in the certificate, e.g. the Fulcio CT log',
+    x509_sct_timestamps Array(DateTime64(3)) COMMENT 'Timestamps of the embedded SCTs, in the order of x509_sct_log_ids',
+    x509_precert_tbs_sha256 String COMMENT 'SHA-256 hash (hex) of the TBSCertificate with the SCT list removed; joins ct_log_entries.precert_tbs_sha256 of the precertificate',
+    x509_chain_sha256 Array(String) COMMENT 'SHA-256 hashes (hex) of the certificates following the leaf when publicKey.content holds a PEM chain, in order',
+    x509_issuing_certificate_sha256 String COMMENT 'SHA-256 hash (hex) of the chain certificate that signed the leaf, e.g. the Fulcio intermediate; empty without a chain',
 
     -- PGP Message Fields (for rekord entries with PGP signatures)
     pgp_signature_hash String COMMENT 'SHA256 hash of the PGP signature block (hex)',
@@ -206,7 +376,38 @@ CREATE TABLE rekor_log_entries
     pgp_signer_name String COMMENT 'Name extracted from user ID',
     pgp_key_algorithm LowCardinality(String) COMMENT 'PGP key algorithm (RSA, ECDSA, EdDSA, etc.)',
     pgp_key_size UInt16 COMMENT 'PGP key size in bits',
+    pgp_key_curve LowCardinality(String) COMMENT 'Curve of elliptic curve PGP keys (P-256, Ed25519, Curve25519, etc.)',
     pgp_subkey_fingerprints Array(String) COMMENT 'Fingerprints of subkeys',
+
+    -- Signer identity, summarized per identity in rekor_identities
+    signer_identity String COMMENT 'Email or URI SAN of the certificate, or email of the PGP signer',
+    oidc_issuer LowCardinality(String) COMMENT 'OIDC issuer of a Fulcio certificate',
+    github_repository String COMMENT 'owner/name of the repository a GitHub Actions certificate was issued for',
+    github_workflow String COMMENT 'owner/name/path of the workflow file that signed, without its ref',
+
+    -- Signing key, summarized per key in rekor_public_keys
+    public_key_type LowCardinality(String) COMMENT 'pgp, ssh or x509 (certificates and PEM public keys)',
+    public_key_fingerprint String COMMENT 'PGP fingerprint (hex), OpenSSH SHA256:<base64> fingerprint, or SHA-256 (hex) of the SubjectPublicKeyInfo of the certificate or PEM public key',
+    public_key_algorithm LowCardinality(String) COMMENT 'RSA, DSA, ECDSA, ECDH or EdDSA, for all signature formats (minisign keys have no fingerprint)',
+    public_key_curve LowCardinality(String) COMMENT 'P-256, P-384, P-521, Ed25519, secp256k1, etc. for elliptic curve keys',
+    public_key_size UInt16 COMMENT 'Modulus size in bits for RSA and DSA keys, curve size otherwise',
+
+    -- Container image referenced by the data URL or annotations (registry/repository@digest)
+    oci_registry LowCardinality(String) COMMENT 'Registry host, docker.io for references without one',
+    oci_repository String COMMENT 'Repository path within the registry (library/ prefixed for official docker.io images)',
+    oci_digest String COMMENT 'Manifest digest (sha256:... or sha512:...)',
+
+    -- Package publish attestations (intoto and dsse entries carrying their in-toto statement)
+    attestation_predicate_type LowCardinality(String) COMMENT 'predicateType of the in-toto statement',
+    package_ecosystem LowCardinality(String) COMMENT 'npm (provenance) or pypi (publish attestation or provenance of a distribution)',
+    package_name String COMMENT 'Package name (@scope/name for npm, PEP 503 normalized for PyPI)',
+    package_version String COMMENT 'Package version',
+
+    -- Fetch provenance, NULL unless ingested with -record_provenance
+    fetch_peer_addr Nullable(String) COMMENT 'Proxy the entry was fetched through, or the Rekor address for direct requests',
+    fetch_local_addr Nullable(String) COMMENT 'Egress IP of the connection',
+    fetch_latency_ms Nullable(UInt32) COMMENT 'Duration of the request that returned the entry',
+    fetch_retries Nullable(UInt8) COMMENT 'Failed attempts before that request',
     
     -- Indexes for common query patterns
     INDEX idx_entry_uuid entry_uuid TYPE bloom_filter GRANULARITY 1,
@@ -219,16 +420,90 @@ CREATE TABLE rekor_log_entries
     INDEX idx_x509_sans x509_sans TYPE bloom_filter GRANULARITY 4,
     INDEX idx_x509_serial x509_serial_number TYPE bloom_filter GRANULARITY 1,
     INDEX idx_x509_not_after x509_not_after TYPE minmax,
+    INDEX idx_x509_precert_tbs x509_precert_tbs_sha256 TYPE bloom_filter GRANULARITY 1,
     INDEX idx_pgp_key_fingerprint pgp_public_key_fingerprint TYPE bloom_filter GRANULARITY 1,
     INDEX idx_pgp_key_id pgp_key_id TYPE bloom_filter GRANULARITY 1,
+    INDEX idx_signer_identity signer_identity TYPE bloom_filter GRANULARITY 1,
+    INDEX idx_public_key_fingerprint public_key_fingerprint TYPE bloom_filter GRANULARITY 1,
     INDEX idx_pgp_signer_email pgp_signer_email TYPE bloom_filter GRANULARITY 1,
-    INDEX idx_pgp_signature_hash pgp_signature_hash TYPE bloom_filter GRANULARITY 1
+    INDEX idx_pgp_signature_hash pgp_signature_hash TYPE bloom_filter GRANULARITY 1,
+    INDEX idx_oci_repository oci_repository TYPE bloom_filter GRANULARITY 1,
+    INDEX idx_oci_digest oci_digest TYPE bloom_filter GRANULARITY 1,
+    INDEX idx_package_name package_name TYPE bloom_filter GRANULARITY 1
 )
 ENGINE = ReplacingMergeTree()
 PARTITION BY toYYYYMM(integrated_time) -- Partition by month of integration
 ORDER BY (tree_id, log_index) -- Primary sorting order
+SETTINGS storage_policy = 's3_policy', index_granularity = 8192, non_replicated_deduplication_window = 1000; -- Honour insert_deduplication_token on non-replicated tables
+
+-- Fulcio CT log entries linked to the Rekor entries signed with the same certificate, written by
+-- `sigstore-ingest correlate`
+CREATE TABLE sigstore_certificate_links
+(
+    ct_log_id LowCardinality(String) COMMENT 'log_id of the Fulcio CT log in ct_log_entries',
+    ct_log_index UInt64,
+    ct_certificate_sha256 FixedString(64) COMMENT 'certificate_sha256 of the CT entry (usually the precertificate)',
+    rekor_tree_id LowCardinality(String),
+    rekor_log_index UInt64,
+    rekor_entry_uuid String,
+    rekor_certificate_sha256 String COMMENT 'x509_certificate_sha256 of the Rekor entry',
+    serial_number String COMMENT 'Certificate serial number (hex string)',
+    match_type LowCardinality(String) COMMENT 'certificate_sha256, precert_tbs (Rekor TBS without SCT list equals the CT precert TBS) or serial',
+    ct_entry_timestamp DateTime,
+    rekor_integrated_time DateTime,
+    linked_at DateTime DEFAULT now()
+)
+ENGINE = ReplacingMergeTree(linked_at)
+ORDER BY (ct_log_id, ct_log_index, rekor_tree_id, rekor_log_index)
+SETTINGS storage_policy = 's3_policy', index_granularity = 8192;
+
+-- Certificates seen on one side only once the correlation grace period has passed, written by
+-- `sigstore-ingest correlate`; the latest row of each entry (by checked_at) is current:
+--   SELECT * FROM sigstore_certificate_orphans FINAL WHERE NOT resolved
+CREATE TABLE sigstore_certificate_orphans
+(
+    side LowCardinality(String) COMMENT 'ct_only (logged in the Fulcio CT log, used in no Rekor entry) or rekor_only (Fulcio certificate used in Rekor, absent from the CT log)',
+    certificate_sha256 String COMMENT 'SHA-256 (hex) of the certificate on that side',
+    serial_number String COMMENT 'Certificate serial number (hex string)',
+    log_id LowCardinality(String) COMMENT 'CT log_id or Rekor tree_id',
+    log_index UInt64,
+    seen_at DateTime COMMENT 'CT entry timestamp or Rekor integrated time',
+    resolved UInt8 DEFAULT 0 COMMENT 'Boolean (0 or 1) set once a later run linked the entry',
+    checked_at DateTime DEFAULT now()
+)
+ENGINE = ReplacingMergeTree(checked_at)
+ORDER BY (side, log_id, log_index)
+SETTINGS storage_policy = 's3_policy', index_granularity = 8192;
+
+-- Stored Rekor entries the log no longer serves as stored (missing, body_changed, moved or
+-- proof_mismatch), found by `sigstore-ingest audit` or the -resample_interval sampler
+CREATE TABLE rekor_discrepancies
+(
+    tree_id LowCardinality(String),
+    log_index UInt64 COMMENT 'Tree-local index the entry is stored at',
+    entry_uuid String,
+    kind LowCardinality(String) COMMENT 'missing (tombstoned or purged), body_changed, moved (other index or tree) or proof_mismatch',
+    detail String,
+    source LowCardinality(String) COMMENT 'audit or resampler',
+    detected_at DateTime
+)
+ENGINE = MergeTree()
+ORDER BY (tree_id, log_index, detected_at)
 SETTINGS storage_policy = 's3_policy', index_granularity = 8192;
 
+-- Entries sigstore-ingest could not parse, with the raw API response, instead of skipping them
+CREATE TABLE rekor_quarantine
+(
+    tree_id LowCardinality(String) COMMENT 'Tree being ingested when the entry was fetched',
+    global_index UInt64 COMMENT 'Global log index the entry was requested at',
+    entry_uuid String,
+    reason String COMMENT 'Error that caused the entry to be quarantined',
+    raw_entry String COMMENT 'Rekor API log entry as JSON, or the parsed row as JSON for entries rejected on insert' CODEC(ZSTD(3)),
+    quarantined_at DateTime64(3)
+)
+ENGINE = ReplacingMergeTree(quarantined_at)
+ORDER BY (tree_id, global_index);
+
 CREATE TABLE rekor_log_entries_by_github_repository (
     repository_name String CODEC(ZSTD(1)),
     entry_uuid String,
@@ -259,6 +534,291 @@ WHERE x509_issuer_cn = 'sigstore-intermediate'
     integrated_time
 FROM entries;
 
+-- One row per signing key of rekor_log_entries, with when it was first and last used and how many
+-- entries it signed (entries fetched again, e.g. by a re-run backfill, are counted again). Keys come
+-- from hashedrekord, rekord, intoto and dsse entries (the first signature of an envelope); the
+-- other kinds have no indexed key.
+CREATE TABLE rekor_public_keys
+(
+    fingerprint String,
+    key_type LowCardinality(String) COMMENT 'pgp, ssh or x509',
+    first_seen SimpleAggregateFunction(min, DateTime) COMMENT 'Earliest integrated_time of an entry signed with the key',
+    last_seen SimpleAggregateFunction(max, DateTime) COMMENT 'Latest integrated_time of an entry signed with the key',
+    entry_count SimpleAggregateFunction(sum, UInt64)
+)
+ENGINE = AggregatingMergeTree()
+ORDER BY (fingerprint, key_type)
+SETTINGS storage_policy = 's3_policy', index_granularity = 8192;
+
+CREATE MATERIALIZED VIEW rekor_public_keys_mv TO rekor_public_keys AS
+SELECT
+    public_key_fingerprint AS fingerprint,
+    public_key_type AS key_type,
+    min(integrated_time) AS first_seen,
+    max(integrated_time) AS last_seen,
+    count() AS entry_count
+FROM rekor_log_entries
+WHERE public_key_fingerprint != ''
+GROUP BY fingerprint, key_type;
+
+-- One row per signer identity of rekor_log_entries (with its OIDC issuer and, for GitHub Actions,
+-- repository and workflow), with when it was first and last seen and how many entries it signed
+CREATE TABLE rekor_identities
+(
+    identity String,
+    oidc_issuer LowCardinality(String),
+    github_repository String,
+    github_workflow String,
+    first_seen SimpleAggregateFunction(min, DateTime),
+    last_seen SimpleAggregateFunction(max, DateTime),
+    entry_count SimpleAggregateFunction(sum, UInt64),
+    INDEX idx_github_repository github_repository TYPE bloom_filter GRANULARITY 1
+)
+ENGINE = AggregatingMergeTree()
+ORDER BY (identity, oidc_issuer, github_repository, github_workflow)
+SETTINGS storage_policy = 's3_policy', index_granularity = 8192;
+
+CREATE MATERIALIZED VIEW rekor_identities_mv TO rekor_identities AS
+SELECT
+    signer_identity AS identity,
+    oidc_issuer,
+    github_repository,
+    github_workflow,
+    min(integrated_time) AS first_seen,
+    max(integrated_time) AS last_seen,
+    count() AS entry_count
+FROM rekor_log_entries
+WHERE signer_identity != ''
+GROUP BY identity, oidc_issuer, github_repository, github_workflow;
+
+-- Entries by signing key, for listing everything signed with a key from rekor_public_keys
+CREATE TABLE rekor_log_entries_by_public_key (
+    fingerprint String CODEC(ZSTD(1)),
+    entry_uuid String,
+    tree_id LowCardinality(String),
+    log_index UInt64,
+    integrated_time DateTime
+)
+ENGINE = ReplacingMergeTree()
+ORDER BY (fingerprint, tree_id, log_index)
+SETTINGS storage_policy = 's3_policy', index_granularity = 8192;
+
+CREATE MATERIALIZED VIEW rekor_log_entries_by_public_key_mv TO rekor_log_entries_by_public_key AS
+SELECT
+    public_key_fingerprint AS fingerprint,
+    entry_uuid,
+    tree_id,
+    log_index,
+    integrated_time
+FROM rekor_log_entries
+WHERE public_key_fingerprint != '';
+
+-- One row per run of ctmon-ingest or sigstore-ingest, written at startup, every minute and at shutdown
+CREATE TABLE ingest_runs
+(
+    run_id UUID,
+    binary LowCardinality(String) COMMENT 'ctmon-ingest or sigstore-ingest',
+    version String COMMENT 'Module version and VCS revision of the build',
+    hostname String,
+    flags String COMMENT 'Flags set on the command line as JSON, secrets redacted',
+    log_id LowCardinality(String) COMMENT 'CT log ID or Rekor tree ID',
+    start_index Int64,
+    next_index Int64 COMMENT 'Next index to fetch as of updated_at',
+    entries_processed UInt64 COMMENT 'Entries inserted by the run',
+    errors UInt64 COMMENT 'Quarantined entries and batches that failed to insert',
+    status LowCardinality(String) COMMENT 'running, stopped, failed or complete (the log is readonly, retired or past its temporal interval and fully ingested)',
+    error String,
+    started_at DateTime64(3),
+    updated_at DateTime64(3),
+    duration_seconds Float64
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY (log_id, started_at, run_id);
+
+-- Requests of the ingesters to their upstream log and response bytes per UTC day and proxy, for budgeting paid proxy bandwidth
+CREATE TABLE ingest_usage
+(
+    binary LowCardinality(String) COMMENT 'ctmon-ingest or sigstore-ingest',
+    log_url String,
+    proxy String COMMENT 'host:port of the proxy, direct without',
+    day Date COMMENT 'UTC',
+    requests UInt64 COMMENT 'Requests to the log, retries included',
+    bytes UInt64 COMMENT 'Response body bytes on the wire, before decompression'
+)
+ENGINE = SummingMergeTree()
+ORDER BY (log_url, day, proxy, binary);
+
+-- Requests of ctmon-ingest to its CT log (retries included) per endpoint and -health_interval window
+CREATE TABLE ct_log_health
+(
+    log_id LowCardinality(String),
+    log_url String,
+    endpoint LowCardinality(String) COMMENT 'get-entries, get-sth, get-proof-by-hash or other',
+    window_start DateTime64(3),
+    window_end DateTime64(3),
+    requests UInt64,
+    ok UInt64 COMMENT 'Responses with a 2xx status',
+    rate_limited UInt64 COMMENT '429',
+    not_found UInt64 COMMENT '404, 410',
+    proxy_denied UInt64 COMMENT '403, 407',
+    client_errors UInt64 COMMENT 'Other 3xx and 4xx',
+    server_errors UInt64 COMMENT '5xx',
+    network_errors UInt64 COMMENT 'Timeouts and connection errors',
+    latency_avg_ms Float64 COMMENT 'Time to the response headers',
+    latency_p50_ms Float64,
+    latency_p95_ms Float64,
+    latency_max_ms Float64,
+    sth_tree_size Int64 COMMENT 'Latest verified STH at window_end',
+    sth_timestamp DateTime64(3)
+)
+ENGINE = MergeTree
+ORDER BY (log_id, endpoint, window_start);
+
+-- Latest entry of every log in the Chrome and Apple log lists, written by `ctmon-ingest loglists`
+CREATE TABLE ct_log_lists
+(
+    list LowCardinality(String) COMMENT 'chrome or apple',
+    log_id String COMMENT 'Base64 SHA-256 of the log key, as in the log list',
+    operator String,
+    description String,
+    kind LowCardinality(String) COMMENT 'rfc6962 or tiled',
+    url String COMMENT 'Monitoring URL for tiled logs',
+    key String COMMENT 'Base64 DER public key',
+    mmd Int64 COMMENT 'Maximum merge delay in seconds',
+    state LowCardinality(String) COMMENT 'pending, qualified, usable, readonly, retired or rejected',
+    state_timestamp DateTime64(3),
+    temporal_interval_start DateTime64(3) COMMENT 'Zero for logs without a temporal interval',
+    temporal_interval_end DateTime64(3),
+    final_tree_size Int64 COMMENT 'Final tree head of readonly logs, 0 otherwise',
+    fetched_at DateTime64(3)
+)
+ENGINE = ReplacingMergeTree(fetched_at)
+ORDER BY (list, log_id);
+
+-- State history of the logs in ct_log_lists: one row per log when first seen and per state change
+CREATE TABLE ct_log_list_states
+(
+    list LowCardinality(String),
+    log_id String,
+    operator String,
+    description String,
+    url String,
+    previous_state LowCardinality(String) COMMENT 'Empty when the log was first seen',
+    state LowCardinality(String) COMMENT 'As in ct_log_lists, or removed once the log left the list',
+    state_timestamp DateTime64(3) COMMENT 'Since when the list has the log in this state',
+    observed_at DateTime64(3)
+)
+ENGINE = MergeTree
+ORDER BY (log_id, list, observed_at);
+
+-- Root program policy metrics per log and day, computed from ct_log_health and ct_log_entries by
+-- `ctmon-ingest compliance`
+CREATE TABLE ct_log_compliance
+(
+    log_id LowCardinality(String),
+    day Date,
+    mmd_seconds Int64 COMMENT 'From ct_log_lists, 86400 for logs missing from it',
+    get_sth_requests UInt64,
+    get_sth_ok UInt64,
+    get_sth_uptime Float64 COMMENT 'Share of ct_log_health windows with a successful get-sth',
+    get_entries_requests UInt64,
+    get_entries_ok UInt64,
+    get_entries_uptime Float64 COMMENT 'Share of ct_log_health windows with a successful get-entries',
+    sth_count UInt64 COMMENT 'Distinct STH timestamps observed',
+    sth_max_age_seconds Int64 COMMENT 'Largest age of the latest observed STH at the end of a window',
+    entries_checked UInt64 COMMENT 'Entries merged between two observed STHs, whose merge delay is known',
+    max_merge_delay_seconds Int64 COMMENT 'Largest delay from entry timestamp to the first observed STH including the entry',
+    p99_merge_delay_seconds Float64,
+    entries_over_mmd UInt64,
+    computed_at DateTime64(3)
+)
+ENGINE = ReplacingMergeTree(computed_at)
+ORDER BY (log_id, day);
+
+-- One row per table rewritten by `ctmon-ingest redact`
+CREATE TABLE redactions
+(
+    redaction_id String COMMENT 'Shared by the rows of one run',
+    identity_sha256 FixedString(64) COMMENT 'SHA-256 (hex) of the lowercased identity, so requests can be matched without storing it',
+    operator String,
+    reason String,
+    table_name LowCardinality(String),
+    action LowCardinality(String) COMMENT 'update or delete',
+    rows UInt64 COMMENT 'Rows matched before the mutation',
+    redacted_at DateTime64(3)
+)
+ENGINE = MergeTree()
+ORDER BY (redacted_at, redaction_id);
+
+CREATE TABLE subscriptions
+(
+    subscription_id String,
+    email String,
+    kind LowCardinality(String) COMMENT 'domain or identity',
+    value String COMMENT 'Domain (including subdomains) or Sigstore identity to notify about',
+    mode LowCardinality(String) COMMENT 'immediate or digest',
+    channel LowCardinality(String) COMMENT 'email, slack or discord',
+    webhook_url String COMMENT 'Slack or Discord webhook for those channels',
+    confirmed UInt8 COMMENT 'Whether the confirmation link was visited',
+    deleted UInt8 COMMENT 'Set when unsubscribed',
+    created_at DateTime64(3),
+    last_notified_at DateTime64(3) COMMENT 'Matches up to this time have been emailed',
+    version UInt64 COMMENT 'Row version, the latest wins'
+)
+ENGINE = ReplacingMergeTree(version)
+ORDER BY subscription_id;
+
+CREATE TABLE subscription_matches
+(
+    subscription_id String,
+    source LowCardinality(String) COMMENT 'ct or rekor',
+    entry_id String COMMENT 'certificate_sha256 or entry_uuid',
+    log_id LowCardinality(String),
+    log_index UInt64,
+    summary String CODEC(ZSTD(1)),
+    event String CODEC(ZSTD(1)) COMMENT 'Published stream event as JSON, used for Slack and Discord embeds',
+    matched_at DateTime64(3)
+)
+ENGINE = MergeTree()
+ORDER BY (subscription_id, matched_at)
+TTL toDateTime(matched_at) + INTERVAL 30 DAY;
+
+-- Keys of the query API (ctmon-api -api_keys), managed with `ctmon-api keys`
+CREATE TABLE api_keys
+(
+    key_id String,
+    key_hash String COMMENT 'SHA-256 (hex) of the key, which is only shown when created',
+    name String COMMENT 'Owner or purpose of the key',
+    rate_limit Float64 COMMENT 'Requests per second, 0 for -default_rate_limit',
+    burst UInt32 COMMENT 'Requests allowed at once, 0 for the rate limit rounded up',
+    disabled UInt8 COMMENT 'Set when revoked',
+    created_at DateTime64(3),
+    version UInt64 COMMENT 'Row version, the latest wins'
+)
+ENGINE = ReplacingMergeTree(version)
+ORDER BY key_id;
+
+-- Requests and query cost per API key, route and minute
+CREATE TABLE api_usage
+(
+    key_id String COMMENT 'API key, empty for anonymous requests',
+    route LowCardinality(String) COMMENT 'graphql, stream, rekor_index, rekor_entries or rekor_entry',
+    window_start DateTime,
+    requests UInt64 COMMENT 'Requests admitted by the rate limit',
+    rejected UInt64 COMMENT 'Requests refused by the rate limit',
+    errors UInt64 COMMENT 'Requests answered with a 5xx status',
+    duration_ms UInt64 COMMENT 'Time spent serving the requests, including the lifetime of streams',
+    rows_read UInt64 COMMENT 'Rows read by the ClickHouse queries of the requests (native protocol only)',
+    bytes_read UInt64 COMMENT 'Bytes read by the ClickHouse queries of the requests (native protocol only)'
+)
+ENGINE = SummingMergeTree()
+ORDER BY (key_id, window_start, route)
+TTL window_start + INTERVAL 90 DAY;
+
+-- Daily rollups (ct_daily_issuer_stats, ct_domain_first_seen, ct_daily_new_domains, rekor_daily_kind_stats,
+-- rekor_daily_key_algorithm_stats, rekor_daily_kind_mix)
+-- are created and backfilled by `ctmon-ingest rollups` and `sigstore-ingest rollups`
+
 CREATE MATERIALIZED VIEW ct_log_stats_by_log_id
 REFRESH EVERY 5 MINUTE
 ENGINE = Memory